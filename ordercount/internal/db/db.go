@@ -1,6 +1,7 @@
 package db
 
 import (
+    "fmt"
     "log"
     "strings"
 
@@ -17,8 +18,48 @@ func InitDB(dsn string) (*gorm.DB, error) {
         return nil, err
     }
 
+    // 费用规则表首次创建时写入默认规则
+    hasFeeRules := db.Migrator().HasTable(&models.FeeRule{})
+    hasExpenseCategories := db.Migrator().HasTable(&models.ExpenseCategory{})
+    // 结算审批状态列首次添加时，把已有记录标记为已审批（只执行一次）
+    hasSettlementStatus := !db.Migrator().HasTable(&models.DailySettlement{}) ||
+        db.Migrator().HasColumn(&models.DailySettlement{}, "status")
+
     // 自动迁移: 包括 User 表，便于首次部署时自动创建缺失表结构
-    if err := db.AutoMigrate(&models.User{}, &models.Order{}, &models.DailySettlement{}, &models.Product{}, &models.Store{}, &models.StoreUser{}, &models.StoreDailyStat{}); err != nil {
+    if err := db.AutoMigrate(
+        &models.User{}, &models.Order{}, &models.DailySettlement{}, &models.Product{}, &models.Store{}, &models.StoreUser{}, &models.StoreDailyStat{},
+        // 采购入库批次与 FIFO 分配记录（成本核算）
+        &models.PurchaseLot{}, &models.OrderCostAllocation{},
+        // 组合商品组成及组合订单的组件成本
+        &models.ProductComponent{}, &models.OrderComponentCost{},
+        // 商品分类、平台 SKU 别名
+        &models.Category{}, &models.SKUAlias{},
+        // 商品分国家标价
+        &models.ProductPrice{},
+        // 商品按角色的成本档位
+        &models.ProductRoleCost{},
+        // 商品状态变更记录
+        &models.ProductStatusLog{},
+        // 结算费用规则
+        &models.FeeRule{},
+        // 结算修订历史
+        &models.SettlementRevision{},
+        // 结算审批状态变更记录
+        &models.SettlementStatusLog{},
+        // 月结会计期间及关账记录
+        &models.AccountingPeriod{},
+        &models.PeriodLog{},
+        // 周期性固定成本
+        &models.FixedCostSchedule{},
+        // 费用流水及分类
+        &models.ExpenseCategory{},
+        &models.Expense{},
+        // 平台结算单导入及结算行
+        &models.PayoutImport{},
+        &models.PayoutLine{},
+        // 月度经营目标
+        &models.Target{},
+    ); err != nil {
         // MySQL 有时会因为索引/约束名不一致尝试执行 DROP，返回 1091 错误（Can't DROP ...）
         // 为了兼容已有数据库，遇到此类错误时记录警告并继续，而非直接失败。
         if strings.Contains(err.Error(), "Can't DROP") || strings.Contains(err.Error(), "Error 1091") {
@@ -28,6 +69,36 @@ func InitDB(dsn string) (*gorm.DB, error) {
         }
     }
 
+    if err := migrateLegacyRoleCosts(db); err != nil {
+        return nil, err
+    }
+    // 启用审批流程前的结算记录都已推送过企微，视为已审批
+    if !hasSettlementStatus {
+        res := db.Model(&models.DailySettlement{}).
+            Where("status = '' OR status IS NULL").
+            Update("status", "approved")
+        if res.Error != nil {
+            return nil, res.Error
+        }
+        log.Printf("marked %d legacy settlements as approved", res.RowsAffected)
+    }
+    if err := migrateSettlementStores(db); err != nil {
+        return nil, err
+    }
+    if err := migrateSettlementCurrencies(db); err != nil {
+        return nil, err
+    }
+    if !hasFeeRules {
+        if err := seedFeeRules(db); err != nil {
+            return nil, err
+        }
+    }
+    if !hasExpenseCategories {
+        if err := seedExpenseCategories(db); err != nil {
+            return nil, err
+        }
+    }
+
     // 如果还没有用户，创建默认超级管理员和管理员账号，便于首次登录
     var count int64
     if err := db.Model(&models.User{}).Count(&count).Error; err != nil {
@@ -70,3 +141,166 @@ func InitDB(dsn string) (*gorm.DB, error) {
 
     return db, nil
 }
+
+// migrateSettlementStores 为按国家结算的历史记录补上店铺：国家下只有一个店铺时归到该店铺，
+// 同一天已有该店铺的结算（或同一天多条历史记录）时只处理第一条；无法确定店铺的记录保持 NULL，按“未指定店铺”统计。
+func migrateSettlementStores(db *gorm.DB) error {
+    var stores []models.Store
+    if err := db.Order("id asc").Find(&stores).Error; err != nil {
+        return err
+    }
+    byCountry := map[string][]models.Store{}
+    for _, s := range stores {
+        byCountry[s.Country] = append(byCountry[s.Country], s)
+    }
+    for country, list := range byCountry {
+        if len(list) != 1 {
+            continue
+        }
+        storeID := list[0].ID
+        var legacy []models.DailySettlement
+        if err := db.Where("store_id IS NULL AND country = ?", country).Order("id asc").Find(&legacy).Error; err != nil {
+            return err
+        }
+        if len(legacy) == 0 {
+            continue
+        }
+        var taken []string
+        if err := db.Model(&models.DailySettlement{}).Where("store_id = ?", storeID).Pluck("date", &taken).Error; err != nil {
+            return err
+        }
+        used := map[string]bool{}
+        for _, d := range taken {
+            used[d] = true
+        }
+        moved := 0
+        for _, s := range legacy {
+            if used[s.Date] {
+                continue
+            }
+            if err := db.Model(&models.DailySettlement{}).Where("id = ?", s.ID).UpdateColumn("store_id", storeID).Error; err != nil {
+                return err
+            }
+            used[s.Date] = true
+            moved++
+        }
+        if moved > 0 {
+            log.Printf("migrated %d legacy settlements of %s to store %d", moved, country, storeID)
+        }
+    }
+
+    // 无法自动归属的历史记录（国家有多个店铺或没有店铺、同一天店铺已有结算）保持 store_id 为 NULL，
+    // 这些记录只有超级管理员和创建人可见，逐个国家打出来便于手动指定店铺
+    var remaining []struct {
+        Country string
+        Cnt     int64
+    }
+    if err := db.Model(&models.DailySettlement{}).
+        Select("country, COUNT(*) AS cnt").Where("store_id IS NULL").
+        Group("country").Order("country").Scan(&remaining).Error; err != nil {
+        return err
+    }
+    for _, r := range remaining {
+        reason := "a settlement of the only store already exists on the same date"
+        switch n := len(byCountry[r.Country]); {
+        case n == 0:
+            reason = "the country has no store"
+        case n > 1:
+            reason = fmt.Sprintf("the country has %d stores", n)
+        }
+        log.Printf("warning: %d legacy settlements of %s were not assigned to a store (%s); they stay visible only to superadmins and their creators until store_id is set manually", r.Cnt, r.Country, reason)
+    }
+    return nil
+}
+
+// migrateSettlementCurrencies 为启用多币种前的结算补齐各项金额的币种和人民币金额。
+// 历史数据没有记录币种，按当时结账工具的录入方式假设：销售额、货款成本、刷单费用、固定成本为人民币，广告费为本国货币，
+// 假设内容写入 currency_note，currency_basis 标记为 legacy_assumed，便于事后识别和更正。
+func migrateSettlementCurrencies(db *gorm.DB) error {
+    res := db.Exec(`UPDATE daily_settlements SET
+            currency = CASE WHEN currency <> '' THEN currency
+                WHEN country = '菲律宾' THEN 'PHP' WHEN country = '印尼' THEN 'IDR' WHEN country = '马来西亚' THEN 'MYR'
+                ELSE '' END,
+            sale_currency = 'CNY', sale_total_cny = sale_total,
+            goods_currency = 'CNY', goods_cost_cny = goods_cost,
+            shua_dan_currency = 'CNY', shua_dan_fee_cny = shua_dan_fee,
+            fixed_currency = 'CNY', fixed_cost_cny = fixed_cost,
+            ad_cost_cny = ROUND(ad_cost * exchange, 2),
+            rate_source = 'legacy',
+            currency_basis = 'legacy_assumed',
+            currency_note = ?
+        WHERE currency_basis = '' OR currency_basis IS NULL`,
+        models.SettlementLegacyCurrencyNote)
+    if res.Error != nil {
+        return res.Error
+    }
+    if res.RowsAffected > 0 {
+        // 广告费币种取补齐后的本国货币
+        if err := db.Exec(`UPDATE daily_settlements SET ad_currency = currency
+            WHERE currency_basis = 'legacy_assumed' AND (ad_currency = '' OR ad_currency IS NULL)`).Error; err != nil {
+            return err
+        }
+        log.Printf("migrated %d legacy settlements to explicit currencies (legacy_assumed)", res.RowsAffected)
+    }
+    return nil
+}
+
+// migrateLegacyRoleCosts 把旧版 products 表上的 cost_admin / cost_staff 列迁移到 product_role_costs，
+// 迁移完成后删除旧列；已经迁移过（列不存在）时直接跳过。
+func migrateLegacyRoleCosts(db *gorm.DB) error {
+    legacy := []struct{ Column, Role string }{
+        {"cost_admin", "admin"},
+        {"cost_staff", "staff"},
+    }
+    for _, l := range legacy {
+        if !db.Migrator().HasColumn(&models.Product{}, l.Column) {
+            continue
+        }
+        // 复制和删列放在同一个事务里，复制失败时不会删掉旧列。
+        // MySQL 的 DDL 会隐式提交，删列失败时已复制的数据保留，下次启动按 NOT EXISTS 跳过已复制的行后重试删列
+        err := db.Transaction(func(tx *gorm.DB) error {
+            if err := tx.Exec(`INSERT INTO product_role_costs (product_id, role, cost, created_at, updated_at)
+                SELECT p.id, ?, p.`+l.Column+`, NOW(), NOW() FROM products p
+                WHERE p.`+l.Column+` <> 0
+                  AND NOT EXISTS (SELECT 1 FROM product_role_costs rc WHERE rc.product_id = p.id AND rc.role = ?)`,
+                l.Role, l.Role).Error; err != nil {
+                return err
+            }
+            return tx.Migrator().DropColumn(&models.Product{}, l.Column)
+        })
+        if err != nil {
+            return err
+        }
+        log.Printf("migrated products.%s to product_role_costs (role=%s)", l.Column, l.Role)
+    }
+    return nil
+}
+
+// seedFeeRules 写入原先固定在结算公式里的两条通用规则：平台手续费 = 销售额 × 7%，广告税 = 广告费 × 11%
+func seedFeeRules(db *gorm.DB) error {
+    rules := []models.FeeRule{
+        {Name: "平台手续费", Code: "platform_fee", Kind: "platform_fee", RateType: "percent", Value: 7, Base: "sale_total", Enabled: true},
+        {Name: "广告税", Code: "ad_tax", Kind: "ad_tax", RateType: "percent", Value: 11, Base: "ad_cost", Enabled: true},
+    }
+    if err := db.Create(&rules).Error; err != nil {
+        return err
+    }
+    log.Println("created default fee rules: platform_fee 7%, ad_tax 11%")
+    return nil
+}
+
+// seedExpenseCategories 首次创建费用分类表时写入常用分类
+func seedExpenseCategories(db *gorm.DB) error {
+    cats := []models.ExpenseCategory{
+        {Name: "刷单", Kind: "shua_dan", SortOrder: 1},
+        {Name: "房租", Kind: "fixed", SortOrder: 2},
+        {Name: "工资", Kind: "fixed", SortOrder: 3},
+        {Name: "软件订阅", Kind: "fixed", SortOrder: 4},
+        {Name: "其他", Kind: "other", SortOrder: 99},
+    }
+    if err := db.Create(&cats).Error; err != nil {
+        return err
+    }
+    log.Println("created default expense categories")
+    return nil
+}
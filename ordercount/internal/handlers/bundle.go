@@ -0,0 +1,306 @@
+package handlers
+
+import (
+    "fmt"
+    "math"
+
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+)
+
+// 组合商品订单的核算方式：成本由各组件按自己的核算方式计算后汇总
+const CostingBundle = "bundle"
+
+type bundleEdge struct {
+    ID       uint // 对端商品 ID
+    Quantity int
+}
+
+// bundleGraph 组合商品的组成关系：children 为 组合 -> 组件，parents 为 组件 -> 组合
+type bundleGraph struct {
+    children map[uint][]bundleEdge
+    parents  map[uint][]bundleEdge
+}
+
+func loadBundleGraph(db *gorm.DB) (*bundleGraph, error) {
+    var rows []models.ProductComponent
+    if err := db.Order("id asc").Find(&rows).Error; err != nil {
+        return nil, err
+    }
+    g := &bundleGraph{children: map[uint][]bundleEdge{}, parents: map[uint][]bundleEdge{}}
+    for _, r := range rows {
+        g.children[r.ProductID] = append(g.children[r.ProductID], bundleEdge{ID: r.ComponentID, Quantity: r.Quantity})
+        g.parents[r.ComponentID] = append(g.parents[r.ComponentID], bundleEdge{ID: r.ProductID, Quantity: r.Quantity})
+    }
+    return g, nil
+}
+
+func (g *bundleGraph) isBundle(id uint) bool {
+    return len(g.children[id]) > 0
+}
+
+// leaves 把组合商品展开到最底层的组件：返回 组件 ID -> 每套用量；普通商品返回自身
+func (g *bundleGraph) leaves(id uint) map[uint]int {
+    res := map[uint]int{}
+    var walk func(id uint, mult int, depth int)
+    walk = func(id uint, mult int, depth int) {
+        // 正常情况下保存时已拒绝循环，这里再加一层深度保护
+        if depth > 32 {
+            return
+        }
+        if !g.isBundle(id) {
+            res[id] += mult
+            return
+        }
+        for _, e := range g.children[id] {
+            walk(e.ID, mult*e.Quantity, depth+1)
+        }
+    }
+    walk(id, 1, 0)
+    return res
+}
+
+// bundleFactors 返回直接或间接包含该商品的所有组合商品，以及每套组合消耗该商品的数量
+func (g *bundleGraph) bundleFactors(id uint) map[uint]int {
+    res := map[uint]int{}
+    var walk func(id uint, mult int, depth int)
+    walk = func(id uint, mult int, depth int) {
+        if depth > 32 {
+            return
+        }
+        for _, e := range g.parents[id] {
+            res[e.ID] += mult * e.Quantity
+            walk(e.ID, mult*e.Quantity, depth+1)
+        }
+    }
+    walk(id, 1, 0)
+    return res
+}
+
+// wouldCycle 判断把 components 设为 productID 的组成后是否会形成循环（组件直接或间接包含该商品）
+func (g *bundleGraph) wouldCycle(productID uint, components []uint) bool {
+    visited := map[uint]bool{}
+    var reaches func(id uint) bool
+    reaches = func(id uint) bool {
+        if id == productID {
+            return true
+        }
+        if visited[id] {
+            return false
+        }
+        visited[id] = true
+        for _, e := range g.children[id] {
+            if reaches(e.ID) {
+                return true
+            }
+        }
+        return false
+    }
+    for _, c := range components {
+        if reaches(c) {
+            return true
+        }
+    }
+    return false
+}
+
+// rollupBundleCosts 按组件重新汇总所有组合商品的基础成本和各角色档位成本，
+// 组件某一档未配置时按回退链解析；组合商品某一档与其回退档结果相同时不单独保存，停售的组合商品不更新。
+// 返回成本有变化的组合商品 ID。
+func rollupBundleCosts(db *gorm.DB) ([]uint, error) {
+    g, err := loadBundleGraph(db)
+    if err != nil {
+        return nil, err
+    }
+    if len(g.children) == 0 {
+        return nil, nil
+    }
+    var products []models.Product
+    if err := db.Find(&products).Error; err != nil {
+        return nil, err
+    }
+    byID := make(map[uint]models.Product, len(products))
+    for _, p := range products {
+        byID[p.ID] = p
+    }
+    res, err := loadCostResolver(db, nil)
+    if err != nil {
+        return nil, err
+    }
+
+    tierNames := []string{BaseCostTier}
+    for _, t := range CostTiers {
+        tierNames = append(tierNames, t.Role)
+    }
+    memo := map[uint]map[string]float64{}
+    var calc func(id uint, depth int) map[string]float64
+    calc = func(id uint, depth int) map[string]float64 {
+        if t, ok := memo[id]; ok {
+            return t
+        }
+        t := make(map[string]float64, len(tierNames))
+        if !g.isBundle(id) || depth > 32 {
+            p := byID[id]
+            for _, name := range tierNames {
+                t[name] = res.Cost(p, name)
+            }
+        } else {
+            for _, e := range g.children[id] {
+                ct := calc(e.ID, depth+1)
+                for _, name := range tierNames {
+                    t[name] += float64(e.Quantity) * ct[name]
+                }
+            }
+            for _, name := range tierNames {
+                t[name] = roundMoney(t[name])
+            }
+        }
+        memo[id] = t
+        return t
+    }
+
+    changed := make([]uint, 0)
+    for id := range g.children {
+        p, ok := byID[id]
+        // 停售（归档）组合的成本已冻结，组件成本变化不再回写
+        if !ok || productStatus(p) == ProductDiscontinued {
+            continue
+        }
+        t := calc(id, 0)
+        edits := costEdits{}
+        for _, tier := range CostTiers {
+            fallback := tier.Fallback
+            if fallback == "" {
+                fallback = BaseCostTier
+            }
+            v := t[tier.Role]
+            raw, has := res.Raw(p, tier.Role)
+            if math.Abs(v-t[fallback]) < 0.005 {
+                // 与回退档结果相同，删除单独配置的值
+                if has {
+                    edits[tier.Role] = nil
+                }
+                continue
+            }
+            if !has || math.Abs(raw-v) >= 0.005 {
+                v := v
+                edits[tier.Role] = &v
+            }
+        }
+        if math.Abs(p.Cost-t[BaseCostTier]) < 0.005 && len(edits) == 0 {
+            continue
+        }
+        err := db.Transaction(func(tx *gorm.DB) error {
+            if err := tx.Model(&models.Product{}).Where("id = ?", id).
+                UpdateColumn("cost", t[BaseCostTier]).Error; err != nil {
+                return err
+            }
+            return saveRoleCosts(tx, id, edits)
+        })
+        if err != nil {
+            return changed, err
+        }
+        changed = append(changed, id)
+    }
+    return changed, nil
+}
+
+// bundleComponentInput SaveProduct 中提交的组件，组件可以用 component_id 或 sku 指定
+type bundleComponentInput struct {
+    ComponentID uint   `json:"component_id"`
+    SKU         string `json:"sku"`
+    Quantity    int    `json:"quantity"`
+}
+
+// validateBundleComponents 校验组件：商品存在、数量大于 0、不包含自身、不形成循环；同一组件多行时合并数量
+func validateBundleComponents(db *gorm.DB, productID uint, input []bundleComponentInput) ([]models.ProductComponent, error) {
+    qty := map[uint]int{}
+    order := make([]uint, 0, len(input))
+    for _, in := range input {
+        if in.Quantity <= 0 {
+            return nil, fmt.Errorf("组件数量必须大于 0")
+        }
+        id := in.ComponentID
+        if id == 0 {
+            p, err := findProductBySKU(db, in.SKU)
+            if err != nil {
+                return nil, err
+            }
+            if p == nil {
+                return nil, fmt.Errorf("组件商品 %s 不存在", in.SKU)
+            }
+            id = p.ID
+        } else {
+            var cnt int64
+            if err := db.Model(&models.Product{}).Where("id = ?", id).Count(&cnt).Error; err != nil {
+                return nil, err
+            }
+            if cnt == 0 {
+                return nil, fmt.Errorf("组件商品 %d 不存在", id)
+            }
+        }
+        if productID != 0 && id == productID {
+            return nil, fmt.Errorf("组合商品不能包含自身")
+        }
+        if _, ok := qty[id]; !ok {
+            order = append(order, id)
+        }
+        qty[id] += in.Quantity
+    }
+
+    if productID != 0 && len(order) > 0 {
+        g, err := loadBundleGraph(db)
+        if err != nil {
+            return nil, err
+        }
+        if g.wouldCycle(productID, order) {
+            return nil, fmt.Errorf("组件中直接或间接包含了当前商品，不能形成循环")
+        }
+    }
+
+    res := make([]models.ProductComponent, 0, len(order))
+    for _, id := range order {
+        res = append(res, models.ProductComponent{ProductID: productID, ComponentID: id, Quantity: qty[id]})
+    }
+    return res, nil
+}
+
+// updateBundleOrderTotals 按组件成本汇总组合商品订单的货款成本
+func updateBundleOrderTotals(tx *gorm.DB, orderIDs []uint) error {
+    if len(orderIDs) == 0 {
+        return nil
+    }
+    type row struct {
+        OrderID   uint
+        GoodsCost float64
+    }
+    var rows []row
+    if err := tx.Model(&models.OrderComponentCost{}).
+        Select("order_id, SUM(goods_cost) AS goods_cost").
+        Where("order_id IN ?", orderIDs).
+        Group("order_id").
+        Scan(&rows).Error; err != nil {
+        return err
+    }
+    totals := make(map[uint]float64, len(rows))
+    for _, r := range rows {
+        totals[r.OrderID] = r.GoodsCost
+    }
+    var orders []models.Order
+    if err := tx.Select("id, quantity").Where("id IN ?", orderIDs).Find(&orders).Error; err != nil {
+        return err
+    }
+    for _, o := range orders {
+        total := roundMoney(totals[o.ID])
+        unit := 0.0
+        if o.Quantity > 0 {
+            unit = total / float64(o.Quantity)
+        }
+        if err := tx.Model(&models.Order{}).Where("id = ?", o.ID).
+            UpdateColumns(map[string]any{"unit_cost": unit, "goods_cost": total, "cost_method": CostingBundle}).Error; err != nil {
+            return err
+        }
+    }
+    return nil
+}
@@ -0,0 +1,657 @@
+package handlers
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+    "ordercount/internal/utils"
+)
+
+// 未分类商品在统计中的虚拟分类
+const uncategorizedName = "未分类"
+
+// categoryNode 分类树节点
+type categoryNode struct {
+    models.Category
+    // 从顶级到当前分类的完整路径，例如 "美妆/口红"
+    Path     string          `json:"path"`
+    Children []*categoryNode `json:"children"`
+}
+
+// loadCategoryTree 读取全部分类并组装成树，返回顶级节点和按 ID 索引的节点表
+func loadCategoryTree(db *gorm.DB) ([]*categoryNode, map[uint]*categoryNode, error) {
+    var cats []models.Category
+    if err := db.Order("sort asc, id asc").Find(&cats).Error; err != nil {
+        return nil, nil, err
+    }
+    nodes := make(map[uint]*categoryNode, len(cats))
+    for _, cat := range cats {
+        nodes[cat.ID] = &categoryNode{Category: cat, Children: []*categoryNode{}}
+    }
+    roots := make([]*categoryNode, 0)
+    for _, cat := range cats {
+        n := nodes[cat.ID]
+        if parent, ok := nodes[cat.ParentID]; ok && cat.ParentID != cat.ID {
+            parent.Children = append(parent.Children, n)
+        } else {
+            // 父分类不存在时按顶级分类处理
+            roots = append(roots, n)
+        }
+    }
+    var fillPath func(n *categoryNode, prefix string)
+    fillPath = func(n *categoryNode, prefix string) {
+        n.Path = n.Name
+        if prefix != "" {
+            n.Path = prefix + "/" + n.Name
+        }
+        for _, ch := range n.Children {
+            fillPath(ch, n.Path)
+        }
+    }
+    for _, r := range roots {
+        fillPath(r, "")
+    }
+    return roots, nodes, nil
+}
+
+// descendantIDs 返回分类自身及其所有子孙分类的 ID
+func (n *categoryNode) descendantIDs() []uint {
+    ids := []uint{n.ID}
+    for _, ch := range n.Children {
+        ids = append(ids, ch.descendantIDs()...)
+    }
+    return ids
+}
+
+// ListCategories 分类列表：同时返回扁平列表（带完整路径）和树形结构
+func ListCategories(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roots, nodes, err := loadCategoryTree(db)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        // 每个分类下直接挂的商品数量
+        type countRow struct {
+            CategoryID uint
+            Cnt        int64
+        }
+        var counts []countRow
+        if err := db.Model(&models.Product{}).Select("category_id, COUNT(*) AS cnt").Group("category_id").Scan(&counts).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        productCount := make(map[uint]int64, len(counts))
+        for _, r := range counts {
+            productCount[r.CategoryID] = r.Cnt
+        }
+
+        type flatItem struct {
+            models.Category
+            Path         string `json:"path"`
+            ProductCount int64  `json:"product_count"`
+        }
+        flat := make([]flatItem, 0, len(nodes))
+        var walk func(list []*categoryNode)
+        walk = func(list []*categoryNode) {
+            for _, n := range list {
+                flat = append(flat, flatItem{Category: n.Category, Path: n.Path, ProductCount: productCount[n.ID]})
+                walk(n.Children)
+            }
+        }
+        walk(roots)
+
+        c.JSON(http.StatusOK, gin.H{
+            "items":         flat,
+            "tree":          roots,
+            "uncategorized": productCount[0],
+        })
+    }
+}
+
+// SaveCategory 新增或修改分类（管理员 / 超级管理员）
+func SaveCategory(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以维护分类"})
+            return
+        }
+
+        var body struct {
+            ID       uint   `json:"id"`
+            Name     string `json:"name"`
+            ParentID uint   `json:"parent_id"`
+            Sort     int    `json:"sort"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        body.Name = strings.TrimSpace(body.Name)
+        if body.Name == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "分类名称不能为空"})
+            return
+        }
+        if strings.Contains(body.Name, "/") {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "分类名称不能包含 /"})
+            return
+        }
+
+        _, nodes, err := loadCategoryTree(db)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        var cat models.Category
+        if body.ID != 0 {
+            n, ok := nodes[body.ID]
+            if !ok {
+                c.JSON(http.StatusNotFound, gin.H{"error": "分类不存在"})
+                return
+            }
+            cat = n.Category
+        }
+        if body.ParentID != 0 {
+            if _, ok := nodes[body.ParentID]; !ok {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "上级分类不存在"})
+                return
+            }
+            // 不能把分类挂到自己或自己的子孙分类下面，否则会形成环
+            if body.ID != 0 {
+                for _, id := range nodes[body.ID].descendantIDs() {
+                    if id == body.ParentID {
+                        c.JSON(http.StatusBadRequest, gin.H{"error": "不能把分类移动到自身或其子分类下"})
+                        return
+                    }
+                }
+            }
+        }
+        // 同一上级下分类名称不能重复
+        for _, n := range nodes {
+            if n.ID != body.ID && n.ParentID == body.ParentID && n.Name == body.Name {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "同级分类下已存在同名分类"})
+                return
+            }
+        }
+
+        cat.Name = body.Name
+        cat.ParentID = body.ParentID
+        cat.Sort = body.Sort
+        if err := db.Save(&cat).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, cat)
+    }
+}
+
+// DeleteCategory 删除分类：有子分类时不允许删除，分类下的商品移到上级分类（顶级分类则变为未分类）
+func DeleteCategory(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以维护分类"})
+            return
+        }
+        id, err := strconv.Atoi(c.Param("id"))
+        if err != nil || id <= 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+            return
+        }
+
+        var cat models.Category
+        if err := db.First(&cat, id).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "分类不存在"})
+            return
+        }
+        var children int64
+        if err := db.Model(&models.Category{}).Where("parent_id = ?", cat.ID).Count(&children).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if children > 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "请先删除或移动子分类"})
+            return
+        }
+
+        var moved int64
+        err = db.Transaction(func(tx *gorm.DB) error {
+            res := tx.Model(&models.Product{}).Where("category_id = ?", cat.ID).Update("category_id", cat.ParentID)
+            if res.Error != nil {
+                return res.Error
+            }
+            moved = res.RowsAffected
+            return tx.Delete(&models.Category{}, cat.ID).Error
+        })
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"deleted": cat.ID, "moved_products": moved})
+    }
+}
+
+// normalizeTags 整理标签：去空白、去重，保持原顺序，返回逗号分隔的字符串（与 User.Permissions 的存储方式一致）
+func normalizeTags(tags []string) string {
+    seen := make(map[string]bool, len(tags))
+    res := make([]string, 0, len(tags))
+    for _, t := range tags {
+        // 兼容前端把多个标签写在一个字符串里（中英文逗号均可）
+        for _, part := range strings.FieldsFunc(t, func(r rune) bool { return r == ',' || r == '，' }) {
+            part = strings.TrimSpace(part)
+            if part == "" || seen[part] {
+                continue
+            }
+            seen[part] = true
+            res = append(res, part)
+        }
+    }
+    return strings.Join(res, ",")
+}
+
+// splitTags 把逗号分隔的标签字符串拆成列表
+func splitTags(s string) []string {
+    res := make([]string, 0)
+    for _, t := range strings.Split(s, ",") {
+        if t = strings.TrimSpace(t); t != "" {
+            res = append(res, t)
+        }
+    }
+    return res
+}
+
+// ListTags 返回所有商品上出现过的标签及使用次数，供前端筛选和自动补全
+func ListTags(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var all []string
+        if err := db.Model(&models.Product{}).Where("tags <> ''").Pluck("tags", &all).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        counts := map[string]int{}
+        for _, s := range all {
+            for _, t := range splitTags(s) {
+                counts[t]++
+            }
+        }
+        type item struct {
+            Tag   string `json:"tag"`
+            Count int    `json:"count"`
+        }
+        res := make([]item, 0, len(counts))
+        for t, n := range counts {
+            res = append(res, item{Tag: t, Count: n})
+        }
+        sort.Slice(res, func(i, j int) bool {
+            if res[i].Count != res[j].Count {
+                return res[i].Count > res[j].Count
+            }
+            return res[i].Tag < res[j].Tag
+        })
+        c.JSON(http.StatusOK, res)
+    }
+}
+
+// RenameTag 批量重命名或删除标签：new_name 为空时从所有商品上移除该标签（管理员 / 超级管理员）
+func RenameTag(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以维护标签"})
+            return
+        }
+        var body struct {
+            Name    string `json:"name"`
+            NewName string `json:"new_name"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        body.Name = strings.TrimSpace(body.Name)
+        body.NewName = strings.TrimSpace(body.NewName)
+        if body.Name == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "name required"})
+            return
+        }
+        if strings.ContainsAny(body.NewName, ",，") {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "标签名称不能包含逗号"})
+            return
+        }
+
+        var products []models.Product
+        if err := db.Where("FIND_IN_SET(?, tags)", body.Name).Find(&products).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        err := db.Transaction(func(tx *gorm.DB) error {
+            for _, p := range products {
+                tags := splitTags(p.Tags)
+                for i, t := range tags {
+                    if t == body.Name {
+                        tags[i] = body.NewName
+                    }
+                }
+                if err := tx.Model(&models.Product{}).Where("id = ?", p.ID).
+                    UpdateColumn("tags", normalizeTags(tags)).Error; err != nil {
+                    return err
+                }
+            }
+            return nil
+        })
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"updated": len(products)})
+    }
+}
+
+// parseStatsRange 解析统计接口的 start / end 参数（YYYY-MM-DD），默认最近 30 天
+func parseStatsRange(c *gin.Context) (string, string, error) {
+    end := c.Query("end")
+    if end == "" {
+        end = time.Now().Format("2006-01-02")
+    }
+    start := c.Query("start")
+    if start == "" {
+        t, err := time.Parse("2006-01-02", end)
+        if err != nil {
+            return "", "", fmt.Errorf("invalid end date")
+        }
+        start = t.AddDate(0, 0, -29).Format("2006-01-02")
+    }
+    s, err := time.Parse("2006-01-02", start)
+    if err != nil {
+        return "", "", fmt.Errorf("invalid start date")
+    }
+    e, err := time.Parse("2006-01-02", end)
+    if err != nil {
+        return "", "", fmt.Errorf("invalid end date")
+    }
+    if e.Before(s) {
+        return "", "", fmt.Errorf("end 不能早于 start")
+    }
+    return start, end, nil
+}
+
+// amountToCNY 按汇率表把订单金额折算为人民币（rates 含义：1 CNY ≈ rates[币种] 外币），
+// 币种为空或缺少汇率时原样返回，ok=false
+func amountToCNY(amount float64, currency string, rates map[string]float64) (float64, bool) {
+    cur := strings.ToUpper(strings.TrimSpace(currency))
+    if cur == "" || cur == "CNY" || cur == "RMB" {
+        return amount, cur != ""
+    }
+    r, ok := rates[cur]
+    if !ok || r <= 0 {
+        return amount, false
+    }
+    return amount / r, true
+}
+
+// cnyConverter 把按币种汇总的订单金额折算为人民币，各处订单销售额统一用它折算：
+// 币种为空或 CNY 按人民币计；fixed 中的币种使用指定汇率（1 外币 ≈ ? 人民币，例如结算使用的汇率），
+// 其余币种按实时汇率折算，实时汇率在第一次用到时才获取。取不到汇率的币种按原金额合计并记录下来。
+type cnyConverter struct {
+    fixed    map[string]float64
+    rates    map[string]float64
+    usedLive bool
+    failed   map[string]bool
+    applied  map[string]float64
+}
+
+func newCNYConverter(fixed map[string]float64) *cnyConverter {
+    cv := &cnyConverter{fixed: map[string]float64{}, failed: map[string]bool{}, applied: map[string]float64{}}
+    for cur, rate := range fixed {
+        if rate > 0 {
+            cv.fixed[strings.ToUpper(strings.TrimSpace(cur))] = rate
+        }
+    }
+    return cv
+}
+
+// convert 折算一笔按币种汇总的金额
+func (cv *cnyConverter) convert(amount float64, currency string) float64 {
+    cur := strings.ToUpper(strings.TrimSpace(currency))
+    if cur == "" || cur == "CNY" || cur == "RMB" {
+        return amount
+    }
+    if rate, ok := cv.fixed[cur]; ok {
+        cv.applied[cur] = rate
+        return amount * rate
+    }
+    if cv.rates == nil {
+        rates, err := utils.GetRates()
+        if err != nil {
+            rates = map[string]float64{}
+        }
+        cv.rates = rates
+    }
+    cny, ok := amountToCNY(amount, cur, cv.rates)
+    if !ok {
+        cv.failed[cur] = true
+        return amount
+    }
+    cv.usedLive = true
+    cv.applied[cur] = 1 / cv.rates[cur]
+    return cny
+}
+
+// used 实际用于折算的汇率（1 外币 ≈ ? 人民币），取不到汇率的币种不在其中
+func (cv *cnyConverter) used() map[string]float64 {
+    return cv.applied
+}
+
+// warnings 折算过程中需要提示的情况：取不到汇率的币种、使用了实时汇率
+func (cv *cnyConverter) warnings() []string {
+    var out []string
+    failed := make([]string, 0, len(cv.failed))
+    for cur := range cv.failed {
+        failed = append(failed, cur)
+    }
+    sort.Strings(failed)
+    for _, cur := range failed {
+        out = append(out, fmt.Sprintf("获取 %s 汇率失败，该币种的订单按原金额合计", cur))
+    }
+    if cv.usedLive {
+        out = append(out, "非人民币订单按实时汇率折算，与结算当时使用的汇率可能略有差异")
+    }
+    return out
+}
+
+// CategoryStats 按分类统计销量、销售额（折算人民币）、货款成本和毛利率。
+// GET /api/stats/categories?start=YYYY-MM-DD&end=YYYY-MM-DD
+// 上级分类的 total_* 字段包含所有子分类的汇总，未分类商品和已删除商品的订单计入“未分类”。
+func CategoryStats(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看分类统计"})
+            return
+        }
+        start, end, err := parseStatsRange(c)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        // 按 分类 + 商品 + 币种 聚合，金额在 Go 里按汇率折算
+        type row struct {
+            CategoryID uint
+            ProductID  uint
+            Currency   string
+            Quantity   int64
+            Revenue    float64
+            GoodsCost  float64
+        }
+        var rows []row
+        err = db.Table("orders AS o").
+            Joins(orderProductJoin).
+            Where("o.created_at >= ? AND o.created_at < DATE_ADD(?, INTERVAL 1 DAY)", start, end).
+            Where("o.quantity > 0 AND o.product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇").
+            Select("IFNULL(p.category_id, 0) AS category_id, IFNULL(p.id, 0) AS product_id, o.currency AS currency, " +
+                "SUM(o.quantity) AS quantity, IFNULL(SUM(o.total_amount), 0) AS revenue, " +
+                "IFNULL(SUM(" + orderGoodsCostExpr + "), 0) AS goods_cost").
+            Group("IFNULL(p.category_id, 0), IFNULL(p.id, 0), o.currency").
+            Scan(&rows).Error
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        // 超级管理员看订单上核算好的真实货款成本；其他角色按自己的成本档位（数量 × 档位成本）计算，
+        // 与商品列表中看到的成本口径一致
+        roleStr, _ := roleVal.(string)
+        if ownCostTier(roleStr) != BaseCostTier {
+            ids := make([]uint, 0, len(rows))
+            for _, r := range rows {
+                if r.ProductID != 0 {
+                    ids = append(ids, r.ProductID)
+                }
+            }
+            var products []models.Product
+            if len(ids) > 0 {
+                if err := db.Where("id IN ?", ids).Find(&products).Error; err != nil {
+                    c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                    return
+                }
+            }
+            costs, err := loadCostResolver(db, ids)
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            unit := make(map[uint]float64, len(products))
+            for _, p := range products {
+                unit[p.ID] = costs.ForRole(p, roleStr)
+            }
+            for i := range rows {
+                rows[i].GoodsCost = float64(rows[i].Quantity) * unit[rows[i].ProductID]
+            }
+        }
+
+        roots, nodes, err := loadCategoryTree(db)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        // 销售额与其他报表一样用 cnyConverter 折算，有外币订单时才获取实时汇率
+        cv := newCNYConverter(nil)
+
+        type agg struct {
+            Quantity  int64
+            Revenue   float64
+            GoodsCost float64
+        }
+        own := map[uint]*agg{}
+        for _, r := range rows {
+            id := r.CategoryID
+            // 分类已被删除的商品计入未分类
+            if _, ok := nodes[id]; !ok {
+                id = 0
+            }
+            revenue := cv.convert(r.Revenue, r.Currency)
+            a := own[id]
+            if a == nil {
+                a = &agg{}
+                own[id] = a
+            }
+            a.Quantity += r.Quantity
+            a.Revenue += revenue
+            a.GoodsCost += r.GoodsCost
+        }
+        warnings := append([]string{}, cv.warnings()...)
+
+        type item struct {
+            ID       uint   `json:"id"`
+            Name     string `json:"name"`
+            Path     string `json:"path"`
+            ParentID uint   `json:"parent_id"`
+            // 仅本分类直接挂的商品
+            Quantity    int64   `json:"quantity"`
+            Revenue     float64 `json:"revenue"`
+            GoodsCost   float64 `json:"goods_cost"`
+            GrossProfit float64 `json:"gross_profit"`
+            Margin      float64 `json:"margin"`
+            // 包含所有子分类
+            TotalQuantity    int64   `json:"total_quantity"`
+            TotalRevenue     float64 `json:"total_revenue"`
+            TotalGoodsCost   float64 `json:"total_goods_cost"`
+            TotalGrossProfit float64 `json:"total_gross_profit"`
+            TotalMargin      float64 `json:"total_margin"`
+        }
+        margin := func(revenue, profit float64) float64 {
+            if revenue == 0 {
+                return 0
+            }
+            return roundMoney(profit / revenue * 100)
+        }
+        build := func(id uint, name, path string, parentID uint, ids []uint) item {
+            it := item{ID: id, Name: name, Path: path, ParentID: parentID}
+            if a := own[id]; a != nil {
+                it.Quantity = a.Quantity
+                it.Revenue = roundMoney(a.Revenue)
+                it.GoodsCost = roundMoney(a.GoodsCost)
+            }
+            var total agg
+            for _, cid := range ids {
+                if a := own[cid]; a != nil {
+                    total.Quantity += a.Quantity
+                    total.Revenue += a.Revenue
+                    total.GoodsCost += a.GoodsCost
+                }
+            }
+            it.GrossProfit = roundMoney(it.Revenue - it.GoodsCost)
+            it.Margin = margin(it.Revenue, it.GrossProfit)
+            it.TotalQuantity = total.Quantity
+            it.TotalRevenue = roundMoney(total.Revenue)
+            it.TotalGoodsCost = roundMoney(total.GoodsCost)
+            it.TotalGrossProfit = roundMoney(total.Revenue - total.GoodsCost)
+            it.TotalMargin = margin(it.TotalRevenue, it.TotalGrossProfit)
+            return it
+        }
+
+        items := make([]item, 0, len(nodes)+1)
+        var walk func(list []*categoryNode)
+        walk = func(list []*categoryNode) {
+            for _, n := range list {
+                items = append(items, build(n.ID, n.Name, n.Path, n.ParentID, n.descendantIDs()))
+                walk(n.Children)
+            }
+        }
+        walk(roots)
+        items = append(items, build(0, uncategorizedName, uncategorizedName, 0, []uint{0}))
+
+        var sum agg
+        for _, a := range own {
+            sum.Quantity += a.Quantity
+            sum.Revenue += a.Revenue
+            sum.GoodsCost += a.GoodsCost
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "start": start,
+            "end":   end,
+            "items": items,
+            "total": gin.H{
+                "quantity":     sum.Quantity,
+                "revenue":      roundMoney(sum.Revenue),
+                "goods_cost":   roundMoney(sum.GoodsCost),
+                "gross_profit": roundMoney(sum.Revenue - sum.GoodsCost),
+                "margin":       margin(sum.Revenue, sum.Revenue-sum.GoodsCost),
+            },
+            "warnings": warnings,
+        })
+    }
+}
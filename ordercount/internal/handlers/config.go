@@ -1,5 +1,10 @@
 package handlers
 
+import (
+    "ordercount/internal/labels"
+    "ordercount/internal/storage"
+)
+
 // WecomWebhook 用于存放企业微信机器人 webhook URL，由 main 在启动时从配置文件注入。
 var WecomWebhook string
 
@@ -7,3 +12,19 @@ var WecomWebhook string
 var DoubaoAPIKey string
 var DoubaoEndpoint string
 var DoubaoModel string
+
+// UploadStorage 上传文件（商品图片等）的存储后端，由 main 在启动时按 storage 配置注入，默认本地 uploads 目录。
+var UploadStorage storage.Storage = storage.NewLocal("uploads")
+
+// CostTiers 按角色区分的商品成本档位，由 main 按 cost_tiers 配置注入（经 SetCostTiers 校验）。
+// 默认：管理员、员工各有一档，未配置时回退到基础成本；管理员可以维护自己的档位，员工只能查看。
+var CostTiers = []CostTier{
+    {Role: "admin", Label: "管理员成本", EditBy: []string{"admin"}},
+    {Role: "staff", Label: "员工成本"},
+}
+
+// SettlementFixedCosts 结算建议使用的每日固定成本（人民币），按国家配置，default 为未单独配置国家的默认值；由 main 按 settlement.fixed_costs 注入。
+var SettlementFixedCosts = map[string]float64{}
+
+// LabelRenderer SKU 标签渲染器，默认使用内置西文字体；main 按 labels.font_path 配置注入支持中文的字体。
+var LabelRenderer = labels.Default()
@@ -0,0 +1,328 @@
+package handlers
+
+import (
+    "fmt"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+)
+
+// 数据一致性检查的差异类型
+const (
+    ConsistencySalesMismatch     = "sales_mismatch"     // 订单销售额合计与结算销售额不一致
+    ConsistencyAdCostMismatch    = "ad_cost_mismatch"   // 店铺每日广告费合计与结算广告费不一致
+    ConsistencyMissingSettlement = "missing_settlement" // 有订单但没有结算记录
+    ConsistencyUnmatchedSKU      = "unmatched_sku"      // 订单 SKU 没有对应的商品
+)
+
+var consistencyKindLabels = map[string]string{
+    ConsistencySalesMismatch:     "销售额不一致",
+    ConsistencyAdCostMismatch:    "广告费不一致",
+    ConsistencyMissingSettlement: "缺少结算",
+    ConsistencyUnmatchedSKU:      "SKU 未匹配商品",
+}
+
+// consistencyListLimit 未匹配商品的订单明细最多返回的条数
+const consistencyListLimit = 500
+
+// consistencyRow 某一天某个国家的核对结果
+type consistencyRow struct {
+    Date     string `json:"date"`
+    Country  string `json:"country"`
+    Currency string `json:"currency"` // 本国货币，广告费按此币种比较
+
+    OrderCount      int64   `json:"order_count"`
+    OrderSalesCNY   float64 `json:"order_sales_cny"` // 订单总额合计（人民币）
+    SettlementCount int     `json:"settlement_count"`
+    SettlementSales float64 `json:"settlement_sales_cny"` // 结算销售额合计（人民币）
+    SalesDiff       float64 `json:"sales_diff"`           // 结算 - 订单
+
+    StatsAdCost      float64 `json:"stats_ad_cost"`      // 店铺每日数据广告费合计（本国货币）
+    SettlementAdCost float64 `json:"settlement_ad_cost"` // 结算广告费合计（本国货币）
+    AdCostDiff       float64 `json:"ad_cost_diff"`       // 结算 - 店铺数据
+
+    UnmatchedOrders int64    `json:"unmatched_orders"` // SKU 未匹配到商品的订单数
+    Issues          []string `json:"issues"`
+}
+
+// consistencyIssue 一条差异
+type consistencyIssue struct {
+    Date     string  `json:"date"`
+    Country  string  `json:"country"`
+    Kind     string  `json:"kind"`
+    Label    string  `json:"label"`
+    Expected float64 `json:"expected"` // 订单 / 店铺数据的合计
+    Actual   float64 `json:"actual"`   // 结算中的金额
+    Diff     float64 `json:"diff"`
+    Message  string  `json:"message"`
+}
+
+// consistencyOrder SKU 未匹配到商品的订单
+type consistencyOrder struct {
+    ID          uint    `json:"id"`
+    Date        string  `json:"date"`
+    Country     string  `json:"country"`
+    Platform    string  `json:"platform"`
+    StoreID     uint    `json:"store_id"`
+    OrderNo     string  `json:"order_no"`
+    SKU         string  `json:"sku"`
+    ProductName string  `json:"product_name"`
+    Quantity    int     `json:"quantity"`
+    TotalAmount float64 `json:"total_amount"`
+    Currency    string  `json:"currency"`
+}
+
+// consistencyReport 一段日期内的一致性检查结果
+type consistencyReport struct {
+    Start        string             `json:"start"`
+    End          string             `json:"end"`
+    Tolerance    float64            `json:"tolerance"`
+    TolerancePct float64            `json:"tolerance_pct"`
+    Rows         []consistencyRow   `json:"rows"`
+    Issues       []consistencyIssue `json:"issues"`
+    ByKind       map[string]int     `json:"by_kind"`
+
+    UnmatchedOrders []consistencyOrder `json:"unmatched_orders"`
+    UnmatchedTotal  int64              `json:"unmatched_total"`
+    Truncated       bool               `json:"truncated"`
+    Warnings        []string           `json:"warnings"`
+}
+
+func (r *consistencyReport) warn(format string, args ...any) {
+    r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (r *consistencyReport) exceeds(expected, actual float64) bool {
+    threshold := math.Max(r.Tolerance, math.Abs(expected)*r.TolerancePct/100)
+    return math.Abs(actual-expected) > threshold
+}
+
+// computeConsistency 按日期 + 国家核对订单、店铺每日数据和结算：
+// - 订单总额（排除每日总额汇总行，非人民币订单按实时汇率折算）合计 vs 结算销售额 SaleTotalCNY；
+// - 店铺每日数据的广告费合计 vs 结算广告费，两者都按本国货币比较；
+// - 有订单但当天该国家没有任何结算；
+// - SKU 未匹配到商品（ProductID = 0）的订单。
+// 结算不区分审批状态，草稿也参与核对。差异超过 max(tolerance, 订单或店铺数据金额 × tolerancePct%) 时记为不一致。
+func computeConsistency(db *gorm.DB, start, end string, tolerance, tolerancePct float64) (*consistencyReport, error) {
+    report := &consistencyReport{
+        Start: start, End: end, Tolerance: tolerance, TolerancePct: tolerancePct,
+        Rows: []consistencyRow{}, Issues: []consistencyIssue{}, ByKind: map[string]int{},
+        UnmatchedOrders: []consistencyOrder{}, Warnings: []string{},
+    }
+    rows := map[string]*consistencyRow{}
+    row := func(date, country string) *consistencyRow {
+        key := date + "|" + country
+        r, ok := rows[key]
+        if !ok {
+            r = &consistencyRow{Date: date, Country: country, Currency: countryCurrency[country], Issues: []string{}}
+            rows[key] = r
+        }
+        return r
+    }
+
+    // 订单：按日期、国家、币种汇总
+    var orderAggs []struct {
+        Date      string
+        Country   string
+        Currency  string
+        Cnt       int64
+        Amount    float64
+        Unmatched int64
+    }
+    if err := db.Model(&models.Order{}).
+        Select("DATE_FORMAT(created_at, '%Y-%m-%d') AS date, country, currency, COUNT(*) AS cnt, "+
+            "IFNULL(SUM(total_amount), 0) AS amount, "+
+            "IFNULL(SUM(CASE WHEN product_id = 0 AND quantity > 0 THEN 1 ELSE 0 END), 0) AS unmatched").
+        Where("DATE(created_at) >= ? AND DATE(created_at) <= ?", start, end).
+        Where("product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇").
+        Group("DATE_FORMAT(created_at, '%Y-%m-%d'), country, currency").
+        Scan(&orderAggs).Error; err != nil {
+        return nil, err
+    }
+    cv := newCNYConverter(nil)
+    for _, a := range orderAggs {
+        r := row(a.Date, a.Country)
+        r.OrderCount += a.Cnt
+        r.UnmatchedOrders += a.Unmatched
+        r.OrderSalesCNY += cv.convert(a.Amount, a.Currency)
+    }
+    report.Warnings = append(report.Warnings, cv.warnings()...)
+
+    // 店铺每日数据的广告费：按店铺所属国家汇总
+    var statAggs []struct {
+        Date    string
+        Country string
+        AdCost  float64
+    }
+    if err := db.Table("store_daily_stats AS sd").
+        Select("sd.date AS date, s.country AS country, IFNULL(SUM(sd.ad_cost), 0) AS ad_cost").
+        Joins("JOIN stores AS s ON s.id = sd.store_id").
+        Where("sd.date >= ? AND sd.date <= ?", start, end).
+        Group("sd.date, s.country").
+        Scan(&statAggs).Error; err != nil {
+        return nil, err
+    }
+    for _, a := range statAggs {
+        row(a.Date, a.Country).StatsAdCost += a.AdCost
+    }
+
+    // 结算：销售额按人民币，广告费换回本国货币
+    var settlements []models.DailySettlement
+    if err := db.Where("date >= ? AND date <= ?", start, end).Find(&settlements).Error; err != nil {
+        return nil, err
+    }
+    for _, s := range settlements {
+        r := row(s.Date, s.Country)
+        r.SettlementCount++
+        r.SettlementSales += s.SaleTotalCNY
+        switch {
+        case s.AdCurrency == "CNY" && s.Exchange > 0:
+            r.SettlementAdCost += s.AdCostCNY / s.Exchange
+        case s.AdCurrency == "CNY":
+            if s.AdCostCNY != 0 {
+                report.warn("%s %s 的结算（ID %d）广告费按人民币录入且没有汇率，未计入广告费核对", s.Date, s.Country, s.ID)
+            }
+        default:
+            r.SettlementAdCost += s.AdCost
+        }
+    }
+
+    for _, r := range rows {
+        r.OrderSalesCNY = roundMoney(r.OrderSalesCNY)
+        r.SettlementSales = roundMoney(r.SettlementSales)
+        r.StatsAdCost = roundMoney(r.StatsAdCost)
+        r.SettlementAdCost = roundMoney(r.SettlementAdCost)
+        r.SalesDiff = roundMoney(r.SettlementSales - r.OrderSalesCNY)
+        r.AdCostDiff = roundMoney(r.SettlementAdCost - r.StatsAdCost)
+        report.Rows = append(report.Rows, *r)
+    }
+    sort.Slice(report.Rows, func(i, j int) bool {
+        if report.Rows[i].Date != report.Rows[j].Date {
+            return report.Rows[i].Date < report.Rows[j].Date
+        }
+        return report.Rows[i].Country < report.Rows[j].Country
+    })
+
+    for i := range report.Rows {
+        r := &report.Rows[i]
+        add := func(kind string, expected, actual float64, msg string) {
+            r.Issues = append(r.Issues, kind)
+            report.ByKind[kind]++
+            report.Issues = append(report.Issues, consistencyIssue{
+                Date: r.Date, Country: r.Country, Kind: kind, Label: consistencyKindLabels[kind],
+                Expected: expected, Actual: actual, Diff: roundMoney(actual - expected), Message: msg,
+            })
+        }
+        if r.SettlementCount == 0 {
+            if r.OrderCount > 0 {
+                add(ConsistencyMissingSettlement, r.OrderSalesCNY, 0,
+                    fmt.Sprintf("有 %d 条订单（合计 ￥%.2f），但没有结算记录", r.OrderCount, r.OrderSalesCNY))
+            }
+        } else {
+            if report.exceeds(r.OrderSalesCNY, r.SettlementSales) {
+                add(ConsistencySalesMismatch, r.OrderSalesCNY, r.SettlementSales,
+                    fmt.Sprintf("订单合计 ￥%.2f，结算销售额 ￥%.2f", r.OrderSalesCNY, r.SettlementSales))
+            }
+            if report.exceeds(r.StatsAdCost, r.SettlementAdCost) {
+                add(ConsistencyAdCostMismatch, r.StatsAdCost, r.SettlementAdCost,
+                    fmt.Sprintf("店铺数据广告费 %.2f %s，结算广告费 %.2f %s", r.StatsAdCost, r.Currency, r.SettlementAdCost, r.Currency))
+            }
+        }
+        if r.UnmatchedOrders > 0 {
+            add(ConsistencyUnmatchedSKU, 0, 0, fmt.Sprintf("有 %d 条订单的 SKU 未匹配到商品", r.UnmatchedOrders))
+        }
+        report.UnmatchedTotal += r.UnmatchedOrders
+    }
+
+    if report.UnmatchedTotal > 0 {
+        var orders []models.Order
+        if err := db.Where("DATE(created_at) >= ? AND DATE(created_at) <= ?", start, end).
+            Where("product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇").
+            Where("product_id = 0 AND quantity > 0").
+            Order("created_at asc, id asc").Limit(consistencyListLimit).
+            Find(&orders).Error; err != nil {
+            return nil, err
+        }
+        for _, o := range orders {
+            report.UnmatchedOrders = append(report.UnmatchedOrders, consistencyOrder{
+                ID: o.ID, Date: o.CreatedAt.Format("2006-01-02"), Country: o.Country, Platform: o.Platform,
+                StoreID: o.StoreID, OrderNo: o.OrderNo, SKU: o.SKU, ProductName: o.ProductName,
+                Quantity: o.Quantity, TotalAmount: o.TotalAmount, Currency: o.Currency,
+            })
+        }
+        report.Truncated = report.UnmatchedTotal > int64(len(orders))
+    }
+    return report, nil
+}
+
+// consistencyWecomSummary 订单日报中的数据一致性检查摘要，最多列出 maxLines 条差异
+func consistencyWecomSummary(report *consistencyReport, maxLines int) string {
+    var b strings.Builder
+    if len(report.Issues) == 0 {
+        b.WriteString("\n数据一致性检查：**无差异**\n")
+        return b.String()
+    }
+    fmt.Fprintf(&b, "\n数据一致性检查：**发现 %d 项差异**\n", len(report.Issues))
+    for i, is := range report.Issues {
+        if i == maxLines {
+            fmt.Fprintf(&b, "> …… 其余 %d 项请在系统中查看\n", len(report.Issues)-maxLines)
+            break
+        }
+        fmt.Fprintf(&b, "> %s %s：%s\n", is.Country, is.Label, is.Message)
+    }
+    return b.String()
+}
+
+// ConsistencyCheck 数据一致性检查：按日期 + 国家核对订单销售额与结算销售额、店铺广告费与结算广告费，并列出 SKU 未匹配商品的订单
+// GET /api/consistency?start=&end=&tolerance=1&tolerance_pct=0
+// start / end 默认为昨天，最多 92 天。
+func ConsistencyCheck(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看数据一致性检查"})
+            return
+        }
+        yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+        start, end := c.DefaultQuery("start", yesterday), c.DefaultQuery("end", yesterday)
+        s, err1 := time.Parse("2006-01-02", start)
+        e, err2 := time.Parse("2006-01-02", end)
+        if err1 != nil || err2 != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "日期格式应为 YYYY-MM-DD"})
+            return
+        }
+        if e.Before(s) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "end 不能早于 start"})
+            return
+        }
+        if e.Sub(s) > 91*24*time.Hour {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "日期范围最多 92 天"})
+            return
+        }
+        tolerance, err := strconv.ParseFloat(c.DefaultQuery("tolerance", "1"), 64)
+        if err != nil || tolerance < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "tolerance 应为非负数"})
+            return
+        }
+        tolerancePct, err := strconv.ParseFloat(c.DefaultQuery("tolerance_pct", "0"), 64)
+        if err != nil || tolerancePct < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "tolerance_pct 应为非负数"})
+            return
+        }
+
+        report, err := computeConsistency(db, start, end, tolerance, tolerancePct)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, report)
+    }
+}
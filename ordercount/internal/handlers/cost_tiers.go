@@ -0,0 +1,329 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+)
+
+// BaseCostTier 基础成本档位，对应 products.cost：超级管理员视角的真实成本，
+// 也是订单货款成本核算使用的成本，所有档位最终都回退到它
+const BaseCostTier = "base"
+
+// CostTier 按角色区分的成本档位。超级管理员始终可以查看和修改所有档位（包括基础成本）。
+type CostTier struct {
+    Role     string   `yaml:"role" json:"role"`         // 档位名，与用户角色同名：该角色的用户看到的“成本”即这个档位
+    Label    string   `yaml:"label" json:"label"`       // 中文名称，用于导出表头和前端展示
+    Fallback string   `yaml:"fallback" json:"fallback"` // 未配置时回退到的档位，空表示直接回退到基础成本
+    ViewBy   []string `yaml:"view_by" json:"view_by"`   // 除本角色外，还可以查看该档位的角色
+    EditBy   []string `yaml:"edit_by" json:"edit_by"`   // 可以修改该档位的角色
+}
+
+// SetCostTiers 校验并替换成本档位配置（由 main 按 config.yaml 的 cost_tiers 注入）
+func SetCostTiers(tiers []CostTier) error {
+    byRole := make(map[string]CostTier, len(tiers))
+    for _, t := range tiers {
+        t.Role = strings.TrimSpace(t.Role)
+        if t.Role == "" || t.Role == BaseCostTier || t.Role == "superadmin" {
+            return fmt.Errorf("cost_tiers: 无效的档位名 %q", t.Role)
+        }
+        if _, dup := byRole[t.Role]; dup {
+            return fmt.Errorf("cost_tiers: 档位 %s 重复", t.Role)
+        }
+        byRole[t.Role] = t
+    }
+    for _, t := range tiers {
+        // 回退链必须最终到达基础成本，不能指向不存在的档位或形成循环
+        seen := map[string]bool{t.Role: true}
+        for next := t.Fallback; next != "" && next != BaseCostTier; next = byRole[next].Fallback {
+            if _, ok := byRole[next]; !ok {
+                return fmt.Errorf("cost_tiers: 档位 %s 的回退档位 %s 不存在", t.Role, next)
+            }
+            if seen[next] {
+                return fmt.Errorf("cost_tiers: 档位 %s 的回退链存在循环", t.Role)
+            }
+            seen[next] = true
+        }
+    }
+    CostTiers = tiers
+    return nil
+}
+
+func findCostTier(name string) (CostTier, bool) {
+    for _, t := range CostTiers {
+        if t.Role == name {
+            return t, true
+        }
+    }
+    return CostTier{}, false
+}
+
+func containsString(list []string, role string) bool {
+    for _, r := range list {
+        if r == role {
+            return true
+        }
+    }
+    return false
+}
+
+// ownCostTier 某个角色默认看到的成本档位：配置了同名档位的角色看该档位，其余（包括超级管理员）看基础成本
+func ownCostTier(role string) string {
+    if _, ok := findCostTier(role); ok {
+        return role
+    }
+    return BaseCostTier
+}
+
+// canViewCostTier 角色是否可以查看某个档位
+func canViewCostTier(role, tier string) bool {
+    if role == "superadmin" || ownCostTier(role) == tier {
+        return true
+    }
+    t, ok := findCostTier(tier)
+    return ok && containsString(t.ViewBy, role)
+}
+
+// canEditCostTier 角色是否可以修改某个档位，基础成本只有超级管理员可以修改
+func canEditCostTier(role, tier string) bool {
+    if role == "superadmin" {
+        return true
+    }
+    t, ok := findCostTier(tier)
+    return ok && containsString(t.EditBy, role)
+}
+
+// visibleCostTiers 角色可以查看的全部档位（基础成本在前，其余按配置顺序）
+func visibleCostTiers(role string) []string {
+    res := make([]string, 0, len(CostTiers)+1)
+    if canViewCostTier(role, BaseCostTier) {
+        res = append(res, BaseCostTier)
+    }
+    for _, t := range CostTiers {
+        if canViewCostTier(role, t.Role) {
+            res = append(res, t.Role)
+        }
+    }
+    return res
+}
+
+// costResolver 统一的成本档位解析：商品列表、保存、导入导出、组合商品汇总、定价和统计都通过它取成本
+type costResolver struct {
+    tiers map[uint]map[string]float64 // 商品 ID -> 档位 -> 已配置的成本
+}
+
+// loadCostResolver 预加载商品的档位成本，productIDs 为 nil 时加载全部
+func loadCostResolver(db *gorm.DB, productIDs []uint) (*costResolver, error) {
+    r := &costResolver{tiers: map[uint]map[string]float64{}}
+    q := db.Model(&models.ProductRoleCost{})
+    if productIDs != nil {
+        if len(productIDs) == 0 {
+            return r, nil
+        }
+        q = q.Where("product_id IN ?", productIDs)
+    }
+    var rows []models.ProductRoleCost
+    if err := q.Find(&rows).Error; err != nil {
+        return nil, err
+    }
+    for _, row := range rows {
+        if r.tiers[row.ProductID] == nil {
+            r.tiers[row.ProductID] = map[string]float64{}
+        }
+        r.tiers[row.ProductID][row.Role] = row.Cost
+    }
+    return r, nil
+}
+
+// Raw 返回某个档位单独配置的成本，未配置时 ok=false；基础成本总是已配置
+func (r *costResolver) Raw(p models.Product, tier string) (float64, bool) {
+    if tier == BaseCostTier {
+        return p.Cost, true
+    }
+    v, ok := r.tiers[p.ID][tier]
+    return v, ok
+}
+
+// Cost 按回退链解析某个档位的成本：未配置时依次回退，最终回退到基础成本
+func (r *costResolver) Cost(p models.Product, tier string) float64 {
+    seen := map[string]bool{}
+    for tier != "" && tier != BaseCostTier && !seen[tier] {
+        seen[tier] = true
+        if v, ok := r.tiers[p.ID][tier]; ok {
+            return v
+        }
+        t, _ := findCostTier(tier)
+        tier = t.Fallback
+    }
+    return p.Cost
+}
+
+// ForRole 当前角色看到的成本
+func (r *costResolver) ForRole(p models.Product, role string) float64 {
+    return r.Cost(p, ownCostTier(role))
+}
+
+// costEdits 一次保存中要修改的成本，key 为档位名；没有 key 表示不修改，
+// nil 值表示清除该档位的单独配置（回退），0 是有效的成本。基础成本不能清除。
+type costEdits map[string]*float64
+
+// parseCostEdits 从请求 JSON 中收集成本修改：
+// - cost 表示“当前角色自己的档位”；
+// - cost_<档位>（例如 cost_admin、cost_staff）或 role_costs: {档位: 成本} 指定具体档位，值为 null 表示清除该档位（回退）。
+// 当前角色无权修改的档位会被忽略，返回被忽略的档位名，由调用方提示给前端。
+func parseCostEdits(raw []byte, role string) (costEdits, []string, error) {
+    var fields map[string]json.RawMessage
+    if err := json.Unmarshal(raw, &fields); err != nil {
+        return nil, nil, err
+    }
+    edits := costEdits{}
+    var denied []string
+    set := func(tier string, data json.RawMessage) error {
+        if string(data) == "null" {
+            // 基础成本没有回退，null 视为不修改
+            if tier == BaseCostTier {
+                return nil
+            }
+            if !canEditCostTier(role, tier) {
+                denied = append(denied, tier)
+                return nil
+            }
+            edits[tier] = nil
+            return nil
+        }
+        var v float64
+        if err := json.Unmarshal(data, &v); err != nil {
+            return fmt.Errorf("成本格式错误：%s", tier)
+        }
+        if v < 0 {
+            return fmt.Errorf("成本不能为负数")
+        }
+        if !canEditCostTier(role, tier) {
+            denied = append(denied, tier)
+            return nil
+        }
+        edits[tier] = &v
+        return nil
+    }
+
+    for _, t := range CostTiers {
+        if data, ok := fields["cost_"+t.Role]; ok {
+            if err := set(t.Role, data); err != nil {
+                return nil, nil, err
+            }
+        }
+    }
+    if data, ok := fields["role_costs"]; ok && string(data) != "null" {
+        var m map[string]json.RawMessage
+        if err := json.Unmarshal(data, &m); err != nil {
+            return nil, nil, fmt.Errorf("role_costs 格式错误")
+        }
+        for tier, v := range m {
+            if _, ok := findCostTier(tier); !ok && tier != BaseCostTier {
+                return nil, nil, fmt.Errorf("未知的成本档位：%s", tier)
+            }
+            if err := set(tier, v); err != nil {
+                return nil, nil, err
+            }
+        }
+    }
+    // cost 字段最后处理：与具体档位同时出现时以 cost 为准（兼容旧版前端）
+    if data, ok := fields["cost"]; ok {
+        if err := set(ownCostTier(role), data); err != nil {
+            return nil, nil, err
+        }
+    }
+    return edits, denied, nil
+}
+
+// changes 判断修改是否会改变商品当前的成本：已单独配置的档位与配置值比较（清除视为修改），
+// 未配置的档位清除或与回退结果相同都视为未修改
+func (e costEdits) changes(r *costResolver, p models.Product) bool {
+    for tier, v := range e {
+        raw, ok := r.Raw(p, tier)
+        if v == nil {
+            if ok && tier != BaseCostTier {
+                return true
+            }
+            continue
+        }
+        if ok {
+            if raw != *v {
+                return true
+            }
+            continue
+        }
+        if *v != r.Cost(p, tier) {
+            return true
+        }
+    }
+    return false
+}
+
+// saveRoleCosts 保存基础成本以外的档位（基础成本由调用方写到商品上），值为 nil 时删除该档位让其回退
+func saveRoleCosts(tx *gorm.DB, productID uint, edits costEdits) error {
+    for tier, v := range edits {
+        if tier == BaseCostTier {
+            continue
+        }
+        if v == nil {
+            if err := tx.Where("product_id = ? AND role = ?", productID, tier).Delete(&models.ProductRoleCost{}).Error; err != nil {
+                return err
+            }
+            continue
+        }
+        var rc models.ProductRoleCost
+        err := tx.Where("product_id = ? AND role = ?", productID, tier).First(&rc).Error
+        if err != nil && err != gorm.ErrRecordNotFound {
+            return err
+        }
+        rc.ProductID = productID
+        rc.Role = tier
+        rc.Cost = *v
+        if err := tx.Save(&rc).Error; err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// ListCostTiers 返回成本档位配置以及当前角色对每个档位的查看 / 修改权限，供前端生成表单
+func ListCostTiers() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        roleStr, _ := roleVal.(string)
+        type item struct {
+            Tier     string `json:"tier"`
+            Label    string `json:"label"`
+            Fallback string `json:"fallback"`
+            CanView  bool   `json:"can_view"`
+            CanEdit  bool   `json:"can_edit"`
+        }
+        items := []item{{
+            Tier:    BaseCostTier,
+            Label:   "基础成本",
+            CanView: canViewCostTier(roleStr, BaseCostTier),
+            CanEdit: canEditCostTier(roleStr, BaseCostTier),
+        }}
+        for _, t := range CostTiers {
+            fallback := t.Fallback
+            if fallback == "" {
+                fallback = BaseCostTier
+            }
+            items = append(items, item{
+                Tier:     t.Role,
+                Label:    t.Label,
+                Fallback: fallback,
+                CanView:  canViewCostTier(roleStr, t.Role),
+                CanEdit:  canEditCostTier(roleStr, t.Role),
+            })
+        }
+        c.JSON(http.StatusOK, gin.H{"own": ownCostTier(roleStr), "items": items})
+    }
+}
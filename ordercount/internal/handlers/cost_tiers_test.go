@@ -0,0 +1,67 @@
+package handlers
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestParseCostEdits(t *testing.T) {
+    num := func(v float64) *float64 { return &v }
+    cases := []struct {
+        name       string
+        raw        string
+        role       string
+        want       costEdits
+        wantDenied []string
+        wantErr    bool
+    }{
+        {
+            name: "超级管理员设置 0 和清除档位",
+            raw:  `{"cost": 10, "cost_admin": 0, "cost_staff": null}`,
+            role: "superadmin",
+            want: costEdits{BaseCostTier: num(10), "admin": num(0), "staff": nil},
+        },
+        {
+            name: "基础成本传 null 视为不修改",
+            raw:  `{"cost": null, "role_costs": {"admin": 3}}`,
+            role: "superadmin",
+            want: costEdits{"admin": num(3)},
+        },
+        {
+            name:       "管理员无权修改的档位被列出",
+            raw:        `{"cost": 8, "cost_staff": 5, "role_costs": {"base": 1}}`,
+            role:       "admin",
+            want:       costEdits{"admin": num(8)},
+            wantDenied: []string{"staff", BaseCostTier},
+        },
+        {
+            name:       "管理员清除无权修改的档位",
+            raw:        `{"cost_staff": null}`,
+            role:       "admin",
+            want:       costEdits{},
+            wantDenied: []string{"staff"},
+        },
+        {name: "负数", raw: `{"cost": -1}`, role: "superadmin", wantErr: true},
+        {name: "未知档位", raw: `{"role_costs": {"boss": 1}}`, role: "superadmin", wantErr: true},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            edits, denied, err := parseCostEdits([]byte(tc.raw), tc.role)
+            if tc.wantErr {
+                if err == nil {
+                    t.Fatalf("期望报错，实际得到 %v", edits)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatal(err)
+            }
+            if !reflect.DeepEqual(edits, tc.want) {
+                t.Errorf("修改 = %v，期望 %v", edits, tc.want)
+            }
+            if !reflect.DeepEqual(denied, tc.wantDenied) {
+                t.Errorf("忽略的档位 = %v，期望 %v", denied, tc.wantDenied)
+            }
+        })
+    }
+}
@@ -0,0 +1,809 @@
+package handlers
+
+import (
+    "errors"
+    "fmt"
+    "log"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+
+    "ordercount/internal/models"
+)
+
+// 成本核算方式
+const (
+    CostingFIFO     = "fifo"     // 先进先出：按入库批次顺序消耗
+    CostingWAvg     = "wavg"     // 移动加权平均：每次入库后重新计算平均单价
+    CostingStandard = "standard" // 标准成本：直接使用 Product.Cost（未配置核算方式或没有入库数据时）
+)
+
+// orderProductJoin 订单关联商品的统一 JOIN 写法，所有按商品统计成本/销量的查询都应使用它。
+// orders.product_id 已经按 SKU 和平台 SKU 别名解析好（见 sku_alias.go），这里不再直接比较 SKU 字符串。
+const orderProductJoin = "LEFT JOIN products AS p ON p.id = o.product_id"
+
+// orderGoodsCostExpr 订单货款成本的统计口径：已核算的订单直接使用 goods_cost，
+// 历史未核算的订单回退到 数量 × 商品基础成本。需配合 orders AS o + orderProductJoin 使用。
+const orderGoodsCostExpr = "CASE WHEN o.cost_method <> '' THEN o.goods_cost ELSE o.quantity * IFNULL(p.cost, 0) END"
+
+func roundMoney(v float64) float64 {
+    return math.Round(v*100) / 100
+}
+
+// findProductBySKU 按 SKU 查找商品，找不到时返回 nil, nil。
+// 商品 SKU 优先；没有时再查 SKU 别名，别名只对应一个商品时才算匹配。
+func findProductBySKU(db *gorm.DB, sku string) (*models.Product, error) {
+    sku = strings.TrimSpace(sku)
+    if sku == "" {
+        return nil, nil
+    }
+    var p models.Product
+    err := db.Where("sku = ?", sku).Order("id asc").First(&p).Error
+    if err == nil {
+        return &p, nil
+    }
+    if err != gorm.ErrRecordNotFound {
+        return nil, err
+    }
+
+    var ids []uint
+    if err := db.Model(&models.SKUAlias{}).Where("external_sku = ?", sku).Distinct().Pluck("product_id", &ids).Error; err != nil {
+        return nil, err
+    }
+    if len(ids) != 1 {
+        return nil, nil
+    }
+    if err := db.First(&p, ids[0]).Error; err != nil {
+        if err == gorm.ErrRecordNotFound {
+            return nil, nil
+        }
+        return nil, err
+    }
+    return &p, nil
+}
+
+// RecostProduct 重新核算某个商品下所有订单（包括通过 SKU 别名匹配到的订单）的货款成本。
+// 核算按时间顺序回放“入库批次 + 订单”，因此订单补录、改日期、删除或新增入库后直接调用即可，
+// 不需要做增量冲销。订单量在单个商品维度上不大，全量回放的开销可以接受。
+// 组合商品本身没有库存，核算时展开到各组件，由组件的入库批次出库。
+func RecostProduct(db *gorm.DB, productID uint) error {
+    if productID == 0 {
+        return nil
+    }
+    var p models.Product
+    if err := db.First(&p, productID).Error; err != nil {
+        if err == gorm.ErrRecordNotFound {
+            // 商品已删除：其订单会在重新匹配 SKU 时清空核算结果
+            return nil
+        }
+        return err
+    }
+    g, err := loadBundleGraph(db)
+    if err != nil {
+        return err
+    }
+    if !g.isBundle(p.ID) {
+        return replayProductCost(db, g, p)
+    }
+
+    leaves := g.leaves(p.ID)
+    leafIDs := make([]uint, 0, len(leaves))
+    lockIDs := []uint{p.ID}
+    for id := range leaves {
+        leafIDs = append(leafIDs, id)
+        lockIDs = append(lockIDs, id)
+        for bundleID := range g.bundleFactors(id) {
+            lockIDs = append(lockIDs, bundleID)
+        }
+    }
+    return db.Transaction(func(tx *gorm.DB) error {
+        // 一次锁住组合、组件以及包含这些组件的其他组合，避免与组件各自的核算交错
+        if err := lockProductsForCosting(tx, lockIDs); err != nil {
+            return err
+        }
+        closed, err := closedPeriods(tx)
+        if err != nil {
+            return err
+        }
+        // 已关账月份的组合订单保留关账时的组件成本
+        var orderIDs []uint
+        q := tx.Model(&models.Order{}).
+            Where("product_id = ? AND quantity > 0 AND product_name NOT IN (?, ?)", p.ID, "今日总额汇总", "今日总汇")
+        if err := excludeClosedOrders(q, closed).Pluck("id", &orderIDs).Error; err != nil {
+            return err
+        }
+        // 组成变化后，已不再属于该组合的组件成本需要清掉
+        if len(orderIDs) > 0 {
+            if err := tx.Where("order_id IN ? AND component_id NOT IN ?", orderIDs, leafIDs).
+                Delete(&models.OrderComponentCost{}).Error; err != nil {
+                return err
+            }
+        }
+        for _, id := range leafIDs {
+            var leaf models.Product
+            if err := tx.First(&leaf, id).Error; err != nil {
+                if err == gorm.ErrRecordNotFound {
+                    continue
+                }
+                return err
+            }
+            if err := replayProductCost(tx, g, leaf); err != nil {
+                return err
+            }
+        }
+        return updateBundleOrderTotals(tx, orderIDs)
+    })
+}
+
+// lockProductsForCosting 在核算事务开始时按 ID 顺序锁住涉及的商品行（SELECT ... FOR UPDATE），
+// 同一商品的核算因此串行执行：并发保存时后一次核算会等前一次提交后再读取订单和批次，不会交错覆盖。
+func lockProductsForCosting(tx *gorm.DB, ids []uint) error {
+    if len(ids) == 0 {
+        return nil
+    }
+    var locked []models.Product
+    return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+        Select("id").Where("id IN ?", ids).Order("id asc").
+        Find(&locked).Error
+}
+
+// costDemand 某个商品在一笔订单上的出库需求：普通订单为订单数量，组合商品订单为 套数 × 每套用量
+type costDemand struct {
+    order     *models.Order
+    quantity  int
+    unitCost  float64
+    goodsCost float64
+    method    string
+    frozen    bool // 订单在已关账月份：参与回放但不回写核算结果
+}
+
+// liveDemands 去掉已关账月份的订单需求
+func liveDemands(demands []costDemand) []costDemand {
+    live := make([]costDemand, 0, len(demands))
+    for _, d := range demands {
+        if !d.frozen {
+            live = append(live, d)
+        }
+    }
+    return live
+}
+
+// replayProductCost 回放单个（非组合）商品的入库批次和出库需求，需求包括该商品自己的订单
+// 以及所有包含它的组合商品订单。普通订单直接写核算结果，组合订单写入组件成本后再汇总。
+// 已关账月份的订单不回写：FIFO 保留它们已占用的批次数量，只在剩余库存上回放之后的订单。
+func replayProductCost(db *gorm.DB, g *bundleGraph, p models.Product) error {
+    factors := g.bundleFactors(p.ID)
+    productIDs := []uint{p.ID}
+    for id := range factors {
+        productIDs = append(productIDs, id)
+    }
+
+    return db.Transaction(func(tx *gorm.DB) error {
+        if err := lockProductsForCosting(tx, productIDs); err != nil {
+            return err
+        }
+        // 拿到锁后重新读取商品，核算方式和基础成本以最新提交的为准
+        if err := tx.First(&p, p.ID).Error; err != nil {
+            return err
+        }
+        var orders []models.Order
+        if err := tx.Where("product_id IN ? AND quantity > 0 AND product_name NOT IN (?, ?)", productIDs, "今日总额汇总", "今日总汇").
+            Order("created_at asc, id asc").
+            Find(&orders).Error; err != nil {
+            return err
+        }
+        closed, err := closedPeriods(tx)
+        if err != nil {
+            return err
+        }
+        closedSet := make(map[string]bool, len(closed))
+        for _, period := range closed {
+            closedSet[period] = true
+        }
+        demands := make([]costDemand, 0, len(orders))
+        var frozenIDs []uint
+        for i := range orders {
+            o := &orders[i]
+            qty := o.Quantity
+            if o.ProductID != p.ID {
+                qty *= factors[o.ProductID]
+            }
+            if qty <= 0 {
+                continue
+            }
+            frozen := closedSet[o.CreatedAt.Format("2006-01")]
+            if frozen {
+                frozenIDs = append(frozenIDs, o.ID)
+            }
+            demands = append(demands, costDemand{order: o, quantity: qty, frozen: frozen})
+        }
+
+        var lots []models.PurchaseLot
+        if err := tx.Where("product_id = ?", p.ID).
+            Order("received_at asc, id asc").
+            Find(&lots).Error; err != nil {
+            return err
+        }
+
+        // 已关账订单占用的批次数量
+        lotIDs := make([]uint, 0, len(lots))
+        for _, l := range lots {
+            lotIDs = append(lotIDs, l.ID)
+        }
+        frozenTaken := map[uint]int{}
+        if len(lotIDs) > 0 && len(frozenIDs) > 0 {
+            var taken []struct {
+                LotID    uint
+                Quantity int
+            }
+            if err := tx.Model(&models.OrderCostAllocation{}).
+                Select("lot_id, SUM(quantity) AS quantity").
+                Where("lot_id IN ? AND order_id IN ?", lotIDs, frozenIDs).
+                Group("lot_id").
+                Scan(&taken).Error; err != nil {
+                return err
+            }
+            for _, t := range taken {
+                frozenTaken[t.LotID] = t.Quantity
+            }
+        }
+
+        // 清理未关账订单旧的批次分配记录，下面会按最新数据重新生成
+        directIDs := make([]uint, 0, len(orders))
+        for _, d := range demands {
+            if d.order.ProductID == p.ID && !d.frozen {
+                directIDs = append(directIDs, d.order.ID)
+            }
+        }
+        if len(directIDs) > 0 {
+            if err := tx.Where("order_id IN ?", directIDs).Delete(&models.OrderCostAllocation{}).Error; err != nil {
+                return err
+            }
+        }
+        if len(lotIDs) > 0 {
+            dq := tx.Where("lot_id IN ?", lotIDs)
+            if len(frozenIDs) > 0 {
+                dq = dq.Where("order_id NOT IN ?", frozenIDs)
+            }
+            if err := dq.Delete(&models.OrderCostAllocation{}).Error; err != nil {
+                return err
+            }
+        }
+
+        method := p.CostingMethod
+        if len(lots) == 0 || (method != CostingFIFO && method != CostingWAvg) {
+            method = CostingStandard
+        }
+
+        var allocations []models.OrderCostAllocation
+        switch method {
+        case CostingFIFO:
+            for i := range lots {
+                lots[i].Remaining = lots[i].Quantity - frozenTaken[lots[i].ID]
+                if lots[i].Remaining < 0 {
+                    lots[i].Remaining = 0
+                }
+            }
+            demands = liveDemands(demands)
+            allocations = allocateFIFO(lots, demands, p.Cost)
+        case CostingWAvg:
+            // 平均成本取决于之前全部出库，已关账订单也要参与回放
+            allocateWAvg(lots, demands, p.Cost)
+            demands = liveDemands(demands)
+        default:
+            demands = liveDemands(demands)
+            allocateStandard(demands, p.Cost)
+        }
+
+        // 未关账的组合订单中该组件的成本全量重写
+        cq := tx.Where("component_id = ?", p.ID)
+        if len(frozenIDs) > 0 {
+            cq = cq.Where("order_id NOT IN ?", frozenIDs)
+        }
+        if err := cq.Delete(&models.OrderComponentCost{}).Error; err != nil {
+            return err
+        }
+        var componentCosts []models.OrderComponentCost
+        var bundleOrderIDs []uint
+        for _, d := range demands {
+            if d.order.ProductID != p.ID {
+                componentCosts = append(componentCosts, models.OrderComponentCost{
+                    OrderID:     d.order.ID,
+                    ComponentID: p.ID,
+                    Quantity:    d.quantity,
+                    GoodsCost:   d.goodsCost,
+                    CostMethod:  d.method,
+                })
+                bundleOrderIDs = append(bundleOrderIDs, d.order.ID)
+                continue
+            }
+            if err := tx.Model(&models.Order{}).Where("id = ?", d.order.ID).
+                UpdateColumns(map[string]any{
+                    "unit_cost":   d.unitCost,
+                    "goods_cost":  d.goodsCost,
+                    "cost_method": d.method,
+                }).Error; err != nil {
+                return err
+            }
+        }
+        if len(componentCosts) > 0 {
+            if err := tx.Create(&componentCosts).Error; err != nil {
+                return err
+            }
+        }
+        if err := updateBundleOrderTotals(tx, bundleOrderIDs); err != nil {
+            return err
+        }
+        for _, l := range lots {
+            if err := tx.Model(&models.PurchaseLot{}).Where("id = ?", l.ID).
+                UpdateColumn("remaining", l.Remaining).Error; err != nil {
+                return err
+            }
+        }
+        if len(allocations) > 0 {
+            if err := tx.Create(&allocations).Error; err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// allocateFIFO 先进先出：demands 按下单时间排序，依次消耗 lots（按入库时间排序）的 Remaining，
+// 只能消耗下单时间之前已入库的批次，库存不足的部分按基础成本 baseCost 计价。
+// 核算结果写回 demands，lots 的 Remaining 原地扣减，返回各订单消耗的批次明细。
+func allocateFIFO(lots []models.PurchaseLot, demands []costDemand, baseCost float64) []models.OrderCostAllocation {
+    var allocations []models.OrderCostAllocation
+    for i := range demands {
+        d := &demands[i]
+        need := d.quantity
+        var total float64
+        for j := range lots {
+            if need == 0 {
+                break
+            }
+            l := &lots[j]
+            // 只能消耗订单时间之前已入库的批次
+            if l.ReceivedAt.After(d.order.CreatedAt) {
+                break
+            }
+            if l.Remaining <= 0 {
+                continue
+            }
+            take := need
+            if l.Remaining < take {
+                take = l.Remaining
+            }
+            l.Remaining -= take
+            need -= take
+            total += float64(take) * l.UnitCost
+            allocations = append(allocations, models.OrderCostAllocation{
+                OrderID:  d.order.ID,
+                LotID:    l.ID,
+                Quantity: take,
+                UnitCost: l.UnitCost,
+            })
+        }
+        // 库存不足的部分按基础成本计价
+        total += float64(need) * baseCost
+        d.goodsCost = roundMoney(total)
+        d.unitCost = total / float64(d.quantity)
+        d.method = CostingFIFO
+    }
+    return allocations
+}
+
+// allocateWAvg 移动加权平均：每笔订单按下单时间之前入库批次的加权平均单价计价，
+// 库存为负（超卖）时新入库按 0 库存重新起算平均；还没有任何入库时按基础成本 baseCost 计价。
+func allocateWAvg(lots []models.PurchaseLot, demands []costDemand, baseCost float64) {
+    var onHand int
+    var avg float64
+    li := 0
+    for i := range demands {
+        d := &demands[i]
+        // 先把订单时间之前的入库批次计入平均成本
+        for li < len(lots) && !lots[li].ReceivedAt.After(d.order.CreatedAt) {
+            l := lots[li]
+            base := onHand
+            if base < 0 {
+                base = 0
+            }
+            if base+l.Quantity > 0 {
+                avg = (float64(base)*avg + float64(l.Quantity)*l.UnitCost) / float64(base+l.Quantity)
+            }
+            onHand = base + l.Quantity
+            li++
+        }
+        unit := avg
+        if li == 0 {
+            // 还没有任何入库记录时按基础成本计价
+            unit = baseCost
+        }
+        onHand -= d.quantity
+        d.unitCost = unit
+        d.goodsCost = roundMoney(unit * float64(d.quantity))
+        d.method = CostingWAvg
+    }
+}
+
+// allocateStandard 标准成本：直接按基础成本 baseCost 计价
+func allocateStandard(demands []costDemand, baseCost float64) {
+    for i := range demands {
+        d := &demands[i]
+        d.unitCost = baseCost
+        d.goodsCost = roundMoney(baseCost * float64(d.quantity))
+        d.method = CostingStandard
+    }
+}
+
+// costWarnings 保存成功但重新核算失败时返回给前端的提示，失败的商品可以稍后通过“重新核算”补算
+func costWarnings(err error) []string {
+    if err == nil {
+        return nil
+    }
+    return []string{"已保存，但成本核算失败，订单货款成本可能未更新，请稍后重新核算：" + err.Error()}
+}
+
+// orderWithWarnings 订单接口的返回：订单字段不变，核算失败时附带 warnings
+func orderWithWarnings(o models.Order, costErr error) any {
+    return struct {
+        models.Order
+        Warnings []string `json:"warnings,omitempty"`
+    }{o, costWarnings(costErr)}
+}
+
+// recostSKUs 订单 SKU 有变化时调用：重新匹配这些 SKU 的订单对应的商品，并重新核算受影响的商品。
+// 调用时主数据已经保存，返回的错误由调用方通过 costWarnings 提示给前端
+func recostSKUs(db *gorm.DB, skus ...string) error {
+    return refreshOrderCosts(db, skus)
+}
+
+// refreshOrderCosts 先按 skus 重新匹配订单商品，再重新核算匹配前后涉及的商品以及额外指定的 productIDs
+func refreshOrderCosts(db *gorm.DB, skus []string, productIDs ...uint) error {
+    affected, err := resolveOrderProductsBySKU(db, skus...)
+    if err != nil {
+        log.Printf("[costing] 重新匹配订单商品失败：%v", err)
+        err = fmt.Errorf("重新匹配订单商品失败：%w", err)
+    }
+    return errors.Join(err, recostProducts(db, append(affected, productIDs...)...))
+}
+
+// recostProducts 依次重新核算多个商品，单个商品失败不影响其余商品，返回所有失败原因
+func recostProducts(db *gorm.DB, ids ...uint) error {
+    seen := make(map[uint]bool, len(ids))
+    var errs []error
+    for _, id := range ids {
+        if id == 0 || seen[id] {
+            continue
+        }
+        seen[id] = true
+        if err := RecostProduct(db, id); err != nil {
+            log.Printf("[costing] 重新核算商品 %d 失败：%v", id, err)
+            errs = append(errs, fmt.Errorf("商品 %d：%w", id, err))
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// parseLotTime 解析入库时间，支持 YYYY-MM-DD 和 YYYY-MM-DD HH:MM:SS，空值视为当前时间
+func parseLotTime(s string) (time.Time, error) {
+    s = strings.TrimSpace(s)
+    if s == "" {
+        return time.Now(), nil
+    }
+    if t, err := time.ParseInLocation("2006-01-02 15:04:05", s, time.Local); err == nil {
+        return t, nil
+    }
+    return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+// ListPurchaseLots 列出入库批次，可按 sku / product_id 过滤（仅超级管理员）
+func ListPurchaseLots(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, ok := c.Get("role"); !ok || roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以查看入库批次"})
+            return
+        }
+
+        q := db.Model(&models.PurchaseLot{})
+        if sku := strings.TrimSpace(c.Query("sku")); sku != "" {
+            q = q.Where("sku = ?", sku)
+        }
+        if pidStr := c.Query("product_id"); pidStr != "" {
+            if pid, err := strconv.Atoi(pidStr); err == nil && pid > 0 {
+                q = q.Where("product_id = ?", pid)
+            }
+        }
+
+        var list []models.PurchaseLot
+        if err := q.Order("received_at desc, id desc").Find(&list).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"items": list})
+    }
+}
+
+// SavePurchaseLot 新增或修改入库批次，保存后自动重新核算该 SKU 的订单成本（仅超级管理员）
+func SavePurchaseLot(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, ok := c.Get("role"); !ok || roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以维护入库批次"})
+            return
+        }
+
+        var body struct {
+            ID         uint    `json:"id"`
+            ProductID  uint    `json:"product_id"`
+            SKU        string  `json:"sku"`
+            Quantity   int     `json:"quantity"`
+            UnitCost   float64 `json:"unit_cost"`
+            ReceivedAt string  `json:"received_at"`
+            Supplier   string  `json:"supplier"`
+            Remark     string  `json:"remark"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误"})
+            return
+        }
+        if body.Quantity <= 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "入库数量必须大于 0"})
+            return
+        }
+        if body.UnitCost < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "入库单价不能为负数"})
+            return
+        }
+        receivedAt, err := parseLotTime(body.ReceivedAt)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid received_at, want YYYY-MM-DD"})
+            return
+        }
+
+        // 商品可以通过 product_id 或 sku 指定
+        var p models.Product
+        if body.ProductID != 0 {
+            if err := db.First(&p, body.ProductID).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "商品不存在"})
+                return
+            }
+        } else {
+            found, err := findProductBySKU(db, body.SKU)
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            if found == nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "商品不存在"})
+                return
+            }
+            p = *found
+        }
+
+        // 组合商品没有自己的库存，入库应记在组件上
+        var components int64
+        if err := db.Model(&models.ProductComponent{}).Where("product_id = ?", p.ID).Count(&components).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if components > 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "组合商品不能直接入库，请对组件商品入库"})
+            return
+        }
+        // 停售商品的成本已冻结，不再入库
+        if productStatus(p) == ProductDiscontinued {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "停售商品不能入库，如需入库请先恢复在售"})
+            return
+        }
+
+        var lot models.PurchaseLot
+        var oldProductID uint
+        if body.ID != 0 {
+            if err := db.First(&lot, body.ID).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "入库批次不存在"})
+                return
+            }
+            oldProductID = lot.ProductID
+        }
+        // 已关账月份的入库会改变该月订单的货款成本，新旧入库时间都不能在已关账月份
+        if err := ensurePeriodOpenAt(db, lot.ReceivedAt, receivedAt); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        lot.ProductID = p.ID
+        lot.SKU = p.SKU
+        lot.Quantity = body.Quantity
+        lot.Remaining = body.Quantity
+        lot.UnitCost = body.UnitCost
+        lot.ReceivedAt = receivedAt
+        lot.Supplier = body.Supplier
+        lot.Remark = body.Remark
+
+        if err := db.Save(&lot).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        costErr := recostProducts(db, oldProductID, lot.ProductID)
+        db.First(&lot, lot.ID)
+        c.JSON(http.StatusOK, struct {
+            models.PurchaseLot
+            Warnings []string `json:"warnings,omitempty"`
+        }{lot, costWarnings(costErr)})
+    }
+}
+
+// DeletePurchaseLot 删除入库批次并重新核算（仅超级管理员）
+func DeletePurchaseLot(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, ok := c.Get("role"); !ok || roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以删除入库批次"})
+            return
+        }
+        id := c.Param("id")
+        var lot models.PurchaseLot
+        if err := db.First(&lot, id).Error; err != nil {
+            if err == gorm.ErrRecordNotFound {
+                c.JSON(http.StatusNotFound, gin.H{"error": "入库批次不存在"})
+            } else {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            }
+            return
+        }
+        var p models.Product
+        if err := db.Select("id, status").First(&p, lot.ProductID).Error; err == nil && productStatus(p) == ProductDiscontinued {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "停售商品的成本已冻结，不能删除入库批次"})
+            return
+        }
+        if err := ensurePeriodOpenAt(db, lot.ReceivedAt); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        // 已关账月份订单的批次分配随月结冻结，被这些订单消耗过的批次不能删除
+        closed, err := closedPeriods(db)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if len(closed) > 0 {
+            var used int64
+            if err := db.Model(&models.OrderCostAllocation{}).
+                Joins("JOIN orders ON orders.id = order_cost_allocations.order_id").
+                Where("order_cost_allocations.lot_id = ? AND DATE_FORMAT(orders.created_at, '%Y-%m') IN ?", lot.ID, closed).
+                Count(&used).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            if used > 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "该批次已被已月结关账月份的订单消耗，不能删除，如需调整请超级管理员重新打开账期"})
+                return
+            }
+        }
+        if err := db.Delete(&lot).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if err := db.Where("lot_id = ?", lot.ID).Delete(&models.OrderCostAllocation{}).Error; err != nil {
+            log.Printf("[costing] 清理批次 %d 的分配记录失败：%v", lot.ID, err)
+        }
+        costErr := recostProducts(db, lot.ProductID)
+        c.JSON(http.StatusOK, gin.H{"ok": true, "warnings": costWarnings(costErr)})
+    }
+}
+
+// RecalculateCosts 手动触发重新核算：body 中传 sku 则只核算该 SKU（订单 SKU 或商品 SKU），
+// 否则先重新匹配全部订单的商品，再核算所有商品（仅超级管理员）
+func RecalculateCosts(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, ok := c.Get("role"); !ok || roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以重新核算成本"})
+            return
+        }
+
+        var body struct {
+            SKU string `json:"sku"`
+        }
+        // body 可为空
+        _ = c.ShouldBindJSON(&body)
+
+        var ids []uint
+        if s := strings.TrimSpace(body.SKU); s != "" {
+            affected, err := resolveOrderProductsBySKU(db, s)
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            ids = affected
+            if p, err := findProductBySKU(db, s); err == nil && p != nil {
+                ids = append(ids, p.ID)
+            }
+        } else {
+            if _, err := resolveOrderProducts(db, nil); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            if err := db.Model(&models.Order{}).
+                Where("product_id > 0 AND quantity > 0").
+                Distinct().
+                Pluck("product_id", &ids).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+        }
+        sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+        seen := make(map[uint]bool, len(ids))
+        var failed []string
+        for _, id := range ids {
+            if seen[id] {
+                continue
+            }
+            seen[id] = true
+            if err := RecostProduct(db, id); err != nil {
+                failed = append(failed, fmt.Sprintf("商品 %d：%v", id, err))
+            }
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+            "ok":     len(failed) == 0,
+            "count":  len(seen),
+            "failed": failed,
+        })
+    }
+}
+
+// OrderCostDetail 查看单笔订单的成本核算明细（消耗的入库批次），仅超级管理员
+func OrderCostDetail(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, ok := c.Get("role"); !ok || roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以查看成本明细"})
+            return
+        }
+        id, err := strconv.Atoi(c.Param("id"))
+        if err != nil || id <= 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
+            return
+        }
+
+        var o models.Order
+        if err := db.First(&o, id).Error; err != nil {
+            if err == gorm.ErrRecordNotFound {
+                c.JSON(http.StatusNotFound, gin.H{"error": "order not found"})
+                return
+            }
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        var allocations []models.OrderCostAllocation
+        if err := db.Where("order_id = ?", o.ID).Order("id asc").Find(&allocations).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        // 组合商品订单按组件拆分的成本
+        var components []models.OrderComponentCost
+        if err := db.Where("order_id = ?", o.ID).Order("id asc").Find(&components).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+            "components":  components,
+            "order_id":    o.ID,
+            "sku":         o.SKU,
+            "quantity":    o.Quantity,
+            "unit_cost":   o.UnitCost,
+            "goods_cost":  o.GoodsCost,
+            "cost_method": o.CostMethod,
+            "allocations": allocations,
+        })
+    }
+}
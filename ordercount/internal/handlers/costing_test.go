@@ -0,0 +1,161 @@
+package handlers
+
+import (
+    "math"
+    "testing"
+    "time"
+
+    "ordercount/internal/models"
+)
+
+func testDay(d int) time.Time {
+    return time.Date(2024, 1, d, 12, 0, 0, 0, time.Local)
+}
+
+func testLot(id uint, day, qty int, unitCost float64) models.PurchaseLot {
+    l := models.PurchaseLot{Quantity: qty, Remaining: qty, UnitCost: unitCost, ReceivedAt: testDay(day)}
+    l.ID = id
+    return l
+}
+
+func testDemands(orders ...[2]int) []costDemand {
+    demands := make([]costDemand, 0, len(orders))
+    for i, o := range orders {
+        order := &models.Order{CreatedAt: testDay(o[0]), Quantity: o[1]}
+        order.ID = uint(i + 1)
+        demands = append(demands, costDemand{order: order, quantity: o[1]})
+    }
+    return demands
+}
+
+func TestAllocateFIFO(t *testing.T) {
+    cases := []struct {
+        name      string
+        lots      []models.PurchaseLot
+        orders    [][2]int // {下单日, 数量}
+        baseCost  float64
+        wantCosts []float64
+        wantAlloc []models.OrderCostAllocation
+        wantLeft  []int
+    }{
+        {
+            name:      "先进先出跨批次",
+            lots:      []models.PurchaseLot{testLot(1, 1, 3, 10), testLot(2, 2, 5, 12)},
+            orders:    [][2]int{{3, 2}, {4, 3}},
+            wantCosts: []float64{20, 34},
+            wantAlloc: []models.OrderCostAllocation{
+                {OrderID: 1, LotID: 1, Quantity: 2, UnitCost: 10},
+                {OrderID: 2, LotID: 1, Quantity: 1, UnitCost: 10},
+                {OrderID: 2, LotID: 2, Quantity: 2, UnitCost: 12},
+            },
+            wantLeft: []int{0, 3},
+        },
+        {
+            name:      "超卖部分按基础成本",
+            lots:      []models.PurchaseLot{testLot(1, 1, 2, 10)},
+            orders:    [][2]int{{3, 5}},
+            baseCost:  8,
+            wantCosts: []float64{44},
+            wantAlloc: []models.OrderCostAllocation{
+                {OrderID: 1, LotID: 1, Quantity: 2, UnitCost: 10},
+            },
+            wantLeft: []int{0},
+        },
+        {
+            name:      "不消耗下单之后入库的批次",
+            lots:      []models.PurchaseLot{testLot(1, 1, 1, 10), testLot(2, 5, 10, 20)},
+            orders:    [][2]int{{3, 2}, {6, 2}},
+            baseCost:  7,
+            wantCosts: []float64{17, 40},
+            wantAlloc: []models.OrderCostAllocation{
+                {OrderID: 1, LotID: 1, Quantity: 1, UnitCost: 10},
+                {OrderID: 2, LotID: 2, Quantity: 2, UnitCost: 20},
+            },
+            wantLeft: []int{0, 8},
+        },
+        {
+            name:      "没有入库批次",
+            orders:    [][2]int{{3, 4}},
+            baseCost:  2.5,
+            wantCosts: []float64{10},
+        },
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            demands := testDemands(tc.orders...)
+            alloc := allocateFIFO(tc.lots, demands, tc.baseCost)
+            for i, d := range demands {
+                if d.goodsCost != tc.wantCosts[i] {
+                    t.Errorf("订单 %d 货款成本 = %v，期望 %v", i+1, d.goodsCost, tc.wantCosts[i])
+                }
+                if want := tc.wantCosts[i] / float64(d.quantity); math.Abs(d.unitCost-want) > 1e-9 {
+                    t.Errorf("订单 %d 单位成本 = %v，期望 %v", i+1, d.unitCost, want)
+                }
+                if d.method != CostingFIFO {
+                    t.Errorf("订单 %d 核算方式 = %q", i+1, d.method)
+                }
+            }
+            if len(alloc) != len(tc.wantAlloc) {
+                t.Fatalf("分配记录 %d 条，期望 %d 条：%+v", len(alloc), len(tc.wantAlloc), alloc)
+            }
+            for i, a := range alloc {
+                w := tc.wantAlloc[i]
+                if a.OrderID != w.OrderID || a.LotID != w.LotID || a.Quantity != w.Quantity || a.UnitCost != w.UnitCost {
+                    t.Errorf("分配记录 %d = %+v，期望 %+v", i, a, w)
+                }
+            }
+            for i, l := range tc.lots {
+                if l.Remaining != tc.wantLeft[i] {
+                    t.Errorf("批次 %d 剩余 %d，期望 %d", l.ID, l.Remaining, tc.wantLeft[i])
+                }
+            }
+        })
+    }
+}
+
+func TestAllocateWAvg(t *testing.T) {
+    cases := []struct {
+        name      string
+        lots      []models.PurchaseLot
+        orders    [][2]int
+        baseCost  float64
+        wantUnits []float64
+    }{
+        {
+            name:      "入库后重新计算平均",
+            lots:      []models.PurchaseLot{testLot(1, 1, 4, 10), testLot(2, 3, 4, 16)},
+            orders:    [][2]int{{2, 2}, {4, 2}},
+            wantUnits: []float64{10, 14},
+        },
+        {
+            name:      "入库前按基础成本",
+            lots:      []models.PurchaseLot{testLot(1, 5, 4, 10)},
+            orders:    [][2]int{{2, 3}, {6, 1}},
+            baseCost:  9,
+            wantUnits: []float64{9, 10},
+        },
+        {
+            name:      "超卖后库存按 0 重新起算",
+            lots:      []models.PurchaseLot{testLot(1, 1, 2, 10), testLot(2, 3, 2, 20)},
+            orders:    [][2]int{{2, 5}, {4, 1}},
+            wantUnits: []float64{10, 20},
+        },
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            demands := testDemands(tc.orders...)
+            allocateWAvg(tc.lots, demands, tc.baseCost)
+            for i, d := range demands {
+                if math.Abs(d.unitCost-tc.wantUnits[i]) > 1e-9 {
+                    t.Errorf("订单 %d 单位成本 = %v，期望 %v", i+1, d.unitCost, tc.wantUnits[i])
+                }
+                if want := roundMoney(tc.wantUnits[i] * float64(d.quantity)); d.goodsCost != want {
+                    t.Errorf("订单 %d 货款成本 = %v，期望 %v", i+1, d.goodsCost, want)
+                }
+                if d.method != CostingWAvg {
+                    t.Errorf("订单 %d 核算方式 = %q", i+1, d.method)
+                }
+            }
+        })
+    }
+}
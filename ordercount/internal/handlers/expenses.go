@@ -0,0 +1,637 @@
+package handlers
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+    "ordercount/internal/storage"
+    "ordercount/internal/utils"
+)
+
+// 费用分类计入结算的成本项
+const (
+    ExpenseKindShuaDan = "shua_dan"
+    ExpenseKindFixed   = "fixed"
+    ExpenseKindOther   = "other"
+)
+
+var expenseKindLabels = map[string]string{
+    ExpenseKindShuaDan: "刷单费用",
+    ExpenseKindFixed:   "固定成本",
+    ExpenseKindOther:   "其他（不计入结算）",
+}
+
+// 费用附件在存储中的 key 前缀，附件上限 10MB
+const (
+    expenseUploadPrefix  = "expenses/"
+    maxExpenseAttachment = 10 << 20
+)
+
+// 允许上传的附件类型（按内容嗅探）及扩展名
+var expenseAttachmentTypes = map[string]string{
+    "image/jpeg":      ".jpg",
+    "image/png":       ".png",
+    "image/gif":       ".gif",
+    "image/webp":      ".webp",
+    "application/pdf": ".pdf",
+}
+
+// settlementCostLine 结算中刷单费用 / 固定成本的一条来源：费用流水或固定成本计划的分配
+type settlementCostLine struct {
+    Kind      string  `json:"kind"`   // shua_dan / fixed
+    Source    string  `json:"source"` // expense 费用流水 / fixed_cost_schedule 固定成本计划
+    RefID     uint    `json:"ref_id"` // 费用流水 ID 或固定成本计划 ID
+    Name      string  `json:"name"`
+    StoreID   uint    `json:"store_id"` // 0 表示国家级，已按店铺数分摊
+    AmountCNY float64 `json:"amount_cny"`
+}
+
+// ledgerCosts 某天某个国家（或店铺）从费用流水和固定成本计划合计得到的成本
+type ledgerCosts struct {
+    ShuaDanFee   float64
+    FixedCost    float64
+    ShuaDanLines []settlementCostLine
+    FixedLines   []settlementCostLine
+    Warnings     []string
+}
+
+// Lines 全部来源明细
+func (l ledgerCosts) Lines() []settlementCostLine {
+    return append(append([]settlementCostLine{}, l.ShuaDanLines...), l.FixedLines...)
+}
+
+// ledgerOverrideWarning 手动输入的刷单费用 / 固定成本被费用流水合计覆盖时的提示；
+// 没有输入（0）或输入的人民币金额与合计一致时返回空字符串
+func ledgerOverrideWarning(label string, typed float64, currency string, ledger float64) string {
+    if typed == 0 {
+        return ""
+    }
+    cur := strings.ToUpper(strings.TrimSpace(currency))
+    if cur == "" || cur == "RMB" {
+        cur = "CNY"
+    }
+    if cur == "CNY" && math.Abs(typed-ledger) < 0.005 {
+        return ""
+    }
+    return fmt.Sprintf("%s已按当天费用流水和固定成本计划合计为 %.2f 元，忽略手动输入的 %.2f %s", label, ledger, typed, cur)
+}
+
+// settlementLedgerCosts 汇总某天某个国家（或店铺）的刷单费用和固定成本：
+// - 费用流水中分类为 shua_dan / fixed 的记录，店铺级记录计入该店铺，国家级记录在该国未封禁店铺间平均分摊；
+// - 固定成本计划按天分配到该国家 / 店铺的金额（见 fixed_costs.go）。
+// storeID 为 0 时统计整个国家。
+func settlementLedgerCosts(db *gorm.DB, date, country string, storeID uint) (ledgerCosts, error) {
+    var out ledgerCosts
+    var rows []struct {
+        models.Expense
+        Kind         string
+        CategoryName string
+    }
+    q := db.Table("expenses AS e").
+        Select("e.*, ec.kind AS kind, ec.name AS category_name").
+        Joins("JOIN expense_categories AS ec ON ec.id = e.category_id").
+        Where("e.date = ? AND e.country = ? AND ec.kind IN ?", date, country, []string{ExpenseKindShuaDan, ExpenseKindFixed})
+    if storeID != 0 {
+        q = q.Where("e.store_id IN ?", []uint{0, storeID})
+    }
+    if err := q.Order("e.id asc").Scan(&rows).Error; err != nil {
+        return out, err
+    }
+    var storeCount int64
+    if storeID != 0 {
+        if err := db.Model(&models.Store{}).Where("country = ? AND is_blocked = ?", country, false).Count(&storeCount).Error; err != nil {
+            return out, err
+        }
+    }
+    for _, r := range rows {
+        amount := r.AmountCNY
+        if storeID != 0 && r.StoreID == 0 {
+            if storeCount == 0 {
+                continue
+            }
+            amount /= float64(storeCount)
+        }
+        name := r.CategoryName
+        if r.Payee != "" {
+            name += " - " + r.Payee
+        }
+        line := settlementCostLine{Kind: r.Kind, Source: "expense", RefID: r.ID, Name: name, StoreID: r.StoreID, AmountCNY: roundMoney(amount)}
+        if r.Kind == ExpenseKindShuaDan {
+            out.ShuaDanFee += amount
+            out.ShuaDanLines = append(out.ShuaDanLines, line)
+        } else {
+            out.FixedCost += amount
+            out.FixedLines = append(out.FixedLines, line)
+        }
+    }
+
+    a, err := newFixedCostAllocator(db, date, date)
+    if err != nil {
+        return out, err
+    }
+    if len(a.schedules) > 0 {
+        shares, err := a.Allocate(date)
+        if err != nil {
+            return out, err
+        }
+        lines, _ := a.ScopeShares(shares, country, storeID)
+        for _, sh := range lines {
+            out.FixedCost += sh.AmountCNY
+            out.FixedLines = append(out.FixedLines, settlementCostLine{
+                Kind: ExpenseKindFixed, Source: "fixed_cost_schedule", RefID: sh.ScheduleID, Name: sh.Name, StoreID: sh.StoreID, AmountCNY: sh.AmountCNY,
+            })
+        }
+        out.Warnings = append(out.Warnings, a.Warnings()...)
+    }
+    out.ShuaDanFee = roundMoney(out.ShuaDanFee)
+    out.FixedCost = roundMoney(out.FixedCost)
+    return out, nil
+}
+
+// costLineNames 来源明细的简短说明，例如“房租 - 张三 100.00；服务器 20.00”
+func costLineNames(lines []settlementCostLine) string {
+    names := make([]string, 0, len(lines))
+    for _, l := range lines {
+        names = append(names, fmt.Sprintf("%s %.2f", l.Name, l.AmountCNY))
+    }
+    return strings.Join(names, "；")
+}
+
+// ListExpenseCategories 费用分类列表
+// GET /api/expense_categories
+func ListExpenseCategories(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var list []models.ExpenseCategory
+        if err := db.Order("sort_order asc, id asc").Find(&list).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"items": list, "kinds": expenseKindLabels})
+    }
+}
+
+// SaveExpenseCategory 新增或修改费用分类（仅超级管理员），body 带 id 时为修改
+// POST /api/expense_categories
+// 修改分类的 kind 会影响之后保存的结算，已保存的结算保留当时的金额和明细。
+func SaveExpenseCategory(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, _ := c.Get("role"); roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以维护费用分类"})
+            return
+        }
+        var cat models.ExpenseCategory
+        if err := c.ShouldBindJSON(&cat); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        cat.Name = strings.TrimSpace(cat.Name)
+        if cat.Name == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "分类名称不能为空"})
+            return
+        }
+        if cat.Kind == "" {
+            cat.Kind = ExpenseKindOther
+        }
+        if _, ok := expenseKindLabels[cat.Kind]; !ok {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "kind 只能是 shua_dan / fixed / other"})
+            return
+        }
+        var dup int64
+        if err := db.Model(&models.ExpenseCategory{}).Where("name = ? AND id <> ?", cat.Name, cat.ID).Count(&dup).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if dup > 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "分类名称已存在"})
+            return
+        }
+        if cat.ID != 0 {
+            var old models.ExpenseCategory
+            if err := db.First(&old, cat.ID).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "费用分类不存在"})
+                return
+            }
+            cat.CreatedAt = old.CreatedAt
+        }
+        if err := db.Save(&cat).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, cat)
+    }
+}
+
+// DeleteExpenseCategory 删除费用分类（仅超级管理员），已有费用流水的分类不能删除
+// DELETE /api/expense_categories/:id
+func DeleteExpenseCategory(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, _ := c.Get("role"); roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以维护费用分类"})
+            return
+        }
+        var cat models.ExpenseCategory
+        if err := db.First(&cat, c.Param("id")).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "费用分类不存在"})
+            return
+        }
+        var n int64
+        if err := db.Model(&models.Expense{}).Where("category_id = ?", cat.ID).Count(&n).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if n > 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("该分类下还有 %d 条费用流水，不能删除", n)})
+            return
+        }
+        if err := db.Delete(&cat).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"ok": true})
+    }
+}
+
+// expenseItem 费用流水列表项，附带分类、店铺名称和附件访问地址
+type expenseItem struct {
+    models.Expense
+    CategoryName  string `json:"category_name"`
+    Kind          string `json:"kind"`
+    StoreName     string `json:"store_name"`
+    AttachmentURL string `json:"attachment_url"`
+}
+
+// ListExpenses 费用流水查询及合计（人民币）
+// GET /api/expenses?start=&end=&country=&store_id=&category_id=&kind=&payee=&page=&page_size=
+// store_id=0 只查国家级费用；by_category / by_country 为筛选范围内全部记录的合计（不受分页影响）。
+// 超级管理员和管理员可以看到全部费用，其他角色只能看到自己录入的。
+func ListExpenses(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        q := db.Table("expenses AS e").Joins("LEFT JOIN expense_categories AS ec ON ec.id = e.category_id")
+        if v := c.Query("start"); v != "" {
+            q = q.Where("e.date >= ?", v)
+        }
+        if v := c.Query("end"); v != "" {
+            q = q.Where("e.date <= ?", v)
+        }
+        if v := c.Query("country"); v != "" {
+            q = q.Where("e.country = ?", v)
+        }
+        if v := c.Query("store_id"); v != "" {
+            if id, err := strconv.Atoi(v); err == nil && id >= 0 {
+                q = q.Where("e.store_id = ?", id)
+            }
+        }
+        if v := c.Query("category_id"); v != "" {
+            q = q.Where("e.category_id = ?", v)
+        }
+        if v := c.Query("kind"); v != "" {
+            q = q.Where("ec.kind = ?", v)
+        }
+        if v := strings.TrimSpace(c.Query("payee")); v != "" {
+            q = q.Where("e.payee LIKE ?", "%"+v+"%")
+        }
+        roleVal, _ := c.Get("role")
+        role, _ := roleVal.(string)
+        if role != "superadmin" && role != "admin" {
+            userIDVal, _ := c.Get("userID")
+            uid, _ := userIDVal.(uint)
+            if uid == 0 {
+                c.JSON(http.StatusUnauthorized, gin.H{"error": "未获取到用户信息"})
+                return
+            }
+            q = q.Where("e.user_id = ?", uid)
+        }
+
+        page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+        if err != nil || page < 1 {
+            page = 1
+        }
+        pageSize, err := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+        if err != nil || pageSize < 1 || pageSize > 200 {
+            pageSize = 20
+        }
+
+        var total int64
+        if err := q.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        var byCategory []struct {
+            CategoryID   uint    `json:"category_id"`
+            CategoryName string  `json:"category_name"`
+            Kind         string  `json:"kind"`
+            Count        int64   `json:"count"`
+            AmountCNY    float64 `json:"amount_cny"`
+        }
+        if err := q.Session(&gorm.Session{}).
+            Select("e.category_id, ec.name AS category_name, ec.kind AS kind, COUNT(*) AS count, IFNULL(SUM(e.amount_cny), 0) AS amount_cny").
+            Group("e.category_id, ec.name, ec.kind").Order("amount_cny desc").Scan(&byCategory).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        var byCountry []struct {
+            Country   string  `json:"country"`
+            Count     int64   `json:"count"`
+            AmountCNY float64 `json:"amount_cny"`
+        }
+        if err := q.Session(&gorm.Session{}).
+            Select("e.country, COUNT(*) AS count, IFNULL(SUM(e.amount_cny), 0) AS amount_cny").
+            Group("e.country").Order("amount_cny desc").Scan(&byCountry).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        sum := 0.0
+        for _, r := range byCategory {
+            sum += r.AmountCNY
+        }
+
+        var items []expenseItem
+        if err := q.Session(&gorm.Session{}).
+            Select("e.*, ec.name AS category_name, ec.kind AS kind").
+            Order("e.date desc, e.id desc").Offset((page - 1) * pageSize).Limit(pageSize).
+            Scan(&items).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        storeIDs := []uint{}
+        for _, it := range items {
+            if it.StoreID != 0 {
+                storeIDs = append(storeIDs, it.StoreID)
+            }
+        }
+        names := map[uint]string{}
+        if len(storeIDs) > 0 {
+            var stores []models.Store
+            db.Select("id", "name").Where("id IN ?", storeIDs).Find(&stores)
+            for _, st := range stores {
+                names[st.ID] = st.Name
+            }
+        }
+        for i := range items {
+            items[i].StoreName = names[items[i].StoreID]
+            if items[i].Attachment != "" {
+                items[i].AttachmentURL = resolveUploadURL(items[i].Attachment)
+            }
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+            "items":       items,
+            "total":       total,
+            "page":        page,
+            "page_size":   pageSize,
+            "total_cny":   roundMoney(sum),
+            "by_category": byCategory,
+            "by_country":  byCountry,
+        })
+    }
+}
+
+// expenseBody 新增 / 修改费用流水的请求体
+type expenseBody struct {
+    Date       string  `json:"date"`
+    CategoryID uint    `json:"category_id"`
+    Country    string  `json:"country"`
+    StoreID    uint    `json:"store_id"`
+    Amount     float64 `json:"amount"`
+    Currency   string  `json:"currency"`
+    // 本国货币金额的汇率（1 外币 ≈ ? 人民币），不传时使用汇率服务的实时汇率
+    Exchange   float64 `json:"exchange"`
+    Payee      string  `json:"payee"`
+    Note       string  `json:"note"`
+    Attachment string  `json:"attachment"`
+}
+
+// fillExpense 校验请求并写入费用流水：店铺级费用按店铺确定国家（需要该店铺权限），
+// 国家级费用只有超级管理员和管理员可以录入；币种只能是人民币或该国货币。
+func fillExpense(db *gorm.DB, e *models.Expense, body expenseBody, role string, uid uint) error {
+    body.Date = strings.TrimSpace(body.Date)
+    if body.Date == "" {
+        return fmt.Errorf("费用日期不能为空")
+    }
+    if _, err := time.Parse("2006-01-02", body.Date); err != nil {
+        return fmt.Errorf("日期格式应为 YYYY-MM-DD")
+    }
+    var cat models.ExpenseCategory
+    if err := db.First(&cat, body.CategoryID).Error; err != nil {
+        return fmt.Errorf("请选择费用分类")
+    }
+    if body.Amount <= 0 {
+        return fmt.Errorf("金额必须大于 0")
+    }
+    if body.StoreID != 0 {
+        store, err := loadSettlementStore(db, body.StoreID, role, uid)
+        if err != nil {
+            return err
+        }
+        body.Country = store.Country
+    } else if role != "superadmin" && role != "admin" {
+        return fmt.Errorf("请选择店铺，国家级费用只有管理员可以录入")
+    }
+    local, ok := countryCurrency[body.Country]
+    if !ok {
+        return fmt.Errorf("国家只能是 菲律宾 / 印尼 / 马来西亚")
+    }
+
+    body.Currency = strings.ToUpper(strings.TrimSpace(body.Currency))
+    if body.Currency == "" {
+        body.Currency = "CNY"
+    }
+    e.RateSource = ""
+    switch body.Currency {
+    case "CNY":
+        body.Exchange = 1
+    case local:
+        if body.Exchange > 0 {
+            e.RateSource = RateSourceManual
+        } else {
+            rates, err := utils.GetRates()
+            if err != nil || rates[local] <= 0 {
+                return fmt.Errorf("获取 %s 汇率失败，请手动填写汇率", local)
+            }
+            body.Exchange = 1 / rates[local]
+            e.RateSource = RateSourceService
+        }
+    default:
+        return fmt.Errorf("币种只能是 CNY 或 %s", local)
+    }
+
+    e.Date = body.Date
+    e.CategoryID = cat.ID
+    e.Country = body.Country
+    e.StoreID = body.StoreID
+    e.Amount = roundMoney(body.Amount)
+    e.Currency = body.Currency
+    e.Exchange = body.Exchange
+    e.AmountCNY = roundMoney(body.Amount * body.Exchange)
+    e.Payee = strings.TrimSpace(body.Payee)
+    e.Note = strings.TrimSpace(body.Note)
+    e.Attachment = normalizeUploadURL(body.Attachment)
+    return nil
+}
+
+// expenseEditable 超级管理员和管理员可以修改全部费用，其他角色只能修改自己录入的
+func expenseEditable(e models.Expense, role string, uid uint) bool {
+    return role == "superadmin" || role == "admin" || e.UserID == uid
+}
+
+// CreateExpense 新增费用流水
+// POST /api/expenses
+func CreateExpense(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var body expenseBody
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        userIDVal, _ := c.Get("userID")
+        uid, _ := userIDVal.(uint)
+        if uid == 0 {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "未获取到用户信息"})
+            return
+        }
+        roleVal, _ := c.Get("role")
+        role, _ := roleVal.(string)
+        if err := ensurePeriodOpen(db, body.Date); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        e := models.Expense{UserID: uid}
+        if err := fillExpense(db, &e, body, role, uid); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := db.Create(&e).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, e)
+    }
+}
+
+// UpdateExpense 修改费用流水；已保存的结算不会随之改变，需要重新保存当天结算
+// PUT /api/expenses/:id
+func UpdateExpense(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var body expenseBody
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        userIDVal, _ := c.Get("userID")
+        uid, _ := userIDVal.(uint)
+        roleVal, _ := c.Get("role")
+        role, _ := roleVal.(string)
+        var e models.Expense
+        if err := db.First(&e, c.Param("id")).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "费用流水不存在"})
+            return
+        }
+        if !expenseEditable(e, role, uid) {
+            c.JSON(http.StatusForbidden, gin.H{"error": "只能修改自己录入的费用"})
+            return
+        }
+        if err := ensurePeriodOpen(db, e.Date, body.Date); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := fillExpense(db, &e, body, role, uid); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := db.Save(&e).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, e)
+    }
+}
+
+// DeleteExpense 删除费用流水（附件文件保留）
+// DELETE /api/expenses/:id
+func DeleteExpense(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        userIDVal, _ := c.Get("userID")
+        uid, _ := userIDVal.(uint)
+        roleVal, _ := c.Get("role")
+        role, _ := roleVal.(string)
+        var e models.Expense
+        if err := db.First(&e, c.Param("id")).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "费用流水不存在"})
+            return
+        }
+        if !expenseEditable(e, role, uid) {
+            c.JSON(http.StatusForbidden, gin.H{"error": "只能删除自己录入的费用"})
+            return
+        }
+        if err := ensurePeriodOpen(db, e.Date); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := db.Delete(&e).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"ok": true})
+    }
+}
+
+// UploadExpenseAttachment 上传费用附件（图片或 PDF，不超过 10MB），按内容哈希命名去重，
+// 返回的 path 填入费用流水的 attachment 字段
+// POST /api/expenses/upload
+func UploadExpenseAttachment() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxExpenseAttachment+(1<<20))
+        file, err := c.FormFile("file")
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
+            return
+        }
+        if file.Size > maxExpenseAttachment {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "附件不能超过 10MB"})
+            return
+        }
+        f, err := file.Open()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        data, err := io.ReadAll(io.LimitReader(f, maxExpenseAttachment+1))
+        f.Close()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        contentType := http.DetectContentType(data)
+        ext, ok := expenseAttachmentTypes[contentType]
+        if !ok {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "附件只支持 JPG / PNG / GIF / WebP 图片或 PDF"})
+            return
+        }
+        sum := sha256.Sum256(data)
+        key := expenseUploadPrefix + hex.EncodeToString(sum[:]) + ext
+        if _, err := UploadStorage.Stat(key); err != nil {
+            if !errors.Is(err, storage.ErrNotExist) {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            if err := UploadStorage.Put(key, data, contentType); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+        }
+        p := storage.CanonicalURL(key)
+        c.JSON(http.StatusOK, gin.H{"url": resolveUploadURL(p), "path": p, "name": file.Filename})
+    }
+}
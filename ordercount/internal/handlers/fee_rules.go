@@ -0,0 +1,462 @@
+package handlers
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+)
+
+// 费用规则计入结算的项目
+const (
+    FeeKindPlatform = "platform_fee" // 平台手续费
+    FeeKindAdTax    = "ad_tax"       // 广告税，并入广告费折算
+)
+
+// 费用规则的计算方式
+const (
+    FeeRatePercent = "percent" // 基数 × Value%
+    FeeRateFixed   = "fixed"   // 每天固定金额（人民币）
+)
+
+var feeKindLabels = map[string]string{
+    FeeKindPlatform: "平台手续费",
+    FeeKindAdTax:    "广告税",
+}
+
+// 百分比规则可用的基数字段
+var feeBaseLabels = map[string]string{
+    "sale_total": "销售额",
+    "ad_cost":    "广告费",
+    "goods_cost": "货款成本",
+}
+
+// feeScope 结算匹配费用规则的范围
+type feeScope struct {
+    Date     string
+    Country  string
+    Platform string
+    StoreID  uint
+}
+
+// feeInput 费用计算的基数：SaleTotal、GoodsCost 为人民币，AdCost 为本国货币（按 Exchange 折算）
+type feeInput struct {
+    SaleTotal float64
+    AdCost    float64
+    Exchange  float64
+    GoodsCost float64
+}
+
+// feeLine 一条规则的计算明细，随结算保存为快照
+type feeLine struct {
+    RuleID     uint    `json:"rule_id"`
+    Name       string  `json:"name"`
+    Code       string  `json:"code"`
+    Kind       string  `json:"kind"`
+    RateType   string  `json:"rate_type"`
+    Value      float64 `json:"value"`
+    Base       string  `json:"base,omitempty"`
+    BaseAmount float64 `json:"base_amount"` // 人民币
+    Amount     float64 `json:"amount"`      // 人民币
+}
+
+// feeResult 费用规则的计算结果（人民币）
+type feeResult struct {
+    AdDeduction float64   `json:"ad_deduction"`
+    PlatformFee float64   `json:"platform_fee"`
+    Lines       []feeLine `json:"lines"`
+    Warnings    []string  `json:"warnings"`
+}
+
+// RuleIDs 使用的规则 ID，逗号分隔
+func (r feeResult) RuleIDs() string {
+    ids := make([]string, 0, len(r.Lines))
+    for _, l := range r.Lines {
+        ids = append(ids, strconv.FormatUint(uint64(l.RuleID), 10))
+    }
+    return strings.Join(ids, ",")
+}
+
+// feeRuleSpecificity 规则范围的具体程度：店铺 > 平台 > 国家 > 通用
+func feeRuleSpecificity(r models.FeeRule) int {
+    score := 0
+    if r.StoreID != 0 {
+        score += 4
+    }
+    if r.Platform != "" {
+        score += 2
+    }
+    if r.Country != "" {
+        score++
+    }
+    return score
+}
+
+// loadFeeRules 加载在 scope.Date 当天生效、且范围匹配的规则。
+// 同一费用项（Code）只保留范围最具体的一条，范围相同时取生效日期较晚、ID 较大的一条。
+func loadFeeRules(db *gorm.DB, scope feeScope) ([]models.FeeRule, error) {
+    var all []models.FeeRule
+    err := db.Where("enabled = ?", true).
+        Where("valid_from = '' OR valid_from <= ?", scope.Date).
+        Where("valid_to = '' OR valid_to >= ?", scope.Date).
+        Where("country IN ?", []string{"", scope.Country}).
+        Where("store_id IN ?", []uint{0, scope.StoreID}).
+        Order("id asc").
+        Find(&all).Error
+    if err != nil {
+        return nil, err
+    }
+
+    best := map[string]models.FeeRule{}
+    for _, r := range all {
+        // 店铺规则只看店铺，店铺本身已经确定了平台
+        if r.StoreID == 0 && r.Platform != "" && !strings.EqualFold(r.Platform, strings.TrimSpace(scope.Platform)) {
+            continue
+        }
+        cur, ok := best[r.Code]
+        if !ok {
+            best[r.Code] = r
+            continue
+        }
+        rs, cs := feeRuleSpecificity(r), feeRuleSpecificity(cur)
+        if rs > cs || (rs == cs && r.ValidFrom >= cur.ValidFrom) {
+            best[r.Code] = r
+        }
+    }
+    rules := make([]models.FeeRule, 0, len(best))
+    for _, r := range best {
+        rules = append(rules, r)
+    }
+    sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+    return rules, nil
+}
+
+// skippedScopedFeeRules 当天生效、限定了平台或店铺但不适用于 scope 的规则名称，
+// 用于提示调用方没有指定平台 / 店铺时这些规则未计入
+func skippedScopedFeeRules(db *gorm.DB, scope feeScope) ([]string, error) {
+    var rules []models.FeeRule
+    err := db.Where("enabled = ?", true).
+        Where("valid_from = '' OR valid_from <= ?", scope.Date).
+        Where("valid_to = '' OR valid_to >= ?", scope.Date).
+        Where("country IN ?", []string{"", scope.Country}).
+        Where("platform <> '' OR store_id <> 0").
+        Order("id asc").
+        Find(&rules).Error
+    if err != nil {
+        return nil, err
+    }
+    var names []string
+    for _, r := range rules {
+        if r.StoreID != 0 {
+            if r.StoreID == scope.StoreID {
+                continue
+            }
+        } else if strings.EqualFold(r.Platform, strings.TrimSpace(scope.Platform)) {
+            continue
+        }
+        names = append(names, r.Name)
+    }
+    return names, nil
+}
+
+// applyFeeRules 按规则计算费用：广告费折算 = 广告费 × 汇率 + 广告税类规则；平台手续费 = 平台手续费类规则合计
+func applyFeeRules(rules []models.FeeRule, in feeInput) feeResult {
+    res := feeResult{AdDeduction: in.AdCost * in.Exchange, Lines: []feeLine{}, Warnings: []string{}}
+    bases := map[string]float64{
+        "sale_total": in.SaleTotal,
+        "ad_cost":    in.AdCost * in.Exchange,
+        "goods_cost": in.GoodsCost,
+    }
+    hasPlatform := false
+    for _, r := range rules {
+        line := feeLine{RuleID: r.ID, Name: r.Name, Code: r.Code, Kind: r.Kind, RateType: r.RateType, Value: r.Value}
+        if r.RateType == FeeRatePercent {
+            line.Base = r.Base
+            line.BaseAmount = bases[r.Base]
+            line.Amount = line.BaseAmount * r.Value / 100
+        } else {
+            line.Amount = r.Value
+        }
+        switch r.Kind {
+        case FeeKindPlatform:
+            res.PlatformFee += line.Amount
+            hasPlatform = true
+        case FeeKindAdTax:
+            res.AdDeduction += line.Amount
+        }
+        res.Lines = append(res.Lines, line)
+    }
+    if !hasPlatform {
+        res.Warnings = append(res.Warnings, "没有匹配到平台手续费规则，平台手续费按 0 计算")
+    }
+    return res
+}
+
+// calcSettlementFees 加载结算当天生效的规则并计算费用
+func calcSettlementFees(db *gorm.DB, scope feeScope, in feeInput) (feeResult, error) {
+    rules, err := loadFeeRules(db, scope)
+    if err != nil {
+        return feeResult{}, err
+    }
+    return applyFeeRules(rules, in), nil
+}
+
+// feeRulePercents 把百分比规则按基数汇总为比例（0~1），用于单件定价；固定金额规则无法按件分摊，单独返回
+func feeRulePercents(rules []models.FeeRule) (rates map[string]float64, fixed []models.FeeRule) {
+    rates = map[string]float64{}
+    for _, r := range rules {
+        if r.RateType == FeeRatePercent {
+            rates[r.Base] += r.Value / 100
+        } else {
+            fixed = append(fixed, r)
+        }
+    }
+    return rates, fixed
+}
+
+// validateFeeRule 校验并规范化规则字段
+func validateFeeRule(r *models.FeeRule) error {
+    r.Name = strings.TrimSpace(r.Name)
+    r.Code = strings.TrimSpace(r.Code)
+    r.Country = strings.TrimSpace(r.Country)
+    r.Platform = strings.TrimSpace(r.Platform)
+    r.ValidFrom = strings.TrimSpace(r.ValidFrom)
+    r.ValidTo = strings.TrimSpace(r.ValidTo)
+    if r.Name == "" {
+        return fmt.Errorf("规则名称不能为空")
+    }
+    if _, ok := feeKindLabels[r.Kind]; !ok {
+        return fmt.Errorf("kind 只能是 platform_fee（平台手续费）或 ad_tax（广告税）")
+    }
+    if r.Code == "" {
+        r.Code = r.Kind
+    }
+    switch r.RateType {
+    case FeeRatePercent:
+        if _, ok := feeBaseLabels[r.Base]; !ok {
+            return fmt.Errorf("百分比规则的基数只能是 sale_total / ad_cost / goods_cost")
+        }
+        if r.Value > 100 {
+            return fmt.Errorf("百分比不能超过 100")
+        }
+    case FeeRateFixed:
+        r.Base = ""
+    default:
+        return fmt.Errorf("rate_type 只能是 percent 或 fixed")
+    }
+    if r.Value < 0 {
+        return fmt.Errorf("费率 / 金额不能为负数")
+    }
+    if r.Country != "" {
+        if _, ok := countryCurrency[r.Country]; !ok {
+            return fmt.Errorf("国家只能是 菲律宾 / 印尼 / 马来西亚")
+        }
+    }
+    for _, d := range []string{r.ValidFrom, r.ValidTo} {
+        if d == "" {
+            continue
+        }
+        if _, err := time.Parse("2006-01-02", d); err != nil {
+            return fmt.Errorf("日期格式应为 YYYY-MM-DD：%s", d)
+        }
+    }
+    if r.ValidFrom != "" && r.ValidTo != "" && r.ValidFrom > r.ValidTo {
+        return fmt.Errorf("生效日期不能晚于失效日期")
+    }
+    return nil
+}
+
+// ListFeeRules 费用规则列表，可按 country / code 过滤，date=YYYY-MM-DD 时只返回当天生效的规则
+// GET /api/fee_rules
+func ListFeeRules(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        q := db.Model(&models.FeeRule{})
+        if v := strings.TrimSpace(c.Query("country")); v != "" {
+            q = q.Where("country = ?", v)
+        }
+        if v := strings.TrimSpace(c.Query("code")); v != "" {
+            q = q.Where("code = ?", v)
+        }
+        if v := strings.TrimSpace(c.Query("date")); v != "" {
+            q = q.Where("enabled = ?", true).
+                Where("valid_from = '' OR valid_from <= ?", v).
+                Where("valid_to = '' OR valid_to >= ?", v)
+        }
+        var list []models.FeeRule
+        if err := q.Order("code asc, id asc").Find(&list).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"items": list, "kinds": feeKindLabels, "bases": feeBaseLabels})
+    }
+}
+
+// SaveFeeRule 新增或修改费用规则（仅超级管理员），body 带 id 时为修改
+// POST /api/fee_rules
+// 已保存的结算记录带有当时的计算明细快照，修改规则不会改变历史结算；
+// 费率调整建议给旧规则设置失效日期并新增一条，便于按日期追溯。
+func SaveFeeRule(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, _ := c.Get("role"); roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以维护费用规则"})
+            return
+        }
+        var body struct {
+            models.FeeRule
+            Enabled *bool `json:"enabled"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        r := body.FeeRule
+        // 未传 enabled 时默认启用
+        r.Enabled = body.Enabled == nil || *body.Enabled
+        if err := validateFeeRule(&r); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if r.StoreID != 0 {
+            var st models.Store
+            if err := db.First(&st, r.StoreID).Error; err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "店铺不存在"})
+                return
+            }
+            // 店铺规则的国家和平台以店铺为准
+            r.Country, r.Platform = st.Country, st.Platform
+        }
+        if r.ID != 0 {
+            var old models.FeeRule
+            if err := db.First(&old, r.ID).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "费用规则不存在"})
+                return
+            }
+            r.CreatedAt = old.CreatedAt
+        }
+        if err := db.Save(&r).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, r)
+    }
+}
+
+// DeleteFeeRule 删除费用规则（仅超级管理员）；已被结算使用的规则不能删除，只能停用或设置失效日期
+// DELETE /api/fee_rules/:id
+func DeleteFeeRule(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, _ := c.Get("role"); roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以维护费用规则"})
+            return
+        }
+        var r models.FeeRule
+        if err := db.First(&r, c.Param("id")).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "费用规则不存在"})
+            return
+        }
+        var used int64
+        if err := db.Model(&models.DailySettlement{}).
+            Where("FIND_IN_SET(?, fee_rule_ids) > 0", r.ID).
+            Count(&used).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if used > 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("该规则已被 %d 条结算记录使用，不能删除，请停用或设置失效日期", used)})
+            return
+        }
+        if err := db.Delete(&r).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"ok": true})
+    }
+}
+
+// PreviewSettlementFees 按结算参数预览会使用哪些费用规则及计算结果，不保存；
+// 金额折算、费用和利润与保存结算使用同一套计算（calcSettlement）
+// POST /api/settlement/preview  body 与 POST /api/settlement 相同
+func PreviewSettlementFees(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var body struct {
+            Date       string  `json:"date"`
+            StoreID    uint    `json:"store_id"`
+            Currency   string  `json:"currency"`
+            SaleTotal  float64 `json:"sale_total"`
+            AdCost     float64 `json:"ad_cost"`
+            Exchange   float64 `json:"exchange"`
+            GoodsCost  float64 `json:"goods_cost"`
+            ShuaDanFee float64 `json:"shua_dan_fee"`
+            FixedCost  float64 `json:"fixed_cost"`
+            // 各项金额的原币种，含义与保存结算相同
+            SaleCurrency    string `json:"sale_currency"`
+            AdCurrency      string `json:"ad_currency"`
+            GoodsCurrency   string `json:"goods_currency"`
+            ShuaDanCurrency string `json:"shua_dan_currency"`
+            FixedCurrency   string `json:"fixed_currency"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if body.Date == "" {
+            body.Date = time.Now().Format("2006-01-02")
+        }
+        // 与保存一致：国家、平台取自店铺，平台 / 店铺范围的规则才能匹配上
+        userIDVal, _ := c.Get("userID")
+        uid, _ := userIDVal.(uint)
+        roleVal, _ := c.Get("role")
+        role, _ := roleVal.(string)
+        store, err := loadSettlementStore(db, body.StoreID, role, uid)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if cur, ok := countryCurrency[store.Country]; ok {
+            body.Currency = cur
+        }
+        s := models.DailySettlement{
+            Date:            body.Date,
+            StoreID:         &store.ID,
+            Country:         store.Country,
+            Platform:        store.Platform,
+            Currency:        body.Currency,
+            SaleTotal:       body.SaleTotal,
+            AdCost:          body.AdCost,
+            Exchange:        body.Exchange,
+            GoodsCost:       body.GoodsCost,
+            ShuaDanFee:      body.ShuaDanFee,
+            FixedCost:       body.FixedCost,
+            SaleCurrency:    body.SaleCurrency,
+            AdCurrency:      body.AdCurrency,
+            GoodsCurrency:   body.GoodsCurrency,
+            ShuaDanCurrency: body.ShuaDanCurrency,
+            FixedCurrency:   body.FixedCurrency,
+        }
+        // 当天有费用流水 / 固定成本计划时，刷单费用和固定成本与保存一样按合计覆盖，并返回覆盖提示
+        fees, warnings, status, err := calcSettlement(db, &s, store)
+        if err != nil {
+            c.JSON(status, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "date":         body.Date,
+            "ad_deduction": s.AdDeduction,
+            "platform_fee": s.PlatformFee,
+            "profit":       s.Profit,
+            "fee_rule_ids": s.FeeRuleIDs,
+            "shua_dan_fee": s.ShuaDanFeeCNY,
+            "fixed_cost":   s.FixedCostCNY,
+            "cost_detail":  s.CostDetail,
+            "lines":        fees.Lines,
+            "warnings":     warnings,
+        })
+    }
+}
@@ -0,0 +1,595 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+    "ordercount/internal/utils"
+)
+
+// 固定成本频率、分配对象和权重方式
+const (
+    FixedCostMonthly = "monthly"
+    FixedCostWeekly  = "weekly"
+
+    AllocateToCountry = "country"
+    AllocateToStore   = "store"
+
+    WeightEqual  = "equal"
+    WeightSales  = "sales"
+    WeightCustom = "custom"
+)
+
+var fixedCostFrequencyLabels = map[string]string{
+    FixedCostMonthly: "每月",
+    FixedCostWeekly:  "每周",
+}
+
+var fixedCostWeightLabels = map[string]string{
+    WeightEqual:  "平均分配",
+    WeightSales:  "按当天销售额比例",
+    WeightCustom: "自定义权重",
+}
+
+// fixedCostShare 某个固定成本某天分配到一个国家或店铺的金额
+type fixedCostShare struct {
+    ScheduleID uint    `json:"schedule_id"`
+    Name       string  `json:"name"`
+    Category   string  `json:"category"`
+    Date       string  `json:"date"`
+    Country    string  `json:"country"`
+    StoreID    uint    `json:"store_id"` // 按国家分配时为 0
+    Weight     float64 `json:"weight"`   // 分配比例（0~1）
+    Currency   string  `json:"currency"`
+    Amount     float64 `json:"amount"`     // 原币种金额
+    AmountCNY  float64 `json:"amount_cny"` // 人民币金额
+}
+
+// splitList 逗号分隔的列表，忽略空项
+func splitList(v string) []string {
+    var list []string
+    for _, s := range strings.Split(v, ",") {
+        if s = strings.TrimSpace(s); s != "" {
+            list = append(list, s)
+        }
+    }
+    return list
+}
+
+// fixedCostDaily 某天摊到的原币种金额：每月按当月天数平均，每周按 7 天平均
+func fixedCostDaily(s models.FixedCostSchedule, day time.Time) float64 {
+    if s.Frequency == FixedCostWeekly {
+        return s.Amount / 7
+    }
+    days := time.Date(day.Year(), day.Month()+1, 0, 0, 0, 0, 0, day.Location()).Day()
+    return s.Amount / float64(days)
+}
+
+// validateFixedCostSchedule 校验并规范化固定成本字段
+func validateFixedCostSchedule(s *models.FixedCostSchedule) error {
+    s.Name = strings.TrimSpace(s.Name)
+    s.Currency = strings.ToUpper(strings.TrimSpace(s.Currency))
+    s.StartDate = strings.TrimSpace(s.StartDate)
+    s.EndDate = strings.TrimSpace(s.EndDate)
+    s.Countries = strings.Join(splitList(s.Countries), ",")
+    s.StoreIDs = strings.Join(splitList(s.StoreIDs), ",")
+    if s.Name == "" {
+        return fmt.Errorf("名称不能为空")
+    }
+    if s.Amount <= 0 {
+        return fmt.Errorf("金额必须大于 0")
+    }
+    if s.Currency == "" {
+        s.Currency = "CNY"
+    }
+    if s.Rate < 0 {
+        return fmt.Errorf("汇率不能为负数")
+    }
+    switch s.Currency {
+    case "CNY", "PHP", "IDR", "MYR", "USD":
+    default:
+        return fmt.Errorf("币种只能是 CNY / PHP / IDR / MYR / USD")
+    }
+    if _, ok := fixedCostFrequencyLabels[s.Frequency]; !ok {
+        return fmt.Errorf("frequency 只能是 monthly（每月）或 weekly（每周）")
+    }
+    if s.StartDate == "" {
+        return fmt.Errorf("开始日期不能为空")
+    }
+    for _, d := range []string{s.StartDate, s.EndDate} {
+        if d == "" {
+            continue
+        }
+        if _, err := time.Parse("2006-01-02", d); err != nil {
+            return fmt.Errorf("日期格式应为 YYYY-MM-DD：%s", d)
+        }
+    }
+    if s.EndDate != "" && s.StartDate > s.EndDate {
+        return fmt.Errorf("开始日期不能晚于结束日期")
+    }
+    if s.AllocateTo != AllocateToCountry && s.AllocateTo != AllocateToStore {
+        return fmt.Errorf("allocate_to 只能是 country（按国家）或 store（按店铺）")
+    }
+    for _, c := range splitList(s.Countries) {
+        if _, ok := countryCurrency[c]; !ok {
+            return fmt.Errorf("国家只能是 菲律宾 / 印尼 / 马来西亚")
+        }
+    }
+    if s.AllocateTo == AllocateToCountry {
+        s.StoreIDs = ""
+    }
+    for _, id := range splitList(s.StoreIDs) {
+        if n, err := strconv.Atoi(id); err != nil || n <= 0 {
+            return fmt.Errorf("store_ids 应为逗号分隔的店铺 ID")
+        }
+    }
+    if _, ok := fixedCostWeightLabels[s.WeightMethod]; !ok {
+        return fmt.Errorf("weight_method 只能是 equal / sales / custom")
+    }
+    if s.WeightMethod == WeightCustom {
+        var w map[string]float64
+        if err := json.Unmarshal([]byte(s.Weights), &w); err != nil || len(w) == 0 {
+            return fmt.Errorf("自定义权重应为 JSON，例如 {\"印尼\":2,\"菲律宾\":1}")
+        }
+        for k, v := range w {
+            if v < 0 {
+                return fmt.Errorf("权重不能为负数：%s", k)
+            }
+        }
+    } else {
+        s.Weights = ""
+    }
+    return nil
+}
+
+// fixedCostAllocator 按天计算固定成本分配，店铺、汇率和 start ~ end 的订单销售额只加载一次
+type fixedCostAllocator struct {
+    db         *gorm.DB
+    start, end string
+    schedules  []models.FixedCostSchedule
+    stores     []models.Store
+    rates      map[string]float64
+    rateErr    error
+    salesCNY   *cnyConverter              // sales 权重的订单销售额折算
+    sales      map[string]*daySalesTotals // 日期 -> 当天销售额，第一次用到 sales 权重时按整个范围加载
+    warnings   map[string]bool
+}
+
+// daySalesTotals 某天按国家、按店铺的订单销售额（人民币）
+type daySalesTotals struct {
+    byCountry map[string]float64
+    byStore   map[uint]float64
+}
+
+func newFixedCostAllocator(db *gorm.DB, start, end string) (*fixedCostAllocator, error) {
+    a := &fixedCostAllocator{db: db, start: start, end: end, salesCNY: newCNYConverter(nil), warnings: map[string]bool{}}
+    if err := db.Where("enabled = ? AND start_date <= ?", true, end).
+        Where("end_date = '' OR end_date >= ?", start).
+        Order("id asc").Find(&a.schedules).Error; err != nil {
+        return nil, err
+    }
+    if len(a.schedules) == 0 {
+        return a, nil
+    }
+    if err := db.Where("is_blocked = ?", false).Order("id asc").Find(&a.stores).Error; err != nil {
+        return nil, err
+    }
+    // 只有没记录汇率的旧数据才需要实时汇率
+    for _, s := range a.schedules {
+        if s.Currency != "" && s.Currency != "CNY" && s.Rate <= 0 {
+            a.rates, a.rateErr = utils.GetRates()
+            break
+        }
+    }
+    return a, nil
+}
+
+func (a *fixedCostAllocator) warn(format string, args ...any) {
+    a.warnings[fmt.Sprintf(format, args...)] = true
+}
+
+// Warnings 分配过程中的提示（去重、排序）
+func (a *fixedCostAllocator) Warnings() []string {
+    list := make([]string, 0, len(a.warnings))
+    for w := range a.warnings {
+        list = append(list, w)
+    }
+    sort.Strings(list)
+    for _, w := range a.salesCNY.warnings() {
+        list = append(list, "按销售额分配权重："+w)
+    }
+    return list
+}
+
+// daySales 当天按国家、按店铺的订单销售额（折算人民币，各国订单币种不同，不能直接相加比较），用于 sales 权重。
+// 第一次调用时一次查询整个 start ~ end 范围，范围外的日期单独查询
+func (a *fixedCostAllocator) daySales(date string) (map[string]float64, map[uint]float64, error) {
+    if a.sales == nil {
+        a.sales = map[string]*daySalesTotals{}
+        if err := a.loadSales(a.start, a.end); err != nil {
+            return nil, nil, err
+        }
+    }
+    if date < a.start || date > a.end {
+        if _, ok := a.sales[date]; !ok {
+            if err := a.loadSales(date, date); err != nil {
+                return nil, nil, err
+            }
+        }
+    }
+    t := a.sales[date]
+    if t == nil {
+        return map[string]float64{}, map[uint]float64{}, nil
+    }
+    return t.byCountry, t.byStore, nil
+}
+
+// loadSales 按日期、国家、店铺、币种汇总 start ~ end 的订单销售额并折算人民币，写入 a.sales
+func (a *fixedCostAllocator) loadSales(start, end string) error {
+    var rows []struct {
+        Date     string
+        Country  string
+        StoreID  uint
+        Currency string
+        Amount   float64
+    }
+    if err := a.db.Model(&models.Order{}).
+        Select("DATE_FORMAT(created_at, '%Y-%m-%d') AS date, country, store_id, currency, IFNULL(SUM(total_amount), 0) AS amount").
+        Where("DATE(created_at) >= ? AND DATE(created_at) <= ?", start, end).
+        Where("product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇").
+        Group("DATE_FORMAT(created_at, '%Y-%m-%d'), country, store_id, currency").Scan(&rows).Error; err != nil {
+        return err
+    }
+    for _, r := range rows {
+        t := a.sales[r.Date]
+        if t == nil {
+            t = &daySalesTotals{byCountry: map[string]float64{}, byStore: map[uint]float64{}}
+            a.sales[r.Date] = t
+        }
+        amount := a.salesCNY.convert(r.Amount, r.Currency)
+        t.byCountry[r.Country] += amount
+        if r.StoreID != 0 {
+            t.byStore[r.StoreID] += amount
+        }
+    }
+    if start == end && a.sales[start] == nil {
+        // 记录没有订单的日期，避免重复查询
+        a.sales[start] = &daySalesTotals{byCountry: map[string]float64{}, byStore: map[uint]float64{}}
+    }
+    return nil
+}
+
+// Allocate 计算某天所有生效固定成本的分配结果
+func (a *fixedCostAllocator) Allocate(date string) ([]fixedCostShare, error) {
+    day, err := time.ParseInLocation("2006-01-02", date, time.Local)
+    if err != nil {
+        return nil, fmt.Errorf("invalid date format, want YYYY-MM-DD")
+    }
+    shares := []fixedCostShare{}
+    var salesByCountry map[string]float64
+    var salesByStore map[uint]float64
+    for _, s := range a.schedules {
+        if s.StartDate > date || (s.EndDate != "" && s.EndDate < date) {
+            continue
+        }
+        daily := fixedCostDaily(s, day)
+        dailyCNY := daily * s.Rate
+        if s.Rate <= 0 {
+            // 旧数据没有记录汇率，只能按实时汇率折算
+            cny, ok := amountToCNY(daily, s.Currency, a.rates)
+            if !ok {
+                if a.rateErr != nil {
+                    a.warn("获取汇率失败，固定成本“%s”（%s）未计入：%v", s.Name, s.Currency, a.rateErr)
+                } else {
+                    a.warn("固定成本“%s”缺少 %s 汇率，未计入", s.Name, s.Currency)
+                }
+                continue
+            }
+            dailyCNY = cny
+            if s.Currency != "CNY" {
+                a.warn("固定成本“%s”没有记录汇率，按当前实时汇率折算成人民币，重新保存即可固定汇率", s.Name)
+            }
+        }
+
+        // 分配对象及其权重
+        countries := splitList(s.Countries)
+        if len(countries) == 0 {
+            countries = pricingCountries
+        }
+        inCountry := map[string]bool{}
+        for _, c := range countries {
+            inCountry[c] = true
+        }
+        type target struct {
+            Key     string
+            Country string
+            StoreID uint
+        }
+        var targets []target
+        if s.AllocateTo == AllocateToCountry {
+            for _, c := range countries {
+                targets = append(targets, target{Key: c, Country: c})
+            }
+        } else {
+            ids := map[string]bool{}
+            for _, id := range splitList(s.StoreIDs) {
+                ids[id] = true
+            }
+            for _, st := range a.stores {
+                key := strconv.Itoa(int(st.ID))
+                if len(ids) > 0 && !ids[key] {
+                    continue
+                }
+                if len(ids) == 0 && !inCountry[st.Country] {
+                    continue
+                }
+                targets = append(targets, target{Key: key, Country: st.Country, StoreID: st.ID})
+            }
+        }
+        if len(targets) == 0 {
+            a.warn("固定成本“%s”没有可分配的%s，未计入", s.Name, map[string]string{AllocateToCountry: "国家", AllocateToStore: "店铺"}[s.AllocateTo])
+            continue
+        }
+
+        weights := make([]float64, len(targets))
+        switch s.WeightMethod {
+        case WeightSales:
+            if salesByCountry == nil {
+                if salesByCountry, salesByStore, err = a.daySales(date); err != nil {
+                    return nil, err
+                }
+            }
+            for i, t := range targets {
+                if t.StoreID != 0 {
+                    weights[i] = salesByStore[t.StoreID]
+                } else {
+                    weights[i] = salesByCountry[t.Country]
+                }
+            }
+        case WeightCustom:
+            var w map[string]float64
+            json.Unmarshal([]byte(s.Weights), &w)
+            for i, t := range targets {
+                weights[i] = w[t.Key]
+            }
+        default:
+            for i := range weights {
+                weights[i] = 1
+            }
+        }
+        sum := 0.0
+        for _, w := range weights {
+            sum += w
+        }
+        if sum <= 0 {
+            // 当天没有销售额（或自定义权重都为 0）时退回平均分配，避免成本丢失
+            a.warn("固定成本“%s”在 %s 的%s合计为 0，改为平均分配", s.Name, date, fixedCostWeightLabels[s.WeightMethod])
+            for i := range weights {
+                weights[i] = 1
+            }
+            sum = float64(len(weights))
+        }
+
+        for i, t := range targets {
+            ratio := weights[i] / sum
+            if ratio == 0 {
+                continue
+            }
+            shares = append(shares, fixedCostShare{
+                ScheduleID: s.ID,
+                Name:       s.Name,
+                Category:   s.Category,
+                Date:       date,
+                Country:    t.Country,
+                StoreID:    t.StoreID,
+                Weight:     ratio,
+                Currency:   s.Currency,
+                Amount:     daily * ratio,
+                AmountCNY:  dailyCNY * ratio,
+            })
+        }
+    }
+    return shares, nil
+}
+
+// ScopeShares 某个国家（或店铺）当天分到的固定成本：
+// - 指定店铺：按店铺分配给该店铺的部分，加上按国家分配给其所在国家的部分在该国未封禁店铺间平均分摊；
+// - 只指定国家：分配给该国家及该国家各店铺的全部。
+func (a *fixedCostAllocator) ScopeShares(shares []fixedCostShare, country string, storeID uint) ([]fixedCostShare, float64) {
+    storeCount := 0
+    for _, st := range a.stores {
+        if st.Country == country {
+            storeCount++
+        }
+    }
+    var out []fixedCostShare
+    total := 0.0
+    for _, sh := range shares {
+        if sh.Country != country {
+            continue
+        }
+        if storeID != 0 {
+            if sh.StoreID != 0 && sh.StoreID != storeID {
+                continue
+            }
+            if sh.StoreID == 0 {
+                if storeCount == 0 {
+                    continue
+                }
+                sh.Weight /= float64(storeCount)
+                sh.Amount /= float64(storeCount)
+                sh.AmountCNY /= float64(storeCount)
+            }
+        }
+        sh.Amount = roundMoney(sh.Amount)
+        sh.AmountCNY = roundMoney(sh.AmountCNY)
+        out = append(out, sh)
+        total += sh.AmountCNY
+    }
+    return out, roundMoney(total)
+}
+
+// ListFixedCosts 固定成本列表，date=YYYY-MM-DD 时只返回当天生效的
+// GET /api/fixed_costs
+func ListFixedCosts(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        q := db.Model(&models.FixedCostSchedule{})
+        if v := strings.TrimSpace(c.Query("date")); v != "" {
+            q = q.Where("enabled = ? AND start_date <= ?", true, v).
+                Where("end_date = '' OR end_date >= ?", v)
+        }
+        var list []models.FixedCostSchedule
+        if err := q.Order("id asc").Find(&list).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"items": list, "frequencies": fixedCostFrequencyLabels, "weight_methods": fixedCostWeightLabels})
+    }
+}
+
+// SaveFixedCost 新增或修改固定成本（仅超级管理员），body 带 id 时为修改
+// POST /api/fixed_costs
+// 已保存的结算不会随之改变；金额调整建议给旧记录设置结束日期并新增一条，便于按日期追溯。
+func SaveFixedCost(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, _ := c.Get("role"); roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以维护固定成本"})
+            return
+        }
+        var body struct {
+            models.FixedCostSchedule
+            Enabled *bool `json:"enabled"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        s := body.FixedCostSchedule
+        // 未传 enabled 时默认启用
+        s.Enabled = body.Enabled == nil || *body.Enabled
+        if err := validateFixedCostSchedule(&s); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        var old models.FixedCostSchedule
+        if s.ID != 0 {
+            if err := db.First(&old, s.ID).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "固定成本不存在"})
+                return
+            }
+            s.CreatedAt = old.CreatedAt
+        }
+        // 折算汇率随记录保存，历史日期的分配不随实时汇率变化：
+        // 人民币为 1；未填写时沿用原记录同币种的汇率，否则记录当前实时汇率
+        switch {
+        case s.Currency == "CNY":
+            s.Rate = 1
+        case s.Rate > 0:
+        case old.Currency == s.Currency && old.Rate > 0:
+            s.Rate = old.Rate
+        default:
+            rates, err := utils.GetRates()
+            if err != nil || rates[s.Currency] <= 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "获取 " + s.Currency + " 汇率失败，请手动填写汇率（1 " + s.Currency + " ≈ ? 人民币）"})
+                return
+            }
+            s.Rate = 1 / rates[s.Currency]
+        }
+        if err := db.Save(&s).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, s)
+    }
+}
+
+// DeleteFixedCost 删除固定成本（仅超级管理员）；已保存的结算保留当时的固定成本金额
+// DELETE /api/fixed_costs/:id
+func DeleteFixedCost(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, _ := c.Get("role"); roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以维护固定成本"})
+            return
+        }
+        var s models.FixedCostSchedule
+        if err := db.First(&s, c.Param("id")).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "固定成本不存在"})
+            return
+        }
+        if err := db.Delete(&s).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"ok": true})
+    }
+}
+
+// FixedCostAllocation 查看一段日期内固定成本的每日分配明细及按国家 / 店铺的合计（人民币），最多 92 天
+// GET /api/fixed_costs/allocation?start=YYYY-MM-DD&end=YYYY-MM-DD
+func FixedCostAllocation(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        today := time.Now().Format("2006-01-02")
+        start := c.DefaultQuery("start", today)
+        end := c.DefaultQuery("end", start)
+        from, err1 := time.Parse("2006-01-02", start)
+        to, err2 := time.Parse("2006-01-02", end)
+        if err1 != nil || err2 != nil || to.Before(from) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "start / end 格式应为 YYYY-MM-DD，且 start 不晚于 end"})
+            return
+        }
+        if to.Sub(from) > 91*24*time.Hour {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "日期范围不能超过 92 天"})
+            return
+        }
+        a, err := newFixedCostAllocator(db, start, end)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        items := []fixedCostShare{}
+        byCountry := map[string]float64{}
+        byStore := map[uint]float64{}
+        total := 0.0
+        for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+            shares, err := a.Allocate(d.Format("2006-01-02"))
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            for _, sh := range shares {
+                byCountry[sh.Country] += sh.AmountCNY
+                if sh.StoreID != 0 {
+                    byStore[sh.StoreID] += sh.AmountCNY
+                }
+                total += sh.AmountCNY
+                sh.Amount = roundMoney(sh.Amount)
+                sh.AmountCNY = roundMoney(sh.AmountCNY)
+                items = append(items, sh)
+            }
+        }
+        for k, v := range byCountry {
+            byCountry[k] = roundMoney(v)
+        }
+        for k, v := range byStore {
+            byStore[k] = roundMoney(v)
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "start":      start,
+            "end":        end,
+            "items":      items,
+            "by_country": byCountry,
+            "by_store":   byStore,
+            "total":      roundMoney(total),
+            "warnings":   a.Warnings(),
+        })
+    }
+}
@@ -0,0 +1,629 @@
+package handlers
+
+import (
+    "fmt"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+)
+
+// 预测指标
+const (
+    ForecastSales    = "sales"    // 订单销售额（人民币）
+    ForecastQuantity = "quantity" // 订单商品件数
+    ForecastOrders   = "orders"   // 订单数（按订单号去重）
+    ForecastProfit   = "profit"   // 结算利润（人民币）
+    ForecastAdCost   = "ad_cost"  // 结算广告成本（人民币，AdDeduction）
+)
+
+var forecastMetricLabels = map[string]string{
+    ForecastSales:    "销售额",
+    ForecastQuantity: "销量",
+    ForecastOrders:   "订单数",
+    ForecastProfit:   "利润",
+    ForecastAdCost:   "广告成本",
+}
+
+// 预测模型
+const (
+    ForecastSeasonalNaive = "seasonal_naive"
+    ForecastMovingAverage = "moving_average"
+    ForecastHoltWinters   = "holt_winters"
+)
+
+// forecastSeason 季节周期：按星期几的规律，7 天
+const forecastSeason = 7
+
+// forecastZ 置信区间对应的正态分位数
+var forecastZ = map[int]float64{80: 1.2816, 90: 1.6449, 95: 1.96, 99: 2.5758}
+
+// forecastOptions 模型参数
+type forecastOptions struct {
+    Season int // 季节周期（天）
+    Window int // 移动平均窗口（天）
+}
+
+// forecastFit 模型拟合结果：未来 h 天的点预测及每一步的预测标准误
+type forecastFit struct {
+    Mean   []float64
+    SE     []float64
+    Sigma  float64            // 一步预测残差的均方根
+    Params map[string]float64 // 模型参数，便于解释
+}
+
+// forecastModel 一个可解释的预测模型
+type forecastModel struct {
+    Key         string
+    Label       string
+    Description string
+    Fit         func(y []float64, h int, opt forecastOptions) (*forecastFit, error)
+}
+
+var forecastModels = []forecastModel{
+    {
+        Key:         ForecastSeasonalNaive,
+        Label:       "季节性朴素",
+        Description: "未来每天等于上周同一天的值；区间按“本周 - 上周同日”的历史波动计算，每往后一周放大一次",
+        Fit:         fitSeasonalNaive,
+    },
+    {
+        Key:         ForecastMovingAverage,
+        Label:       "移动平均",
+        Description: "未来每天等于最近 N 天的平均值；区间按“当天 - 前 N 天平均”的历史波动计算",
+        Fit:         fitMovingAverage,
+    },
+    {
+        Key:         ForecastHoltWinters,
+        Label:       "Holt-Winters",
+        Description: "加法 Holt-Winters 指数平滑：分别平滑水平、趋势和星期规律，平滑系数按历史一步预测误差最小选取",
+        Fit:         fitHoltWinters,
+    },
+}
+
+func findForecastModel(key string) (forecastModel, bool) {
+    for _, m := range forecastModels {
+        if m.Key == key {
+            return m, true
+        }
+    }
+    return forecastModel{}, false
+}
+
+// rootMeanSquare 残差的均方根
+func rootMeanSquare(res []float64) float64 {
+    if len(res) == 0 {
+        return 0
+    }
+    sum := 0.0
+    for _, e := range res {
+        sum += e * e
+    }
+    return math.Sqrt(sum / float64(len(res)))
+}
+
+// fitSeasonalNaive 季节性朴素：ŷ(n+h) = y(n+h-m×k)，k 为覆盖 h 所需的周数；第 k 周的标准误为 σ×√k
+func fitSeasonalNaive(y []float64, h int, opt forecastOptions) (*forecastFit, error) {
+    m, n := opt.Season, len(y)
+    if n < m+2 {
+        return nil, fmt.Errorf("至少需要 %d 天历史数据", m+2)
+    }
+    res := make([]float64, 0, n-m)
+    for t := m; t < n; t++ {
+        res = append(res, y[t]-y[t-m])
+    }
+    sigma := rootMeanSquare(res)
+    fit := &forecastFit{Mean: make([]float64, h), SE: make([]float64, h), Sigma: sigma, Params: map[string]float64{"season": float64(m)}}
+    for i := 0; i < h; i++ {
+        fit.Mean[i] = y[n-m+i%m]
+        fit.SE[i] = sigma * math.Sqrt(float64(i/m+1))
+    }
+    return fit, nil
+}
+
+// fitMovingAverage 移动平均：ŷ = 最近 w 天平均；标准误为 σ×√(1+1/w)
+func fitMovingAverage(y []float64, h int, opt forecastOptions) (*forecastFit, error) {
+    w, n := opt.Window, len(y)
+    if n < w+2 {
+        return nil, fmt.Errorf("至少需要 %d 天历史数据", w+2)
+    }
+    sum := 0.0
+    for _, v := range y[:w] {
+        sum += v
+    }
+    res := make([]float64, 0, n-w)
+    for t := w; t < n; t++ {
+        res = append(res, y[t]-sum/float64(w))
+        sum += y[t] - y[t-w]
+    }
+    mean := sum / float64(w)
+    sigma := rootMeanSquare(res)
+    fit := &forecastFit{Mean: make([]float64, h), SE: make([]float64, h), Sigma: sigma, Params: map[string]float64{"window": float64(w)}}
+    for i := 0; i < h; i++ {
+        fit.Mean[i] = mean
+        fit.SE[i] = sigma * math.Sqrt(1+1/float64(w))
+    }
+    return fit, nil
+}
+
+// holtWintersRun 按给定平滑系数跑一遍加法 Holt-Winters，返回最终水平、趋势、季节项及一步预测残差
+func holtWintersRun(y []float64, m int, alpha, beta, gamma float64) (float64, float64, []float64, []float64) {
+    n := len(y)
+    // 初始值：第一周的平均为水平，前两周平均之差 / m 为趋势，第一周各天与平均的差为季节项
+    first, second := 0.0, 0.0
+    for i := 0; i < m; i++ {
+        first += y[i]
+        second += y[m+i]
+    }
+    first, second = first/float64(m), second/float64(m)
+    level, trend := first, (second-first)/float64(m)
+    season := make([]float64, n)
+    for i := 0; i < m; i++ {
+        season[i] = y[i] - first
+    }
+    res := make([]float64, 0, n-m)
+    for t := m; t < n; t++ {
+        res = append(res, y[t]-(level+trend+season[t-m]))
+        newLevel := alpha*(y[t]-season[t-m]) + (1-alpha)*(level+trend)
+        trend = beta*(newLevel-level) + (1-beta)*trend
+        season[t] = gamma*(y[t]-newLevel) + (1-gamma)*season[t-m]
+        level = newLevel
+    }
+    return level, trend, season, res
+}
+
+// fitHoltWinters 加法 Holt-Winters（周期 m）：在固定网格上选取一步预测误差平方和最小的 α、β、γ；
+// ŷ(n+h) = 水平 + h×趋势 + 对应星期的季节项，标准误按 σ×√(1+Σ(α(1+jβ)+γ·[j 是 m 的倍数])²) 计算
+func fitHoltWinters(y []float64, h int, opt forecastOptions) (*forecastFit, error) {
+    m, n := opt.Season, len(y)
+    if n < 2*m+2 {
+        return nil, fmt.Errorf("至少需要 %d 天历史数据", 2*m+2)
+    }
+    alphas := []float64{0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9}
+    betas := []float64{0, 0.01, 0.05, 0.1, 0.2}
+    gammas := []float64{0.05, 0.1, 0.2, 0.3, 0.5}
+    best, bestSSE := [3]float64{}, math.Inf(1)
+    for _, a := range alphas {
+        for _, b := range betas {
+            for _, g := range gammas {
+                _, _, _, res := holtWintersRun(y, m, a, b, g)
+                sse := 0.0
+                for _, e := range res {
+                    sse += e * e
+                }
+                if sse < bestSSE {
+                    best, bestSSE = [3]float64{a, b, g}, sse
+                }
+            }
+        }
+    }
+    alpha, beta, gamma := best[0], best[1], best[2]
+    level, trend, season, res := holtWintersRun(y, m, alpha, beta, gamma)
+    sigma := rootMeanSquare(res)
+    fit := &forecastFit{
+        Mean: make([]float64, h), SE: make([]float64, h), Sigma: sigma,
+        Params: map[string]float64{"alpha": alpha, "beta": beta, "gamma": gamma, "season": float64(m), "level": roundMoney(level), "trend": roundMoney(trend)},
+    }
+    variance := 1.0
+    for i := 0; i < h; i++ {
+        step := i + 1
+        fit.Mean[i] = level + float64(step)*trend + season[n-m+i%m]
+        if i > 0 {
+            c := alpha * (1 + float64(i)*beta)
+            if i%m == 0 {
+                c += gamma
+            }
+            variance += c * c
+        }
+        fit.SE[i] = sigma * math.Sqrt(variance)
+    }
+    return fit, nil
+}
+
+// forecastQuery 预测的数据范围
+type forecastQuery struct {
+    Metric   string
+    Country  string
+    SKU      string
+    Statuses []string // 结算指标统计的结算状态，为空时包含全部状态
+}
+
+// nonNegative 销售额、销量、订单数不会为负，预测值和区间下限截断到 0
+func (q forecastQuery) nonNegative() bool {
+    return q.Metric != ForecastProfit
+}
+
+// forecastSeries 加载 start ~ end 每天的历史值，没有数据的日期补 0
+func forecastSeries(db *gorm.DB, q forecastQuery, start, end time.Time) ([]string, []float64, []string, error) {
+    startStr, endStr := start.Format("2006-01-02"), end.Format("2006-01-02")
+    warnings := []string{}
+    byDate := map[string]float64{}
+
+    switch q.Metric {
+    case ForecastSales, ForecastQuantity, ForecastOrders:
+        oq := db.Model(&models.Order{}).
+            Where("DATE(created_at) >= ? AND DATE(created_at) <= ?", startStr, endStr).
+            Where("product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇")
+        if q.Country != "" {
+            oq = oq.Where("country = ?", q.Country)
+        }
+        if q.SKU != "" {
+            oq = oq.Where("product_id IN (?)", db.Model(&models.Product{}).Select("id").Where("sku = ?", q.SKU))
+        }
+        var rows []struct {
+            Date     string
+            Currency string
+            Value    float64
+        }
+        var sel string
+        switch q.Metric {
+        case ForecastSales:
+            sel = "IFNULL(SUM(total_amount), 0)"
+        case ForecastQuantity:
+            sel = "IFNULL(SUM(CASE WHEN quantity > 0 THEN quantity ELSE 0 END), 0)"
+        default:
+            sel = "COUNT(DISTINCT CASE WHEN order_no <> '' THEN order_no ELSE CONCAT('#', id) END)"
+        }
+        // 销售额按币种分组以便折算人民币，其余指标只按日期分组
+        group, currency := "DATE_FORMAT(created_at, '%Y-%m-%d')", "'' AS currency"
+        if q.Metric == ForecastSales {
+            group, currency = group+", currency", "currency"
+        }
+        if err := oq.Select("DATE_FORMAT(created_at, '%Y-%m-%d') AS date, " + currency + ", " + sel + " AS value").
+            Group(group).Scan(&rows).Error; err != nil {
+            return nil, nil, nil, err
+        }
+        // 只有销售额带币种，其余指标的币种为空，按原值合计
+        cv := newCNYConverter(nil)
+        for _, r := range rows {
+            byDate[r.Date] += cv.convert(r.Value, r.Currency)
+        }
+        warnings = append(warnings, cv.warnings()...)
+    case ForecastProfit, ForecastAdCost:
+        col := "profit"
+        if q.Metric == ForecastAdCost {
+            col = "ad_deduction"
+        }
+        sq := db.Model(&models.DailySettlement{}).Where("date >= ? AND date <= ?", startStr, endStr)
+        if q.Country != "" {
+            sq = sq.Where("country = ?", q.Country)
+        }
+        if len(q.Statuses) > 0 {
+            sq = sq.Where("status IN ?", q.Statuses)
+        }
+        var rows []struct {
+            Date  string
+            Value float64
+        }
+        if err := sq.Select("date, IFNULL(SUM(" + col + "), 0) AS value").Group("date").Scan(&rows).Error; err != nil {
+            return nil, nil, nil, err
+        }
+        for _, r := range rows {
+            byDate[r.Date] += r.Value
+        }
+    default:
+        return nil, nil, nil, fmt.Errorf("未知指标 %s", q.Metric)
+    }
+
+    var dates []string
+    var values []float64
+    for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+        ds := d.Format("2006-01-02")
+        dates = append(dates, ds)
+        values = append(values, roundMoney(byDate[ds]))
+    }
+    if len(byDate) < len(dates)/2 {
+        warnings = append(warnings, fmt.Sprintf("%d 天中只有 %d 天有数据，其余按 0 计算，预测可能偏低", len(dates), len(byDate)))
+    }
+    return dates, values, warnings, nil
+}
+
+// forecastPoint 某一天的预测值及置信区间
+type forecastPoint struct {
+    Date  string  `json:"date"`
+    Value float64 `json:"value"`
+    Lower float64 `json:"lower"`
+    Upper float64 `json:"upper"`
+}
+
+// forecastPoints 把拟合结果转换为带日期和置信区间的预测点
+func forecastPoints(fit *forecastFit, from time.Time, z float64, nonNegative bool) []forecastPoint {
+    points := make([]forecastPoint, len(fit.Mean))
+    for i, v := range fit.Mean {
+        p := forecastPoint{
+            Date:  from.AddDate(0, 0, i).Format("2006-01-02"),
+            Value: v,
+            Lower: v - z*fit.SE[i],
+            Upper: v + z*fit.SE[i],
+        }
+        if nonNegative {
+            p.Value, p.Lower, p.Upper = math.Max(p.Value, 0), math.Max(p.Lower, 0), math.Max(p.Upper, 0)
+        }
+        p.Value, p.Lower, p.Upper = roundMoney(p.Value), roundMoney(p.Lower), roundMoney(p.Upper)
+        points[i] = p
+    }
+    return points
+}
+
+// forecastRequest 预测 / 回测接口的公共参数
+type forecastRequest struct {
+    forecastQuery
+    Models  []forecastModel
+    Options forecastOptions
+    Horizon int
+    History int
+    End     time.Time
+    Level   int
+}
+
+// parseForecastRequest 解析公共参数：
+// metric=sales|quantity|orders|profit|ad_cost，country、sku 可选（利润和广告成本只能按国家），
+// model=all 或逗号分隔的 seasonal_naive / moving_average / holt_winters，horizon 预测天数（1~90，默认 defaultHorizon），
+// history 历史天数（默认 120，最多 730），end 历史截止日期（默认昨天），window 移动平均窗口（默认 7），
+// level 置信水平（80 / 90 / 95 / 99，默认 95），include_drafts=1 时结算指标包含草稿和已提交的结算。
+func parseForecastRequest(c *gin.Context, defaultHorizon string) (*forecastRequest, error) {
+    req := &forecastRequest{Options: forecastOptions{Season: forecastSeason}}
+    req.Metric = c.DefaultQuery("metric", ForecastSales)
+    if _, ok := forecastMetricLabels[req.Metric]; !ok {
+        return nil, fmt.Errorf("metric 只能是 sales / quantity / orders / profit / ad_cost")
+    }
+    req.Country = strings.TrimSpace(c.Query("country"))
+    if req.Country != "" {
+        if _, ok := countryCurrency[req.Country]; !ok {
+            return nil, fmt.Errorf("未知国家 %s", req.Country)
+        }
+    }
+    req.SKU = strings.TrimSpace(c.Query("sku"))
+    if req.SKU != "" && (req.Metric == ForecastProfit || req.Metric == ForecastAdCost) {
+        return nil, fmt.Errorf("利润和广告成本来自结算记录，只能按国家预测")
+    }
+    req.Statuses = settlementFinalStatuses
+    if v := c.Query("include_drafts"); v == "1" || v == "true" {
+        req.Statuses = nil
+    }
+
+    switch key := c.DefaultQuery("model", "all"); key {
+    case "all":
+        req.Models = forecastModels
+    default:
+        for _, k := range strings.Split(key, ",") {
+            m, ok := findForecastModel(strings.TrimSpace(k))
+            if !ok {
+                return nil, fmt.Errorf("未知模型 %s", k)
+            }
+            req.Models = append(req.Models, m)
+        }
+    }
+
+    var err error
+    if req.Horizon, err = strconv.Atoi(c.DefaultQuery("horizon", defaultHorizon)); err != nil || req.Horizon < 1 || req.Horizon > 90 {
+        return nil, fmt.Errorf("horizon 应为 1~90 的整数")
+    }
+    if req.History, err = strconv.Atoi(c.DefaultQuery("history", "120")); err != nil || req.History < 14 || req.History > 730 {
+        return nil, fmt.Errorf("history 应为 14~730 的整数")
+    }
+    if req.Options.Window, err = strconv.Atoi(c.DefaultQuery("window", "7")); err != nil || req.Options.Window < 1 || req.Options.Window > 60 {
+        return nil, fmt.Errorf("window 应为 1~60 的整数")
+    }
+    if req.Level, err = strconv.Atoi(c.DefaultQuery("level", "95")); err != nil || forecastZ[req.Level] == 0 {
+        return nil, fmt.Errorf("level 只能是 80 / 90 / 95 / 99")
+    }
+    if req.End, err = time.Parse("2006-01-02", c.DefaultQuery("end", time.Now().AddDate(0, 0, -1).Format("2006-01-02"))); err != nil {
+        return nil, fmt.Errorf("end 格式应为 YYYY-MM-DD")
+    }
+    return req, nil
+}
+
+// forecastModelResult 单个模型的预测结果
+type forecastModelResult struct {
+    Model       string             `json:"model"`
+    Label       string             `json:"label"`
+    Description string             `json:"description"`
+    Params      map[string]float64 `json:"params,omitempty"`
+    Sigma       float64            `json:"sigma"`
+    Points      []forecastPoint    `json:"points"`
+    Error       string             `json:"error,omitempty"` // 历史数据不足等原因无法拟合
+}
+
+// Forecast 按国家或 SKU 的每日历史数据拟合季节性朴素、移动平均、Holt-Winters 模型，返回未来 N 天的预测及置信区间（管理员及以上）
+// GET /api/forecast?metric=sales&country=&sku=&model=all&horizon=14&history=120&level=95&window=7
+func Forecast(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看预测"})
+            return
+        }
+        req, err := parseForecastRequest(c, "14")
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        start := req.End.AddDate(0, 0, -(req.History - 1))
+        dates, values, warnings, err := forecastSeries(db, req.forecastQuery, start, req.End)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        history := make([]gin.H, len(dates))
+        for i := range dates {
+            history[i] = gin.H{"date": dates[i], "value": values[i]}
+        }
+        results := make([]forecastModelResult, 0, len(req.Models))
+        for _, m := range req.Models {
+            r := forecastModelResult{Model: m.Key, Label: m.Label, Description: m.Description, Points: []forecastPoint{}}
+            fit, err := m.Fit(values, req.Horizon, req.Options)
+            if err != nil {
+                r.Error = err.Error()
+            } else {
+                r.Params, r.Sigma = fit.Params, roundMoney(fit.Sigma)
+                r.Points = forecastPoints(fit, req.End.AddDate(0, 0, 1), forecastZ[req.Level], req.nonNegative())
+            }
+            results = append(results, r)
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "metric":       req.Metric,
+            "metric_label": forecastMetricLabels[req.Metric],
+            "country":      req.Country,
+            "sku":          req.SKU,
+            "level":        req.Level,
+            "history":      history,
+            "models":       results,
+            "warnings":     warnings,
+        })
+    }
+}
+
+// forecastAccuracy 回测误差
+type forecastAccuracy struct {
+    Points   int      `json:"points"`
+    MAE      float64  `json:"mae"`      // 平均绝对误差
+    RMSE     float64  `json:"rmse"`     // 均方根误差
+    MAPE     *float64 `json:"mape"`     // 平均绝对百分比误差 %（忽略实际值为 0 的天）
+    Bias     float64  `json:"bias"`     // 平均偏差（预测 - 实际），正数表示偏高
+    Coverage float64  `json:"coverage"` // 实际值落在置信区间内的比例 %
+}
+
+type forecastErrors struct {
+    n, pctN, covered              int
+    absSum, sqSum, pctSum, errSum float64
+}
+
+func (e *forecastErrors) add(p forecastPoint, actual float64) {
+    diff := p.Value - actual
+    e.n++
+    e.absSum += math.Abs(diff)
+    e.sqSum += diff * diff
+    e.errSum += diff
+    if actual != 0 {
+        e.pctN++
+        e.pctSum += math.Abs(diff / actual)
+    }
+    if actual >= p.Lower && actual <= p.Upper {
+        e.covered++
+    }
+}
+
+func (e *forecastErrors) accuracy() forecastAccuracy {
+    a := forecastAccuracy{Points: e.n}
+    if e.n == 0 {
+        return a
+    }
+    n := float64(e.n)
+    a.MAE = roundMoney(e.absSum / n)
+    a.RMSE = roundMoney(math.Sqrt(e.sqSum / n))
+    a.Bias = roundMoney(e.errSum / n)
+    a.Coverage = roundMoney(float64(e.covered) / n * 100)
+    if e.pctN > 0 {
+        mape := roundMoney(e.pctSum / float64(e.pctN) * 100)
+        a.MAPE = &mape
+    }
+    return a
+}
+
+// ForecastBacktest 滚动回测：把历史最后 folds × horizon 天依次作为检验期，只用检验期之前的数据拟合并预测，
+// 统计各模型的误差（MAE / RMSE / MAPE / 偏差 / 区间覆盖率），按 MAE 从小到大排序（管理员及以上）
+// GET /api/forecast/backtest?metric=sales&country=&sku=&model=all&horizon=7&folds=4&history=120&level=95
+func ForecastBacktest(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看预测"})
+            return
+        }
+        req, err := parseForecastRequest(c, "7")
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        folds, err := strconv.Atoi(c.DefaultQuery("folds", "4"))
+        if err != nil || folds < 1 || folds > 12 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "folds 应为 1~12 的整数"})
+            return
+        }
+        start := req.End.AddDate(0, 0, -(req.History - 1))
+        dates, values, warnings, err := forecastSeries(db, req.forecastQuery, start, req.End)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        n := len(values)
+        if n-folds*req.Horizon < forecastSeason+2 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "历史天数不足以按当前 horizon 和 folds 回测，请增大 history 或减小 folds"})
+            return
+        }
+
+        type foldResult struct {
+            TrainEnd  string           `json:"train_end"`
+            TestStart string           `json:"test_start"`
+            TestEnd   string           `json:"test_end"`
+            Accuracy  forecastAccuracy `json:"accuracy"`
+        }
+        type modelResult struct {
+            Model       string           `json:"model"`
+            Label       string           `json:"label"`
+            Description string           `json:"description"`
+            Accuracy    forecastAccuracy `json:"accuracy"`
+            Folds       []foldResult     `json:"folds"`
+            Error       string           `json:"error,omitempty"`
+        }
+        z := forecastZ[req.Level]
+        results := make([]modelResult, 0, len(req.Models))
+        for _, m := range req.Models {
+            r := modelResult{Model: m.Key, Label: m.Label, Description: m.Description, Folds: []foldResult{}}
+            var total forecastErrors
+            for k := folds; k >= 1; k-- {
+                cut := n - k*req.Horizon
+                fit, err := m.Fit(values[:cut], req.Horizon, req.Options)
+                if err != nil {
+                    r.Error = err.Error()
+                    continue
+                }
+                from, _ := time.Parse("2006-01-02", dates[cut])
+                points := forecastPoints(fit, from, z, req.nonNegative())
+                var fe forecastErrors
+                for i, p := range points {
+                    fe.add(p, values[cut+i])
+                    total.add(p, values[cut+i])
+                }
+                r.Folds = append(r.Folds, foldResult{
+                    TrainEnd: dates[cut-1], TestStart: dates[cut], TestEnd: dates[cut+req.Horizon-1], Accuracy: fe.accuracy(),
+                })
+            }
+            r.Accuracy = total.accuracy()
+            results = append(results, r)
+        }
+        // 有结果的模型按 MAE 从小到大排在前面
+        sort.SliceStable(results, func(i, j int) bool {
+            ai, aj := results[i].Accuracy, results[j].Accuracy
+            if (ai.Points > 0) != (aj.Points > 0) {
+                return ai.Points > 0
+            }
+            return ai.MAE < aj.MAE
+        })
+        best := ""
+        if len(results) > 0 && results[0].Accuracy.Points > 0 {
+            best = results[0].Model
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "metric":       req.Metric,
+            "metric_label": forecastMetricLabels[req.Metric],
+            "country":      req.Country,
+            "sku":          req.SKU,
+            "horizon":      req.Horizon,
+            "folds":        folds,
+            "level":        req.Level,
+            "models":       results,
+            "best":         best,
+            "warnings":     warnings,
+        })
+    }
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+    "math"
+    "testing"
+)
+
+// testSeries 生成 n 天的序列：f(t) 为第 t 天（从 0 开始）的值
+func testSeries(n int, f func(t int) float64) []float64 {
+    y := make([]float64, n)
+    for t := range y {
+        y[t] = f(t)
+    }
+    return y
+}
+
+// testWeekPattern 每周的规律，合计为 0，便于核对移动平均
+var testWeekPattern = []float64{-30, -10, 0, 10, 20, 40, -30}
+
+func TestForecastFits(t *testing.T) {
+    const n, h = 28, 14
+    opt := forecastOptions{Season: forecastSeason, Window: 7}
+    constant := testSeries(n, func(int) float64 { return 100 })
+    linear := testSeries(n, func(t int) float64 { return 10 + 2*float64(t) })
+    weekly := testSeries(n, func(t int) float64 { return 100 + testWeekPattern[t%7] })
+
+    cases := []struct {
+        name  string
+        fit   func(y []float64, h int, opt forecastOptions) (*forecastFit, error)
+        y     []float64
+        want  func(i int) float64 // 第 i 步（从 0 开始）的期望预测值
+        tol   float64
+        sigma float64 // 期望的残差均方根，NaN 表示不检查
+    }{
+        {"季节性朴素 常数", fitSeasonalNaive, constant, func(int) float64 { return 100 }, 1e-9, 0},
+        // 线性趋势下只重复最后一周，残差为一周的增量 14
+        {"季节性朴素 线性趋势", fitSeasonalNaive, linear, func(i int) float64 { return linear[n-7+i%7] }, 1e-9, 14},
+        {"季节性朴素 周规律", fitSeasonalNaive, weekly, func(i int) float64 { return weekly[n+i-7*(i/7+1)] }, 1e-9, 0},
+
+        {"移动平均 常数", fitMovingAverage, constant, func(int) float64 { return 100 }, 1e-9, 0},
+        // 最近 7 天的平均等于第 n-4 天的值，每天比前 7 天平均高 4 天的增量
+        {"移动平均 线性趋势", fitMovingAverage, linear, func(int) float64 { return linear[n-4] }, 1e-9, 8},
+        {"移动平均 周规律", fitMovingAverage, weekly, func(int) float64 { return 100 }, 1e-9, rootMeanSquare(testWeekPattern)},
+
+        {"Holt-Winters 常数", fitHoltWinters, constant, func(int) float64 { return 100 }, 1e-9, 0},
+        // 初始季节项吸收了第一周的趋势，预测值允许偏离直线 2 以内
+        {"Holt-Winters 线性趋势", fitHoltWinters, linear, func(i int) float64 { return 10 + 2*float64(n+i) }, 2, math.NaN()},
+        {"Holt-Winters 周规律", fitHoltWinters, weekly, func(i int) float64 { return 100 + testWeekPattern[(n+i)%7] }, 1e-9, 0},
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            fit, err := tc.fit(tc.y, h, opt)
+            if err != nil {
+                t.Fatal(err)
+            }
+            if len(fit.Mean) != h || len(fit.SE) != h {
+                t.Fatalf("预测 %d 天、标准误 %d 天，期望 %d 天", len(fit.Mean), len(fit.SE), h)
+            }
+            for i, v := range fit.Mean {
+                if want := tc.want(i); math.Abs(v-want) > tc.tol {
+                    t.Errorf("第 %d 天预测 %v，期望 %v", i+1, v, want)
+                }
+            }
+            if !math.IsNaN(tc.sigma) && math.Abs(fit.Sigma-tc.sigma) > 1e-9 {
+                t.Errorf("残差均方根 %v，期望 %v", fit.Sigma, tc.sigma)
+            }
+            // 标准误随预测步数单调不减
+            for i := 1; i < h; i++ {
+                if fit.SE[i] < fit.SE[i-1]-1e-9 {
+                    t.Errorf("第 %d 天标准误 %v 小于前一天 %v", i+1, fit.SE[i], fit.SE[i-1])
+                }
+            }
+        })
+    }
+}
+
+func TestForecastFitsTrendAndIntervals(t *testing.T) {
+    opt := forecastOptions{Season: forecastSeason, Window: 7}
+    linear := testSeries(28, func(t int) float64 { return 10 + 2*float64(t) })
+    fit, err := fitHoltWinters(linear, 14, opt)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if trend := fit.Params["trend"]; math.Abs(trend-2) > 0.01 {
+        t.Errorf("Holt-Winters 趋势 %v，期望 2", trend)
+    }
+    // 相隔一周的预测值相差 7 × 趋势
+    for i := 0; i+7 < len(fit.Mean); i++ {
+        if d := fit.Mean[i+7] - fit.Mean[i]; math.Abs(d-14) > 0.01 {
+            t.Errorf("第 %d 天与一周后的预测相差 %v，期望 14", i+1, d)
+        }
+    }
+
+    // 季节性朴素第 k 周的标准误为 σ×√k
+    sn, err := fitSeasonalNaive(linear, 14, opt)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if sn.SE[0] != 14 || math.Abs(sn.SE[7]-14*math.Sqrt2) > 1e-9 {
+        t.Errorf("季节性朴素标准误 %v / %v，期望 14 / %v", sn.SE[0], sn.SE[7], 14*math.Sqrt2)
+    }
+    // 移动平均各步标准误相同：σ×√(1+1/w)
+    ma, err := fitMovingAverage(linear, 14, opt)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := 8 * math.Sqrt(1+1.0/7); math.Abs(ma.SE[0]-want) > 1e-9 || ma.SE[13] != ma.SE[0] {
+        t.Errorf("移动平均标准误 %v / %v，期望 %v", ma.SE[0], ma.SE[13], want)
+    }
+}
+
+func TestForecastFitsShortHistory(t *testing.T) {
+    opt := forecastOptions{Season: forecastSeason, Window: 7}
+    y := testSeries(8, func(int) float64 { return 1 })
+    for name, fit := range map[string]func([]float64, int, forecastOptions) (*forecastFit, error){
+        ForecastSeasonalNaive: fitSeasonalNaive,
+        ForecastMovingAverage: fitMovingAverage,
+        ForecastHoltWinters:   fitHoltWinters,
+    } {
+        if _, err := fit(y, 7, opt); err == nil {
+            t.Errorf("%s 历史只有 8 天时应报错", name)
+        }
+    }
+}
@@ -4,10 +4,10 @@ import (
     "bytes"
     "encoding/csv"
     "encoding/json"
+    "errors"
     "fmt"
     "net/http"
     "os"
-    "path/filepath"
     "sort"
     "strconv"
     "strings"
@@ -20,11 +20,110 @@ import (
     "ordercount/internal/models"
 )
 
+type productComponentItem struct {
+    ComponentID uint    `json:"component_id"`
+    SKU         string  `json:"sku"`
+    Name        string  `json:"name"`
+    Quantity    int     `json:"quantity"`
+    UnitCost    float64 `json:"unit_cost"`
+    Subtotal    float64 `json:"subtotal"`
+}
+
+// productListItem 商品列表项
+type productListItem struct {
+    ID        uint      `json:"id"`
+    SKU       string    `json:"sku"`
+    Name      string    `json:"name"`
+    ImageURL  string    `json:"image_url"`
+    // 上传时自动生成的缩略图和 WebP，旧图片为空
+    ThumbURL  string    `json:"thumb_url,omitempty"`
+    WebPURL   string    `json:"webp_url,omitempty"`
+    // cost 为“当前角色可见的成本”（按成本档位的回退链解析）
+    Cost      float64   `json:"cost"`
+    // 当前角色有权查看的其他档位中单独配置过的成本（档位 -> 成本），用于配置；
+    // 同时展开为 cost_<档位> 字段（例如 cost_admin、cost_staff），兼容旧版前端
+    RoleCosts map[string]float64 `json:"role_costs,omitempty"`
+    CostingMethod string `json:"costing_method"`
+    CategoryID uint     `json:"category_id"`
+    Tags      []string  `json:"tags"`
+    Status          string     `json:"status"`
+    StatusReason    string     `json:"status_reason,omitempty"`
+    StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+    // 组合商品的组件明细（成本为当前角色可见的成本），普通商品为空
+    IsBundle   bool                   `json:"is_bundle"`
+    Components []productComponentItem `json:"components,omitempty"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (it productListItem) MarshalJSON() ([]byte, error) {
+    type plain productListItem
+    data, err := json.Marshal(plain(it))
+    if err != nil || len(it.RoleCosts) == 0 {
+        return data, err
+    }
+    var m map[string]json.RawMessage
+    if err := json.Unmarshal(data, &m); err != nil {
+        return nil, err
+    }
+    for tier, v := range it.RoleCosts {
+        if tier == BaseCostTier {
+            continue
+        }
+        b, _ := json.Marshal(v)
+        m["cost_"+tier] = b
+    }
+    return json.Marshal(m)
+}
+
 // 商品列表（简单分页/全部）
 func ListProducts(db *gorm.DB) gin.HandlerFunc {
     return func(c *gin.Context) {
+        q := db.Model(&models.Product{})
+        // 按分类筛选（默认包含子分类，include_children=0 时只查当前分类）；category_id=0 表示未分类
+        if cidStr := c.Query("category_id"); cidStr != "" {
+            cid, err := strconv.Atoi(cidStr)
+            if err != nil || cid < 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "invalid category_id"})
+                return
+            }
+            ids := []uint{uint(cid)}
+            if cid > 0 && c.Query("include_children") != "0" {
+                _, nodes, err := loadCategoryTree(db)
+                if err != nil {
+                    c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                    return
+                }
+                if n, ok := nodes[uint(cid)]; ok {
+                    ids = n.descendantIDs()
+                }
+            }
+            q = q.Where("category_id IN ?", ids)
+        }
+        // 按标签筛选，多个标签用逗号分隔，需同时包含
+        for _, t := range splitTags(c.Query("tag")) {
+            q = q.Where("FIND_IN_SET(?, tags)", t)
+        }
+        if kw := strings.TrimSpace(c.Query("keyword")); kw != "" {
+            q = q.Where("sku LIKE ? OR name LIKE ?", "%"+kw+"%", "%"+kw+"%")
+        }
+        // 按状态筛选：status=active,paused（逗号分隔）或 status=all；默认不返回停售商品
+        statuses, all, err := parseStatusFilter(c.Query("status"))
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if !all {
+            if containsString(statuses, ProductActive) {
+                // 旧数据状态可能为空，视为在售
+                q = q.Where("status IN ? OR status = '' OR status IS NULL", statuses)
+            } else {
+                q = q.Where("status IN ?", statuses)
+            }
+        }
+
         var products []models.Product
-        if err := db.Order("created_at desc").Find(&products).Error; err != nil {
+        if err := q.Order("created_at desc").Find(&products).Error; err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
@@ -32,49 +131,85 @@ func ListProducts(db *gorm.DB) gin.HandlerFunc {
         roleVal, _ := c.Get("role")
         roleStr, _ := roleVal.(string)
 
-        type item struct {
-            ID        uint      `json:"id"`
-            SKU       string    `json:"sku"`
-            Name      string    `json:"name"`
-            ImageURL  string    `json:"image_url"`
-            // cost 为“当前角色可见的成本”
-            Cost      float64   `json:"cost"`
-            // 仅超级管理员需要看到各角色的成本详情，用于配置
-            CostAdmin float64   `json:"cost_admin,omitempty"`
-            CostStaff float64   `json:"cost_staff,omitempty"`
-            CreatedAt time.Time `json:"created_at"`
-            UpdatedAt time.Time `json:"updated_at"`
-        }
-
-        res := make([]item, 0, len(products))
+        // 组合商品的组成明细，组件可能不在当前筛选结果中，单独查询
+        g, err := loadBundleGraph(db)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        componentProducts := map[uint]models.Product{}
+        var componentIDs []uint
         for _, p := range products {
-            // 默认使用基础成本
-            visibleCost := p.Cost
-            switch roleStr {
-            case "admin":
-                if p.CostAdmin != 0 {
-                    visibleCost = p.CostAdmin
-                }
-            case "staff":
-                if p.CostStaff != 0 {
-                    visibleCost = p.CostStaff
-                }
+            for _, e := range g.children[p.ID] {
+                componentIDs = append(componentIDs, e.ID)
+            }
+        }
+        if len(componentIDs) > 0 {
+            var list []models.Product
+            if err := db.Where("id IN ?", componentIDs).Find(&list).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
             }
+            for _, cp := range list {
+                componentProducts[cp.ID] = cp
+            }
+        }
 
-            it := item{
+        costIDs := make([]uint, 0, len(products)+len(componentIDs))
+        for _, p := range products {
+            costIDs = append(costIDs, p.ID)
+        }
+        costs, err := loadCostResolver(db, append(costIDs, componentIDs...))
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        own := ownCostTier(roleStr)
+        visibleTiers := visibleCostTiers(roleStr)
+
+        res := make([]productListItem, 0, len(products))
+        for _, p := range products {
+            it := productListItem{
                 ID:        p.ID,
                 SKU:       p.SKU,
                 Name:      p.Name,
-                ImageURL:  p.ImageURL,
-                Cost:      visibleCost,
+                ImageURL:  resolveUploadURL(p.ImageURL),
+                Cost:      costs.ForRole(p, roleStr),
+                CostingMethod: p.CostingMethod,
+                CategoryID: p.CategoryID,
+                Tags:      splitTags(p.Tags),
+                Status:    productStatus(p),
+                StatusReason: p.StatusReason,
+                StatusChangedAt: p.StatusChangedAt,
                 CreatedAt: p.CreatedAt,
                 UpdatedAt: p.UpdatedAt,
             }
-            // 超级管理员需要看到三个角色的成本明细，便于配置
-            if roleStr == "superadmin" {
-                it.CostAdmin = p.CostAdmin
-                it.CostStaff = p.CostStaff
+            // 有权查看的其他档位只返回单独配置的值，未配置时前端按回退显示
+            for _, tier := range visibleTiers {
+                if tier == own {
+                    continue
+                }
+                if v, ok := costs.Raw(p, tier); ok {
+                    if it.RoleCosts == nil {
+                        it.RoleCosts = map[string]float64{}
+                    }
+                    it.RoleCosts[tier] = v
+                }
+            }
+            it.ThumbURL, it.WebPURL = productImageVariants(p.ImageURL)
+            for _, e := range g.children[p.ID] {
+                cp := componentProducts[e.ID]
+                unit := costs.ForRole(cp, roleStr)
+                it.Components = append(it.Components, productComponentItem{
+                    ComponentID: e.ID,
+                    SKU:         cp.SKU,
+                    Name:        cp.Name,
+                    Quantity:    e.Quantity,
+                    UnitCost:    unit,
+                    Subtotal:    roundMoney(unit * float64(e.Quantity)),
+                })
             }
+            it.IsBundle = len(it.Components) > 0
             res = append(res, it)
         }
 
@@ -90,13 +225,23 @@ func SaveProduct(db *gorm.DB) gin.HandlerFunc {
             SKU       string   `json:"sku"`
             Name      string   `json:"name"`
             ImageURL  string   `json:"image_url"`
-            // 对于非超级管理员，这里的 cost 表示“当前角色看到/维护的成本”
-            Cost      float64  `json:"cost"`
-            // 超级管理员可以一次性配置不同角色的成本
-            CostAdmin *float64 `json:"cost_admin"`
-            CostStaff *float64 `json:"cost_staff"`
+            // 成本字段（cost / cost_<档位> / role_costs）由 parseCostEdits 按成本档位配置单独解析
+            // 成本核算方式（fifo / wavg / 空），仅超级管理员可配置
+            CostingMethod *string `json:"costing_method"`
+            // 分类与标签，nil 表示不修改
+            CategoryID *uint    `json:"category_id"`
+            Tags      []string `json:"tags"`
+            // 组合商品的组件，nil 表示不修改，空数组表示取消组合
+            Components *[]bundleComponentInput `json:"components"`
+            // 新建商品的初始状态（draft / active，默认 active）；已有商品的状态通过 /products/:id/status 修改
+            Status string `json:"status"`
+        }
+        raw, err := c.GetRawData()
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
         }
-        if err := c.ShouldBindJSON(&body); err != nil {
+        if err := json.Unmarshal(raw, &body); err != nil {
             c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
             return
         }
@@ -113,6 +258,12 @@ func SaveProduct(db *gorm.DB) gin.HandlerFunc {
             c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以维护商品信息"})
             return
         }
+        // cost 表示当前角色自己的成本档位，无权修改的档位忽略并在返回结果中提示
+        edits, denied, err := parseCostEdits(raw, roleStr)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
 
         var p models.Product
         if body.ID != 0 {
@@ -121,37 +272,139 @@ func SaveProduct(db *gorm.DB) gin.HandlerFunc {
                 return
             }
         }
+        // 停售商品的成本冻结，保证历史订单的成本和报表不变（提交的成本与当前一致时忽略）
+        if body.ID != 0 && productStatus(p) == ProductDiscontinued {
+            costs, err := loadCostResolver(db, []uint{p.ID})
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            if edits.changes(costs, p) || body.Components != nil || body.SKU != p.SKU ||
+                (body.CostingMethod != nil && strings.TrimSpace(*body.CostingMethod) != p.CostingMethod) {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "停售商品的 SKU 和成本已冻结，如需修改请先恢复在售"})
+                return
+            }
+            edits = costEdits{}
+        }
+        if body.ID == 0 {
+            switch body.Status {
+            case "", ProductActive:
+                p.Status = ProductActive
+            case ProductDraft:
+                p.Status = ProductDraft
+            default:
+                c.JSON(http.StatusBadRequest, gin.H{"error": "新建商品的状态只能是 draft 或 active"})
+                return
+            }
+            now := time.Now()
+            p.StatusChangedAt = &now
+        }
+        oldSKU, oldMethod, oldCost := p.SKU, p.CostingMethod, p.Cost
         p.SKU = body.SKU
         p.Name = body.Name
-        p.ImageURL = body.ImageURL
-        // 按角色写入对应的成本字段
-        switch roleStr {
-        case "superadmin":
-            // 超级管理员可以配置所有角色成本
-            p.Cost = body.Cost
-            if body.CostAdmin != nil {
-                p.CostAdmin = *body.CostAdmin
-            }
-            if body.CostStaff != nil {
-                p.CostStaff = *body.CostStaff
-            }
-        case "admin":
-            // 管理员只能维护自己的成本
-            p.CostAdmin = body.Cost
-        default:
-            // 员工不允许直接修改成本；新建时确保成本字段为 0
-            if body.ID == 0 {
-                p.Cost = 0
-                p.CostAdmin = 0
-                p.CostStaff = 0
-            }
-        }
-
-        if err := db.Save(&p).Error; err != nil {
+        // 对象存储下前端拿到的是预签名地址，入库前还原为统一的 /uploads/ 地址
+        p.ImageURL = normalizeUploadURL(body.ImageURL)
+        if v := edits[BaseCostTier]; v != nil {
+            p.Cost = *v
+        }
+        if roleStr == "superadmin" && body.CostingMethod != nil {
+            m := strings.TrimSpace(*body.CostingMethod)
+            if m != "" && m != CostingFIFO && m != CostingWAvg {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "成本核算方式只能是 fifo 或 wavg"})
+                return
+            }
+            p.CostingMethod = m
+        }
+        if body.CategoryID != nil {
+            if *body.CategoryID != 0 {
+                var cnt int64
+                if err := db.Model(&models.Category{}).Where("id = ?", *body.CategoryID).Count(&cnt).Error; err != nil {
+                    c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                    return
+                }
+                if cnt == 0 {
+                    c.JSON(http.StatusBadRequest, gin.H{"error": "分类不存在"})
+                    return
+                }
+            }
+            p.CategoryID = *body.CategoryID
+        }
+        if body.Tags != nil {
+            p.Tags = normalizeTags(body.Tags)
+        }
+        var components []models.ProductComponent
+        if body.Components != nil {
+            list, err := validateBundleComponents(db, p.ID, *body.Components)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+            }
+            components = list
+        }
+
+        // 组成变化前后涉及的底层组件都需要重新核算（库存出库来源变化）
+        g, err := loadBundleGraph(db)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        var recostIDs []uint
+        if p.ID != 0 && body.Components != nil {
+            for id := range g.leaves(p.ID) {
+                recostIDs = append(recostIDs, id)
+            }
+        }
+
+        err = db.Transaction(func(tx *gorm.DB) error {
+            if err := tx.Save(&p).Error; err != nil {
+                return err
+            }
+            if err := saveRoleCosts(tx, p.ID, edits); err != nil {
+                return err
+            }
+            if body.Components == nil {
+                return nil
+            }
+            if err := tx.Where("product_id = ?", p.ID).Delete(&models.ProductComponent{}).Error; err != nil {
+                return err
+            }
+            for i := range components {
+                components[i].ProductID = p.ID
+            }
+            if len(components) > 0 {
+                return tx.Create(&components).Error
+            }
+            return nil
+        })
+        if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
-        c.JSON(http.StatusOK, p)
+
+        // 组合商品的成本由组件汇总；组件成本变化也会影响包含它的组合商品
+        if _, err := rollupBundleCosts(db); err != nil {
+            log.Printf("[bundle] 汇总组合商品成本失败：%v", err)
+        }
+        db.First(&p, p.ID)
+
+        // SKU 变化会影响订单匹配；核算方式、基础成本或组成变化会影响订单货款成本，都需要重新核算
+        if body.Components != nil || p.CostingMethod != oldMethod || p.Cost != oldCost {
+            recostIDs = append(recostIDs, p.ID)
+        }
+        var costErr error
+        if p.SKU != oldSKU {
+            costErr = refreshOrderCosts(db, []string{oldSKU, p.SKU}, recostIDs...)
+        } else {
+            costErr = recostProducts(db, recostIDs...)
+        }
+        warnings := costWarnings(costErr)
+        if len(denied) > 0 {
+            warnings = append(warnings, fmt.Sprintf("无权修改成本档位 %s，已忽略", strings.Join(denied, "、")))
+        }
+        c.JSON(http.StatusOK, struct {
+            models.Product
+            Warnings []string `json:"warnings,omitempty"`
+        }{p, warnings})
     }
 }
 
@@ -169,45 +422,86 @@ func DeleteProduct(db *gorm.DB) gin.HandlerFunc {
             c.JSON(http.StatusBadRequest, gin.H{"error": "id required"})
             return
         }
-        if err := db.Delete(&models.Product{}, id).Error; err != nil {
+        var p models.Product
+        if err := db.First(&p, id).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "商品不存在"})
+            return
+        }
+        // 仍被组合商品引用的组件不能删除
+        var parents []models.ProductComponent
+        if err := db.Where("component_id = ?", p.ID).Find(&parents).Error; err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
-        c.JSON(http.StatusOK, gin.H{"ok": true})
-    }
-}
-
-// 上传商品图片，返回可访问的 URL
-func UploadProductImage() gin.HandlerFunc {
-    return func(c *gin.Context) {
-        file, err := c.FormFile("file")
-        if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
+        if len(parents) > 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("该商品是 %d 个组合商品的组件，请先从组合中移除", len(parents))})
             return
         }
-
-        // 确保目录存在
-        uploadDir := "uploads/products"
-        if err := os.MkdirAll(uploadDir, 0755); err != nil {
+        // 有历史订单的商品不物理删除，改为停售归档，保留成本供历史订单统计
+        var orderCount int64
+        if err := db.Model(&models.Order{}).Where("product_id = ?", p.ID).Count(&orderCount).Error; err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
-
-        // 使用时间戳+原始扩展名生成文件名
-        ext := filepath.Ext(file.Filename)
-        if ext == "" {
-            ext = ".jpg"
+        if orderCount > 0 {
+            if productStatus(p) == ProductDiscontinued {
+                c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("该商品已归档且有 %d 条历史订单，不能删除", orderCount)})
+                return
+            }
+            userIDVal, _ := c.Get("userID")
+            uid, _ := userIDVal.(uint)
+            err := db.Transaction(func(tx *gorm.DB) error {
+                return changeProductStatus(tx, &p, ProductDiscontinued, fmt.Sprintf("删除商品（有 %d 条历史订单，已归档）", orderCount), uid)
+            })
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            c.JSON(http.StatusOK, gin.H{"ok": true, "archived": true, "product": p})
+            return
         }
-        filename := fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-        fullPath := filepath.Join(uploadDir, filename)
-
-        if err := c.SaveUploadedFile(file, fullPath); err != nil {
+        g, err := loadBundleGraph(db)
+        if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
-
-        url := "/uploads/products/" + filename
-        c.JSON(http.StatusOK, gin.H{"url": url})
+        leafIDs := make([]uint, 0)
+        if g.isBundle(p.ID) {
+            for id := range g.leaves(p.ID) {
+                leafIDs = append(leafIDs, id)
+            }
+        }
+        err = db.Transaction(func(tx *gorm.DB) error {
+            if err := tx.Delete(&models.Product{}, p.ID).Error; err != nil {
+                return err
+            }
+            // 组合商品的组成一并删除
+            if err := tx.Where("product_id = ?", p.ID).Delete(&models.ProductComponent{}).Error; err != nil {
+                return err
+            }
+            // 指向该商品的 SKU 别名、档位成本、状态记录一并删除
+            if err := tx.Where("product_id = ?", p.ID).Delete(&models.SKUAlias{}).Error; err != nil {
+                return err
+            }
+            if err := tx.Where("product_id = ?", p.ID).Delete(&models.ProductRoleCost{}).Error; err != nil {
+                return err
+            }
+            return tx.Where("product_id = ?", p.ID).Delete(&models.ProductStatusLog{}).Error
+        })
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        // 原来匹配到该商品的订单重新匹配（可能匹配到同 SKU 的其他商品，否则变为未匹配）
+        affected, err := resolveOrderProducts(db, func(q *gorm.DB) *gorm.DB {
+            return q.Where("product_id = ?", p.ID)
+        })
+        if err != nil {
+            log.Printf("[costing] 删除商品 %d 后重新匹配订单失败：%v", p.ID, err)
+        }
+        // 组合商品删除后，其组件不再为这些订单出库
+        costErr := errors.Join(err, recostProducts(db, append(affected, leafIDs...)...))
+        c.JSON(http.StatusOK, gin.H{"ok": true, "warnings": costWarnings(costErr)})
     }
 }
 
@@ -378,6 +672,10 @@ func SaveStoreStat(db *gorm.DB) gin.HandlerFunc {
         if strings.TrimSpace(body.Date) == "" {
             body.Date = time.Now().Format("2006-01-02")
         }
+        if err := ensurePeriodOpen(db, body.Date); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
 
         // 确保店铺存在
         var store models.Store
@@ -544,12 +842,135 @@ func UpdateStoreUsers(db *gorm.DB) gin.HandlerFunc {
     }
 }
 
-// 保存每日结算记录
+// loadSettlementStore 取结算所属店铺，非超级管理员只能为自己被授权的店铺结算
+func loadSettlementStore(db *gorm.DB, storeID uint, role string, userID uint) (*models.Store, error) {
+    if storeID == 0 {
+        return nil, fmt.Errorf("请选择结算的店铺")
+    }
+    var store models.Store
+    if err := db.First(&store, storeID).Error; err != nil {
+        if err == gorm.ErrRecordNotFound {
+            return nil, fmt.Errorf("店铺不存在")
+        }
+        return nil, err
+    }
+    if role != "superadmin" {
+        var n int64
+        if err := db.Model(&models.StoreUser{}).Where("store_id = ? AND user_id = ?", storeID, userID).Count(&n).Error; err != nil {
+            return nil, err
+        }
+        if n == 0 {
+            return nil, fmt.Errorf("没有该店铺的权限")
+        }
+    }
+    return &store, nil
+}
+
+// settlementVisibleScope 非超级管理员可以查看的结算：被授权店铺的结算（其他人的草稿除外），
+// 以及自己创建的历史按国家结算记录（没有店铺）
+func settlementVisibleScope(db *gorm.DB, q *gorm.DB, role string, userID uint) *gorm.DB {
+    if role == "superadmin" {
+        return q
+    }
+    stores := db.Model(&models.StoreUser{}).Select("store_id").Where("user_id = ?", userID)
+    return q.Where("((store_id IN (?) AND (user_id = ? OR status <> ?)) OR (store_id IS NULL AND user_id = ?))",
+        stores, userID, SettlementDraft, userID)
+}
+
+// settlementStoreNames 结算记录涉及的店铺名称，历史按国家结算的记录（StoreID 为空）不在其中
+func settlementStoreNames(db *gorm.DB, list []models.DailySettlement) (map[uint]string, error) {
+    ids := []uint{}
+    for _, s := range list {
+        if s.StoreID != nil {
+            ids = append(ids, *s.StoreID)
+        }
+    }
+    names := map[uint]string{}
+    if len(ids) == 0 {
+        return names, nil
+    }
+    var stores []models.Store
+    if err := db.Select("id", "name").Where("id IN ?", ids).Find(&stores).Error; err != nil {
+        return nil, err
+    }
+    for _, st := range stores {
+        names[st.ID] = st.Name
+    }
+    return names, nil
+}
+
+// settlementStoreLabel 报表中展示的店铺名称
+func settlementStoreLabel(s models.DailySettlement, names map[uint]string) string {
+    if s.StoreID == nil {
+        return "未指定店铺"
+    }
+    if name := names[*s.StoreID]; name != "" {
+        return name
+    }
+    return fmt.Sprintf("店铺#%d", *s.StoreID)
+}
+
+// calcSettlement 按店铺计算结算的人民币金额、广告折算、平台手续费和利润（保存和预览共用，预览的利润与保存一致）。
+// s 需已填好日期、原币种金额、币种和汇率；返回需要提示的信息，出错时返回对应的 HTTP 状态码。
+func calcSettlement(db *gorm.DB, s *models.DailySettlement, store *models.Store) (feeResult, []string, int, error) {
+    // 刷单费用和固定成本优先按当天费用流水和固定成本计划合计（人民币），都没有记录时使用手动输入的金额
+    ledger, err := settlementLedgerCosts(db, s.Date, store.Country, store.ID)
+    if err != nil {
+        return feeResult{}, nil, http.StatusInternalServerError, err
+    }
+    // 手动输入的金额与合计不一致时不会静默替换，在返回结果中提示
+    warnings := ledger.Warnings
+    if len(ledger.ShuaDanLines) > 0 {
+        if w := ledgerOverrideWarning("刷单费用", s.ShuaDanFee, s.ShuaDanCurrency, ledger.ShuaDanFee); w != "" {
+            warnings = append(warnings, w)
+        }
+        s.ShuaDanFee, s.ShuaDanCurrency = ledger.ShuaDanFee, "CNY"
+    }
+    if len(ledger.FixedLines) > 0 {
+        if w := ledgerOverrideWarning("固定成本", s.FixedCost, s.FixedCurrency, ledger.FixedCost); w != "" {
+            warnings = append(warnings, w)
+        }
+        s.FixedCost, s.FixedCurrency = ledger.FixedCost, "CNY"
+    }
+    s.CostDetail = ""
+    if lines := ledger.Lines(); len(lines) > 0 {
+        costDetail, _ := json.Marshal(lines)
+        s.CostDetail = string(costDetail)
+    }
+
+    // 每项金额按声明的币种折算成人民币，利润和费用都按人民币金额计算
+    if err := fillSettlementCNY(s); err != nil {
+        return feeResult{}, nil, http.StatusBadRequest, err
+    }
+
+    // 按结算日期生效的费用规则计算广告税和平台手续费，并保存所用规则和明细，便于事后核对
+    scope := feeScope{Date: s.Date, Country: store.Country, Platform: store.Platform, StoreID: store.ID}
+    fees, err := calcSettlementFees(db, scope, feeInput{
+        SaleTotal: s.SaleTotalCNY,
+        AdCost:    s.AdCostCNY,
+        Exchange:  1,
+        GoodsCost: s.GoodsCostCNY,
+    })
+    if err != nil {
+        return fees, nil, http.StatusInternalServerError, err
+    }
+    feeDetail, _ := json.Marshal(fees.Lines)
+    s.AdDeduction = fees.AdDeduction
+    s.PlatformFee = fees.PlatformFee
+    s.Profit = settlementProfitCNY(*s)
+    s.FeeRuleIDs = fees.RuleIDs()
+    s.FeeDetail = string(feeDetail)
+    return fees, append(warnings, fees.Warnings...), 0, nil
+}
+
+// 保存每日结算记录：每个店铺每天一条，国家、平台取自店铺
 func SaveSettlement(db *gorm.DB) gin.HandlerFunc {
     return func(c *gin.Context) {
         var body struct {
             Date        string  `json:"date"`
+            StoreID     uint    `json:"store_id"`
             Country     string  `json:"country"`
+            Platform    string  `json:"platform"`
             Currency    string  `json:"currency"`
             SaleTotal   float64 `json:"sale_total"`
             AdCost      float64 `json:"ad_cost"`
@@ -558,47 +979,81 @@ func SaveSettlement(db *gorm.DB) gin.HandlerFunc {
             ShuaDanFee  float64 `json:"shua_dan_fee"`
             FixedCost   float64 `json:"fixed_cost"`
             Remark      string  `json:"remark"`
+            // 各项金额的原币种（CNY 或本国货币），不传时销售额、货款成本、刷单费用、固定成本为人民币，广告费为本国货币
+            SaleCurrency    string `json:"sale_currency"`
+            AdCurrency      string `json:"ad_currency"`
+            GoodsCurrency   string `json:"goods_currency"`
+            ShuaDanCurrency string `json:"shua_dan_currency"`
+            FixedCurrency   string `json:"fixed_currency"`
+            // 汇率来源：rate_service / manual
+            RateSource string `json:"rate_source"`
         }
         if err := c.ShouldBindJSON(&body); err != nil {
             c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
             return
         }
+        if body.RateSource != "" && body.RateSource != RateSourceService && body.RateSource != RateSourceManual {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "rate_source 只能是 rate_service / manual"})
+            return
+        }
 
         // 默认日期为今天
         if body.Date == "" {
             body.Date = time.Now().Format("2006-01-02")
         }
+        if err := ensurePeriodOpen(db, body.Date); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
 
-        // 计算各项费用（在后端保存一份计算凭证，和前端保持同样公式）
-        adDeduction := (body.AdCost + body.AdCost*0.11) * body.Exchange
-        platformFee := body.SaleTotal * 0.07
-        profit := body.SaleTotal - adDeduction - body.GoodsCost - platformFee - body.ShuaDanFee - body.FixedCost
-
-        // 需求：同一天可以多次计算利润，但最终只保留最后一次的结果。
-        // 实现方式：按 Date + Country 查找已存在记录，若有则覆盖更新，否则创建新记录。
-
-        var existing models.DailySettlement
-        // 按当前登录用户 + 日期 + 国家 唯一一条记录
+        // 如果没有取到 userID，视为未登录或上下文异常
         userIDVal, _ := c.Get("userID")
         uid, _ := userIDVal.(uint)
-
-        // 如果没有取到 userID，视为未登录或上下文异常
         if uid == 0 {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "未获取到用户信息"})
             return
         }
+        roleVal, _ := c.Get("role")
+        role, _ := roleVal.(string)
+        store, err := loadSettlementStore(db, body.StoreID, role, uid)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        body.Country = store.Country
+        body.Platform = store.Platform
+        if cur, ok := countryCurrency[store.Country]; ok {
+            body.Currency = cur
+        }
 
-        err := db.Where("date = ? AND country = ? AND user_id = ?", body.Date, body.Country, uid).First(&existing).Error
+        // 需求：同一天可以多次计算利润，结算表只保留最后一次的结果，之前的结果保存在修订历史中。
+        // 实现方式：按 Date + 店铺 查找已存在记录，若有则覆盖更新，否则创建新记录。
+        var existing models.DailySettlement
+        err = db.Where("date = ? AND store_id = ?", body.Date, store.ID).First(&existing).Error
 
         if err != nil && err != gorm.ErrRecordNotFound {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
 
-        // 用本次计算结果覆盖字段
+        notFound := err == gorm.ErrRecordNotFound
+
+        // 已审批 / 已锁定的结算不能覆盖（这里提前拒绝，保存事务内按锁定后的最新状态再检查一次）
+        if !notFound {
+            if err := ensureSettlementEditable(existing); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+            }
+        }
+
+        // 用本次计算结果覆盖字段；创建人保持不变，每次修改的操作人记录在修订历史中
         existing.Date = body.Date
-        existing.UserID = uid
+        existing.StoreID = &store.ID
+        if notFound {
+            existing.UserID = uid
+        }
         existing.Country = body.Country
+        existing.Platform = body.Platform
         existing.Currency = body.Currency
         existing.SaleTotal = body.SaleTotal
         existing.AdCost = body.AdCost
@@ -606,32 +1061,74 @@ func SaveSettlement(db *gorm.DB) gin.HandlerFunc {
         existing.GoodsCost = body.GoodsCost
         existing.ShuaDanFee = body.ShuaDanFee
         existing.FixedCost = body.FixedCost
-        existing.AdDeduction = adDeduction
-        existing.PlatformFee = platformFee
-        existing.Profit = profit
+        existing.SaleCurrency = body.SaleCurrency
+        existing.AdCurrency = body.AdCurrency
+        existing.GoodsCurrency = body.GoodsCurrency
+        existing.ShuaDanCurrency = body.ShuaDanCurrency
+        existing.FixedCurrency = body.FixedCurrency
+        existing.RateSource = body.RateSource
         existing.Remark = body.Remark
+        _, warnings, status, err := calcSettlement(db, &existing, store)
+        if err != nil {
+            c.JSON(status, gin.H{"error": err.Error()})
+            return
+        }
 
         // 如果之前没有记录，CreatedAt 会由 GORM 自动填充当前时间；
         // 如果有记录，则保留原来的 ID，仅更新时间和字段。
-        if err == gorm.ErrRecordNotFound {
-            if err := db.Create(&existing).Error; err != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-                return
+        // 每次保存都记录一个修订版本（操作人、时间、字段变化），覆盖前的数值可以在修订历史中查看和回滚。
+        var editErr error
+        err = db.Transaction(func(tx *gorm.DB) error {
+            action := "update"
+            if notFound {
+                action = "create"
+                existing.Status = SettlementDraft
+                if err := tx.Create(&existing).Error; err != nil {
+                    return err
+                }
+            } else {
+                cur, err := lockSettlement(tx, existing.ID)
+                if err != nil {
+                    return err
+                }
+                if editErr = ensureSettlementEditable(cur); editErr != nil {
+                    return editErr
+                }
+                // 覆盖前先为启用修订历史之前的记录补一条基线版本（覆盖前的数值），与本次保存一起提交
+                if err := ensureSettlementBaseline(tx, &cur); err != nil {
+                    return err
+                }
+                // 审批状态和版本号以锁定后读到的为准，不能用读取时的旧值覆盖
+                existing.Status, existing.StatusChangedAt = cur.Status, cur.StatusChangedAt
+                existing.ApprovedBy, existing.ApprovedAt = cur.ApprovedBy, cur.ApprovedAt
+                existing.RevisionID, existing.Revision = cur.RevisionID, cur.Revision
+                if err := tx.Save(&existing).Error; err != nil {
+                    return err
+                }
             }
-        } else {
-            if err := db.Save(&existing).Error; err != nil {
-                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-                return
+            if _, err := recordSettlementRevision(tx, &existing, action, uid, ""); err != nil {
+                return err
             }
+            // 已提交的结算被修改后退回草稿，需要重新提交审批
+            if settlementStatus(existing) == SettlementSubmitted {
+                return setSettlementStatus(tx, &existing, SettlementDraft, "提交后修改了数据，需重新提交", uid)
+            }
+            return nil
+        })
+        if editErr != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": editErr.Error()})
+            return
         }
-
-        // 每次保存结算，即视为一次手动推送当日利润汇总到企微群
-        if err := NotifyWecomSettlementForDate(db, existing.Date); err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": "保存结算成功，但推送企业微信失败: " + err.Error()})
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
 
-        c.JSON(http.StatusOK, existing)
+        // 保存只生成草稿，审批通过后才推送当日利润汇总到企微群（见 SetSettlementStatus）
+        c.JSON(http.StatusOK, struct {
+            models.DailySettlement
+            Warnings []string `json:"warnings,omitempty"`
+        }{existing, warnings})
     }
 }
 
@@ -654,6 +1151,20 @@ func PostOrder(db *gorm.DB) gin.HandlerFunc {
         if o.CreatedAt.IsZero() {
             o.CreatedAt = time.Now()
         }
+        if err := ensurePeriodOpenAt(db, o.CreatedAt); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        // 商品匹配和货款成本由后端计算，忽略前端传入的值
+        o.ProductID = 0
+        o.UnitCost, o.GoodsCost, o.CostMethod = 0, 0, ""
+        // 只能为在售商品录入新订单
+        if o.ProductName != "今日总额汇总" {
+            if err := ensureOrderProductActive(db, o); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+            }
+        }
 
         // 如果是“今日总额汇总”，则当日只保留一条记录：后提交的覆盖前一次
         if o.ProductName == "今日总额汇总" {
@@ -688,7 +1199,13 @@ func PostOrder(db *gorm.DB) gin.HandlerFunc {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
-        c.JSON(http.StatusOK, o)
+        // 匹配商品（SKU 或平台 SKU 别名），并按商品的核算方式计算本单货款成本
+        var costErr error
+        if o.SKU != "" {
+            costErr = recostSKUs(db, o.SKU)
+            db.First(&o, o.ID)
+        }
+        c.JSON(http.StatusOK, orderWithWarnings(o, costErr))
     }
 }
 
@@ -813,17 +1330,18 @@ func TodaySales(db *gorm.DB) gin.HandlerFunc {
 }
 
 // TodayGoodsCost 计算今日货款成本：
-// 按当天订单中各商品的数量 * 商品基础成本（Product.Cost）求和，排除“今日总额汇总”等数量为 0 的汇总记录。
+// 按当天订单上核算好的货款成本（goods_cost，FIFO / 加权平均 / 标准成本）求和，
+// 历史未核算的订单回退到 数量 * 商品基础成本（Product.Cost），排除“今日总额汇总”等数量为 0 的汇总记录。
 func TodayGoodsCost(db *gorm.DB) gin.HandlerFunc {
     return func(c *gin.Context) {
         today := time.Now().Format("2006-01-02")
         var total float64
 
-        // JOIN 订单与商品表，按 SKU 匹配，使用基础成本字段 Cost 计算货款成本
+        // LEFT JOIN 商品表仅用于未核算订单的回退口径
         err := db.Table("orders AS o").
-            Joins("JOIN products AS p ON o.sku = p.sku").
+            Joins(orderProductJoin).
             Where("DATE(o.created_at) = ? AND o.quantity > 0 AND o.product_name <> ?", today, "今日总额汇总").
-            Select("IFNULL(SUM(o.quantity * p.cost), 0)").
+            Select("IFNULL(SUM(" + orderGoodsCostExpr + "), 0)").
             Scan(&total).Error
         if err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -901,6 +1419,11 @@ func ListSettlements(db *gorm.DB) gin.HandlerFunc {
     return func(c *gin.Context) {
         date := c.Query("date")
         country := c.Query("country")
+        statuses, err := parseSettlementStatusFilter(c.Query("status"))
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
 
         // 分页参数，默认第 1 页，每页 10 条
         pageStr := c.DefaultQuery("page", "1")
@@ -922,10 +1445,18 @@ func ListSettlements(db *gorm.DB) gin.HandlerFunc {
         if country != "" {
             q = q.Where("country = ?", country)
         }
+        if v := c.Query("store_id"); v != "" {
+            if id, err := strconv.Atoi(v); err == nil && id > 0 {
+                q = q.Where("store_id = ?", id)
+            }
+        }
+        if len(statuses) > 0 {
+            q = q.Where("status IN ?", statuses)
+        }
 
         // 权限控制：
-        // - 超级管理员可以看到所有用户的结算记录；
-        // - 其他角色只能看到自己创建的结算记录。
+        // - 超级管理员可以看到所有结算记录；
+        // - 其他角色按被授权的店铺查看（其他人的草稿除外），以及自己创建的历史按国家结算记录。
         roleVal, _ := c.Get("role")
         roleStr, _ := roleVal.(string)
         userIDVal, _ := c.Get("userID")
@@ -935,7 +1466,7 @@ func ListSettlements(db *gorm.DB) gin.HandlerFunc {
                 c.JSON(http.StatusUnauthorized, gin.H{"error": "未获取到用户信息"})
                 return
             }
-            q = q.Where("user_id = ?", uid)
+            q = settlementVisibleScope(db, q, roleStr, uid)
         }
 
         // 先统计总数
@@ -956,7 +1487,10 @@ func ListSettlements(db *gorm.DB) gin.HandlerFunc {
         type item struct {
             ID           uint    `json:"id"`
             Date         string  `json:"date"`
+            StoreID      *uint   `json:"store_id"`
+            StoreName    string  `json:"store_name"`
             Country      string  `json:"country"`
+            Platform     string  `json:"platform"`
             Currency     string  `json:"currency"`
             SaleTotal    float64 `json:"sale_total"`
             AdCost       float64 `json:"ad_cost"`
@@ -964,19 +1498,47 @@ func ListSettlements(db *gorm.DB) gin.HandlerFunc {
             GoodsCost    float64 `json:"goods_cost"`
             ShuaDanFee   float64 `json:"shua_dan_fee"`
             FixedCost    float64 `json:"fixed_cost"`
+            // 各项金额的原币种和人民币金额
+            SaleCurrency    string  `json:"sale_currency"`
+            SaleTotalCNY    float64 `json:"sale_total_cny"`
+            AdCurrency      string  `json:"ad_currency"`
+            AdCostCNY       float64 `json:"ad_cost_cny"`
+            GoodsCurrency   string  `json:"goods_currency"`
+            GoodsCostCNY    float64 `json:"goods_cost_cny"`
+            ShuaDanCurrency string  `json:"shua_dan_currency"`
+            ShuaDanFeeCNY   float64 `json:"shua_dan_fee_cny"`
+            FixedCurrency   string  `json:"fixed_currency"`
+            FixedCostCNY    float64 `json:"fixed_cost_cny"`
+            RateSource      string  `json:"rate_source"`
+            CurrencyBasis   string  `json:"currency_basis"`
+            CurrencyNote    string  `json:"currency_note"`
             AdDeduction  float64 `json:"ad_deduction"`
             PlatformFee  float64 `json:"platform_fee"`
             Profit       float64 `json:"profit"`
+            // 计算时使用的费用规则及明细
+            FeeRuleIDs   string          `json:"fee_rule_ids"`
+            FeeDetail    json.RawMessage `json:"fee_detail,omitempty"`
+            Revision     int     `json:"revision"`
+            Status       string  `json:"status"`
+            UserID       uint    `json:"user_id"`
             Remark       string  `json:"remark"`
             CreatedAtStr string  `json:"created_at"`
         }
 
+        storeNames, err := settlementStoreNames(db, list)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
         items := make([]item, 0, len(list))
         for _, s := range list {
             it := item{
                 ID:          s.ID,
                 Date:        s.Date,
+                StoreID:     s.StoreID,
+                StoreName:   settlementStoreLabel(s, storeNames),
                 Country:     s.Country,
+                Platform:    s.Platform,
                 Currency:    s.Currency,
                 SaleTotal:   s.SaleTotal,
                 AdCost:      s.AdCost,
@@ -984,11 +1546,33 @@ func ListSettlements(db *gorm.DB) gin.HandlerFunc {
                 GoodsCost:   s.GoodsCost,
                 ShuaDanFee:  s.ShuaDanFee,
                 FixedCost:   s.FixedCost,
+
+                SaleCurrency:    s.SaleCurrency,
+                SaleTotalCNY:    s.SaleTotalCNY,
+                AdCurrency:      s.AdCurrency,
+                AdCostCNY:       s.AdCostCNY,
+                GoodsCurrency:   s.GoodsCurrency,
+                GoodsCostCNY:    s.GoodsCostCNY,
+                ShuaDanCurrency: s.ShuaDanCurrency,
+                ShuaDanFeeCNY:   s.ShuaDanFeeCNY,
+                FixedCurrency:   s.FixedCurrency,
+                FixedCostCNY:    s.FixedCostCNY,
+                RateSource:      s.RateSource,
+                CurrencyBasis:   s.CurrencyBasis,
+                CurrencyNote:    s.CurrencyNote,
+
                 AdDeduction: s.AdDeduction,
                 PlatformFee: s.PlatformFee,
                 Profit:      s.Profit,
+                FeeRuleIDs:  s.FeeRuleIDs,
+                Revision:    s.Revision,
+                Status:      settlementStatus(s),
+                UserID:      s.UserID,
                 Remark:      s.Remark,
             }
+            if s.FeeDetail != "" {
+                it.FeeDetail = json.RawMessage(s.FeeDetail)
+            }
             if !s.CreatedAt.IsZero() {
                 it.CreatedAtStr = s.CreatedAt.Format("2006-01-02 15:04:05")
             }
@@ -1215,16 +1799,37 @@ func UpdateOrder(db *gorm.DB) gin.HandlerFunc {
             return
         }
 
+        if err := ensurePeriodOpenAt(db, o.CreatedAt); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        oldSKU := o.SKU
+        // 改成其他 SKU 时同样只能使用在售商品
+        if payload.SKU != nil && strings.TrimSpace(*payload.SKU) != strings.TrimSpace(oldSKU) {
+            check := o
+            check.SKU = *payload.SKU
+            if err := ensureOrderProductActive(db, check); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+            }
+        }
         if err := db.Model(&o).Updates(payload).Error; err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
+        // 修改订单后需要重新核算新旧 SKU 的成本
+        if err := db.First(&o, id).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        costErr := recostSKUs(db, oldSKU, o.SKU)
         // 重新查询最新数据返回
         if err := db.First(&o, id).Error; err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
-        c.JSON(http.StatusOK, o)
+        c.JSON(http.StatusOK, orderWithWarnings(o, costErr))
     }
 }
 
@@ -1267,12 +1872,21 @@ func UpdateOrderDate(db *gorm.DB) gin.HandlerFunc {
             return
         }
 
+        // 原日期和新日期所在月份都不能已关账
+        if err := ensurePeriodOpenAt(db, o.CreatedAt, t); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
         o.CreatedAt = t
         if err := db.Save(&o).Error; err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
-        c.JSON(http.StatusOK, o)
+        // 日期变化会影响 FIFO / 加权平均的消耗顺序
+        costErr := recostSKUs(db, o.SKU)
+        db.First(&o, o.ID)
+        c.JSON(http.StatusOK, orderWithWarnings(o, costErr))
     }
 }
 
@@ -1285,11 +1899,29 @@ func DeleteOrder(db *gorm.DB) gin.HandlerFunc {
             c.JSON(http.StatusBadRequest, gin.H{"error": "invalid id"})
             return
         }
+        // 先取出 SKU，删除后用于重新核算
+        var o models.Order
+        if err := db.First(&o, id).Error; err != nil {
+            if err == gorm.ErrRecordNotFound {
+                c.JSON(http.StatusNotFound, gin.H{"error": "订单不存在"})
+                return
+            }
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if err := ensurePeriodOpenAt(db, o.CreatedAt); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
         if err := db.Delete(&models.Order{}, id).Error; err != nil {
             c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
             return
         }
-        c.JSON(http.StatusOK, gin.H{"deleted": true})
+        if err := db.Where("order_id = ?", id).Delete(&models.OrderCostAllocation{}).Error; err != nil {
+            log.Printf("[costing] 清理订单 %d 的分配记录失败：%v", id, err)
+        }
+        costErr := recostSKUs(db, o.SKU)
+        c.JSON(http.StatusOK, gin.H{"deleted": true, "warnings": costWarnings(costErr)})
     }
 }
 
@@ -1432,6 +2064,24 @@ func NotifyWecomOrdersForDate(db *gorm.DB, date string) error {
         }
     }
 
+    // 本月目标进度（截至推送日期）
+    if lines, err := targetWecomLines(db, date); err != nil {
+        log.Printf("[targets] %s 目标进度计算失败：%v", date, err)
+    } else {
+        buf.WriteString(lines)
+    }
+
+    // 数据一致性检查：订单销售额、店铺广告费与结算是否一致，以及 SKU 未匹配商品的订单
+    if report, err := computeConsistency(db, date, date, 1, 0); err != nil {
+        log.Printf("[consistency] %s 数据一致性检查失败：%v", date, err)
+        buf.WriteString("\n数据一致性检查：**检查失败**\n")
+    } else {
+        if len(report.Issues) > 0 {
+            log.Printf("[consistency] %s 发现 %d 项数据差异", date, len(report.Issues))
+        }
+        buf.WriteString(consistencyWecomSummary(report, 10))
+    }
+
     content := buf.String()
 
     // 读取企微机器人地址：优先使用 config.yaml 注入的 WecomWebhook，
@@ -1473,36 +2123,50 @@ func NotifyWecomOrdersForDate(db *gorm.DB, date string) error {
 }
 
 // NotifyWecomSettlementForDate 将指定日期的每日结算记录，通过企业微信机器人以简易表格形式推送到企微群。
-// 表头字段：国家、时间、销售额、广告成本、货款成本、平台手续费、刷单费用、固定成本、利润。
+// 内容：整体汇总、按国家汇总（各店铺合计）、按店铺明细（销售额、广告成本、货款成本、平台手续费、刷单费用、固定成本、利润）。
 // 时间只展示到天（YYYY-MM-DD），不包含小时和分钟。
 func NotifyWecomSettlementForDate(db *gorm.DB, date string) error {
     if date == "" {
         date = time.Now().Format("2006-01-02")
     }
 
+    // 只汇总已审批 / 已锁定的结算，草稿和待审批的记录单独提示数量
     var list []models.DailySettlement
-    if err := db.Where("date = ?", date).
-        Order("country asc, created_at asc").
+    if err := db.Where("date = ? AND status IN ?", date, settlementFinalStatuses).
+        Order("country asc, store_id asc, created_at asc").
         Find(&list).Error; err != nil {
         return err
     }
+    storeNames, err := settlementStoreNames(db, list)
+    if err != nil {
+        return err
+    }
+    var pending int64
+    if err := db.Model(&models.DailySettlement{}).
+        Where("date = ? AND status IN ?", date, []string{SettlementDraft, SettlementSubmitted}).
+        Count(&pending).Error; err != nil {
+        return err
+    }
 
     var buf bytes.Buffer
     fmt.Fprintf(&buf, "【每日结算利润汇总】%s\n", date)
+    if pending > 0 {
+        fmt.Fprintf(&buf, "\n> 另有 %d 条结算尚未审批，未计入汇总\n", pending)
+    }
 
     if len(list) == 0 {
-        buf.WriteString("\n今日暂无结算记录。\n")
+        buf.WriteString("\n今日暂无已审批的结算记录。\n")
     } else {
         // 先做整体汇总，便于在群里快速看到当天总情况
         // 这里的广告成本使用折算后的 AdDeduction 字段，而不是原始广告费 AdCost
         var totalSale, totalAdDeduction, totalGoodsCost, totalPlatformFee, totalShuaDanFee, totalFixedCost, totalProfit float64
         for _, s := range list {
-            totalSale += s.SaleTotal
+            totalSale += s.SaleTotalCNY
             totalAdDeduction += s.AdDeduction
-            totalGoodsCost += s.GoodsCost
+            totalGoodsCost += s.GoodsCostCNY
             totalPlatformFee += s.PlatformFee
-            totalShuaDanFee += s.ShuaDanFee
-            totalFixedCost += s.FixedCost
+            totalShuaDanFee += s.ShuaDanFeeCNY
+            totalFixedCost += s.FixedCostCNY
             totalProfit += s.Profit
         }
 
@@ -1512,7 +2176,29 @@ func NotifyWecomSettlementForDate(db *gorm.DB, date string) error {
         fmt.Fprintf(&buf, "> 平台手续费：￥%.2f，刷单费用：￥%.2f，固定成本：￥%.2f\n", totalPlatformFee, totalShuaDanFee, totalFixedCost)
         fmt.Fprintf(&buf, "> **利润合计：￥%.2f**\n", totalProfit)
 
-        buf.WriteString("\n**按国家明细**\n")
+        // 按国家汇总：同一国家下各店铺的结算合计
+        buf.WriteString("\n**按国家汇总**\n")
+        countryTotals := map[string]*models.DailySettlement{}
+        var countries []string
+        for _, s := range list {
+            ct := countryTotals[s.Country]
+            if ct == nil {
+                ct = &models.DailySettlement{Country: s.Country}
+                countryTotals[s.Country] = ct
+                countries = append(countries, s.Country)
+            }
+            ct.SaleTotal += s.SaleTotalCNY
+            ct.AdDeduction += s.AdDeduction
+            ct.GoodsCost += s.GoodsCostCNY
+            ct.Profit += s.Profit
+        }
+        for _, ctry := range countries {
+            ct := countryTotals[ctry]
+            fmt.Fprintf(&buf, "> %s：销售 ￥%.2f | 广告成本 ￥%.2f | 货款 ￥%.2f | 利润 **￥%.2f**\n",
+                ctry, ct.SaleTotal, ct.AdDeduction, ct.GoodsCost, ct.Profit)
+        }
+
+        buf.WriteString("\n**按店铺明细**\n")
         for _, s := range list {
             // 时间列只展示日期，不包含时分
             day := s.Date
@@ -1526,12 +2212,12 @@ func NotifyWecomSettlementForDate(db *gorm.DB, date string) error {
                 profitStr = fmt.Sprintf("-￥%.2f", -s.Profit)
             }
 
-            fmt.Fprintf(&buf, "\n> %s %s\n", s.Country, day)
-            fmt.Fprintf(&buf, "> 销售：￥%.2f | 广告成本：￥%.2f | 货款：￥%.2f\n", s.SaleTotal, s.AdDeduction, s.GoodsCost)
+            fmt.Fprintf(&buf, "\n> %s · %s %s\n", s.Country, settlementStoreLabel(s, storeNames), day)
+            fmt.Fprintf(&buf, "> 销售：￥%.2f | 广告成本：￥%.2f | 货款：￥%.2f\n", s.SaleTotalCNY, s.AdDeduction, s.GoodsCostCNY)
             fmt.Fprintf(&buf, "> 平台：￥%.2f | 刷单：￥%.2f | 固定：￥%.2f | 利润：**%s**\n",
                 s.PlatformFee,
-                s.ShuaDanFee,
-                s.FixedCost,
+                s.ShuaDanFeeCNY,
+                s.FixedCostCNY,
                 profitStr,
             )
         }
@@ -1669,12 +2355,17 @@ func NotifyWecomSettlementForRange(db *gorm.DB, startDate, endDate, reportType s
         return fmt.Errorf("startDate 和 endDate 不能为空")
     }
 
+    // 只汇总已审批 / 已锁定的结算
     var list []models.DailySettlement
-    if err := db.Where("date >= ? AND date <= ?", startDate, endDate).
-        Order("date asc, country asc, created_at asc").
+    if err := db.Where("date >= ? AND date <= ? AND status IN ?", startDate, endDate, settlementFinalStatuses).
+        Order("date asc, country asc, store_id asc, created_at asc").
         Find(&list).Error; err != nil {
         return err
     }
+    storeNames, err := settlementStoreNames(db, list)
+    if err != nil {
+        return err
+    }
 
     var title string
     switch reportType {
@@ -1694,8 +2385,8 @@ func NotifyWecomSettlementForRange(db *gorm.DB, startDate, endDate, reportType s
     } else {
         // 一、明细列表
         buf.WriteString("\n**一、明细列表**\n")
-        buf.WriteString("\n| 日期 | 国家 | 销售额 | 广告成本 | 货款成本 | 平台手续费 | 刷单费用 | 固定成本 | 利润 |\n")
-        buf.WriteString("| --- | --- | --- | --- | --- | --- | --- | --- | --- |\n")
+        buf.WriteString("\n| 日期 | 国家 | 店铺 | 销售额 | 广告成本 | 货款成本 | 平台手续费 | 刷单费用 | 固定成本 | 利润 |\n")
+        buf.WriteString("| --- | --- | --- | --- | --- | --- | --- | --- | --- | --- |\n")
 
         type agg struct {
             SaleTotal   float64
@@ -1709,6 +2400,8 @@ func NotifyWecomSettlementForRange(db *gorm.DB, startDate, endDate, reportType s
 
         total := agg{}
         countryAgg := make(map[string]*agg)
+        // 按店铺汇总，键为“国家 · 店铺”，店铺名不同国家可能重名
+        storeAgg := make(map[string]*agg)
         dayProfit := make(map[string]float64)
 
         for _, s := range list {
@@ -1717,28 +2410,30 @@ func NotifyWecomSettlementForRange(db *gorm.DB, startDate, endDate, reportType s
                 day = s.CreatedAt.Format("2006-01-02")
             }
 
+            storeLabel := settlementStoreLabel(s, storeNames)
             fmt.Fprintf(&buf,
-                "| %s | %s | %.2f | %.2f | %.2f | %.2f | %.2f | %.2f | %.2f |\n",
+                "| %s | %s | %s | %.2f | %.2f | %.2f | %.2f | %.2f | %.2f | %.2f |\n",
                 day,
                 s.Country,
-                s.SaleTotal,
+                storeLabel,
+                s.SaleTotalCNY,
                 // 明细中的广告成本也应展示为折算成人民币后的广告成本
                 s.AdDeduction,
-                s.GoodsCost,
+                s.GoodsCostCNY,
                 s.PlatformFee,
-                s.ShuaDanFee,
-                s.FixedCost,
+                s.ShuaDanFeeCNY,
+                s.FixedCostCNY,
                 s.Profit,
             )
 
             // 汇总整体
-            total.SaleTotal += s.SaleTotal
+            total.SaleTotal += s.SaleTotalCNY
             // 广告成本汇总应使用折算成人民币的 AdDeduction，而不是原始广告费 AdCost
             total.AdCost += s.AdDeduction
-            total.GoodsCost += s.GoodsCost
+            total.GoodsCost += s.GoodsCostCNY
             total.PlatformFee += s.PlatformFee
-            total.ShuaDanFee += s.ShuaDanFee
-            total.FixedCost += s.FixedCost
+            total.ShuaDanFee += s.ShuaDanFeeCNY
+            total.FixedCost += s.FixedCostCNY
             total.Profit += s.Profit
 
             // 按国家汇总
@@ -1747,15 +2442,30 @@ func NotifyWecomSettlementForRange(db *gorm.DB, startDate, endDate, reportType s
                 ca = &agg{}
                 countryAgg[s.Country] = ca
             }
-            ca.SaleTotal += s.SaleTotal
+            ca.SaleTotal += s.SaleTotalCNY
             // 按国家的广告成本也同样使用折算后的人民币广告成本
             ca.AdCost += s.AdDeduction
-            ca.GoodsCost += s.GoodsCost
+            ca.GoodsCost += s.GoodsCostCNY
             ca.PlatformFee += s.PlatformFee
-            ca.ShuaDanFee += s.ShuaDanFee
-            ca.FixedCost += s.FixedCost
+            ca.ShuaDanFee += s.ShuaDanFeeCNY
+            ca.FixedCost += s.FixedCostCNY
             ca.Profit += s.Profit
 
+            // 按店铺汇总
+            storeKey := s.Country + " · " + storeLabel
+            sa := storeAgg[storeKey]
+            if sa == nil {
+                sa = &agg{}
+                storeAgg[storeKey] = sa
+            }
+            sa.SaleTotal += s.SaleTotalCNY
+            sa.AdCost += s.AdDeduction
+            sa.GoodsCost += s.GoodsCostCNY
+            sa.PlatformFee += s.PlatformFee
+            sa.ShuaDanFee += s.ShuaDanFeeCNY
+            sa.FixedCost += s.FixedCostCNY
+            sa.Profit += s.Profit
+
             // 按日期统计利润
             dayProfit[day] += s.Profit
         }
@@ -1816,8 +2526,32 @@ func NotifyWecomSettlementForRange(db *gorm.DB, startDate, endDate, reportType s
                 }
             }
 
-            // 四、简要分析
-            buf.WriteString("\n**四、简要分析**\n")
+            // 四、按店铺汇总
+            storeKeys := make([]string, 0, len(storeAgg))
+            for k := range storeAgg {
+                storeKeys = append(storeKeys, k)
+            }
+            sort.Strings(storeKeys)
+            buf.WriteString("\n**四、按店铺汇总（人民币）**\n")
+            buf.WriteString("\n| 店铺 | 销售额 | 广告成本 | 货款成本 | 平台手续费 | 刷单费用 | 固定成本 | 总利润 |\n")
+            buf.WriteString("| --- | --- | --- | --- | --- | --- | --- | --- |\n")
+            var bestStore, worstStore string
+            for i, k := range storeKeys {
+                sa := storeAgg[k]
+                fmt.Fprintf(&buf,
+                    "| %s | %.2f | %.2f | %.2f | %.2f | %.2f | %.2f | %.2f |\n",
+                    k, sa.SaleTotal, sa.AdCost, sa.GoodsCost, sa.PlatformFee, sa.ShuaDanFee, sa.FixedCost, sa.Profit,
+                )
+                if i == 0 || sa.Profit > storeAgg[bestStore].Profit {
+                    bestStore = k
+                }
+                if i == 0 || sa.Profit < storeAgg[worstStore].Profit {
+                    worstStore = k
+                }
+            }
+
+            // 五、简要分析
+            buf.WriteString("\n**五、简要分析**\n")
 
             // 统计天数和日均利润
             days := make([]string, 0, len(dayProfit))
@@ -1840,6 +2574,10 @@ func NotifyWecomSettlementForRange(db *gorm.DB, startDate, endDate, reportType s
             if worstCountry != "" && worstCountry != bestCountry {
                 fmt.Fprintf(&buf, "- 利润最低的国家：%s（总利润：%.2f）。\n", worstCountry, worstProfit)
             }
+            if len(storeKeys) > 1 {
+                fmt.Fprintf(&buf, "- 利润最高的店铺：%s（总利润：%.2f）；利润最低的店铺：%s（总利润：%.2f）。\n",
+                    bestStore, storeAgg[bestStore].Profit, worstStore, storeAgg[worstStore].Profit)
+            }
 
             // 找出利润最高/最低的日期
             if len(days) > 0 {
@@ -1863,7 +2601,7 @@ func NotifyWecomSettlementForRange(db *gorm.DB, startDate, endDate, reportType s
                 }
             }
 
-            // 五、AI 数据分析解读（通过豆包）
+            // 六、AI 数据分析解读（通过豆包）
             if DoubaoEndpoint != "" && DoubaoAPIKey != "" {
                 type aiPayload struct {
                     ReportType string             `json:"report_type"`
@@ -1871,6 +2609,7 @@ func NotifyWecomSettlementForRange(db *gorm.DB, startDate, endDate, reportType s
                     EndDate    string             `json:"end_date"`
                     Total      agg                `json:"total"`
                     Countries  map[string]*agg    `json:"countries"`
+                    Stores     map[string]*agg    `json:"stores"`
                     DayProfit  map[string]float64 `json:"day_profit"`
                 }
 
@@ -1880,6 +2619,7 @@ func NotifyWecomSettlementForRange(db *gorm.DB, startDate, endDate, reportType s
                     EndDate:    endDate,
                     Total:      total,
                     Countries:  countryAgg,
+                    Stores:     storeAgg,
                     DayProfit:  dayProfit,
                 }
 
@@ -1887,7 +2627,7 @@ func NotifyWecomSettlementForRange(db *gorm.DB, startDate, endDate, reportType s
                 if err != nil {
                     fmt.Printf("[ai-analysis] 调用豆包分析失败：%v\n", err)
                 } else if aiText != "" {
-                    buf.WriteString("\n**五、AI 数据分析解读（豆包）**\n\n")
+                    buf.WriteString("\n**六、AI 数据分析解读（豆包）**\n\n")
                     buf.WriteString(aiText)
                 }
             }
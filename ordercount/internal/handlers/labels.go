@@ -0,0 +1,213 @@
+package handlers
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    "unicode"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/labels"
+    "ordercount/internal/models"
+    "ordercount/internal/storage"
+    "ordercount/internal/utils"
+)
+
+// 单次生成标签的总张数上限，避免一次请求生成过大的 PDF
+const maxLabelsPerRequest = 2000
+
+// labelItem 把商品转换成标签内容；withImage 时从上传存储读取商品图片（优先缩略图），
+// 外部图片地址不下载，保证离线可用
+func labelItem(p models.Product, withImage bool) labels.Item {
+    item := labels.Item{SKU: p.SKU, Name: p.Name}
+    if withImage {
+        item.Image = loadLabelImage(p.ImageURL)
+    }
+    return item
+}
+
+func loadLabelImage(imageURL string) image.Image {
+    key, ok := storage.KeyFromCanonical(imageURL)
+    if !ok {
+        return nil
+    }
+    keys := []string{key}
+    if thumb, _, ok := utils.ImageVariantNames(key); ok && strings.HasPrefix(key, productUploadPrefix) {
+        keys = []string{productUploadPrefix + thumb, key}
+    }
+    for _, k := range keys {
+        rc, _, err := UploadStorage.Get(k)
+        if err != nil {
+            continue
+        }
+        data, err := io.ReadAll(io.LimitReader(rc, utils.MaxImageBytes))
+        rc.Close()
+        if err != nil {
+            continue
+        }
+        if img, _, err := image.Decode(bytes.NewReader(data)); err == nil {
+            return img
+        }
+    }
+    return nil
+}
+
+// labelFontMissing 内置字体只包含西文字符，商品名称含中文等字符时需要配置字体（labels.font_path），
+// 此时响应头 X-Label-Warning 为 font-missing，前端据此提示
+func labelFontMissing(items []labels.Item) bool {
+    if !LabelRenderer.Builtin() {
+        return false
+    }
+    for _, it := range items {
+        for _, r := range it.Name {
+            if r > unicode.MaxLatin1 {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// ListLabelSheets 支持的标签纸规格
+// GET /api/labels/sheets
+func ListLabelSheets() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        c.JSON(http.StatusOK, gin.H{"default": labels.DefaultSheet, "items": labels.Sheets})
+    }
+}
+
+// PrintLabels 按标签纸规格生成可打印的 PDF
+// POST /api/labels  {items: [{product_id, quantity}], sheet: "a4-3x7", skip: 0, with_image: false}
+// skip 为第一页跳过的标签数，用于接着打印用过一部分的标签纸；每张标签包含 Code128 条码、SKU 二维码和商品名称。
+func PrintLabels(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var body struct {
+            Items []struct {
+                ProductID uint `json:"product_id"`
+                Quantity  int  `json:"quantity"`
+            } `json:"items"`
+            Sheet     string `json:"sheet"`
+            Skip      int    `json:"skip"`
+            WithImage bool   `json:"with_image"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        sheet, ok := labels.FindSheet(body.Sheet)
+        if !ok {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的标签纸规格：" + body.Sheet})
+            return
+        }
+        if len(body.Items) == 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "请选择要打印的商品"})
+            return
+        }
+
+        ids := make([]uint, 0, len(body.Items))
+        total := 0
+        for _, it := range body.Items {
+            if it.Quantity <= 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "打印数量必须大于 0"})
+                return
+            }
+            ids = append(ids, it.ProductID)
+            total += it.Quantity
+        }
+        if total > maxLabelsPerRequest {
+            c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("单次最多打印 %d 张标签", maxLabelsPerRequest)})
+            return
+        }
+        var products []models.Product
+        if err := db.Where("id IN ?", ids).Find(&products).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        byID := make(map[uint]models.Product, len(products))
+        for _, p := range products {
+            byID[p.ID] = p
+        }
+
+        list := make([]labels.Placement, 0, len(body.Items))
+        items := make([]labels.Item, 0, len(body.Items))
+        for _, it := range body.Items {
+            p, ok := byID[it.ProductID]
+            if !ok {
+                c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("商品 %d 不存在", it.ProductID)})
+                return
+            }
+            if strings.TrimSpace(p.SKU) == "" {
+                c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("商品 %s 没有 SKU，无法生成条码", p.Name)})
+                return
+            }
+            item := labelItem(p, body.WithImage)
+            items = append(items, item)
+            list = append(list, labels.Placement{Item: item, Quantity: it.Quantity})
+        }
+
+        pdf, err := LabelRenderer.PDF(sheet, list, body.Skip)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if labelFontMissing(items) {
+            c.Header("X-Label-Warning", "font-missing")
+        }
+        filename := fmt.Sprintf("labels_%s.pdf", time.Now().Format("20060102_150405"))
+        c.Header("Content-Disposition", "attachment; filename="+filename)
+        c.Data(http.StatusOK, "application/pdf", pdf)
+    }
+}
+
+// ProductLabelPNG 单张标签的 PNG，尺寸取标签纸规格或 w / h（毫米）
+// GET /api/products/:id/label.png?sheet=roll-50x30&w=&h=&with_image=1
+func ProductLabelPNG(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var p models.Product
+        if err := db.First(&p, c.Param("id")).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "商品不存在"})
+            return
+        }
+        if strings.TrimSpace(p.SKU) == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "商品没有 SKU，无法生成条码"})
+            return
+        }
+        sheet, ok := labels.FindSheet(c.Query("sheet"))
+        if !ok {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的标签纸规格：" + c.Query("sheet")})
+            return
+        }
+        w, h := sheet.LabelW, sheet.LabelH
+        for _, f := range []struct {
+            name string
+            dst  *float64
+        }{{"w", &w}, {"h", &h}} {
+            if v := c.Query(f.name); v != "" {
+                n, err := strconv.ParseFloat(v, 64)
+                if err != nil || n < 15 || n > 200 {
+                    c.JSON(http.StatusBadRequest, gin.H{"error": "标签宽高需在 15~200 毫米之间"})
+                    return
+                }
+                *f.dst = n
+            }
+        }
+        withImage := c.Query("with_image") == "1" || c.Query("with_image") == "true"
+        item := labelItem(p, withImage)
+        data, err := LabelRenderer.PNG(item, w, h)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if labelFontMissing([]labels.Item{item}) {
+            c.Header("X-Label-Warning", "font-missing")
+        }
+        c.Data(http.StatusOK, "image/png", data)
+    }
+}
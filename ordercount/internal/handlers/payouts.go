@@ -0,0 +1,893 @@
+package handlers
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/csv"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xuri/excelize/v2"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+    "ordercount/internal/utils"
+)
+
+// 结算单文件上限
+const maxPayoutImportBytes = 20 << 20
+
+// 结算单字段
+const (
+    payoutFieldOrderNo  = "order_no"
+    payoutFieldDate     = "payout_date"
+    payoutFieldType     = "transaction_type"
+    payoutFieldCurrency = "currency"
+    payoutFieldGross    = "gross_amount"
+    payoutFieldFee      = "fee_amount"
+    payoutFieldNet      = "net_amount"
+)
+
+var payoutFields = []string{payoutFieldOrderNo, payoutFieldDate, payoutFieldType, payoutFieldCurrency, payoutFieldGross, payoutFieldFee, payoutFieldNet}
+
+// payoutFormat 平台结算单格式：按表头识别，Columns 为各字段可能的表头（规范化后比较，见 normalizePayoutHeader）
+type payoutFormat struct {
+    Name     string
+    Platform string
+    Label    string
+    // 识别格式时必须同时出现的表头
+    Detect  []string
+    Columns map[string][]string
+    // 分列给出的各项扣费，合计为 fee_amount（统一记为负数）
+    FeeColumns []string
+    // 按费用项逐行给出收支的格式（Lazada）：每行金额记为 net_amount，GrossTypes 中的类型同时记为 gross_amount，其余记为 fee_amount
+    LineItems  bool
+    GrossTypes []string
+}
+
+var payoutFormats = []payoutFormat{
+    {
+        Name: "shopee_income", Platform: "Shopee", Label: "Shopee 收入报表（已拨款）",
+        Detect: []string{"order id", "payout completed date"},
+        Columns: map[string][]string{
+            payoutFieldOrderNo: {"order id"},
+            payoutFieldDate:    {"payout completed date"},
+            payoutFieldGross:   {"original product price", "product price"},
+            payoutFieldNet:     {"total released amount", "escrow amount"},
+        },
+        FeeColumns: []string{"commission fee", "service fee", "transaction fee"},
+    },
+    {
+        Name: "lazada_transaction", Platform: "Lazada", Label: "Lazada 交易明细",
+        Detect: []string{"fee name", "amount", "order no"},
+        Columns: map[string][]string{
+            payoutFieldOrderNo: {"order no", "order number"},
+            payoutFieldDate:    {"transaction date"},
+            payoutFieldType:    {"fee name", "transaction type"},
+            payoutFieldNet:     {"amount"},
+        },
+        LineItems:  true,
+        GrossTypes: []string{"item price credit", "item price"},
+    },
+    {
+        Name: "tiktok_settlement", Platform: "TikTok", Label: "TikTok Shop 结算明细",
+        Detect: []string{"order/adjustment id", "total settlement amount"},
+        Columns: map[string][]string{
+            payoutFieldOrderNo:  {"order/adjustment id", "order id"},
+            payoutFieldDate:     {"order settled time", "settlement time", "statement date"},
+            payoutFieldType:     {"type"},
+            payoutFieldCurrency: {"currency"},
+            payoutFieldGross:    {"total revenue"},
+            payoutFieldFee:      {"total fees"},
+            payoutFieldNet:      {"total settlement amount"},
+        },
+    },
+}
+
+var payoutHeaderParens = regexp.MustCompile(`[(（][^)）]*[)）]`)
+
+// normalizePayoutHeader 表头规范化：去掉括号中的币种符号等说明、末尾的句点，转小写并合并空白
+func normalizePayoutHeader(h string) string {
+    h = payoutHeaderParens.ReplaceAllString(strings.TrimPrefix(h, "\ufeff"), "")
+    h = strings.TrimRight(strings.TrimSpace(h), ".:：")
+    return strings.ToLower(strings.Join(strings.Fields(h), " "))
+}
+
+// statementDotThousands 只有一个点号时也按千分位理解：印尼盾没有小数，结算单中的 15.000 即 15000
+var statementDotThousands = regexp.MustCompile(`^-?\d{1,3}(\.\d{3})+$`)
+
+// parseStatementAmount 解析结算单金额：支持千分位、币种符号、括号负数，以及 1.234,56 这类逗号小数。
+// currency 为该行的币种，印尼盾（或金额带 Rp）时 15.000 这类写法按千分位解析。
+func parseStatementAmount(s, currency string) (float64, bool) {
+    s = strings.TrimSpace(s)
+    idr := strings.EqualFold(strings.TrimSpace(currency), "IDR") || strings.Contains(strings.ToLower(s), "rp")
+    if s == "" || s == "-" {
+        return 0, true
+    }
+    neg := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+    var b strings.Builder
+    for _, r := range s {
+        if (r >= '0' && r <= '9') || r == '.' || r == ',' || r == '-' {
+            b.WriteRune(r)
+        }
+    }
+    v := b.String()
+    if v == "" {
+        return 0, false
+    }
+    lastDot, lastComma := strings.LastIndex(v, "."), strings.LastIndex(v, ",")
+    switch {
+    case lastDot >= 0 && lastComma >= 0:
+        if lastComma > lastDot {
+            // 1.234,56
+            v = strings.ReplaceAll(v, ".", "")
+            v = strings.Replace(v, ",", ".", 1)
+        } else {
+            v = strings.ReplaceAll(v, ",", "")
+        }
+    case lastComma >= 0:
+        // 只有逗号：逗号后恰好 3 位数字视为千分位，否则为小数点
+        if len(v)-lastComma-1 == 3 {
+            v = strings.ReplaceAll(v, ",", "")
+        } else {
+            v = strings.Replace(v, ",", ".", 1)
+        }
+    case strings.Count(v, ".") > 1, idr && statementDotThousands.MatchString(v):
+        // 1.234.567、Rp 15.000（印尼盾常见写法）
+        v = strings.ReplaceAll(v, ".", "")
+    }
+    n, err := strconv.ParseFloat(v, 64)
+    if err != nil {
+        return 0, false
+    }
+    if neg && n > 0 {
+        n = -n
+    }
+    return n, true
+}
+
+var statementDateLayouts = []string{
+    "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02",
+    "2006/01/02 15:04:05", "2006/01/02 15:04", "2006/01/02",
+    "02/01/2006 15:04:05", "02/01/2006 15:04", "02/01/2006",
+    "02-01-2006 15:04:05", "02-01-2006",
+    "02 Jan 2006 15:04", "02 Jan 2006", "2 Jan 2006", "Jan 2, 2006",
+    time.RFC3339,
+}
+
+// parseStatementDate 解析结算单日期为 YYYY-MM-DD，无法识别时返回空
+func parseStatementDate(s string) string {
+    s = strings.TrimSpace(s)
+    if s == "" {
+        return ""
+    }
+    for _, layout := range statementDateLayouts {
+        if t, err := time.Parse(layout, s); err == nil {
+            return t.Format("2006-01-02")
+        }
+    }
+    // Excel 日期序列号
+    if n, err := strconv.ParseFloat(s, 64); err == nil && n > 30000 && n < 80000 {
+        if t, err := excelize.ExcelDateToTime(n, false); err == nil {
+            return t.Format("2006-01-02")
+        }
+    }
+    return ""
+}
+
+// readStatementRows 读取结算单所有行：xlsx 取第一个工作表，其余按 CSV（自动识别逗号 / 分号 / 制表符分隔）
+func readStatementRows(data []byte) ([][]string, error) {
+    if bytes.HasPrefix(data, []byte("PK")) {
+        f, err := excelize.OpenReader(bytes.NewReader(data))
+        if err != nil {
+            return nil, fmt.Errorf("Excel 无法解析：%v", err)
+        }
+        defer f.Close()
+        sheets := f.GetSheetList()
+        if len(sheets) == 0 {
+            return nil, fmt.Errorf("Excel 中没有工作表")
+        }
+        return f.GetRows(sheets[0])
+    }
+    data = bytes.TrimPrefix(data, []byte("\ufeff"))
+    firstLine := data
+    if i := bytes.IndexByte(data, '\n'); i >= 0 {
+        firstLine = data[:i]
+    }
+    delim := ','
+    for _, d := range []rune{';', '\t'} {
+        if bytes.Count(firstLine, []byte(string(d))) > bytes.Count(firstLine, []byte(string(delim))) {
+            delim = d
+        }
+    }
+    r := csv.NewReader(bytes.NewReader(data))
+    r.Comma = delim
+    r.FieldsPerRecord = -1
+    r.LazyQuotes = true
+    rows, err := r.ReadAll()
+    if err != nil {
+        return nil, fmt.Errorf("CSV 无法解析：%v", err)
+    }
+    return rows, nil
+}
+
+// payoutColumns 识别出的格式和表头所在行，cols 为字段 → 列序号，feeCols 为扣费列
+type payoutColumns struct {
+    format    payoutFormat
+    headerRow int
+    headers   []string
+    cols      map[string]int
+    feeCols   []int
+}
+
+// detectPayoutFormat 在前 30 行中查找表头：平台结算单开头常有标题、店铺信息等说明行。
+// platform 不为空时只在该平台的格式中识别；mapping 为用户指定的字段 → 表头，覆盖格式中的默认列。
+func detectPayoutFormat(rows [][]string, platform string, mapping map[string]string) (*payoutColumns, error) {
+    limit := len(rows)
+    if limit > 30 {
+        limit = 30
+    }
+    for i := 0; i < limit; i++ {
+        index := map[string]int{}
+        for ci, h := range rows[i] {
+            if n := normalizePayoutHeader(h); n != "" {
+                if _, ok := index[n]; !ok {
+                    index[n] = ci
+                }
+            }
+        }
+        if len(index) == 0 {
+            continue
+        }
+        for _, f := range payoutFormats {
+            if platform != "" && !strings.EqualFold(platform, f.Platform) {
+                continue
+            }
+            matched := true
+            for _, h := range f.Detect {
+                if _, ok := index[h]; !ok {
+                    matched = false
+                    break
+                }
+            }
+            if !matched && len(mapping) > 0 {
+                // 表头与内置格式不一致时，按指定的映射识别（至少要有订单号列）
+                _, matched = index[normalizePayoutHeader(mapping[payoutFieldOrderNo])]
+                matched = matched && platform != ""
+            }
+            if !matched {
+                continue
+            }
+            pc := &payoutColumns{format: f, headerRow: i, headers: rows[i], cols: map[string]int{}}
+            for field, candidates := range f.Columns {
+                for _, h := range candidates {
+                    if ci, ok := index[h]; ok {
+                        pc.cols[field] = ci
+                        break
+                    }
+                }
+            }
+            for _, h := range f.FeeColumns {
+                if ci, ok := index[h]; ok {
+                    pc.feeCols = append(pc.feeCols, ci)
+                }
+            }
+            for field, h := range mapping {
+                ci, ok := index[normalizePayoutHeader(h)]
+                if !ok {
+                    return nil, fmt.Errorf("映射的表头“%s”在第 %d 行中不存在", h, i+1)
+                }
+                pc.cols[field] = ci
+                if field == payoutFieldFee {
+                    pc.feeCols = nil
+                }
+            }
+            if _, ok := pc.cols[payoutFieldOrderNo]; !ok {
+                return nil, fmt.Errorf("没有找到订单号列")
+            }
+            if _, ok := pc.cols[payoutFieldNet]; !ok {
+                return nil, fmt.Errorf("没有找到结算金额列")
+            }
+            return pc, nil
+        }
+    }
+    if platform != "" {
+        return nil, fmt.Errorf("无法识别 %s 结算单格式，请确认文件或通过 mapping 指定列", platform)
+    }
+    return nil, fmt.Errorf("无法识别结算单格式（支持 Shopee 收入报表、Lazada 交易明细、TikTok Shop 结算明细），请指定 platform 和 mapping")
+}
+
+// parsePayoutRows 按识别出的列解析结算单，返回结算行（尚未去重）和逐行错误
+func parsePayoutRows(rows [][]string, pc *payoutColumns, currency string) ([]models.PayoutLine, []gin.H) {
+    cell := func(r []string, field string) string {
+        ci, ok := pc.cols[field]
+        if !ok || ci >= len(r) {
+            return ""
+        }
+        return strings.TrimSpace(r[ci])
+    }
+    grossTypes := map[string]bool{}
+    for _, t := range pc.format.GrossTypes {
+        grossTypes[t] = true
+    }
+    var lines []models.PayoutLine
+    var rowErrors []gin.H
+    for i := pc.headerRow + 1; i < len(rows); i++ {
+        r := rows[i]
+        rowNo := i + 1
+        orderNo := cell(r, payoutFieldOrderNo)
+        netRaw := cell(r, payoutFieldNet)
+        if orderNo == "" && netRaw == "" {
+            // 空行、小计行
+            continue
+        }
+        l := models.PayoutLine{
+            Platform:        pc.format.Platform,
+            OrderNo:         orderNo,
+            TransactionType: cell(r, payoutFieldType),
+            Currency:        strings.ToUpper(cell(r, payoutFieldCurrency)),
+        }
+        if l.Currency == "" {
+            l.Currency = currency
+        }
+        var ok bool
+        if l.NetAmount, ok = parseStatementAmount(netRaw, l.Currency); !ok {
+            rowErrors = append(rowErrors, gin.H{"row": rowNo, "order_no": orderNo, "error": "结算金额无法识别：" + netRaw})
+            continue
+        }
+        if v := cell(r, payoutFieldDate); v != "" {
+            if l.PayoutDate = parseStatementDate(v); l.PayoutDate == "" {
+                rowErrors = append(rowErrors, gin.H{"row": rowNo, "order_no": orderNo, "error": "日期无法识别：" + v})
+                continue
+            }
+        }
+        if pc.format.LineItems {
+            if grossTypes[strings.ToLower(l.TransactionType)] {
+                l.GrossAmount = l.NetAmount
+            } else {
+                l.FeeAmount = l.NetAmount
+            }
+        } else {
+            l.GrossAmount, _ = parseStatementAmount(cell(r, payoutFieldGross), l.Currency)
+            if _, ok := pc.cols[payoutFieldFee]; ok {
+                l.FeeAmount, _ = parseStatementAmount(cell(r, payoutFieldFee), l.Currency)
+                l.FeeAmount = -math.Abs(l.FeeAmount)
+            }
+            for _, ci := range pc.feeCols {
+                if ci < len(r) {
+                    v, _ := parseStatementAmount(r[ci], l.Currency)
+                    l.FeeAmount -= math.Abs(v)
+                }
+            }
+        }
+        l.GrossAmount = roundMoney(l.GrossAmount)
+        l.FeeAmount = roundMoney(l.FeeAmount)
+        l.NetAmount = roundMoney(l.NetAmount)
+
+        raw := map[string]string{}
+        for ci, h := range pc.headers {
+            if ci < len(r) && strings.TrimSpace(h) != "" && strings.TrimSpace(r[ci]) != "" {
+                raw[strings.TrimSpace(h)] = strings.TrimSpace(r[ci])
+            }
+        }
+        b, _ := json.Marshal(raw)
+        l.Raw = string(b)
+        lines = append(lines, l)
+    }
+    // 行指纹：内容相同的行按出现次序编号，重复导入同一批数据时指纹一致
+    seen := map[string]int{}
+    for i := range lines {
+        l := &lines[i]
+        content := fmt.Sprintf("%s|%s|%s|%s|%.2f|%.2f|%.2f", l.Platform, l.OrderNo, l.TransactionType, l.PayoutDate, l.GrossAmount, l.FeeAmount, l.NetAmount)
+        seen[content]++
+        sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d", content, seen[content])))
+        l.LineKey = hex.EncodeToString(sum[:])
+    }
+    return lines, rowErrors
+}
+
+// ImportPayouts 导入平台结算单（CSV / Excel），仅管理员及以上：
+// - multipart 字段 file；platform 可选（Shopee / Lazada / TikTok，不传时按表头自动识别）；
+// - store_id 可选（结算单所属店铺，国家、币种取自店铺），否则需要 country；
+// - mapping 可选（JSON，字段 → 表头，字段见 payoutFields），用于平台调整了表头或自定义报表；
+// - ?dry_run=1 只返回识别结果和前 20 行预览，不写库。
+// 同一文件不能重复导入；不同文件中内容相同的行按行指纹跳过。
+// POST /api/payouts/import
+func ImportPayouts(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        role, _ := roleVal.(string)
+        if role != "superadmin" && role != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以导入结算单"})
+            return
+        }
+        userIDVal, _ := c.Get("userID")
+        uid, _ := userIDVal.(uint)
+        dryRun := c.Query("dry_run") == "1" || c.Query("dry_run") == "true"
+        c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPayoutImportBytes+(1<<20))
+
+        fh, err := c.FormFile("file")
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
+            return
+        }
+        f, err := fh.Open()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        data, err := io.ReadAll(io.LimitReader(f, maxPayoutImportBytes+1))
+        f.Close()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if len(data) > maxPayoutImportBytes {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "结算单不能超过 20MB"})
+            return
+        }
+        sum := sha256.Sum256(data)
+        fileHash := hex.EncodeToString(sum[:])
+        var existing models.PayoutImport
+        if err := db.Where("file_sha256 = ?", fileHash).First(&existing).Error; err == nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("该文件已导入（导入记录 #%d，%s）", existing.ID, existing.CreatedAt.Format("2006-01-02 15:04"))})
+            return
+        }
+
+        platform := strings.TrimSpace(c.PostForm("platform"))
+        var mapping map[string]string
+        if v := strings.TrimSpace(c.PostForm("mapping")); v != "" {
+            if err := json.Unmarshal([]byte(v), &mapping); err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "mapping 应为 JSON，例如 {\"order_no\":\"Order ID\",\"net_amount\":\"Payout Amount\"}"})
+                return
+            }
+            for field := range mapping {
+                known := false
+                for _, f := range payoutFields {
+                    known = known || f == field
+                }
+                if !known {
+                    c.JSON(http.StatusBadRequest, gin.H{"error": "mapping 字段只能是 " + strings.Join(payoutFields, " / ")})
+                    return
+                }
+            }
+        }
+
+        var storeID uint
+        country := strings.TrimSpace(c.PostForm("country"))
+        if v := c.PostForm("store_id"); v != "" {
+            id, _ := strconv.Atoi(v)
+            store, err := loadSettlementStore(db, uint(id), role, uid)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+            }
+            storeID, country = store.ID, store.Country
+            if platform == "" {
+                platform = store.Platform
+            }
+        }
+        currency, ok := countryCurrency[country]
+        if !ok {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "请选择店铺或国家（菲律宾 / 印尼 / 马来西亚）"})
+            return
+        }
+
+        rows, err := readStatementRows(data)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        pc, err := detectPayoutFormat(rows, platform, mapping)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        lines, rowErrors := parsePayoutRows(rows, pc, currency)
+        if rowErrors == nil {
+            rowErrors = []gin.H{}
+        }
+
+        // 跨文件去重
+        dup := map[string]bool{}
+        for i := 0; i < len(lines); i += 500 {
+            end := i + 500
+            if end > len(lines) {
+                end = len(lines)
+            }
+            keys := make([]string, 0, end-i)
+            for _, l := range lines[i:end] {
+                keys = append(keys, l.LineKey)
+            }
+            var found []string
+            if err := db.Model(&models.PayoutLine{}).Where("line_key IN ?", keys).Pluck("line_key", &found).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            for _, k := range found {
+                dup[k] = true
+            }
+        }
+        imp := models.PayoutImport{
+            Platform: pc.format.Platform, Format: pc.format.Name, FileName: fh.Filename, FileSHA256: fileHash,
+            StoreID: storeID, Country: country, Currency: currency, UserID: uid,
+        }
+        fresh := make([]models.PayoutLine, 0, len(lines))
+        for _, l := range lines {
+            if dup[l.LineKey] {
+                imp.DuplicateCount++
+                continue
+            }
+            l.StoreID, l.Country = storeID, country
+            fresh = append(fresh, l)
+            imp.NetTotal += l.NetAmount
+            if l.PayoutDate != "" && (imp.PeriodStart == "" || l.PayoutDate < imp.PeriodStart) {
+                imp.PeriodStart = l.PayoutDate
+            }
+            if l.PayoutDate > imp.PeriodEnd {
+                imp.PeriodEnd = l.PayoutDate
+            }
+        }
+        imp.LineCount = len(fresh)
+        imp.NetTotal = roundMoney(imp.NetTotal)
+
+        mapped := map[string]string{}
+        for field, ci := range pc.cols {
+            if ci < len(pc.headers) {
+                mapped[field] = pc.headers[ci]
+            }
+        }
+        feeHeaders := []string{}
+        for _, ci := range pc.feeCols {
+            feeHeaders = append(feeHeaders, pc.headers[ci])
+        }
+        result := gin.H{
+            "dry_run":      dryRun,
+            "format":       pc.format.Name,
+            "format_label": pc.format.Label,
+            "platform":     pc.format.Platform,
+            "header_row":   pc.headerRow + 1,
+            "columns":      mapped,
+            "fee_columns":  feeHeaders,
+            "errors":       rowErrors,
+        }
+        if dryRun {
+            preview := fresh
+            if len(preview) > 20 {
+                preview = preview[:20]
+            }
+            result["import"] = imp
+            result["preview"] = preview
+            c.JSON(http.StatusOK, result)
+            return
+        }
+        if len(fresh) == 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "没有可导入的新结算行", "duplicate_count": imp.DuplicateCount, "errors": rowErrors})
+            return
+        }
+        err = db.Transaction(func(tx *gorm.DB) error {
+            if err := tx.Create(&imp).Error; err != nil {
+                return err
+            }
+            for i := range fresh {
+                fresh[i].ImportID = imp.ID
+            }
+            return tx.CreateInBatches(&fresh, 500).Error
+        })
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        result["import"] = imp
+        c.JSON(http.StatusOK, result)
+    }
+}
+
+// ListPayoutImports 结算单导入记录
+// GET /api/payouts/imports?platform=
+func ListPayoutImports(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看结算单"})
+            return
+        }
+        q := db.Model(&models.PayoutImport{})
+        if v := c.Query("platform"); v != "" {
+            q = q.Where("platform = ?", v)
+        }
+        var list []models.PayoutImport
+        if err := q.Order("id desc").Limit(200).Find(&list).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        formats := make([]gin.H, 0, len(payoutFormats))
+        for _, f := range payoutFormats {
+            formats = append(formats, gin.H{"name": f.Name, "platform": f.Platform, "label": f.Label, "columns": f.Columns, "fee_columns": f.FeeColumns})
+        }
+        c.JSON(http.StatusOK, gin.H{"items": list, "formats": formats})
+    }
+}
+
+// DeletePayoutImport 删除一次导入及其结算行（仅超级管理员），用于导错文件后重新导入
+// DELETE /api/payouts/imports/:id
+func DeletePayoutImport(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, _ := c.Get("role"); roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以删除结算单"})
+            return
+        }
+        var imp models.PayoutImport
+        if err := db.First(&imp, c.Param("id")).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "导入记录不存在"})
+            return
+        }
+        err := db.Transaction(func(tx *gorm.DB) error {
+            if err := tx.Where("import_id = ?", imp.ID).Delete(&models.PayoutLine{}).Error; err != nil {
+                return err
+            }
+            return tx.Delete(&imp).Error
+        })
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"ok": true})
+    }
+}
+
+// 对账明细每类最多返回的条数
+const reconcileListLimit = 500
+
+// reconcileOrder 一个订单号的订单金额与结算金额
+type reconcileOrder struct {
+    OrderNo     string  `json:"order_no"`
+    Date        string  `json:"date"`
+    Country     string  `json:"country"`
+    StoreID     uint    `json:"store_id"`
+    Currency    string  `json:"currency"`
+    OrderAmount float64 `json:"order_amount"`
+    PayoutDate  string  `json:"payout_date"`
+    PayoutCur   string  `json:"payout_currency"`
+    Gross       float64 `json:"gross_amount"`
+    Fee         float64 `json:"fee_amount"`
+    Net         float64 `json:"net_amount"`
+    Basis       string  `json:"basis,omitempty"` // 比较口径：gross 结算单原价 / net 结算金额
+    Diff        float64 `json:"diff"`            // 结算单金额 - 订单金额（币种不同时为人民币）
+}
+
+// ReconcilePayouts 结算单对账：按订单号匹配订单和结算行
+// GET /api/payouts/reconcile?platform=&start=&end=&store_id=&country=&tolerance=1&tolerance_pct=0
+// - 订单范围：该平台在 start ~ end 录入的订单（按订单号合并多行商品），可按店铺 / 国家筛选；
+// - unmatched_orders：没有任何结算行的订单（可能尚未打款）；
+// - unmatched_payouts：结算日期在 start ~ end、订单号在系统中找不到的结算行；
+// - mismatches：结算单原价（没有原价时用结算金额）与订单金额相差超过 max(tolerance, 订单金额 × tolerance_pct%) 的订单。
+func ReconcilePayouts(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以对账"})
+            return
+        }
+        platform := strings.TrimSpace(c.Query("platform"))
+        start, end := c.Query("start"), c.Query("end")
+        if platform == "" || start == "" || end == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "platform、start、end 不能为空"})
+            return
+        }
+        s, err1 := time.Parse("2006-01-02", start)
+        e, err2 := time.Parse("2006-01-02", end)
+        if err1 != nil || err2 != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "日期格式应为 YYYY-MM-DD"})
+            return
+        }
+        if e.Before(s) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "end 不能早于 start"})
+            return
+        }
+        tolerance, err := strconv.ParseFloat(c.DefaultQuery("tolerance", "1"), 64)
+        if err != nil || tolerance < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "tolerance 应为非负数"})
+            return
+        }
+        tolerancePct, err := strconv.ParseFloat(c.DefaultQuery("tolerance_pct", "0"), 64)
+        if err != nil || tolerancePct < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "tolerance_pct 应为非负数"})
+            return
+        }
+        storeID, _ := strconv.Atoi(c.Query("store_id"))
+        country := c.Query("country")
+
+        // 订单（排除每日总额汇总行），按订单号合并
+        var orders []reconcileOrder
+        oq := db.Model(&models.Order{}).
+            Select("order_no, DATE_FORMAT(MIN(created_at), '%Y-%m-%d') AS date, MAX(country) AS country, MAX(store_id) AS store_id, MAX(currency) AS currency, SUM(total_amount) AS order_amount").
+            Where("LOWER(platform) = LOWER(?)", platform).
+            Where("DATE(created_at) >= ? AND DATE(created_at) <= ?", start, end).
+            Where("product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇").
+            Where("order_no <> ''")
+        if storeID > 0 {
+            oq = oq.Where("store_id = ?", storeID)
+        }
+        if country != "" {
+            oq = oq.Where("country = ?", country)
+        }
+        if err := oq.Group("order_no").Order("date asc, order_no asc").Scan(&orders).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        // 这些订单的结算行（不限结算日期）
+        type payoutAgg struct {
+            OrderNo    string
+            PayoutDate string
+            Currency   string
+            Gross      float64
+            Fee        float64
+            Net        float64
+        }
+        payouts := map[string]payoutAgg{}
+        for i := 0; i < len(orders); i += 1000 {
+            endIdx := i + 1000
+            if endIdx > len(orders) {
+                endIdx = len(orders)
+            }
+            nos := make([]string, 0, endIdx-i)
+            for _, o := range orders[i:endIdx] {
+                nos = append(nos, o.OrderNo)
+            }
+            var rows []payoutAgg
+            if err := db.Model(&models.PayoutLine{}).
+                Select("order_no, MAX(payout_date) AS payout_date, MAX(currency) AS currency, SUM(gross_amount) AS gross, SUM(fee_amount) AS fee, SUM(net_amount) AS net").
+                Where("platform = ? AND order_no IN ?", platformName(platform), nos).
+                Group("order_no").Scan(&rows).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            for _, r := range rows {
+                payouts[r.OrderNo] = r
+            }
+        }
+
+        var rates map[string]float64
+        var rateErr error
+        warnings := []string{}
+        unmatchedOrders := []reconcileOrder{}
+        mismatches := []reconcileOrder{}
+        summary := gin.H{}
+        var orderTotal, grossTotal, feeTotal, netTotal float64
+        matched := 0
+        for _, o := range orders {
+            orderTotal += o.OrderAmount
+            p, ok := payouts[o.OrderNo]
+            if !ok {
+                unmatchedOrders = append(unmatchedOrders, o)
+                continue
+            }
+            matched++
+            grossTotal += p.Gross
+            feeTotal += p.Fee
+            netTotal += p.Net
+            o.PayoutDate, o.PayoutCur = p.PayoutDate, p.Currency
+            o.Gross, o.Fee, o.Net = roundMoney(p.Gross), roundMoney(p.Fee), roundMoney(p.Net)
+            o.OrderAmount = roundMoney(o.OrderAmount)
+            payoutAmount, basis := p.Gross, "gross"
+            if p.Gross == 0 {
+                payoutAmount, basis = p.Net, "net"
+            }
+            o.Basis = basis
+            orderAmount := o.OrderAmount
+            if o.Currency != "" && p.Currency != "" && !strings.EqualFold(o.Currency, p.Currency) {
+                // 订单和结算单币种不同：都折算成人民币再比较
+                if rates == nil && rateErr == nil {
+                    rates, rateErr = utils.GetRates()
+                }
+                a, ok1 := amountToCNY(orderAmount, o.Currency, rates)
+                b, ok2 := amountToCNY(payoutAmount, p.Currency, rates)
+                if !ok1 || !ok2 {
+                    warnings = append(warnings, fmt.Sprintf("订单 %s 币种 %s 与结算单币种 %s 不同且无法获取汇率，未比较金额", o.OrderNo, o.Currency, p.Currency))
+                    continue
+                }
+                orderAmount, payoutAmount = a, b
+            }
+            o.Diff = roundMoney(payoutAmount - orderAmount)
+            threshold := math.Max(tolerance, math.Abs(orderAmount)*tolerancePct/100)
+            if math.Abs(o.Diff) > threshold {
+                mismatches = append(mismatches, o)
+            }
+        }
+        sort.SliceStable(mismatches, func(i, j int) bool { return math.Abs(mismatches[i].Diff) > math.Abs(mismatches[j].Diff) })
+
+        // 结算日期在范围内、订单号在系统中找不到的结算行
+        unmatchedPayouts := []reconcileOrder{}
+        uq := db.Table("payout_lines AS pl").
+            Select("pl.order_no, MAX(pl.payout_date) AS payout_date, MAX(pl.country) AS country, MAX(pl.store_id) AS store_id, MAX(pl.currency) AS payout_cur, SUM(pl.gross_amount) AS gross, SUM(pl.fee_amount) AS fee, SUM(pl.net_amount) AS net").
+            Where("pl.platform = ? AND pl.payout_date >= ? AND pl.payout_date <= ? AND pl.order_no <> ''", platformName(platform), start, end).
+            Where("NOT EXISTS (SELECT 1 FROM orders o WHERE o.order_no = pl.order_no)")
+        if storeID > 0 {
+            uq = uq.Where("pl.store_id = ?", storeID)
+        }
+        if country != "" {
+            uq = uq.Where("pl.country = ?", country)
+        }
+        if err := uq.Group("pl.order_no").Order("payout_date asc").Scan(&unmatchedPayouts).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        var unmatchedPayoutNet float64
+        for i := range unmatchedPayouts {
+            unmatchedPayoutNet += unmatchedPayouts[i].Net
+            unmatchedPayouts[i].Gross = roundMoney(unmatchedPayouts[i].Gross)
+            unmatchedPayouts[i].Fee = roundMoney(unmatchedPayouts[i].Fee)
+            unmatchedPayouts[i].Net = roundMoney(unmatchedPayouts[i].Net)
+        }
+        var noOrderNo int64
+        nq := db.Model(&models.PayoutLine{}).Where("platform = ? AND payout_date >= ? AND payout_date <= ? AND order_no = ''", platformName(platform), start, end)
+        if storeID > 0 {
+            nq = nq.Where("store_id = ?", storeID)
+        }
+        if err := nq.Count(&noOrderNo).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if noOrderNo > 0 {
+            warnings = append(warnings, fmt.Sprintf("结算期内有 %d 条没有订单号的结算行（调整、提现等），不参与对账", noOrderNo))
+        }
+        if rateErr != nil {
+            warnings = append(warnings, "获取汇率失败："+rateErr.Error())
+        }
+
+        summary["orders"] = len(orders)
+        summary["matched"] = matched
+        summary["unmatched_orders"] = len(unmatchedOrders)
+        summary["unmatched_payouts"] = len(unmatchedPayouts)
+        summary["mismatches"] = len(mismatches)
+        summary["order_amount"] = roundMoney(orderTotal)
+        summary["payout_gross"] = roundMoney(grossTotal)
+        summary["payout_fee"] = roundMoney(feeTotal)
+        summary["payout_net"] = roundMoney(netTotal)
+        summary["unmatched_payout_net"] = roundMoney(unmatchedPayoutNet)
+
+        truncated := false
+        limit := func(list []reconcileOrder) []reconcileOrder {
+            if len(list) > reconcileListLimit {
+                truncated = true
+                return list[:reconcileListLimit]
+            }
+            return list
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "platform":          platformName(platform),
+            "start":             start,
+            "end":               end,
+            "tolerance":         tolerance,
+            "tolerance_pct":     tolerancePct,
+            "summary":           summary,
+            "unmatched_orders":  limit(unmatchedOrders),
+            "unmatched_payouts": limit(unmatchedPayouts),
+            "mismatches":        limit(mismatches),
+            "truncated":         truncated,
+            "warnings":          warnings,
+        })
+    }
+}
+
+// platformName 把 shopee / LAZADA 等写法统一为结算单中的平台名
+func platformName(p string) string {
+    for _, f := range payoutFormats {
+        if strings.EqualFold(p, f.Platform) {
+            return f.Platform
+        }
+    }
+    return p
+}
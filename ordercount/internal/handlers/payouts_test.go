@@ -0,0 +1,150 @@
+package handlers
+
+import "testing"
+
+func TestParseStatementAmount(t *testing.T) {
+    cases := []struct {
+        in       string
+        currency string
+        want     float64
+        ok       bool
+    }{
+        {"", "PHP", 0, true},
+        {"-", "PHP", 0, true},
+        {"1234.56", "PHP", 1234.56, true},
+        {"1,234.56", "PHP", 1234.56, true},
+        {"₱1,234.56", "PHP", 1234.56, true},
+        {"(1,234.56)", "PHP", -1234.56, true},
+        {"-88.5", "MYR", -88.5, true},
+        {"RM 12,50", "MYR", 12.5, true},
+        {"1,234", "PHP", 1234, true},
+        {"15.000", "PHP", 15, true},
+        {"1.234,56", "IDR", 1234.56, true},
+        {"1.234.567", "IDR", 1234567, true},
+        {"15.000", "IDR", 15000, true},
+        {"15.000", "idr", 15000, true},
+        {"-15.000", "IDR", -15000, true},
+        {"Rp 15.000", "", 15000, true},
+        {"(Rp 1.500)", "", -1500, true},
+        {"15.5", "IDR", 15.5, true},
+        {"abc", "PHP", 0, false},
+    }
+    for _, tc := range cases {
+        got, ok := parseStatementAmount(tc.in, tc.currency)
+        if ok != tc.ok || got != tc.want {
+            t.Errorf("parseStatementAmount(%q, %q) = %v, %v，期望 %v, %v", tc.in, tc.currency, got, ok, tc.want, tc.ok)
+        }
+    }
+}
+
+func TestParseStatementDate(t *testing.T) {
+    cases := []struct {
+        in, want string
+    }{
+        {"", ""},
+        {"2024-03-05", "2024-03-05"},
+        {"2024-03-05 13:45:10", "2024-03-05"},
+        {"2024/03/05 13:45", "2024-03-05"},
+        {"05/03/2024", "2024-03-05"},
+        {"05-03-2024 08:00:00", "2024-03-05"},
+        {"05 Mar 2024", "2024-03-05"},
+        {"Mar 5, 2024", "2024-03-05"},
+        {"2024-03-05T13:45:10+08:00", "2024-03-05"},
+        {"45292", "2024-01-01"},
+        {"12345", ""},
+        {"yesterday", ""},
+    }
+    for _, tc := range cases {
+        if got := parseStatementDate(tc.in); got != tc.want {
+            t.Errorf("parseStatementDate(%q) = %q，期望 %q", tc.in, got, tc.want)
+        }
+    }
+}
+
+func TestDetectPayoutFormat(t *testing.T) {
+    shopee := [][]string{
+        {"Income Report"},
+        {"Shop: demo", ""},
+        {"\ufeffOrder ID", "Payout Completed Date", "Original Product Price (₱)", "Commission Fee", "Service Fee", "Total Released Amount (₱)"},
+        {"2403050001", "2024-03-05", "100", "5", "2", "93"},
+    }
+    lazada := [][]string{
+        {"Transaction Date", "Fee Name", "Amount", "Order No."},
+        {"05 Mar 2024", "Item Price Credit", "100", "LZ1"},
+    }
+    tiktok := [][]string{
+        {"Order/adjustment ID", "Type", "Order settled time", "Currency", "Total Revenue", "Total Fees", "Total settlement amount"},
+    }
+    custom := [][]string{
+        {"Ref", "Paid On", "Payout Amount"},
+    }
+
+    cases := []struct {
+        name      string
+        rows      [][]string
+        platform  string
+        mapping   map[string]string
+        format    string
+        headerRow int
+        cols      map[string]int
+        feeCols   int
+        wantErr   bool
+    }{
+        {
+            name: "Shopee 表头前有说明行", rows: shopee, format: "shopee_income", headerRow: 2,
+            cols:    map[string]int{payoutFieldOrderNo: 0, payoutFieldDate: 1, payoutFieldGross: 2, payoutFieldNet: 5},
+            feeCols: 2,
+        },
+        {
+            name: "Lazada 表头末尾句点", rows: lazada, format: "lazada_transaction",
+            cols: map[string]int{payoutFieldOrderNo: 3, payoutFieldDate: 0, payoutFieldType: 1, payoutFieldNet: 2},
+        },
+        {
+            name: "TikTok", rows: tiktok, format: "tiktok_settlement",
+            cols: map[string]int{payoutFieldOrderNo: 0, payoutFieldType: 1, payoutFieldDate: 2, payoutFieldCurrency: 3, payoutFieldGross: 4, payoutFieldFee: 5, payoutFieldNet: 6},
+        },
+        {name: "指定平台不匹配", rows: shopee, platform: "TikTok", wantErr: true},
+        {name: "无法识别", rows: custom, wantErr: true},
+        {
+            name: "按映射识别", rows: custom, platform: "Shopee", format: "shopee_income",
+            mapping: map[string]string{payoutFieldOrderNo: "Ref", payoutFieldDate: "Paid On", payoutFieldNet: "Payout Amount"},
+            cols:    map[string]int{payoutFieldOrderNo: 0, payoutFieldDate: 1, payoutFieldNet: 2},
+        },
+        {
+            name: "映射的表头不存在", rows: custom, platform: "Shopee", wantErr: true,
+            mapping: map[string]string{payoutFieldOrderNo: "Ref", payoutFieldNet: "Net"},
+        },
+        {
+            name: "映射缺少结算金额列", rows: custom, platform: "Shopee", wantErr: true,
+            mapping: map[string]string{payoutFieldOrderNo: "Ref"},
+        },
+    }
+    for _, tc := range cases {
+        t.Run(tc.name, func(t *testing.T) {
+            pc, err := detectPayoutFormat(tc.rows, tc.platform, tc.mapping)
+            if tc.wantErr {
+                if err == nil {
+                    t.Fatalf("期望识别失败，实际识别为 %s", pc.format.Name)
+                }
+                return
+            }
+            if err != nil {
+                t.Fatalf("识别失败：%v", err)
+            }
+            if pc.format.Name != tc.format || pc.headerRow != tc.headerRow {
+                t.Errorf("识别为 %s（表头第 %d 行），期望 %s（第 %d 行）", pc.format.Name, pc.headerRow, tc.format, tc.headerRow)
+            }
+            if len(pc.cols) != len(tc.cols) {
+                t.Errorf("识别出的列 %v，期望 %v", pc.cols, tc.cols)
+            }
+            for field, ci := range tc.cols {
+                if got, ok := pc.cols[field]; !ok || got != ci {
+                    t.Errorf("字段 %s 在第 %d 列，期望第 %d 列", field, got, ci)
+                }
+            }
+            if len(pc.feeCols) != tc.feeCols {
+                t.Errorf("扣费列 %v，期望 %d 列", pc.feeCols, tc.feeCols)
+            }
+        })
+    }
+}
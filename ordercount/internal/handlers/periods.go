@@ -0,0 +1,497 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+)
+
+// 会计期间状态
+const (
+    PeriodOpen   = "open"
+    PeriodClosed = "closed"
+)
+
+// periodMetrics 月结快照中的汇总指标，顺序即报表展示顺序。
+// 订单、每日总额汇总按币种合计后折算为人民币（见 computePeriodSnapshot），结算金额为人民币；
+// 店铺每日数据为本国货币，只在按国家对比时有意义。
+var periodMetrics = []struct{ Key, Label string }{
+    {"order_count", "订单数"},
+    {"order_quantity", "销量"},
+    {"order_amount", "订单销售额"},
+    {"order_goods_cost", "订单货款成本"},
+    {"summary_amount", "每日总额汇总"},
+    {"settlement_count", "结算条数"},
+    {"settlement_sale_total", "结算销售额"},
+    {"settlement_ad_deduction", "结算广告成本"},
+    {"settlement_goods_cost", "结算货款成本"},
+    {"settlement_platform_fee", "结算平台手续费"},
+    {"settlement_shua_dan_fee", "结算刷单费用"},
+    {"settlement_fixed_cost", "结算固定成本"},
+    {"settlement_profit", "结算利润"},
+    {"store_ad_cost", "店铺广告费（本国货币）"},
+    {"store_sale_total", "店铺销售额（本国货币）"},
+}
+
+// periodSnapshot 某个月的汇总数据：全部合计和按国家合计。
+// Rates 为订单金额折算人民币使用的汇率（1 外币 ≈ ? 人民币），对比实时数据时沿用，避免汇率变动被当成数据变化。
+type periodSnapshot struct {
+    Period    string                        `json:"period"`
+    TakenAt   string                        `json:"taken_at"`
+    Totals    map[string]float64            `json:"totals"`
+    ByCountry map[string]map[string]float64 `json:"by_country"`
+    Rates     map[string]float64            `json:"rates,omitempty"`
+    Warnings  []string                      `json:"warnings,omitempty"`
+}
+
+// parsePeriod 校验 YYYY-MM，返回该月第一天和下个月第一天（日期字符串）
+func parsePeriod(period string) (string, string, error) {
+    t, err := time.Parse("2006-01", strings.TrimSpace(period))
+    if err != nil {
+        return "", "", fmt.Errorf("账期格式应为 YYYY-MM")
+    }
+    return t.Format("2006-01-02"), t.AddDate(0, 1, 0).Format("2006-01-02"), nil
+}
+
+// ensurePeriodOpen 检查日期（YYYY-MM-DD，可传多个）所在月份是否已关账，已关账时返回错误
+func ensurePeriodOpen(db *gorm.DB, dates ...string) error {
+    periods := make([]string, 0, len(dates))
+    for _, d := range dates {
+        if len(d) >= 7 {
+            periods = append(periods, d[:7])
+        }
+    }
+    if len(periods) == 0 {
+        return nil
+    }
+    var closed []models.AccountingPeriod
+    if err := db.Select("period").Where("period IN ? AND status = ?", periods, PeriodClosed).Find(&closed).Error; err != nil {
+        return err
+    }
+    if len(closed) > 0 {
+        return fmt.Errorf("%s 已月结关账，不能修改该月的订单、结算和店铺数据，如需调整请超级管理员重新打开账期", closed[0].Period)
+    }
+    return nil
+}
+
+// ensurePeriodOpenAt 同 ensurePeriodOpen，参数为时间（订单按 created_at 的日期归属月份）
+func ensurePeriodOpenAt(db *gorm.DB, times ...time.Time) error {
+    dates := make([]string, 0, len(times))
+    for _, t := range times {
+        if !t.IsZero() {
+            dates = append(dates, t.Format("2006-01-02"))
+        }
+    }
+    return ensurePeriodOpen(db, dates...)
+}
+
+// closedPeriods 返回全部已关账的月份（YYYY-MM）
+func closedPeriods(db *gorm.DB) ([]string, error) {
+    var periods []string
+    err := db.Model(&models.AccountingPeriod{}).Where("status = ?", PeriodClosed).Pluck("period", &periods).Error
+    return periods, err
+}
+
+// excludeClosedOrders 过滤掉下单日期在已关账月份的订单：这些订单的商品匹配和货款成本已随月结冻结
+func excludeClosedOrders(q *gorm.DB, closed []string) *gorm.DB {
+    if len(closed) == 0 {
+        return q
+    }
+    return q.Where("DATE_FORMAT(created_at, '%Y-%m') NOT IN ?", closed)
+}
+
+// computePeriodSnapshot 按当前数据计算某个月的汇总；订单金额按币种折算人民币，rates 中的币种使用指定汇率，其余按实时汇率
+func computePeriodSnapshot(db *gorm.DB, period string, rates map[string]float64) (*periodSnapshot, error) {
+    start, end, err := parsePeriod(period)
+    if err != nil {
+        return nil, err
+    }
+    snap := &periodSnapshot{
+        Period:    period,
+        TakenAt:   time.Now().Format("2006-01-02 15:04:05"),
+        Totals:    map[string]float64{},
+        ByCountry: map[string]map[string]float64{},
+    }
+    add := func(country string, values map[string]float64) {
+        if snap.ByCountry[country] == nil {
+            snap.ByCountry[country] = map[string]float64{}
+        }
+        for k, v := range values {
+            snap.ByCountry[country][k] += v
+            snap.Totals[k] += v
+        }
+    }
+
+    // 订单金额为各自币种，按国家、币种合计后折算人民币再相加
+    cv := newCNYConverter(rates)
+    var orders []struct {
+        Country   string
+        Currency  string
+        Cnt       float64
+        Quantity  float64
+        Amount    float64
+        GoodsCost float64
+    }
+    if err := db.Table("orders AS o").Joins(orderProductJoin).
+        Select("o.country, o.currency, COUNT(*) AS cnt, IFNULL(SUM(o.quantity), 0) AS quantity, IFNULL(SUM(o.total_amount), 0) AS amount, "+
+            "IFNULL(SUM("+orderGoodsCostExpr+"), 0) AS goods_cost").
+        Where("o.created_at >= ? AND o.created_at < ?", start, end).
+        Where("o.product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇").
+        Group("o.country, o.currency").Scan(&orders).Error; err != nil {
+        return nil, err
+    }
+    for _, r := range orders {
+        add(r.Country, map[string]float64{
+            "order_count":      r.Cnt,
+            "order_quantity":   r.Quantity,
+            "order_amount":     cv.convert(r.Amount, r.Currency),
+            "order_goods_cost": r.GoodsCost,
+        })
+    }
+
+    var summaries []struct {
+        Country  string
+        Currency string
+        Amount   float64
+    }
+    if err := db.Model(&models.Order{}).
+        Select("country, currency, IFNULL(SUM(total_amount), 0) AS amount").
+        Where("created_at >= ? AND created_at < ?", start, end).
+        Where("product_name IN (?, ?)", "今日总额汇总", "今日总汇").
+        Group("country, currency").Scan(&summaries).Error; err != nil {
+        return nil, err
+    }
+    for _, r := range summaries {
+        add(r.Country, map[string]float64{"summary_amount": cv.convert(r.Amount, r.Currency)})
+    }
+    snap.Rates = cv.used()
+    snap.Warnings = cv.warnings()
+
+    var settlements []struct {
+        Country     string
+        Cnt         float64
+        SaleTotal   float64
+        AdDeduction float64
+        GoodsCost   float64
+        PlatformFee float64
+        ShuaDanFee  float64
+        FixedCost   float64
+        Profit      float64
+    }
+    if err := db.Model(&models.DailySettlement{}).
+        Select("country, COUNT(*) AS cnt, SUM(sale_total_cny) AS sale_total, SUM(ad_deduction) AS ad_deduction, " +
+            "SUM(goods_cost_cny) AS goods_cost, SUM(platform_fee) AS platform_fee, SUM(shua_dan_fee_cny) AS shua_dan_fee, " +
+            "SUM(fixed_cost_cny) AS fixed_cost, SUM(profit) AS profit").
+        Where("date >= ? AND date < ?", start, end).
+        Group("country").Scan(&settlements).Error; err != nil {
+        return nil, err
+    }
+    for _, r := range settlements {
+        add(r.Country, map[string]float64{
+            "settlement_count":        r.Cnt,
+            "settlement_sale_total":   r.SaleTotal,
+            "settlement_ad_deduction": r.AdDeduction,
+            "settlement_goods_cost":   r.GoodsCost,
+            "settlement_platform_fee": r.PlatformFee,
+            "settlement_shua_dan_fee": r.ShuaDanFee,
+            "settlement_fixed_cost":   r.FixedCost,
+            "settlement_profit":       r.Profit,
+        })
+    }
+
+    var stats []struct {
+        Country   string
+        AdCost    float64
+        SaleTotal float64
+    }
+    if err := db.Table("store_daily_stats AS d").
+        Joins("LEFT JOIN stores AS s ON s.id = d.store_id").
+        Select("IFNULL(s.country, '') AS country, IFNULL(SUM(d.ad_cost), 0) AS ad_cost, IFNULL(SUM(d.sale_total), 0) AS sale_total").
+        Where("d.date >= ? AND d.date < ?", start, end).
+        Group("s.country").Scan(&stats).Error; err != nil {
+        return nil, err
+    }
+    for _, r := range stats {
+        add(r.Country, map[string]float64{"store_ad_cost": r.AdCost, "store_sale_total": r.SaleTotal})
+    }
+
+    for k, v := range snap.Totals {
+        snap.Totals[k] = roundMoney(v)
+    }
+    for _, m := range snap.ByCountry {
+        for k, v := range m {
+            m[k] = roundMoney(v)
+        }
+    }
+    return snap, nil
+}
+
+// periodCompareItem 快照与实时数据的对比
+type periodCompareItem struct {
+    Key      string  `json:"key"`
+    Label    string  `json:"label"`
+    Snapshot float64 `json:"snapshot"`
+    Live     float64 `json:"live"`
+    Diff     float64 `json:"diff"`
+}
+
+func comparePeriodMetrics(snap, live map[string]float64) ([]periodCompareItem, bool) {
+    items := make([]periodCompareItem, 0, len(periodMetrics))
+    changed := false
+    for _, m := range periodMetrics {
+        it := periodCompareItem{Key: m.Key, Label: m.Label, Snapshot: snap[m.Key], Live: live[m.Key]}
+        it.Diff = roundMoney(it.Live - it.Snapshot)
+        if it.Diff != 0 {
+            changed = true
+        }
+        items = append(items, it)
+    }
+    return items, changed
+}
+
+// ListPeriods 会计期间列表（只包含关过账的月份，其余月份均为未关账）
+// GET /api/periods
+func ListPeriods(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看账期"})
+            return
+        }
+        var list []models.AccountingPeriod
+        if err := db.Omit("snapshot").Order("period desc").Find(&list).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"items": list})
+    }
+}
+
+// ClosePeriod 月结关账（仅超级管理员）：保存当月汇总快照，当月已审批的结算同时锁定。
+// 关账后该月的订单、结算和店铺每日数据的任何修改都会被拒绝。
+// POST /api/periods/:period/close
+func ClosePeriod(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, _ := c.Get("role"); roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以月结关账"})
+            return
+        }
+        period := strings.TrimSpace(c.Param("period"))
+        start, end, err := parsePeriod(period)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if end > time.Now().Format("2006-01-02") {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "只能关闭已经结束的月份"})
+            return
+        }
+        var p models.AccountingPeriod
+        err = db.Where("period = ?", period).First(&p).Error
+        if err != nil && err != gorm.ErrRecordNotFound {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if p.Status == PeriodClosed {
+            c.JSON(http.StatusBadRequest, gin.H{"error": period + " 已经关账"})
+            return
+        }
+
+        userIDVal, _ := c.Get("userID")
+        uid, _ := userIDVal.(uint)
+
+        var snap *periodSnapshot
+        var pending int64
+        err = db.Transaction(func(tx *gorm.DB) error {
+            now := time.Now()
+            p.Period = period
+            p.Status = PeriodClosed
+            p.ClosedBy = uid
+            p.ClosedAt = &now
+            if err := tx.Save(&p).Error; err != nil {
+                return err
+            }
+            // 先写入关账状态再计算快照，之后的写入会被 ensurePeriodOpen 拒绝，快照不会漏掉关账前最后的修改
+            var err error
+            if snap, err = computePeriodSnapshot(tx, period, nil); err != nil {
+                return err
+            }
+            data, _ := json.Marshal(snap)
+            p.Snapshot = string(data)
+            if err := tx.Model(&p).Update("snapshot", p.Snapshot).Error; err != nil {
+                return err
+            }
+            // 草稿和待审批的结算不阻止关账，但提示出来
+            if err := tx.Model(&models.DailySettlement{}).
+                Where("date >= ? AND date < ? AND status IN ?", start, end, []string{SettlementDraft, SettlementSubmitted}).
+                Count(&pending).Error; err != nil {
+                return err
+            }
+            var approved []models.DailySettlement
+            if err := tx.Where("date >= ? AND date < ? AND status = ?", start, end, SettlementApproved).Find(&approved).Error; err != nil {
+                return err
+            }
+            for i := range approved {
+                if err := setSettlementStatus(tx, &approved[i], SettlementLocked, "月结关账 "+period, uid); err != nil {
+                    return err
+                }
+            }
+            return tx.Create(&models.PeriodLog{Period: period, Action: "close", UserID: uid, CreatedAt: now}).Error
+        })
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        warnings := []string{}
+        if pending > 0 {
+            warnings = append(warnings, fmt.Sprintf("该月还有 %d 条结算未审批，关账后不能再修改", pending))
+        }
+        c.JSON(http.StatusOK, gin.H{"period": p, "snapshot": snap, "warnings": warnings})
+    }
+}
+
+// ReopenPeriod 重新打开已关账的月份（仅超级管理员，必须填写原因），保留上次关账的快照用于对比
+// POST /api/periods/:period/reopen  {reason}
+func ReopenPeriod(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, _ := c.Get("role"); roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以重新打开账期"})
+            return
+        }
+        var body struct {
+            Reason string `json:"reason"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        body.Reason = strings.TrimSpace(body.Reason)
+        if body.Reason == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "重新打开账期需要填写原因"})
+            return
+        }
+        period := strings.TrimSpace(c.Param("period"))
+        var p models.AccountingPeriod
+        if err := db.Where("period = ?", period).First(&p).Error; err != nil && err != gorm.ErrRecordNotFound {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if p.Status != PeriodClosed {
+            c.JSON(http.StatusBadRequest, gin.H{"error": period + " 未关账"})
+            return
+        }
+        userIDVal, _ := c.Get("userID")
+        uid, _ := userIDVal.(uint)
+        err := db.Transaction(func(tx *gorm.DB) error {
+            now := time.Now()
+            if err := tx.Model(&p).Updates(map[string]any{
+                "status":        PeriodOpen,
+                "reopened_by":   uid,
+                "reopened_at":   now,
+                "reopen_reason": body.Reason,
+            }).Error; err != nil {
+                return err
+            }
+            return tx.Create(&models.PeriodLog{Period: period, Action: "reopen", Reason: body.Reason, UserID: uid, CreatedAt: now}).Error
+        })
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, p)
+    }
+}
+
+// ListPeriodLogs 账期关账 / 重新打开记录
+// GET /api/periods/:period/logs
+func ListPeriodLogs(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看账期"})
+            return
+        }
+        var logs []models.PeriodLog
+        if err := db.Where("period = ?", c.Param("period")).Order("id desc").Find(&logs).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"items": logs})
+    }
+}
+
+// PeriodReport 对比关账快照与当前实时数据，列出合计及各国家的差异
+// GET /api/periods/:period/report
+func PeriodReport(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看账期"})
+            return
+        }
+        period := strings.TrimSpace(c.Param("period"))
+        var p models.AccountingPeriod
+        if err := db.Where("period = ?", period).First(&p).Error; err != nil && err != gorm.ErrRecordNotFound {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if p.Snapshot == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": period + " 尚未关账，没有快照可以对比"})
+            return
+        }
+        var snap periodSnapshot
+        if err := json.Unmarshal([]byte(p.Snapshot), &snap); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "快照数据损坏: " + err.Error()})
+            return
+        }
+        // 沿用关账时的汇率折算，差异只反映数据变化
+        live, err := computePeriodSnapshot(db, period, snap.Rates)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        totals, changed := comparePeriodMetrics(snap.Totals, live.Totals)
+        countrySet := map[string]bool{}
+        for k := range snap.ByCountry {
+            countrySet[k] = true
+        }
+        for k := range live.ByCountry {
+            countrySet[k] = true
+        }
+        countries := make([]string, 0, len(countrySet))
+        for k := range countrySet {
+            countries = append(countries, k)
+        }
+        sort.Strings(countries)
+        type countryItem struct {
+            Country string              `json:"country"`
+            Changed bool                `json:"changed"`
+            Items   []periodCompareItem `json:"items"`
+        }
+        byCountry := make([]countryItem, 0, len(countries))
+        for _, country := range countries {
+            items, ch := comparePeriodMetrics(snap.ByCountry[country], live.ByCountry[country])
+            byCountry = append(byCountry, countryItem{Country: country, Changed: ch, Items: items})
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+            "period":            period,
+            "status":            p.Status,
+            "snapshot_taken_at": snap.TakenAt,
+            "changed":           changed,
+            "totals":            totals,
+            "by_country":        byCountry,
+            "warnings":          live.Warnings,
+        })
+    }
+}
@@ -0,0 +1,528 @@
+package handlers
+
+import (
+    "fmt"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xuri/excelize/v2"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+)
+
+// 利润表最长统计天数（对比期各自同样长度）
+const pnlMaxDays = 366
+
+// pnlLines 利润表各项（人民币）
+// 毛利 = 销售额 - 货款成本；费用合计 = 广告成本 + 平台手续费 + 刷单费用 + 固定成本 + 其他费用；净利润 = 毛利 - 费用合计
+type pnlLines struct {
+    Revenue          float64 `json:"revenue"`
+    GoodsCost        float64 `json:"goods_cost"`
+    GrossProfit      float64 `json:"gross_profit"`
+    GrossMargin      float64 `json:"gross_margin"` // 毛利率（%）
+    AdCost           float64 `json:"ad_cost"`
+    PlatformFee      float64 `json:"platform_fee"`
+    ShuaDanFee       float64 `json:"shua_dan_fee"`
+    FixedCost        float64 `json:"fixed_cost"`
+    OtherExpense     float64 `json:"other_expense"`
+    OperatingExpense float64 `json:"operating_expense"`
+    NetProfit        float64 `json:"net_profit"`
+    NetMargin        float64 `json:"net_margin"` // 净利率（%）
+
+    SettlementCount int `json:"settlement_count"`
+    // 没有结算的店铺日直接计入的费用流水和固定成本计划（已包含在上面各项中）
+    UnsettledCost float64 `json:"unsettled_cost"`
+}
+
+// finish 金额保留两位小数并计算毛利、费用合计、净利润和利润率
+func (l *pnlLines) finish() {
+    l.Revenue = roundMoney(l.Revenue)
+    l.GoodsCost = roundMoney(l.GoodsCost)
+    l.AdCost = roundMoney(l.AdCost)
+    l.PlatformFee = roundMoney(l.PlatformFee)
+    l.ShuaDanFee = roundMoney(l.ShuaDanFee)
+    l.FixedCost = roundMoney(l.FixedCost)
+    l.OtherExpense = roundMoney(l.OtherExpense)
+    l.UnsettledCost = roundMoney(l.UnsettledCost)
+    l.GrossProfit = roundMoney(l.Revenue - l.GoodsCost)
+    l.OperatingExpense = roundMoney(l.AdCost + l.PlatformFee + l.ShuaDanFee + l.FixedCost + l.OtherExpense)
+    l.NetProfit = roundMoney(l.GrossProfit - l.OperatingExpense)
+    l.GrossMargin, l.NetMargin = 0, 0
+    if l.Revenue != 0 {
+        l.GrossMargin = roundMoney(l.GrossProfit / l.Revenue * 100)
+        l.NetMargin = roundMoney(l.NetProfit / l.Revenue * 100)
+    }
+}
+
+// pnlMetrics 利润表项目，顺序即展示 / 导出顺序；Percent 为百分比项，对比时只计算差值（百分点）
+var pnlMetrics = []struct {
+    Key     string
+    Label   string
+    Percent bool
+    Get     func(pnlLines) float64
+}{
+    {"revenue", "销售额", false, func(l pnlLines) float64 { return l.Revenue }},
+    {"goods_cost", "货款成本", false, func(l pnlLines) float64 { return l.GoodsCost }},
+    {"gross_profit", "毛利", false, func(l pnlLines) float64 { return l.GrossProfit }},
+    {"gross_margin", "毛利率（%）", true, func(l pnlLines) float64 { return l.GrossMargin }},
+    {"ad_cost", "广告成本", false, func(l pnlLines) float64 { return l.AdCost }},
+    {"platform_fee", "平台手续费", false, func(l pnlLines) float64 { return l.PlatformFee }},
+    {"shua_dan_fee", "刷单费用", false, func(l pnlLines) float64 { return l.ShuaDanFee }},
+    {"fixed_cost", "固定成本", false, func(l pnlLines) float64 { return l.FixedCost }},
+    {"other_expense", "其他费用", false, func(l pnlLines) float64 { return l.OtherExpense }},
+    {"operating_expense", "费用合计", false, func(l pnlLines) float64 { return l.OperatingExpense }},
+    {"net_profit", "净利润", false, func(l pnlLines) float64 { return l.NetProfit }},
+    {"net_margin", "净利率（%）", true, func(l pnlLines) float64 { return l.NetMargin }},
+}
+
+// pnlChange 与对比期相比的变化：Delta 为差值（百分比项为百分点），Pct 为变化率（%），对比期为 0 时为空
+type pnlChange struct {
+    Delta float64  `json:"delta"`
+    Pct   *float64 `json:"pct"`
+}
+
+func comparePnL(cur, prev pnlLines) map[string]pnlChange {
+    out := make(map[string]pnlChange, len(pnlMetrics))
+    for _, m := range pnlMetrics {
+        a, b := m.Get(cur), m.Get(prev)
+        ch := pnlChange{Delta: roundMoney(a - b)}
+        if !m.Percent && b != 0 {
+            pct := roundMoney((a - b) / math.Abs(b) * 100)
+            ch.Pct = &pct
+        }
+        out[m.Key] = ch
+    }
+    return out
+}
+
+// pnlReport 一个期间的利润表：合计和按分组的各项
+type pnlReport struct {
+    Start    string
+    End      string
+    Total    pnlLines
+    Groups   map[string]*pnlLines
+    Labels   map[string]string
+    Warnings []string
+}
+
+// pnlGrouper 按国家 / 店铺 / 平台确定分组
+type pnlGrouper struct {
+    by     string
+    stores map[uint]models.Store
+}
+
+func (g pnlGrouper) key(storeID uint, country, platform string) (string, string) {
+    switch g.by {
+    case "country":
+        if country == "" {
+            return "", "未指定国家"
+        }
+        return country, country
+    case "platform":
+        if st, ok := g.stores[storeID]; ok && platform == "" {
+            platform = st.Platform
+        }
+        if platform == "" {
+            return "", "未指定平台"
+        }
+        return platform, platform
+    case "store":
+        if st, ok := g.stores[storeID]; ok {
+            return strconv.Itoa(int(st.ID)), st.Country + " · " + st.Name
+        }
+        return "0:" + country, country + " · 未指定店铺"
+    }
+    return "", ""
+}
+
+// computePnL 汇总 start ~ end 的利润表：
+// - 销售额及各项成本来自结算记录（statuses 为空时包含全部状态）的人民币金额；
+// - 其他类费用流水直接计入“其他费用”；
+// - 刷单 / 固定类费用流水和固定成本计划只在当天该店铺没有结算时计入（有结算时已包含在结算的刷单费用 / 固定成本中），
+//   国家级流水和按国家分配的固定成本在该国未封禁店铺间平均分摊。
+func computePnL(db *gorm.DB, start, end, groupBy string, statuses []string) (*pnlReport, error) {
+    r := &pnlReport{Start: start, End: end, Groups: map[string]*pnlLines{}, Labels: map[string]string{}}
+
+    var stores []models.Store
+    if err := db.Order("id asc").Find(&stores).Error; err != nil {
+        return nil, err
+    }
+    g := pnlGrouper{by: groupBy, stores: map[uint]models.Store{}}
+    activeStores := map[string][]uint{}
+    for _, st := range stores {
+        g.stores[st.ID] = st
+        if !st.IsBlocked {
+            activeStores[st.Country] = append(activeStores[st.Country], st.ID)
+        }
+    }
+    group := func(storeID uint, country, platform string) *pnlLines {
+        if groupBy == "" {
+            return nil
+        }
+        k, label := g.key(storeID, country, platform)
+        if r.Groups[k] == nil {
+            r.Groups[k] = &pnlLines{}
+            r.Labels[k] = label
+        }
+        return r.Groups[k]
+    }
+    add := func(storeID uint, country, platform string, fn func(l *pnlLines)) {
+        fn(&r.Total)
+        if l := group(storeID, country, platform); l != nil {
+            fn(l)
+        }
+    }
+
+    // 结算记录
+    q := db.Where("date >= ? AND date <= ?", start, end)
+    if len(statuses) > 0 {
+        q = q.Where("status IN ?", statuses)
+    }
+    var list []models.DailySettlement
+    if err := q.Order("date asc, id asc").Find(&list).Error; err != nil {
+        return nil, err
+    }
+    coveredStore := map[string]bool{}   // date|store_id
+    coveredCountry := map[string]bool{} // date|country，按国家结算的历史记录覆盖该国全部店铺
+    for _, s := range list {
+        var storeID uint
+        if s.StoreID != nil {
+            storeID = *s.StoreID
+            coveredStore[s.Date+"|"+strconv.Itoa(int(storeID))] = true
+        } else {
+            coveredCountry[s.Date+"|"+s.Country] = true
+        }
+        add(storeID, s.Country, s.Platform, func(l *pnlLines) {
+            l.Revenue += s.SaleTotalCNY
+            l.GoodsCost += s.GoodsCostCNY
+            l.AdCost += s.AdDeduction
+            l.PlatformFee += s.PlatformFee
+            l.ShuaDanFee += s.ShuaDanFeeCNY
+            l.FixedCost += s.FixedCostCNY
+            l.SettlementCount++
+        })
+    }
+    unsettled := map[string]bool{}
+    covered := func(date string, storeID uint, country string) bool {
+        return coveredCountry[date+"|"+country] || (storeID != 0 && coveredStore[date+"|"+strconv.Itoa(int(storeID))])
+    }
+    // storeShares 国家级金额在该国未封禁店铺间平均分摊，没有店铺时归到“未指定店铺”
+    storeShares := func(storeID uint, country string, amount float64) map[uint]float64 {
+        if storeID != 0 {
+            return map[uint]float64{storeID: amount}
+        }
+        ids := activeStores[country]
+        if len(ids) == 0 {
+            return map[uint]float64{0: amount}
+        }
+        out := make(map[uint]float64, len(ids))
+        for _, id := range ids {
+            out[id] = amount / float64(len(ids))
+        }
+        return out
+    }
+
+    // 费用流水
+    var expenses []struct {
+        models.Expense
+        Kind string
+    }
+    if err := db.Table("expenses AS e").
+        Select("e.*, ec.kind AS kind").
+        Joins("JOIN expense_categories AS ec ON ec.id = e.category_id").
+        Where("e.date >= ? AND e.date <= ?", start, end).
+        Order("e.id asc").Scan(&expenses).Error; err != nil {
+        return nil, err
+    }
+    for _, e := range expenses {
+        for storeID, amount := range storeShares(e.StoreID, e.Country, e.AmountCNY) {
+            amount := amount
+            switch e.Kind {
+            case ExpenseKindOther:
+                add(storeID, e.Country, "", func(l *pnlLines) { l.OtherExpense += amount })
+            case ExpenseKindShuaDan, ExpenseKindFixed:
+                if covered(e.Date, storeID, e.Country) {
+                    continue
+                }
+                unsettled[e.Date+"|"+strconv.Itoa(int(storeID))+"|"+e.Country] = true
+                kind := e.Kind
+                add(storeID, e.Country, "", func(l *pnlLines) {
+                    if kind == ExpenseKindShuaDan {
+                        l.ShuaDanFee += amount
+                    } else {
+                        l.FixedCost += amount
+                    }
+                    l.UnsettledCost += amount
+                })
+            }
+        }
+    }
+
+    // 固定成本计划
+    a, err := newFixedCostAllocator(db, start, end)
+    if err != nil {
+        return nil, err
+    }
+    if len(a.schedules) > 0 {
+        from, _ := time.Parse("2006-01-02", start)
+        to, _ := time.Parse("2006-01-02", end)
+        for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+            date := d.Format("2006-01-02")
+            shares, err := a.Allocate(date)
+            if err != nil {
+                return nil, err
+            }
+            for _, sh := range shares {
+                for storeID, amount := range storeShares(sh.StoreID, sh.Country, sh.AmountCNY) {
+                    if covered(date, storeID, sh.Country) {
+                        continue
+                    }
+                    amount := amount
+                    unsettled[date+"|"+strconv.Itoa(int(storeID))+"|"+sh.Country] = true
+                    add(storeID, sh.Country, "", func(l *pnlLines) {
+                        l.FixedCost += amount
+                        l.UnsettledCost += amount
+                    })
+                }
+            }
+        }
+        r.Warnings = append(r.Warnings, a.Warnings()...)
+    }
+
+    r.Total.finish()
+    for _, l := range r.Groups {
+        l.finish()
+    }
+    if len(list) == 0 {
+        r.Warnings = append(r.Warnings, fmt.Sprintf("%s ~ %s 没有符合条件的结算记录", start, end))
+    }
+    if len(unsettled) > 0 {
+        r.Warnings = append(r.Warnings, fmt.Sprintf("%s ~ %s 有 %d 个店铺日没有结算，其刷单 / 固定类费用流水和固定成本计划共 %.2f 已直接计入，对应的销售额未计入",
+            start, end, len(unsettled), r.Total.UnsettledCost))
+    }
+    return r, nil
+}
+
+// pnlGroupItem 利润表中的一个分组，附带对比期同一分组的数据
+type pnlGroupItem struct {
+    Key             string               `json:"key"`
+    Label           string               `json:"label"`
+    Lines           pnlLines             `json:"lines"`
+    Previous        pnlLines             `json:"previous"`
+    LastYear        pnlLines             `json:"last_year"`
+    ChangePrevious  map[string]pnlChange `json:"change_previous"`
+    ChangeLastYear  map[string]pnlChange `json:"change_last_year"`
+}
+
+// pnlCompare 对比期的合计
+type pnlCompare struct {
+    Start  string               `json:"start"`
+    End    string               `json:"end"`
+    Total  pnlLines             `json:"total"`
+    Change map[string]pnlChange `json:"change"`
+}
+
+// PnLReport 利润表：任意日期范围的销售额、各项成本、毛利 / 净利润和利润率，可按国家 / 店铺 / 平台分组，
+// 并与上一期（紧邻的同样天数）和去年同期对比；format=xlsx 时导出 Excel。
+// 默认只统计已审批 / 已锁定的结算，include_drafts=1 时包含草稿和已提交的结算。
+// GET /api/reports/pnl?start=YYYY-MM-DD&end=YYYY-MM-DD&group_by=country|store|platform&include_drafts=1&format=xlsx
+func PnLReport(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看利润表"})
+            return
+        }
+        now := time.Now()
+        start := c.DefaultQuery("start", now.Format("2006-01")+"-01")
+        end := c.DefaultQuery("end", now.Format("2006-01-02"))
+        from, err1 := time.Parse("2006-01-02", start)
+        to, err2 := time.Parse("2006-01-02", end)
+        if err1 != nil || err2 != nil || to.Before(from) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "start / end 格式应为 YYYY-MM-DD，且 start 不晚于 end"})
+            return
+        }
+        days := int(to.Sub(from).Hours()/24) + 1
+        if days > pnlMaxDays {
+            c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("日期范围不能超过 %d 天", pnlMaxDays)})
+            return
+        }
+        groupBy := c.Query("group_by")
+        switch groupBy {
+        case "", "country", "store", "platform":
+        default:
+            c.JSON(http.StatusBadRequest, gin.H{"error": "group_by 只能是 country / store / platform"})
+            return
+        }
+        statuses := settlementFinalStatuses
+        if v := c.Query("include_drafts"); v == "1" || v == "true" {
+            statuses = nil
+        }
+
+        prevTo := from.AddDate(0, 0, -1)
+        prevFrom := prevTo.AddDate(0, 0, -(days - 1))
+        periods := [][2]string{
+            {start, end},
+            {prevFrom.Format("2006-01-02"), prevTo.Format("2006-01-02")},
+            {from.AddDate(-1, 0, 0).Format("2006-01-02"), to.AddDate(-1, 0, 0).Format("2006-01-02")},
+        }
+        reports := make([]*pnlReport, len(periods))
+        for i, p := range periods {
+            if reports[i], err1 = computePnL(db, p[0], p[1], groupBy, statuses); err1 != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err1.Error()})
+                return
+            }
+        }
+        cur, prev, lastYear := reports[0], reports[1], reports[2]
+
+        // 分组取三个期间的并集，本期没有数据但对比期有的分组也列出
+        groups := []pnlGroupItem{}
+        seen := map[string]bool{}
+        for _, rep := range reports {
+            for k := range rep.Groups {
+                if seen[k] {
+                    continue
+                }
+                seen[k] = true
+                it := pnlGroupItem{Key: k, Label: rep.Labels[k]}
+                if l := cur.Groups[k]; l != nil {
+                    it.Lines = *l
+                }
+                if l := prev.Groups[k]; l != nil {
+                    it.Previous = *l
+                }
+                if l := lastYear.Groups[k]; l != nil {
+                    it.LastYear = *l
+                }
+                it.ChangePrevious = comparePnL(it.Lines, it.Previous)
+                it.ChangeLastYear = comparePnL(it.Lines, it.LastYear)
+                groups = append(groups, it)
+            }
+        }
+        sort.SliceStable(groups, func(i, j int) bool {
+            if groups[i].Lines.Revenue != groups[j].Lines.Revenue {
+                return groups[i].Lines.Revenue > groups[j].Lines.Revenue
+            }
+            return groups[i].Label < groups[j].Label
+        })
+
+        previous := pnlCompare{Start: prev.Start, End: prev.End, Total: prev.Total, Change: comparePnL(cur.Total, prev.Total)}
+        lastYearCmp := pnlCompare{Start: lastYear.Start, End: lastYear.End, Total: lastYear.Total, Change: comparePnL(cur.Total, lastYear.Total)}
+
+        if c.Query("format") == "xlsx" {
+            buf, err := writePnLXLSX(cur, previous, lastYearCmp, groups, groupBy)
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            filename := fmt.Sprintf("pnl_%s_%s.xlsx", from.Format("20060102"), to.Format("20060102"))
+            c.Header("Content-Disposition", "attachment; filename="+filename)
+            c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf)
+            return
+        }
+
+        warnings := cur.Warnings
+        if warnings == nil {
+            warnings = []string{}
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "start":          start,
+            "end":            end,
+            "days":           days,
+            "group_by":       groupBy,
+            "include_drafts": statuses == nil,
+            "total":          cur.Total,
+            "groups":         groups,
+            "previous":       previous,
+            "last_year":      lastYearCmp,
+            "warnings":       warnings,
+        })
+    }
+}
+
+var pnlGroupByLabels = map[string]string{
+    "country":  "国家",
+    "store":    "店铺",
+    "platform": "平台",
+}
+
+// writePnLXLSX 导出利润表：“利润表”为合计及对比，“分组”为每个分组的各项（仅在分组时生成）
+func writePnLXLSX(cur *pnlReport, previous, lastYear pnlCompare, groups []pnlGroupItem, groupBy string) ([]byte, error) {
+    f := excelize.NewFile()
+    defer f.Close()
+
+    sheet := "利润表"
+    f.SetSheetName("Sheet1", sheet)
+    rows := [][]interface{}{
+        {"期间", cur.Start + " ~ " + cur.End, "上期", previous.Start + " ~ " + previous.End, "去年同期", lastYear.Start + " ~ " + lastYear.End},
+        {},
+        {"项目", "本期", "上期", "环比变化", "环比变化率（%）", "去年同期", "同比变化", "同比变化率（%）"},
+    }
+    pct := func(ch pnlChange) interface{} {
+        if ch.Pct == nil {
+            return ""
+        }
+        return *ch.Pct
+    }
+    for _, m := range pnlMetrics {
+        cp, cy := previous.Change[m.Key], lastYear.Change[m.Key]
+        rows = append(rows, []interface{}{
+            m.Label, m.Get(cur.Total),
+            m.Get(previous.Total), cp.Delta, pct(cp),
+            m.Get(lastYear.Total), cy.Delta, pct(cy),
+        })
+    }
+    rows = append(rows, []interface{}{"结算条数", cur.Total.SettlementCount, previous.Total.SettlementCount, "", "", lastYear.Total.SettlementCount})
+    if len(cur.Warnings) > 0 {
+        rows = append(rows, []interface{}{})
+        for _, w := range cur.Warnings {
+            rows = append(rows, []interface{}{"提示", w})
+        }
+    }
+    for i := range rows {
+        cell, _ := excelize.CoordinatesToCellName(1, i+1)
+        if err := f.SetSheetRow(sheet, cell, &rows[i]); err != nil {
+            return nil, err
+        }
+    }
+    f.SetColWidth(sheet, "A", "A", 16)
+    f.SetColWidth(sheet, "B", "H", 14)
+
+    if groupBy != "" {
+        sheet = "按" + pnlGroupByLabels[groupBy]
+        if _, err := f.NewSheet(sheet); err != nil {
+            return nil, err
+        }
+        header := []interface{}{pnlGroupByLabels[groupBy]}
+        for _, m := range pnlMetrics {
+            header = append(header, m.Label)
+        }
+        header = append(header, "结算条数", "上期销售额", "上期净利润", "去年同期销售额", "去年同期净利润")
+        if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+            return nil, err
+        }
+        for i, gi := range groups {
+            row := []interface{}{gi.Label}
+            for _, m := range pnlMetrics {
+                row = append(row, m.Get(gi.Lines))
+            }
+            row = append(row, gi.Lines.SettlementCount, gi.Previous.Revenue, gi.Previous.NetProfit, gi.LastYear.Revenue, gi.LastYear.NetProfit)
+            cell, _ := excelize.CoordinatesToCellName(1, i+2)
+            if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+                return nil, err
+            }
+        }
+        f.SetColWidth(sheet, "A", "A", 24)
+    }
+
+    buf, err := f.WriteToBuffer()
+    if err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
@@ -0,0 +1,400 @@
+package handlers
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+    "ordercount/internal/utils"
+)
+
+// 各国家对应的本国货币
+var countryCurrency = map[string]string{
+    "菲律宾":  "PHP",
+    "印尼":   "IDR",
+    "马来西亚": "MYR",
+}
+
+// 定价计算中国家的固定顺序
+var pricingCountries = []string{"菲律宾", "印尼", "马来西亚"}
+
+// ListProductPrices 商品分国家标价列表，可按 product_id / country 过滤
+func ListProductPrices(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        q := db.Model(&models.ProductPrice{})
+        if v := c.Query("product_id"); v != "" {
+            id, err := strconv.Atoi(v)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "invalid product_id"})
+                return
+            }
+            q = q.Where("product_id = ?", id)
+        }
+        if v := strings.TrimSpace(c.Query("country")); v != "" {
+            q = q.Where("country = ?", v)
+        }
+        var list []models.ProductPrice
+        if err := q.Order("product_id asc, id asc").Find(&list).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"items": list})
+    }
+}
+
+// SaveProductPrice 设置商品在某个国家的标价（同一商品同一国家只有一条，重复提交即覆盖），管理员 / 超级管理员
+func SaveProductPrice(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以维护商品标价"})
+            return
+        }
+        var body struct {
+            ProductID uint    `json:"product_id"`
+            Country   string  `json:"country"`
+            Price     float64 `json:"price"`
+            Remark    string  `json:"remark"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        cur, ok := countryCurrency[body.Country]
+        if !ok {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "国家只能是 菲律宾 / 印尼 / 马来西亚"})
+            return
+        }
+        if body.Price < 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "标价不能为负数"})
+            return
+        }
+        var cnt int64
+        db.Model(&models.Product{}).Where("id = ?", body.ProductID).Count(&cnt)
+        if cnt == 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "商品不存在"})
+            return
+        }
+
+        var pp models.ProductPrice
+        err := db.Where("product_id = ? AND country = ?", body.ProductID, body.Country).First(&pp).Error
+        if err != nil && err != gorm.ErrRecordNotFound {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        pp.ProductID = body.ProductID
+        pp.Country = body.Country
+        pp.Currency = cur
+        pp.Price = body.Price
+        pp.Remark = body.Remark
+        if err := db.Save(&pp).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, pp)
+    }
+}
+
+// DeleteProductPrice 删除商品标价（管理员 / 超级管理员）
+func DeleteProductPrice(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以维护商品标价"})
+            return
+        }
+        res := db.Delete(&models.ProductPrice{}, c.Param("id"))
+        if res.Error != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": res.Error.Error()})
+            return
+        }
+        if res.RowsAffected == 0 {
+            c.JSON(http.StatusNotFound, gin.H{"error": "标价不存在"})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"ok": true})
+    }
+}
+
+// pricingInput 单个国家的定价计算参数，金额单位见字段注释
+type pricingInput struct {
+    UnitCost     float64 // 商品成本（人民币/件）
+    Shipping     float64 // 运费估算（人民币/件）
+    AdSpend      float64 // 每件分摊的广告费（本国货币，不含广告税）
+    Rate         float64 // 汇率：1 本国货币 ≈ ? 人民币
+    TargetMargin float64 // 目标利润率（0~1）
+    Price        float64 // 当前标价（本国货币），0 表示没有标价
+    // 费用规则折算出的比例（0~1）：按销售额、按广告费、按货款成本
+    SaleFeeRate float64
+    AdFeeRate   float64
+    CostFeeRate float64
+}
+
+// pricingResult 定价计算结果；金额为人民币，*_local 为本国货币
+type pricingResult struct {
+    SuggestedPrice float64 `json:"suggested_price_local"`
+    Price          float64 `json:"price_local"`
+    Revenue        float64 `json:"revenue"`
+    PlatformFee    float64 `json:"platform_fee"`
+    AdCost         float64 `json:"ad_cost"`
+    Profit         float64 `json:"profit_per_unit"`
+    Margin         float64 `json:"margin"`
+    // 每件最多能花多少广告费（本国货币，不含广告税）刚好不亏
+    BreakEvenAdSpend float64 `json:"break_even_ad_spend_local"`
+    Warnings         []string `json:"warnings"`
+}
+
+// calcPricing 与 SaveSettlement 使用同一套费用规则（百分比部分）：
+// 利润 = 售价 × 汇率 × (1 − 销售额费率) − 广告费 × 汇率 × (1 + 广告费费率) − 成本 × (1 + 货款费率) − 运费
+func calcPricing(in pricingInput) pricingResult {
+    res := pricingResult{Warnings: []string{}}
+    adCost := in.AdSpend * (1 + in.AdFeeRate) * in.Rate
+    goodsCost := in.UnitCost * (1 + in.CostFeeRate)
+    fixed := goodsCost + in.Shipping + adCost
+    res.AdCost = roundMoney(adCost)
+
+    // 建议售价：售价 × 汇率 × (1 − 销售额费率 − 目标利润率) = 成本 + 运费 + 广告
+    keep := 1 - in.SaleFeeRate - in.TargetMargin
+    if keep <= 0 {
+        res.Warnings = append(res.Warnings, fmt.Sprintf("目标利润率加平台费率（%.1f%%）不能达到 100%%", in.SaleFeeRate*100))
+    } else {
+        res.SuggestedPrice = roundMoney(fixed / (in.Rate * keep))
+    }
+
+    price := in.Price
+    if price <= 0 {
+        // 没有标价时按建议售价计算利润等指标
+        price = res.SuggestedPrice
+    }
+    res.Price = roundMoney(price)
+    revenue := price * in.Rate
+    fee := revenue * in.SaleFeeRate
+    profit := revenue - fee - fixed
+    res.Revenue = roundMoney(revenue)
+    res.PlatformFee = roundMoney(fee)
+    res.Profit = roundMoney(profit)
+    if revenue > 0 {
+        res.Margin = roundMoney(profit / revenue * 100)
+    }
+
+    beforeAd := revenue - fee - goodsCost - in.Shipping
+    if beforeAd > 0 {
+        res.BreakEvenAdSpend = roundMoney(beforeAd / ((1 + in.AdFeeRate) * in.Rate))
+    } else {
+        res.Warnings = append(res.Warnings, "不投广告也无法盈利")
+    }
+    if in.UnitCost == 0 {
+        res.Warnings = append(res.Warnings, "商品成本为 0，请确认是否已配置成本")
+    }
+    return res
+}
+
+// ProductPricing 定价计算：按商品成本、实时汇率、当天生效的结算费用规则、运费估算和目标利润率，
+// 计算建议售价；对已有标价（或传入的 price）计算利润率、单件利润和盈亏平衡广告费。
+// GET /api/pricing?product_id=1&country=印尼&platform=Shopee&store_id=&target_margin=20&shipping=3&ad_spend=0&price=
+// country 为空时计算所有国家；成本为当前角色可见的成本；汇率可用 rate 参数手动指定（1 本国货币 ≈ ? 人民币）。
+// 费用规则按 platform 匹配平台规则；传 store_id 时国家和平台取自店铺，同时匹配店铺规则。
+func ProductPricing(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        pid, err := strconv.Atoi(c.Query("product_id"))
+        if err != nil || pid <= 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "product_id required"})
+            return
+        }
+        var p models.Product
+        if err := db.First(&p, pid).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "商品不存在"})
+            return
+        }
+
+        parseFloat := func(name string, def float64) (float64, error) {
+            v := strings.TrimSpace(c.Query(name))
+            if v == "" {
+                return def, nil
+            }
+            f, err := strconv.ParseFloat(v, 64)
+            if err != nil || f < 0 {
+                return 0, fmt.Errorf("invalid %s", name)
+            }
+            return f, nil
+        }
+        var targetMargin, shipping, adSpend, price, manualRate float64
+        for _, f := range []struct {
+            name string
+            dst  *float64
+            def  float64
+        }{
+            {"target_margin", &targetMargin, 20},
+            {"shipping", &shipping, 0},
+            {"ad_spend", &adSpend, 0},
+            {"price", &price, 0},
+            {"rate", &manualRate, 0},
+        } {
+            v, err := parseFloat(f.name, f.def)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+            }
+            *f.dst = v
+        }
+        // 目标利润率按百分比传入（20 表示 20%），不接受小数形式，避免 0.5 被当成 50%
+        if targetMargin >= 100 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "target_margin 为百分比，需小于 100"})
+            return
+        }
+        targetMargin /= 100
+
+        roleVal, _ := c.Get("role")
+        roleStr, _ := roleVal.(string)
+
+        countries := pricingCountries
+        country := strings.TrimSpace(c.Query("country"))
+        if country != "" {
+            if _, ok := countryCurrency[country]; !ok {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "国家只能是 菲律宾 / 印尼 / 马来西亚"})
+                return
+            }
+            countries = []string{country}
+        }
+        // 费用规则的平台 / 店铺范围，与结算时的匹配方式相同
+        platform := strings.TrimSpace(c.Query("platform"))
+        var storeID uint
+        if v := c.Query("store_id"); v != "" {
+            id, err := strconv.Atoi(v)
+            if err != nil || id <= 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "invalid store_id"})
+                return
+            }
+            userIDVal, _ := c.Get("userID")
+            uid, _ := userIDVal.(uint)
+            store, err := loadSettlementStore(db, uint(id), roleStr, uid)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+            }
+            if country != "" && country != store.Country {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "店铺不属于国家 " + country})
+                return
+            }
+            countries = []string{store.Country}
+            platform, storeID = store.Platform, store.ID
+        }
+        if (price > 0 || manualRate > 0) && len(countries) != 1 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "指定 price 或 rate 时需要同时指定 country"})
+            return
+        }
+
+        var prices []models.ProductPrice
+        if err := db.Where("product_id = ?", p.ID).Find(&prices).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        listPrice := make(map[string]float64, len(prices))
+        for _, pp := range prices {
+            listPrice[pp.Country] = pp.Price
+        }
+
+        var rates map[string]float64
+        rateSource := "manual"
+        if manualRate == 0 {
+            rateSource = "live"
+            if rates, err = utils.GetRates(); err != nil {
+                c.JSON(http.StatusBadGateway, gin.H{"error": "获取汇率失败：" + err.Error()})
+                return
+            }
+        }
+
+        costs, err := loadCostResolver(db, []uint{p.ID})
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        unitCost := costs.ForRole(p, roleStr)
+
+        type item struct {
+            Country         string  `json:"country"`
+            Currency        string  `json:"currency"`
+            Rate            float64 `json:"rate"`
+            ListPrice       float64 `json:"list_price_local"`
+            PlatformFeeRate float64 `json:"platform_fee_rate"` // 按销售额计算的费率合计（%）
+            AdTaxRate       float64 `json:"ad_tax_rate"`       // 按广告费计算的费率合计（%）
+            pricingResult
+        }
+        today := time.Now().Format("2006-01-02")
+        items := make([]item, 0, len(countries))
+        for _, country := range countries {
+            scope := feeScope{Date: today, Country: country, Platform: platform, StoreID: storeID}
+            rules, err := loadFeeRules(db, scope)
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            skipped, err := skippedScopedFeeRules(db, scope)
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            feeRates, fixedRules := feeRulePercents(rules)
+            cur := countryCurrency[country]
+            rate := manualRate
+            if rate == 0 {
+                if r := rates[cur]; r > 0 {
+                    rate = 1 / r
+                }
+            }
+            if rate <= 0 {
+                c.JSON(http.StatusBadGateway, gin.H{"error": "缺少币种 " + cur + " 的汇率"})
+                return
+            }
+            in := pricingInput{
+                UnitCost:     unitCost,
+                Shipping:     shipping,
+                AdSpend:      adSpend,
+                Rate:         rate,
+                TargetMargin: targetMargin,
+                Price:        listPrice[country],
+                SaleFeeRate:  feeRates["sale_total"],
+                AdFeeRate:    feeRates["ad_cost"],
+                CostFeeRate:  feeRates["goods_cost"],
+            }
+            if price > 0 {
+                in.Price = price
+            }
+            res := calcPricing(in)
+            for _, r := range fixedRules {
+                res.Warnings = append(res.Warnings, fmt.Sprintf("固定金额费用规则「%s」按天计收，未计入单件定价", r.Name))
+            }
+            if len(skipped) > 0 {
+                res.Warnings = append(res.Warnings, fmt.Sprintf("以下限定平台或店铺的费用规则未计入，可传 platform / store_id 按实际店铺计算：%s", strings.Join(skipped, "、")))
+            }
+            items = append(items, item{
+                Country:         country,
+                Currency:        cur,
+                Rate:            rate,
+                ListPrice:       listPrice[country],
+                PlatformFeeRate: roundMoney(in.SaleFeeRate * 100),
+                AdTaxRate:       roundMoney(in.AdFeeRate * 100),
+                pricingResult:   res,
+            })
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+            "product_id":        p.ID,
+            "sku":               p.SKU,
+            "unit_cost":         unitCost,
+            "shipping":          shipping,
+            "ad_spend_local":    adSpend,
+            "target_margin":     roundMoney(targetMargin * 100),
+            "rate_source":       rateSource,
+            "platform":          platform,
+            "store_id":          storeID,
+            "items":             items,
+        })
+    }
+}
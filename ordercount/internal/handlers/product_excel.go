@@ -0,0 +1,558 @@
+package handlers
+
+import (
+    "archive/zip"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "path"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "github.com/xuri/excelize/v2"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+    "ordercount/internal/storage"
+    "ordercount/internal/utils"
+)
+
+// 商品批量导入的请求体上限（Excel + 图片压缩包）
+const maxProductImportBytes = 100 << 20
+
+type productExcelColumn struct {
+    Field  string
+    Header string
+}
+
+// productExcelColumns 商品 Excel 的列定义：表头使用中文，导入时同时兼容英文字段名；
+// 成本列按成本档位配置生成：cost 为基础成本，cost_<档位> 为各角色档位
+func productExcelColumns() []productExcelColumn {
+    cols := []productExcelColumn{{"sku", "SKU"}, {"name", "名称"}, {"cost", "成本"}}
+    for _, t := range CostTiers {
+        label := t.Label
+        if label == "" {
+            label = t.Role + "成本"
+        }
+        cols = append(cols, productExcelColumn{"cost_" + t.Role, label})
+    }
+    return append(cols, productExcelColumn{"image_url", "图片地址"})
+}
+
+// costFieldTier 成本列对应的档位
+func costFieldTier(field string) string {
+    if field == "cost" {
+        return BaseCostTier
+    }
+    return strings.TrimPrefix(field, "cost_")
+}
+
+// productExcelCostFields 返回当前角色在 Excel 中可见的成本列，与 ListProducts 的可见规则一致
+func productExcelCostFields(role string) []string {
+    var fields []string
+    for _, tier := range visibleCostTiers(role) {
+        if tier == BaseCostTier {
+            fields = append(fields, "cost")
+        } else {
+            fields = append(fields, "cost_"+tier)
+        }
+    }
+    return fields
+}
+
+// productExcelCost 导出 / 对比时成本列的取值：角色自己的档位按回退链解析（与列表中的 cost 一致），
+// 其他档位为单独配置的值，未配置为 0
+func productExcelCost(costs *costResolver, p models.Product, role, tier string) float64 {
+    if tier == ownCostTier(role) {
+        return costs.Cost(p, tier)
+    }
+    v, _ := costs.Raw(p, tier)
+    return v
+}
+
+// ExportProducts 导出全部商品为 Excel：SKU、名称、当前角色可见的成本列、图片地址
+func ExportProducts(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        roleStr, _ := roleVal.(string)
+
+        var products []models.Product
+        if err := db.Order("sku asc, id asc").Find(&products).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        costs, err := loadCostResolver(db, nil)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        fields := []string{"sku", "name"}
+        fields = append(fields, productExcelCostFields(roleStr)...)
+        fields = append(fields, "image_url")
+
+        headers := make([]interface{}, 0, len(fields))
+        for _, f := range fields {
+            for _, col := range productExcelColumns() {
+                if col.Field == f {
+                    headers = append(headers, col.Header)
+                }
+            }
+        }
+
+        f := excelize.NewFile()
+        defer f.Close()
+        sheet := "商品"
+        f.SetSheetName("Sheet1", sheet)
+        if err := f.SetSheetRow(sheet, "A1", &headers); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        for i, p := range products {
+            row := make([]interface{}, 0, len(fields))
+            for _, field := range fields {
+                switch field {
+                case "sku":
+                    row = append(row, p.SKU)
+                case "name":
+                    row = append(row, p.Name)
+                case "image_url":
+                    row = append(row, resolveUploadURL(p.ImageURL))
+                default:
+                    row = append(row, productExcelCost(costs, p, roleStr, costFieldTier(field)))
+                }
+            }
+            cell, _ := excelize.CoordinatesToCellName(1, i+2)
+            if err := f.SetSheetRow(sheet, cell, &row); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+        }
+
+        buf, err := f.WriteToBuffer()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        filename := fmt.Sprintf("products_%s.xlsx", time.Now().Format("20060102"))
+        c.Header("Content-Disposition", "attachment; filename="+filename)
+        c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+    }
+}
+
+// productImportRow Excel 中解析出的一行，nil 表示该列不存在或单元格为空（不修改）
+type productImportRow struct {
+    Row       int
+    SKU       string
+    Name      *string
+    ImageURL  *string
+    // 成本列，key 为档位名（基础成本为 base）
+    Costs costEdits
+}
+
+// productChange 导入预览中单个字段的变化
+type productChange struct {
+    From interface{} `json:"from"`
+    To   interface{} `json:"to"`
+}
+
+// ImportProducts 按 SKU 批量新增/更新商品：
+// - multipart 字段 file 为 Excel（表头同导出），images 为可选的图片压缩包，文件名即 SKU；
+// - ?dry_run=1 时只返回差异预览，不写库、不保存图片；
+// - 成本列遵循成本档位的权限配置（与 SaveProduct 一致），无权修改的列忽略。
+func ImportProducts(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        roleStr, _ := roleVal.(string)
+        // 与 SaveProduct 一致：仅超级管理员和管理员可以维护商品
+        if roleStr != "superadmin" && roleStr != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以维护商品信息"})
+            return
+        }
+
+        dryRun := c.Query("dry_run") == "1" || c.Query("dry_run") == "true"
+        c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxProductImportBytes)
+
+        var rows []productImportRow
+        var warnings []string
+        var rowErrors []gin.H
+
+        if fh, err := c.FormFile("file"); err == nil {
+            f, err := fh.Open()
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            rows, warnings, rowErrors, err = parseProductExcel(f, roleStr)
+            f.Close()
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+            }
+        }
+
+        // 图片压缩包：文件名（不含扩展名）即 SKU
+        images := make(map[string]*utils.ProcessedImage)
+        if fh, err := c.FormFile("images"); err == nil {
+            f, err := fh.Open()
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            zr, err := zip.NewReader(f, fh.Size)
+            if err != nil {
+                f.Close()
+                c.JSON(http.StatusBadRequest, gin.H{"error": "图片压缩包无法解析：" + err.Error()})
+                return
+            }
+            for _, zf := range zr.File {
+                if zf.FileInfo().IsDir() || strings.HasPrefix(path.Base(zf.Name), ".") {
+                    continue
+                }
+                sku := strings.TrimSuffix(path.Base(zf.Name), path.Ext(zf.Name))
+                if zf.UncompressedSize64 > utils.MaxImageBytes {
+                    rowErrors = append(rowErrors, gin.H{"file": zf.Name, "error": "图片不能超过 5MB"})
+                    continue
+                }
+                rc, err := zf.Open()
+                if err != nil {
+                    rowErrors = append(rowErrors, gin.H{"file": zf.Name, "error": err.Error()})
+                    continue
+                }
+                data, err := io.ReadAll(io.LimitReader(rc, utils.MaxImageBytes+1))
+                rc.Close()
+                if err != nil {
+                    rowErrors = append(rowErrors, gin.H{"file": zf.Name, "error": err.Error()})
+                    continue
+                }
+                img, err := utils.ProcessImage(data)
+                if err != nil {
+                    rowErrors = append(rowErrors, gin.H{"file": zf.Name, "error": err.Error()})
+                    continue
+                }
+                images[sku] = img
+            }
+            f.Close()
+        }
+
+        if len(rows) == 0 && len(images) == 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "请上传商品 Excel（file）或图片压缩包（images）", "errors": rowErrors})
+            return
+        }
+
+        // 只有图片、Excel 中没有对应行的 SKU，按“仅更新图片”处理
+        inSheet := make(map[string]bool, len(rows))
+        for _, r := range rows {
+            inSheet[r.SKU] = true
+        }
+        for sku := range images {
+            if !inSheet[sku] {
+                rows = append(rows, productImportRow{SKU: sku})
+            }
+        }
+
+        created := make([]gin.H, 0)
+        updated := make([]gin.H, 0)
+        unchanged := 0
+        var recost []string
+
+        bundles, err := loadBundleGraph(db)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        costs, err := loadCostResolver(db, nil)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        // 事务提交后再写入存储的图片，写入失败时恢复商品原来的图片地址
+        type pendingImage struct {
+            productID uint
+            sku       string
+            oldURL    string
+            img       *utils.ProcessedImage
+        }
+        var pendingImages []pendingImage
+        err = db.Transaction(func(tx *gorm.DB) error {
+            for _, r := range rows {
+                var p models.Product
+                isNew := false
+                if err := tx.Where("sku = ?", r.SKU).Order("id asc").First(&p).Error; err != nil {
+                    if err != gorm.ErrRecordNotFound {
+                        return err
+                    }
+                    if !inSheet[r.SKU] {
+                        // 图片压缩包里的 SKU 在商品库和 Excel 中都不存在
+                        rowErrors = append(rowErrors, gin.H{"sku": r.SKU, "error": "商品不存在，图片未导入"})
+                        continue
+                    }
+                    isNew = true
+                    p.SKU = r.SKU
+                    p.Status = ProductActive
+                }
+                before := p
+
+                if r.Name != nil {
+                    p.Name = *r.Name
+                }
+                if r.ImageURL != nil {
+                    p.ImageURL = normalizeUploadURL(*r.ImageURL)
+                }
+                // 图片地址由内容决定，事务内只写地址，提交后再写入存储，避免在事务中做存储 I/O
+                img := images[r.SKU]
+                if img != nil {
+                    p.ImageURL = productUploadURLFor(img)
+                }
+                // 只保留与当前值不同的成本修改
+                changes := diffProduct(before, p)
+                edits := costEdits{}
+                for tier, v := range r.Costs {
+                    old := productExcelCost(costs, before, roleStr, tier)
+                    if isNew {
+                        old = 0
+                    }
+                    if v != nil && *v != old {
+                        edits[tier] = v
+                        field := "cost"
+                        if tier != BaseCostTier {
+                            field = "cost_" + tier
+                        }
+                        changes[field] = productChange{old, *v}
+                    }
+                }
+                // 组合商品的成本由组件汇总、停售商品的成本已冻结，都忽略 Excel 中的成本列
+                frozen := ""
+                if !isNew && bundles.isBundle(p.ID) {
+                    frozen = "是组合商品，成本由组件汇总"
+                } else if !isNew && productStatus(p) == ProductDiscontinued {
+                    frozen = "已停售，成本已冻结"
+                }
+                if frozen != "" && len(edits) > 0 {
+                    warnings = append(warnings, fmt.Sprintf("第 %d 行 %s %s，已忽略成本列", r.Row, r.SKU, frozen))
+                    for tier := range edits {
+                        field := "cost"
+                        if tier != BaseCostTier {
+                            field = "cost_" + tier
+                        }
+                        delete(changes, field)
+                    }
+                    edits = costEdits{}
+                }
+                if v := edits[BaseCostTier]; v != nil {
+                    p.Cost = *v
+                }
+
+                if isNew {
+                    if !dryRun {
+                        if err := tx.Create(&p).Error; err != nil {
+                            return err
+                        }
+                        if err := saveRoleCosts(tx, p.ID, edits); err != nil {
+                            return err
+                        }
+                        if img != nil {
+                            pendingImages = append(pendingImages, pendingImage{p.ID, p.SKU, "", img})
+                        }
+                    }
+                    created = append(created, gin.H{"row": r.Row, "sku": r.SKU, "changes": changes})
+                    // 新商品可能匹配上之前未匹配的订单
+                    recost = append(recost, p.SKU)
+                    continue
+                }
+                if len(changes) == 0 {
+                    unchanged++
+                    continue
+                }
+                if !dryRun {
+                    if err := tx.Save(&p).Error; err != nil {
+                        return err
+                    }
+                    if err := saveRoleCosts(tx, p.ID, edits); err != nil {
+                        return err
+                    }
+                    if img != nil && p.ImageURL != before.ImageURL {
+                        pendingImages = append(pendingImages, pendingImage{p.ID, p.SKU, before.ImageURL, img})
+                    }
+                }
+                updated = append(updated, gin.H{"row": r.Row, "sku": r.SKU, "id": p.ID, "changes": changes})
+                if before.Cost != p.Cost {
+                    recost = append(recost, p.SKU)
+                }
+            }
+            return nil
+        })
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        for _, pi := range pendingImages {
+            if _, err := saveProductImage(pi.img); err != nil {
+                rowErrors = append(rowErrors, gin.H{"sku": pi.sku, "error": "图片保存失败，已恢复原图片：" + err.Error()})
+                if err := db.Model(&models.Product{}).Where("id = ?", pi.productID).
+                    UpdateColumn("image_url", pi.oldURL).Error; err != nil {
+                    log.Printf("[import] 恢复商品 %s 的图片地址失败：%v", pi.sku, err)
+                }
+            }
+        }
+
+        if !dryRun {
+            // 组合商品的成本始终由组件汇总，导入的组件成本变化后需要重新汇总
+            if _, err := rollupBundleCosts(db); err != nil {
+                log.Printf("[bundle] 汇总组合商品成本失败：%v", err)
+            }
+            if err := recostSKUs(db, recost...); err != nil {
+                warnings = append(warnings, costWarnings(err)...)
+            }
+        }
+
+        c.JSON(http.StatusOK, gin.H{
+            "dry_run":   dryRun,
+            "created":   created,
+            "updated":   updated,
+            "unchanged": unchanged,
+            "errors":    rowErrors,
+            "warnings":  warnings,
+        })
+    }
+}
+
+// productUploadURLFor 推算图片保存后的统一地址（与 saveProductImage 返回的一致，不实际写入存储）
+func productUploadURLFor(img *utils.ProcessedImage) string {
+    return storage.CanonicalURL(productUploadPrefix + img.OriginalName())
+}
+
+// diffProduct 对比导入前后的商品名称和图片，成本列的变化由导入时按档位单独对比
+func diffProduct(before, after models.Product) map[string]productChange {
+    changes := make(map[string]productChange)
+    if before.Name != after.Name {
+        changes["name"] = productChange{before.Name, after.Name}
+    }
+    if before.ImageURL != after.ImageURL {
+        changes["image_url"] = productChange{before.ImageURL, after.ImageURL}
+    }
+    return changes
+}
+
+// parseProductExcel 解析导入的 Excel（取第一个工作表），按表头识别列。
+// 当前角色无权修改的成本列会被忽略并给出提示。
+func parseProductExcel(r io.Reader, role string) ([]productImportRow, []string, []gin.H, error) {
+    f, err := excelize.OpenReader(r)
+    if err != nil {
+        return nil, nil, nil, fmt.Errorf("Excel 无法解析：%v", err)
+    }
+    defer f.Close()
+
+    sheets := f.GetSheetList()
+    if len(sheets) == 0 {
+        return nil, nil, nil, fmt.Errorf("Excel 中没有工作表")
+    }
+    all, err := f.GetRows(sheets[0])
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    if len(all) == 0 {
+        return nil, nil, nil, fmt.Errorf("Excel 为空")
+    }
+
+    // 表头 → 字段
+    colField := make(map[int]string)
+    for i, h := range all[0] {
+        h = strings.TrimSpace(h)
+        for _, col := range productExcelColumns() {
+            if strings.EqualFold(h, col.Header) || strings.EqualFold(h, col.Field) {
+                colField[i] = col.Field
+            }
+        }
+    }
+    hasSKU := false
+    for _, f := range colField {
+        if f == "sku" {
+            hasSKU = true
+        }
+    }
+    if !hasSKU {
+        return nil, nil, nil, fmt.Errorf("Excel 缺少 SKU 列")
+    }
+
+    allowed := make(map[string]bool)
+    for _, f := range productExcelCostFields(role) {
+        if canEditCostTier(role, costFieldTier(f)) {
+            allowed[f] = true
+        }
+    }
+    var warnings []string
+    for _, f := range colField {
+        if strings.HasPrefix(f, "cost") && !allowed[f] {
+            warnings = append(warnings, fmt.Sprintf("当前角色无权修改“%s”列，已忽略", f))
+        }
+    }
+
+    var rows []productImportRow
+    var rowErrors []gin.H
+    seen := make(map[string]int)
+    for i, cells := range all[1:] {
+        rowNo := i + 2
+        r := productImportRow{Row: rowNo}
+        empty := true
+        var parseErr string
+        for ci, raw := range cells {
+            field, ok := colField[ci]
+            if !ok {
+                continue
+            }
+            v := strings.TrimSpace(raw)
+            if v == "" {
+                continue
+            }
+            empty = false
+            switch field {
+            case "sku":
+                r.SKU = v
+            case "name":
+                r.Name = &v
+            case "image_url":
+                r.ImageURL = &v
+            default:
+                if !allowed[field] {
+                    continue
+                }
+                n, err := strconv.ParseFloat(v, 64)
+                if err != nil || n < 0 {
+                    parseErr = fmt.Sprintf("“%s”列不是有效金额：%s", field, v)
+                    continue
+                }
+                if r.Costs == nil {
+                    r.Costs = costEdits{}
+                }
+                r.Costs[costFieldTier(field)] = &n
+            }
+        }
+        if empty {
+            continue
+        }
+        if r.SKU == "" {
+            rowErrors = append(rowErrors, gin.H{"row": rowNo, "error": "SKU 不能为空"})
+            continue
+        }
+        if parseErr != "" {
+            rowErrors = append(rowErrors, gin.H{"row": rowNo, "sku": r.SKU, "error": parseErr})
+            continue
+        }
+        if prev, dup := seen[r.SKU]; dup {
+            rowErrors = append(rowErrors, gin.H{"row": rowNo, "sku": r.SKU, "error": fmt.Sprintf("与第 %d 行 SKU 重复，已忽略", prev)})
+            continue
+        }
+        seen[r.SKU] = rowNo
+        rows = append(rows, r)
+    }
+    return rows, warnings, rowErrors, nil
+}
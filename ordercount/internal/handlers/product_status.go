@@ -0,0 +1,188 @@
+package handlers
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+)
+
+// 商品生命周期状态
+const (
+    ProductDraft        = "draft"        // 草稿：资料未完善，不能下单
+    ProductActive       = "active"       // 在售
+    ProductPaused       = "paused"       // 暂停销售，可随时恢复
+    ProductDiscontinued = "discontinued" // 停售 / 归档：不再出现在选品列表，成本冻结
+)
+
+// productStatusLabels 状态的中文名称，用于错误提示
+var productStatusLabels = map[string]string{
+    ProductDraft:        "草稿",
+    ProductActive:       "在售",
+    ProductPaused:       "暂停",
+    ProductDiscontinued: "停售",
+}
+
+// productStatusTransitions 允许的状态流转：草稿只能是新建商品的初始状态
+var productStatusTransitions = map[string][]string{
+    ProductDraft:        {ProductActive, ProductDiscontinued},
+    ProductActive:       {ProductPaused, ProductDiscontinued},
+    ProductPaused:       {ProductActive, ProductDiscontinued},
+    ProductDiscontinued: {ProductActive},
+}
+
+// productStatus 兼容旧数据：状态为空视为在售
+func productStatus(p models.Product) string {
+    if p.Status == "" {
+        return ProductActive
+    }
+    return p.Status
+}
+
+// changeProductStatus 修改商品状态并写入变更记录；暂停和停售必须填写原因
+func changeProductStatus(tx *gorm.DB, p *models.Product, to, reason string, userID uint) error {
+    from := productStatus(*p)
+    reason = strings.TrimSpace(reason)
+    if _, ok := productStatusLabels[to]; !ok {
+        return fmt.Errorf("状态只能是 draft / active / paused / discontinued")
+    }
+    if from == to {
+        return fmt.Errorf("商品已经是“%s”状态", productStatusLabels[to])
+    }
+    allowed := false
+    for _, s := range productStatusTransitions[from] {
+        if s == to {
+            allowed = true
+        }
+    }
+    if !allowed {
+        return fmt.Errorf("不能从“%s”变更为“%s”", productStatusLabels[from], productStatusLabels[to])
+    }
+    if (to == ProductPaused || to == ProductDiscontinued) && reason == "" {
+        return fmt.Errorf("暂停或停售需要填写原因")
+    }
+
+    now := time.Now()
+    p.Status = to
+    p.StatusReason = reason
+    p.StatusChangedAt = &now
+    if err := tx.Model(&models.Product{}).Where("id = ?", p.ID).UpdateColumns(map[string]any{
+        "status":            to,
+        "status_reason":     reason,
+        "status_changed_at": now,
+    }).Error; err != nil {
+        return err
+    }
+    return tx.Create(&models.ProductStatusLog{
+        ProductID:  p.ID,
+        FromStatus: from,
+        ToStatus:   to,
+        Reason:     reason,
+        UserID:     userID,
+        CreatedAt:  now,
+    }).Error
+}
+
+// parseStatusFilter 解析 ListProducts 的 status 参数：逗号分隔的多个状态，all 表示全部；
+// 未传时默认不返回停售商品
+func parseStatusFilter(v string) ([]string, bool, error) {
+    v = strings.TrimSpace(v)
+    if v == "all" {
+        return nil, true, nil
+    }
+    if v == "" {
+        return []string{ProductDraft, ProductActive, ProductPaused}, false, nil
+    }
+    var list []string
+    for _, s := range strings.Split(v, ",") {
+        s = strings.TrimSpace(s)
+        if s == "" {
+            continue
+        }
+        if _, ok := productStatusLabels[s]; !ok {
+            return nil, false, fmt.Errorf("invalid status: %s", s)
+        }
+        list = append(list, s)
+    }
+    return list, false, nil
+}
+
+// ensureOrderProductActive 新录入（或修改 SKU）的订单只能使用在售商品；匹配不到商品的 SKU 不拦截
+func ensureOrderProductActive(db *gorm.DB, o models.Order) error {
+    if strings.TrimSpace(o.SKU) == "" {
+        return nil
+    }
+    r, err := loadSKUResolver(db, []string{o.SKU})
+    if err != nil {
+        return err
+    }
+    id := r.resolve(o.Platform, o.StoreID, o.SKU)
+    if id == 0 {
+        return nil
+    }
+    var p models.Product
+    if err := db.Select("id, sku, status").First(&p, id).Error; err != nil {
+        if err == gorm.ErrRecordNotFound {
+            return nil
+        }
+        return err
+    }
+    if st := productStatus(p); st != ProductActive {
+        return fmt.Errorf("商品 %s 当前为“%s”状态，不能录入新订单", p.SKU, productStatusLabels[st])
+    }
+    return nil
+}
+
+// SetProductStatus 修改商品状态（管理员 / 超级管理员）
+// POST /api/products/:id/status  {status: "paused", reason: "断货"}
+func SetProductStatus(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以修改商品状态"})
+            return
+        }
+        var body struct {
+            Status string `json:"status"`
+            Reason string `json:"reason"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        var p models.Product
+        if err := db.First(&p, c.Param("id")).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "商品不存在"})
+            return
+        }
+        userIDVal, _ := c.Get("userID")
+        uid, _ := userIDVal.(uint)
+
+        err := db.Transaction(func(tx *gorm.DB) error {
+            return changeProductStatus(tx, &p, strings.TrimSpace(body.Status), body.Reason, uid)
+        })
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, p)
+    }
+}
+
+// ListProductStatusLogs 商品状态变更记录，按时间倒序
+// GET /api/products/:id/status_logs
+func ListProductStatusLogs(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var logs []models.ProductStatusLog
+        if err := db.Where("product_id = ?", c.Param("id")).Order("id desc").Find(&logs).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"items": logs})
+    }
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+    "fmt"
+
+    "ordercount/internal/models"
+)
+
+// 汇率来源
+const (
+    RateSourceService = "rate_service" // 汇率服务的实时汇率
+    RateSourceManual  = "manual"       // 手动输入
+    RateSourceLegacy  = "legacy"       // 启用多币种前的数据，来源未知
+)
+
+// 币种口径
+const (
+    CurrencyBasisExplicit = "explicit"
+    CurrencyBasisLegacy   = "legacy_assumed"
+)
+
+// settlementLegacyCurrencyNote 历史结算补齐币种时采用的假设，与启动迁移（internal/db）共用
+const settlementLegacyCurrencyNote = models.SettlementLegacyCurrencyNote
+
+// settlementAmountCNY 把原币种金额折算成人民币：currency 只能是 CNY 或结算的本国货币 local（汇率 exchange 为 1 本国货币 ≈ ? 人民币）
+func settlementAmountCNY(amount float64, currency, local string, exchange float64) (float64, error) {
+    switch {
+    case currency == "CNY":
+        return roundMoney(amount), nil
+    case currency == local && local != "":
+        if exchange <= 0 {
+            if amount == 0 {
+                return 0, nil
+            }
+            return 0, fmt.Errorf("%s 金额需要有效的汇率", currency)
+        }
+        return roundMoney(amount * exchange), nil
+    default:
+        return 0, fmt.Errorf("币种 %s 无效，只能是 CNY 或 %s", currency, local)
+    }
+}
+
+// fillSettlementCNY 按各项金额声明的币种计算人民币金额，未声明的币种按结账工具的默认口径补齐
+// （广告费为本国货币，其余为人民币）
+func fillSettlementCNY(s *models.DailySettlement) error {
+    if s.SaleCurrency == "" {
+        s.SaleCurrency = "CNY"
+    }
+    if s.AdCurrency == "" {
+        s.AdCurrency = s.Currency
+    }
+    if s.GoodsCurrency == "" {
+        s.GoodsCurrency = "CNY"
+    }
+    if s.ShuaDanCurrency == "" {
+        s.ShuaDanCurrency = "CNY"
+    }
+    if s.FixedCurrency == "" {
+        s.FixedCurrency = "CNY"
+    }
+    if s.RateSource == "" {
+        s.RateSource = RateSourceManual
+    }
+    items := []struct {
+        Label    string
+        Amount   float64
+        Currency string
+        CNY      *float64
+    }{
+        {"销售额", s.SaleTotal, s.SaleCurrency, &s.SaleTotalCNY},
+        {"广告费", s.AdCost, s.AdCurrency, &s.AdCostCNY},
+        {"货款成本", s.GoodsCost, s.GoodsCurrency, &s.GoodsCostCNY},
+        {"刷单费用", s.ShuaDanFee, s.ShuaDanCurrency, &s.ShuaDanFeeCNY},
+        {"固定成本", s.FixedCost, s.FixedCurrency, &s.FixedCostCNY},
+    }
+    for _, it := range items {
+        v, err := settlementAmountCNY(it.Amount, it.Currency, s.Currency, s.Exchange)
+        if err != nil {
+            return fmt.Errorf("%s：%w", it.Label, err)
+        }
+        *it.CNY = v
+    }
+    s.CurrencyBasis = CurrencyBasisExplicit
+    s.CurrencyNote = ""
+    return nil
+}
+
+// assumeLegacySettlementCurrency 启用多币种前的结算（或其修订快照）没有币种信息，按 settlementLegacyCurrencyNote 的假设补齐
+func assumeLegacySettlementCurrency(s *models.DailySettlement) {
+    if s.Currency == "" {
+        s.Currency = countryCurrency[s.Country]
+    }
+    s.SaleCurrency, s.SaleTotalCNY = "CNY", s.SaleTotal
+    s.AdCurrency, s.AdCostCNY = s.Currency, roundMoney(s.AdCost*s.Exchange)
+    s.GoodsCurrency, s.GoodsCostCNY = "CNY", s.GoodsCost
+    s.ShuaDanCurrency, s.ShuaDanFeeCNY = "CNY", s.ShuaDanFee
+    s.FixedCurrency, s.FixedCostCNY = "CNY", s.FixedCost
+    s.RateSource = RateSourceLegacy
+    s.CurrencyBasis = CurrencyBasisLegacy
+    s.CurrencyNote = settlementLegacyCurrencyNote
+}
+
+// settlementProfitCNY 利润（人民币）= 销售额 - 广告费折算 - 货款成本 - 平台手续费 - 刷单费用 - 固定成本
+func settlementProfitCNY(s models.DailySettlement) float64 {
+    return roundMoney(s.SaleTotalCNY - s.AdDeduction - s.GoodsCostCNY - s.PlatformFee - s.ShuaDanFeeCNY - s.FixedCostCNY)
+}
@@ -0,0 +1,416 @@
+package handlers
+
+import (
+    "fmt"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+    "ordercount/internal/utils"
+)
+
+// 销售额来源
+const (
+    SalesSourceAuto    = "auto"    // 有订单明细时按订单汇总，否则使用“今日总额汇总”
+    SalesSourceOrders  = "orders"  // 按订单明细汇总
+    SalesSourceSummary = "summary" // 使用当天的“今日总额汇总”记录
+)
+
+// proposalSource 结算建议中每一项数值的来源说明
+type proposalSource struct {
+    Field  string  `json:"field"`
+    Label  string  `json:"label"`
+    Value  float64 `json:"value"`
+    Source string  `json:"source"` // orders / summary / store_stats / product_cost / rate_service / config / fee_rules / manual
+    Detail string  `json:"detail"`
+}
+
+// settlementProposal 服务端按已有数据计算的结算建议，字段与 SaveSettlement 的请求体一致，确认后原样提交保存
+type settlementProposal struct {
+    Date       string  `json:"date"`
+    Country    string  `json:"country"`
+    Platform   string  `json:"platform"`
+    StoreID    uint    `json:"store_id"`
+    Currency   string  `json:"currency"`
+    SaleTotal  float64 `json:"sale_total"`
+    AdCost     float64 `json:"ad_cost"`
+    Exchange   float64 `json:"exchange"`
+    GoodsCost  float64 `json:"goods_cost"`
+    ShuaDanFee float64 `json:"shua_dan_fee"`
+    FixedCost  float64 `json:"fixed_cost"`
+
+    // 各项金额的币种：广告费为本国货币，其余为人民币
+    SaleCurrency    string `json:"sale_currency"`
+    AdCurrency      string `json:"ad_currency"`
+    GoodsCurrency   string `json:"goods_currency"`
+    ShuaDanCurrency string `json:"shua_dan_currency"`
+    FixedCurrency   string `json:"fixed_currency"`
+    RateSource      string `json:"rate_source"`
+
+    AdDeduction float64   `json:"ad_deduction"`
+    PlatformFee float64   `json:"platform_fee"`
+    Profit      float64   `json:"profit"`
+    FeeLines    []feeLine `json:"fee_lines"`
+
+    Sources  []proposalSource `json:"sources"`
+    Warnings []string         `json:"warnings"`
+}
+
+func (p *settlementProposal) warn(format string, args ...any) {
+    p.Warnings = append(p.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (p *settlementProposal) source(field, label string, value float64, source, detail string) {
+    p.Sources = append(p.Sources, proposalSource{Field: field, Label: label, Value: roundMoney(value), Source: source, Detail: detail})
+}
+
+// proposalOrderScope 结算建议统计订单的范围：日期 + 国家，可选平台 / 店铺，排除汇总记录；
+// 非超级管理员只统计自己被授权店铺的订单（与 proposeAdCost 的店铺范围一致）
+func proposalOrderScope(db *gorm.DB, p *settlementProposal, role string, userID uint) *gorm.DB {
+    q := db.Table("orders AS o").
+        Where("DATE(o.created_at) = ? AND o.country = ?", p.Date, p.Country).
+        Where("o.product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇")
+    if p.StoreID != 0 {
+        q = q.Where("o.store_id = ?", p.StoreID)
+    } else if p.Platform != "" {
+        q = q.Where("o.platform = ?", p.Platform)
+    }
+    if role != "superadmin" {
+        q = q.Where("o.store_id IN (?)", db.Model(&models.StoreUser{}).Select("store_id").Where("user_id = ?", userID))
+    }
+    return q
+}
+
+// proposalConverter 结算建议的人民币折算：本国货币使用建议中的汇率，与广告费折算口径一致
+func proposalConverter(p *settlementProposal) *cnyConverter {
+    fixed := map[string]float64{}
+    if p.Currency != "" && p.Exchange > 0 {
+        fixed[p.Currency] = p.Exchange
+    }
+    return newCNYConverter(fixed)
+}
+
+// proposeSales 销售额（人民币）：订单按币种合计后折算，本国货币按建议的汇率，其他币种按实时汇率
+func proposeSales(db *gorm.DB, p *settlementProposal, salesSource, role string, userID uint) error {
+    var aggs []struct {
+        Currency string
+        Cnt      int64
+        Amount   float64
+    }
+    if err := proposalOrderScope(db, p, role, userID).
+        Select("o.currency AS currency, COUNT(*) AS cnt, IFNULL(SUM(o.total_amount), 0) AS amount").
+        Group("o.currency").Order("o.currency").
+        Scan(&aggs).Error; err != nil {
+        return err
+    }
+    var cnt int64
+    for _, a := range aggs {
+        cnt += a.Cnt
+    }
+    cv := proposalConverter(p)
+
+    useOrders := salesSource == SalesSourceOrders || (salesSource == SalesSourceAuto && cnt > 0)
+    if useOrders {
+        total := 0.0
+        parts := make([]string, 0, len(aggs))
+        for _, a := range aggs {
+            total += cv.convert(a.Amount, a.Currency)
+            cur := a.Currency
+            if cur == "" {
+                cur = "CNY"
+            }
+            parts = append(parts, fmt.Sprintf("%s %.2f", cur, a.Amount))
+        }
+        p.SaleTotal = roundMoney(total)
+        detail := fmt.Sprintf("%d 条订单的总额合计", cnt)
+        if len(parts) > 0 {
+            detail += "（" + strings.Join(parts, "，") + "，折算人民币）"
+        }
+        p.source("sale_total", "当天销售总额", p.SaleTotal, "orders", detail)
+        if cnt == 0 {
+            p.warn("%s %s 没有订单记录，销售额为 0", p.Date, p.Country)
+        }
+        p.Warnings = append(p.Warnings, cv.warnings()...)
+        return nil
+    }
+
+    // 每日总额汇总只有一条，不区分国家和店铺
+    var summary models.Order
+    err := db.Where("product_name IN (?, ?) AND DATE(created_at) = ?", "今日总额汇总", "今日总汇", p.Date).
+        Order("created_at desc").First(&summary).Error
+    if err != nil && err != gorm.ErrRecordNotFound {
+        return err
+    }
+    if err == gorm.ErrRecordNotFound {
+        p.source("sale_total", "当天销售总额", 0, "summary", "当天没有提交今日总额汇总")
+        p.warn("%s 没有订单明细，也没有提交今日总额汇总，销售额为 0", p.Date)
+        return nil
+    }
+    p.SaleTotal = roundMoney(cv.convert(summary.TotalAmount, summary.Currency))
+    p.source("sale_total", "当天销售总额", p.SaleTotal, "summary",
+        "今日总额汇总（"+summary.CreatedAt.Format("2006-01-02 15:04")+" 提交）")
+    p.warn("今日总额汇总不区分国家和店铺，请确认是否全部属于%s", p.Country)
+    p.Warnings = append(p.Warnings, cv.warnings()...)
+    return nil
+}
+
+// proposeAdCost 广告费（本国货币）：范围内各店铺当天的 StoreDailyStat 合计，非超级管理员只统计自己被授权的店铺
+func proposeAdCost(db *gorm.DB, p *settlementProposal, role string, userID uint) error {
+    q := db.Model(&models.Store{}).Where("country = ? AND is_blocked = ?", p.Country, false)
+    if p.StoreID != 0 {
+        q = db.Model(&models.Store{}).Where("id = ?", p.StoreID)
+    } else if p.Platform != "" {
+        q = q.Where("platform = ?", p.Platform)
+    }
+    if role != "superadmin" {
+        q = q.Where("id IN (?)", db.Model(&models.StoreUser{}).Select("store_id").Where("user_id = ?", userID))
+    }
+    var stores []models.Store
+    if err := q.Order("id asc").Find(&stores).Error; err != nil {
+        return err
+    }
+    if len(stores) == 0 {
+        p.source("ad_cost", "广告费", 0, "store_stats", "没有可统计的店铺")
+        p.warn("%s 没有可统计的店铺，广告费为 0，请手动填写", p.Country)
+        return nil
+    }
+    ids := make([]uint, 0, len(stores))
+    for _, s := range stores {
+        ids = append(ids, s.ID)
+    }
+    var stats []models.StoreDailyStat
+    if err := db.Where("store_id IN ? AND date = ?", ids, p.Date).Find(&stats).Error; err != nil {
+        return err
+    }
+    got := map[uint]bool{}
+    total := 0.0
+    for _, st := range stats {
+        got[st.StoreID] = true
+        total += st.AdCost
+    }
+    var missing []string
+    for _, s := range stores {
+        if !got[s.ID] {
+            missing = append(missing, s.Name)
+        }
+    }
+    p.AdCost = roundMoney(total)
+    p.source("ad_cost", "广告费", p.AdCost, "store_stats",
+        fmt.Sprintf("%d 个店铺中 %d 个已录入当天广告费（本国货币）", len(stores), len(stores)-len(missing)))
+    if len(missing) > 0 {
+        p.warn("以下店铺未录入 %s 的广告费：%s", p.Date, strings.Join(missing, "、"))
+    }
+    return nil
+}
+
+// proposeGoodsCost 货款成本（人民币）：订单上核算好的成本，未核算的订单回退到 数量 × 商品基础成本
+func proposeGoodsCost(db *gorm.DB, p *settlementProposal, role string, userID uint) error {
+    var r struct {
+        Cost      float64
+        Cnt       int64
+        Unmatched int64
+        NoCost    int64
+    }
+    if err := proposalOrderScope(db, p, role, userID).Joins(orderProductJoin).
+        Where("o.quantity > 0").
+        Select("IFNULL(SUM(" + orderGoodsCostExpr + "), 0) AS cost, COUNT(*) AS cnt, " +
+            "IFNULL(SUM(CASE WHEN o.cost_method = '' AND p.id IS NULL THEN 1 ELSE 0 END), 0) AS unmatched, " +
+            "IFNULL(SUM(CASE WHEN o.cost_method = '' AND p.id IS NOT NULL AND IFNULL(p.cost, 0) = 0 THEN 1 ELSE 0 END), 0) AS no_cost").
+        Scan(&r).Error; err != nil {
+        return err
+    }
+    p.GoodsCost = roundMoney(r.Cost)
+    p.source("goods_cost", "货款成本", p.GoodsCost, "product_cost", fmt.Sprintf("%d 条订单的货款成本合计", r.Cnt))
+    if r.Unmatched > 0 {
+        p.warn("有 %d 条订单未匹配到商品，货款成本按 0 计算", r.Unmatched)
+    }
+    if r.NoCost > 0 {
+        p.warn("有 %d 条订单的商品未维护成本，货款成本按 0 计算", r.NoCost)
+    }
+    return nil
+}
+
+// proposeExchange 汇率（1 本国货币 ≈ ? 人民币），来自汇率服务的实时汇率
+func proposeExchange(p *settlementProposal) {
+    cur, ok := countryCurrency[p.Country]
+    if !ok {
+        p.warn("未知国家 %s，无法确定币种和汇率", p.Country)
+        return
+    }
+    p.Currency = cur
+    p.AdCurrency = cur
+    rates, err := utils.GetRates()
+    if err != nil || rates[cur] <= 0 {
+        p.source("exchange", "对应汇率", 0, "rate_service", "获取汇率失败")
+        p.warn("获取 %s 汇率失败，请手动填写汇率", cur)
+        return
+    }
+    p.Exchange = 1 / rates[cur]
+    p.RateSource = RateSourceService
+    p.Sources = append(p.Sources, proposalSource{
+        Field: "exchange", Label: "对应汇率", Value: p.Exchange, Source: "rate_service",
+        Detail: fmt.Sprintf("实时汇率 1 CNY ≈ %.4f %s", rates[cur], cur),
+    })
+    if p.Date != time.Now().Format("2006-01-02") {
+        p.warn("汇率服务只提供实时汇率，%s 的结算使用的是当前汇率", p.Date)
+    }
+}
+
+// proposeLedgerCosts 刷单费用和固定成本（人民币）：按当天费用流水和固定成本计划合计（与保存结算时的口径一致），
+// 没有固定成本记录时使用配置 settlement.fixed_costs，没有刷单流水时提示手动填写
+func proposeLedgerCosts(db *gorm.DB, p *settlementProposal) error {
+    ledger, err := settlementLedgerCosts(db, p.Date, p.Country, p.StoreID)
+    if err != nil {
+        return err
+    }
+    p.Warnings = append(p.Warnings, ledger.Warnings...)
+
+    if len(ledger.ShuaDanLines) > 0 {
+        p.ShuaDanFee = ledger.ShuaDanFee
+        p.source("shua_dan_fee", "刷单费用", ledger.ShuaDanFee, "expenses", "费用流水："+costLineNames(ledger.ShuaDanLines))
+    } else {
+        p.source("shua_dan_fee", "刷单费用", 0, "manual", "当天没有刷单费用流水")
+        p.warn("当天没有刷单费用流水，如有刷单请先在费用流水中录入或手动填写")
+    }
+
+    if len(ledger.FixedLines) > 0 {
+        p.FixedCost = ledger.FixedCost
+        p.source("fixed_cost", "固定成本", ledger.FixedCost, "expenses", "费用流水 / 固定成本计划："+costLineNames(ledger.FixedLines))
+        return nil
+    }
+    if v, ok := SettlementFixedCosts[p.Country]; ok {
+        p.FixedCost = v
+        p.source("fixed_cost", "固定成本", v, "config", "配置 settlement.fixed_costs."+p.Country)
+        return nil
+    }
+    if v, ok := SettlementFixedCosts["default"]; ok {
+        p.FixedCost = v
+        p.source("fixed_cost", "固定成本", v, "config", "配置 settlement.fixed_costs.default")
+        return nil
+    }
+    p.source("fixed_cost", "固定成本", 0, "config", "未配置")
+    p.warn("未配置 %s 的固定成本计划或每日固定成本（settlement.fixed_costs），按 0 计算", p.Country)
+    return nil
+}
+
+// buildSettlementProposal 按日期 + 国家（可选平台 / 店铺）汇总已有数据生成结算建议，缺少的数据以 0 计并给出提示
+func buildSettlementProposal(db *gorm.DB, p *settlementProposal, salesSource, role string, userID uint) error {
+    // 先确定汇率，销售额中的本国货币订单按该汇率折算人民币
+    proposeExchange(p)
+    if err := proposeSales(db, p, salesSource, role, userID); err != nil {
+        return err
+    }
+    if err := proposeAdCost(db, p, role, userID); err != nil {
+        return err
+    }
+    if err := proposeGoodsCost(db, p, role, userID); err != nil {
+        return err
+    }
+    if err := proposeLedgerCosts(db, p); err != nil {
+        return err
+    }
+
+    fees, err := calcSettlementFees(db, feeScope{Date: p.Date, Country: p.Country, Platform: p.Platform, StoreID: p.StoreID}, feeInput{
+        SaleTotal: p.SaleTotal,
+        AdCost:    p.AdCost,
+        Exchange:  p.Exchange,
+        GoodsCost: p.GoodsCost,
+    })
+    if err != nil {
+        return err
+    }
+    p.AdDeduction = fees.AdDeduction
+    p.PlatformFee = fees.PlatformFee
+    p.FeeLines = fees.Lines
+    p.Warnings = append(p.Warnings, fees.Warnings...)
+    p.source("ad_deduction", "广告费折算", fees.AdDeduction, "fee_rules", "广告费 × 汇率 + 广告税")
+    p.source("platform_fee", "平台手续费", fees.PlatformFee, "fee_rules", "按结算日期生效的费用规则")
+    p.Profit = roundMoney(p.SaleTotal - p.AdDeduction - p.GoodsCost - p.PlatformFee - p.ShuaDanFee - p.FixedCost)
+    return nil
+}
+
+// ProposeSettlement 按已有数据生成某天某个国家（或店铺）的结算建议，只计算不保存；
+// 前端展示每一项的来源和提示，用户确认 / 修改后再提交 POST /api/settlement 保存。
+// GET /api/settlement/proposal?date=&country=&platform=&store_id=&sales_source=auto|orders|summary
+func ProposeSettlement(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        p := &settlementProposal{
+            Date:     strings.TrimSpace(c.Query("date")),
+            Country:  strings.TrimSpace(c.Query("country")),
+            Platform: strings.TrimSpace(c.Query("platform")),
+
+            SaleCurrency:    "CNY",
+            GoodsCurrency:   "CNY",
+            ShuaDanCurrency: "CNY",
+            FixedCurrency:   "CNY",
+
+            Sources:  []proposalSource{},
+            Warnings: []string{},
+        }
+        if p.Date == "" {
+            p.Date = time.Now().Format("2006-01-02")
+        }
+        if _, err := time.Parse("2006-01-02", p.Date); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "invalid date format, want YYYY-MM-DD"})
+            return
+        }
+        salesSource := c.DefaultQuery("sales_source", SalesSourceAuto)
+        if salesSource != SalesSourceAuto && salesSource != SalesSourceOrders && salesSource != SalesSourceSummary {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "sales_source 只能是 auto / orders / summary"})
+            return
+        }
+
+        roleVal, _ := c.Get("role")
+        role, _ := roleVal.(string)
+        userIDVal, _ := c.Get("userID")
+        uid, _ := userIDVal.(uint)
+
+        // 指定店铺时国家和平台取自店铺，非超级管理员只能查看自己被授权的店铺（与保存结算相同）
+        if v := c.Query("store_id"); v != "" {
+            id, err := strconv.Atoi(v)
+            if err != nil || id <= 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "invalid store_id"})
+                return
+            }
+            store, err := loadSettlementStore(db, uint(id), role, uid)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+            }
+            p.StoreID = store.ID
+            p.Country = store.Country
+            p.Platform = store.Platform
+        }
+        if p.Country == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "country 或 store_id 必填"})
+            return
+        }
+        if err := buildSettlementProposal(db, p, salesSource, role, uid); err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if err := ensurePeriodOpen(db, p.Date); err != nil {
+            p.Warnings = append(p.Warnings, err.Error())
+        }
+
+        // 结算按店铺保存；已有结算时提示保存会覆盖（或因已审批无法保存）
+        var existing models.DailySettlement
+        if p.StoreID == 0 {
+            p.warn("按国家生成的建议仅供参考，保存结算需要选择店铺")
+        } else if err := db.Where("date = ? AND store_id = ?", p.Date, p.StoreID).First(&existing).Error; err == nil {
+            st := settlementStatus(existing)
+            if ensureSettlementEditable(existing) != nil {
+                p.warn("该日期已有%s的结算（第 %d 版），不能再保存", settlementStatusLabels[st], existing.Revision)
+            } else {
+                p.warn("该日期已有结算（第 %d 版，%s），确认保存后将生成新版本", existing.Revision, settlementStatusLabels[st])
+            }
+        }
+
+        c.JSON(http.StatusOK, p)
+    }
+}
@@ -0,0 +1,479 @@
+package handlers
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "reflect"
+    "strconv"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+)
+
+// settlementSnapshot 修订记录中保存的结算字段
+type settlementSnapshot struct {
+    Date        string  `json:"date"`
+    Country     string  `json:"country"`
+    Platform    string  `json:"platform"`
+    Currency    string  `json:"currency"`
+    SaleTotal   float64 `json:"sale_total"`
+    AdCost      float64 `json:"ad_cost"`
+    Exchange    float64 `json:"exchange"`
+    GoodsCost   float64 `json:"goods_cost"`
+    ShuaDanFee  float64 `json:"shua_dan_fee"`
+    FixedCost   float64 `json:"fixed_cost"`
+    // 启用多币种前的修订快照没有以下字段，回滚时按历史假设补齐
+    SaleCurrency    string  `json:"sale_currency"`
+    SaleTotalCNY    float64 `json:"sale_total_cny"`
+    AdCurrency      string  `json:"ad_currency"`
+    AdCostCNY       float64 `json:"ad_cost_cny"`
+    GoodsCurrency   string  `json:"goods_currency"`
+    GoodsCostCNY    float64 `json:"goods_cost_cny"`
+    ShuaDanCurrency string  `json:"shua_dan_currency"`
+    ShuaDanFeeCNY   float64 `json:"shua_dan_fee_cny"`
+    FixedCurrency   string  `json:"fixed_currency"`
+    FixedCostCNY    float64 `json:"fixed_cost_cny"`
+    RateSource      string  `json:"rate_source"`
+    CurrencyBasis   string  `json:"currency_basis"`
+    CurrencyNote    string  `json:"currency_note"`
+    AdDeduction float64 `json:"ad_deduction"`
+    PlatformFee float64 `json:"platform_fee"`
+    Profit      float64 `json:"profit"`
+    FeeRuleIDs  string  `json:"fee_rule_ids"`
+    FeeDetail   string  `json:"fee_detail"`
+    CostDetail  string  `json:"cost_detail"`
+    Remark      string  `json:"remark"`
+}
+
+// 字段差异展示用的中文名称，顺序即展示顺序
+var settlementFieldLabels = []struct{ Field, Label string }{
+    {"date", "日期"},
+    {"country", "国家"},
+    {"platform", "平台"},
+    {"currency", "币种"},
+    {"sale_total", "销售总额"},
+    {"ad_cost", "广告费"},
+    {"exchange", "汇率"},
+    {"goods_cost", "货款成本"},
+    {"shua_dan_fee", "刷单费用"},
+    {"fixed_cost", "固定成本"},
+    {"sale_currency", "销售额币种"},
+    {"sale_total_cny", "销售额（人民币）"},
+    {"ad_currency", "广告费币种"},
+    {"ad_cost_cny", "广告费（人民币）"},
+    {"goods_currency", "货款成本币种"},
+    {"goods_cost_cny", "货款成本（人民币）"},
+    {"shua_dan_currency", "刷单费用币种"},
+    {"shua_dan_fee_cny", "刷单费用（人民币）"},
+    {"fixed_currency", "固定成本币种"},
+    {"fixed_cost_cny", "固定成本（人民币）"},
+    {"rate_source", "汇率来源"},
+    {"currency_basis", "币种口径"},
+    {"ad_deduction", "广告费折算"},
+    {"platform_fee", "平台手续费"},
+    {"profit", "利润"},
+    {"fee_rule_ids", "费用规则"},
+    {"fee_detail", "费用明细"},
+    {"cost_detail", "成本来源明细"},
+    {"remark", "备注"},
+}
+
+// settlementChange 一个字段的变化
+type settlementChange struct {
+    Field string `json:"field"`
+    Label string `json:"label"`
+    Old   any    `json:"old"`
+    New   any    `json:"new"`
+}
+
+func snapshotSettlement(s models.DailySettlement) settlementSnapshot {
+    return settlementSnapshot{
+        Date:        s.Date,
+        Country:     s.Country,
+        Platform:    s.Platform,
+        Currency:    s.Currency,
+        SaleTotal:   s.SaleTotal,
+        AdCost:      s.AdCost,
+        Exchange:    s.Exchange,
+        GoodsCost:   s.GoodsCost,
+        ShuaDanFee:  s.ShuaDanFee,
+        FixedCost:   s.FixedCost,
+
+        SaleCurrency:    s.SaleCurrency,
+        SaleTotalCNY:    s.SaleTotalCNY,
+        AdCurrency:      s.AdCurrency,
+        AdCostCNY:       s.AdCostCNY,
+        GoodsCurrency:   s.GoodsCurrency,
+        GoodsCostCNY:    s.GoodsCostCNY,
+        ShuaDanCurrency: s.ShuaDanCurrency,
+        ShuaDanFeeCNY:   s.ShuaDanFeeCNY,
+        FixedCurrency:   s.FixedCurrency,
+        FixedCostCNY:    s.FixedCostCNY,
+        RateSource:      s.RateSource,
+        CurrencyBasis:   s.CurrencyBasis,
+        CurrencyNote:    s.CurrencyNote,
+
+        AdDeduction: s.AdDeduction,
+        PlatformFee: s.PlatformFee,
+        Profit:      s.Profit,
+        FeeRuleIDs:  s.FeeRuleIDs,
+        FeeDetail:   s.FeeDetail,
+        CostDetail:  s.CostDetail,
+        Remark:      s.Remark,
+    }
+}
+
+// apply 把快照中的字段写回结算记录（回滚用）
+func (snap settlementSnapshot) apply(s *models.DailySettlement) {
+    s.Date = snap.Date
+    s.Country = snap.Country
+    s.Platform = snap.Platform
+    s.Currency = snap.Currency
+    s.SaleTotal = snap.SaleTotal
+    s.AdCost = snap.AdCost
+    s.Exchange = snap.Exchange
+    s.GoodsCost = snap.GoodsCost
+    s.ShuaDanFee = snap.ShuaDanFee
+    s.FixedCost = snap.FixedCost
+    s.AdDeduction = snap.AdDeduction
+    s.PlatformFee = snap.PlatformFee
+    s.Profit = snap.Profit
+    s.FeeRuleIDs = snap.FeeRuleIDs
+    s.FeeDetail = snap.FeeDetail
+    s.CostDetail = snap.CostDetail
+    s.Remark = snap.Remark
+    if snap.CurrencyBasis == "" {
+        assumeLegacySettlementCurrency(s)
+        return
+    }
+    s.SaleCurrency, s.SaleTotalCNY = snap.SaleCurrency, snap.SaleTotalCNY
+    s.AdCurrency, s.AdCostCNY = snap.AdCurrency, snap.AdCostCNY
+    s.GoodsCurrency, s.GoodsCostCNY = snap.GoodsCurrency, snap.GoodsCostCNY
+    s.ShuaDanCurrency, s.ShuaDanFeeCNY = snap.ShuaDanCurrency, snap.ShuaDanFeeCNY
+    s.FixedCurrency, s.FixedCostCNY = snap.FixedCurrency, snap.FixedCostCNY
+    s.RateSource = snap.RateSource
+    s.CurrencyBasis = snap.CurrencyBasis
+    s.CurrencyNote = snap.CurrencyNote
+}
+
+func snapshotFields(snap *settlementSnapshot) map[string]any {
+    m := map[string]any{}
+    if snap == nil {
+        return m
+    }
+    data, _ := json.Marshal(snap)
+    json.Unmarshal(data, &m)
+    return m
+}
+
+// diffSnapshots 逐字段比较两个快照，from 为 nil 时所有字段都视为新增
+func diffSnapshots(from, to *settlementSnapshot) []settlementChange {
+    a, b := snapshotFields(from), snapshotFields(to)
+    changes := []settlementChange{}
+    for _, f := range settlementFieldLabels {
+        oldV, newV := a[f.Field], b[f.Field]
+        if from != nil && reflect.DeepEqual(oldV, newV) {
+            continue
+        }
+        changes = append(changes, settlementChange{Field: f.Field, Label: f.Label, Old: oldV, New: newV})
+    }
+    return changes
+}
+
+func revisionSnapshot(r models.SettlementRevision) (*settlementSnapshot, error) {
+    var snap settlementSnapshot
+    if err := json.Unmarshal([]byte(r.Data), &snap); err != nil {
+        return nil, fmt.Errorf("修订 %d 数据损坏: %w", r.Revision, err)
+    }
+    return &snap, nil
+}
+
+// ensureSettlementBaseline 启用修订历史之前保存的结算没有任何修订记录，
+// 修改前先把当前数据记为第 1 版（baseline），保证改动前的数值可以追溯
+func ensureSettlementBaseline(tx *gorm.DB, s *models.DailySettlement) error {
+    if s.ID == 0 || s.RevisionID != 0 {
+        return nil
+    }
+    var cnt int64
+    if err := tx.Model(&models.SettlementRevision{}).Where("settlement_id = ?", s.ID).Count(&cnt).Error; err != nil {
+        return err
+    }
+    if cnt > 0 {
+        return nil
+    }
+    snap := snapshotSettlement(*s)
+    data, _ := json.Marshal(snap)
+    diff, _ := json.Marshal(diffSnapshots(nil, &snap))
+    rev := models.SettlementRevision{
+        SettlementID: s.ID,
+        Revision:     1,
+        Action:       "baseline",
+        UserID:       s.UserID,
+        Data:         string(data),
+        Diff:         string(diff),
+        Remark:       "启用修订历史前的数据",
+        CreatedAt:    s.CreatedAt,
+    }
+    if err := tx.Create(&rev).Error; err != nil {
+        return err
+    }
+    s.RevisionID, s.Revision = rev.ID, rev.Revision
+    return tx.Model(s).Updates(map[string]any{"revision_id": rev.ID, "revision": rev.Revision}).Error
+}
+
+// recordSettlementRevision 结算保存后调用：记录新版本及相对上一版本的字段变化，并让结算指向该版本
+func recordSettlementRevision(tx *gorm.DB, s *models.DailySettlement, action string, uid uint, remark string) (*models.SettlementRevision, error) {
+    var prev models.SettlementRevision
+    err := tx.Where("settlement_id = ?", s.ID).Order("revision desc").First(&prev).Error
+    if err != nil && err != gorm.ErrRecordNotFound {
+        return nil, err
+    }
+    var prevSnap *settlementSnapshot
+    if err == nil {
+        if prevSnap, err = revisionSnapshot(prev); err != nil {
+            return nil, err
+        }
+    }
+    snap := snapshotSettlement(*s)
+    data, _ := json.Marshal(snap)
+    diff, _ := json.Marshal(diffSnapshots(prevSnap, &snap))
+    rev := models.SettlementRevision{
+        SettlementID: s.ID,
+        Revision:     prev.Revision + 1,
+        Action:       action,
+        UserID:       uid,
+        Data:         string(data),
+        Diff:         string(diff),
+        Remark:       remark,
+    }
+    if err := tx.Create(&rev).Error; err != nil {
+        return nil, err
+    }
+    s.RevisionID, s.Revision = rev.ID, rev.Revision
+    if err := tx.Model(s).Updates(map[string]any{"revision_id": rev.ID, "revision": rev.Revision}).Error; err != nil {
+        return nil, err
+    }
+    return &rev, nil
+}
+
+// loadVisibleSettlement 按 :id 读取结算记录，可见范围与 ListSettlements 一致（见 settlementVisibleScope），看不到的记录按不存在处理
+func loadVisibleSettlement(c *gin.Context, db *gorm.DB) (*models.DailySettlement, bool) {
+    roleVal, _ := c.Get("role")
+    role, _ := roleVal.(string)
+    userIDVal, _ := c.Get("userID")
+    uid, _ := userIDVal.(uint)
+    var s models.DailySettlement
+    q := settlementVisibleScope(db, db.Model(&models.DailySettlement{}), role, uid)
+    if err := q.Where("id = ?", c.Param("id")).First(&s).Error; err != nil {
+        if err == gorm.ErrRecordNotFound {
+            c.JSON(http.StatusNotFound, gin.H{"error": "结算记录不存在"})
+        } else {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+        }
+        return nil, false
+    }
+    return &s, true
+}
+
+// ListSettlementRevisions 结算记录的修订历史（新版本在前）
+// GET /api/settlements/:id/revisions
+func ListSettlementRevisions(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        s, ok := loadVisibleSettlement(c, db)
+        if !ok {
+            return
+        }
+        var revs []models.SettlementRevision
+        if err := db.Where("settlement_id = ?", s.ID).Order("revision desc").Find(&revs).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        userIDs := make([]uint, 0, len(revs))
+        for _, r := range revs {
+            userIDs = append(userIDs, r.UserID)
+        }
+        var users []models.User
+        if len(userIDs) > 0 {
+            db.Select("id, username").Where("id IN ?", userIDs).Find(&users)
+        }
+        names := make(map[uint]string, len(users))
+        for _, u := range users {
+            names[u.ID] = u.Username
+        }
+
+        type item struct {
+            ID        uint            `json:"id"`
+            Revision  int             `json:"revision"`
+            Action    string          `json:"action"`
+            UserID    uint            `json:"user_id"`
+            Username  string          `json:"username"`
+            Remark    string          `json:"remark"`
+            Current   bool            `json:"current"`
+            Data      json.RawMessage `json:"data"`
+            Diff      json.RawMessage `json:"diff"`
+            CreatedAt string          `json:"created_at"`
+        }
+        items := make([]item, 0, len(revs))
+        for _, r := range revs {
+            items = append(items, item{
+                ID:        r.ID,
+                Revision:  r.Revision,
+                Action:    r.Action,
+                UserID:    r.UserID,
+                Username:  names[r.UserID],
+                Remark:    r.Remark,
+                Current:   r.ID == s.RevisionID,
+                Data:      json.RawMessage(r.Data),
+                Diff:      json.RawMessage(r.Diff),
+                CreatedAt: r.CreatedAt.Format("2006-01-02 15:04:05"),
+            })
+        }
+        c.JSON(http.StatusOK, gin.H{"settlement_id": s.ID, "revision": s.Revision, "items": items})
+    }
+}
+
+// findRevision 按版本号查找结算的某个修订
+func findRevision(db *gorm.DB, settlementID uint, revision int) (*models.SettlementRevision, error) {
+    var r models.SettlementRevision
+    if err := db.Where("settlement_id = ? AND revision = ?", settlementID, revision).First(&r).Error; err != nil {
+        if err == gorm.ErrRecordNotFound {
+            return nil, fmt.Errorf("版本 %d 不存在", revision)
+        }
+        return nil, err
+    }
+    return &r, nil
+}
+
+// DiffSettlementRevisions 比较同一条结算的两个版本
+// GET /api/settlements/:id/revisions/diff?from=1&to=3（to 不传时为当前版本）
+func DiffSettlementRevisions(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        s, ok := loadVisibleSettlement(c, db)
+        if !ok {
+            return
+        }
+        from, err := strconv.Atoi(c.Query("from"))
+        if err != nil || from < 1 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "from 需为版本号"})
+            return
+        }
+        to := s.Revision
+        if v := c.Query("to"); v != "" {
+            if to, err = strconv.Atoi(v); err != nil || to < 1 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "to 需为版本号"})
+                return
+            }
+        }
+        snaps := make([]*settlementSnapshot, 2)
+        for i, n := range []int{from, to} {
+            r, err := findRevision(db, s.ID, n)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+            }
+            if snaps[i], err = revisionSnapshot(*r); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "settlement_id": s.ID,
+            "from":          from,
+            "to":            to,
+            "changes":       diffSnapshots(snaps[0], snaps[1]),
+        })
+    }
+}
+
+// RollbackSettlement 把结算恢复到指定版本的数值（包括当时的费用规则明细），回滚本身也记为一个新版本
+// POST /api/settlements/:id/rollback  {revision: 2}
+func RollbackSettlement(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        s, ok := loadVisibleSettlement(c, db)
+        if !ok {
+            return
+        }
+        var body struct {
+            Revision int `json:"revision"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if err := ensureSettlementEditable(*s); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if body.Revision == s.Revision {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "已经是该版本"})
+            return
+        }
+        r, err := findRevision(db, s.ID, body.Revision)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        snap, err := revisionSnapshot(*r)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if err := ensurePeriodOpen(db, s.Date, snap.Date); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        // 同一店铺同一天只能有一条结算，回滚到的版本如果改过日期，不能与其他记录冲突
+        if s.StoreID != nil {
+            var dup int64
+            if err := db.Model(&models.DailySettlement{}).
+                Where("date = ? AND store_id = ? AND id <> ?", snap.Date, *s.StoreID, s.ID).
+                Count(&dup).Error; err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+                return
+            }
+            if dup > 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "该店铺在该日期已有其他结算记录，无法回滚到此版本"})
+                return
+            }
+        }
+
+        userIDVal, _ := c.Get("userID")
+        uid, _ := userIDVal.(uint)
+        var editErr error
+        err = db.Transaction(func(tx *gorm.DB) error {
+            cur, err := lockSettlement(tx, s.ID)
+            if err != nil {
+                return err
+            }
+            if editErr = ensureSettlementEditable(cur); editErr != nil {
+                return editErr
+            }
+            s.Status, s.StatusChangedAt = cur.Status, cur.StatusChangedAt
+            s.ApprovedBy, s.ApprovedAt = cur.ApprovedBy, cur.ApprovedAt
+            snap.apply(s)
+            if err := tx.Save(s).Error; err != nil {
+                return err
+            }
+            if _, err := recordSettlementRevision(tx, s, "rollback", uid, fmt.Sprintf("回滚到第 %d 版", body.Revision)); err != nil {
+                return err
+            }
+            // 与保存一致：已提交的结算被回滚后退回草稿
+            if settlementStatus(*s) == SettlementSubmitted {
+                return setSettlementStatus(tx, s, SettlementDraft, "提交后回滚了数据，需重新提交", uid)
+            }
+            return nil
+        })
+        if editErr != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": editErr.Error()})
+            return
+        }
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, s)
+    }
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+    "gorm.io/gorm/clause"
+
+    "ordercount/internal/models"
+)
+
+// 结算审批状态
+const (
+    SettlementDraft     = "draft"     // 草稿：可以随时修改
+    SettlementSubmitted = "submitted" // 已提交，等待管理员审批；修改后退回草稿
+    SettlementApproved  = "approved"  // 已审批：推送企微，不能再修改
+    SettlementLocked    = "locked"    // 已锁定：最终数据
+)
+
+var settlementStatusLabels = map[string]string{
+    SettlementDraft:     "草稿",
+    SettlementSubmitted: "已提交",
+    SettlementApproved:  "已审批",
+    SettlementLocked:    "已锁定",
+}
+
+// settlementStatusTransitions 允许的状态流转；退回草稿在已审批 / 已锁定时即为“重新打开”
+var settlementStatusTransitions = map[string][]string{
+    SettlementDraft:     {SettlementSubmitted},
+    SettlementSubmitted: {SettlementApproved, SettlementDraft},
+    SettlementApproved:  {SettlementLocked, SettlementDraft},
+    SettlementLocked:    {SettlementDraft},
+}
+
+// settlementFinalStatuses 已审批的状态，企微结算汇总只统计这些记录
+var settlementFinalStatuses = []string{SettlementApproved, SettlementLocked}
+
+// settlementStatus 兼容旧数据：状态为空视为已审批（启用审批前的记录都已推送过企微）
+func settlementStatus(s models.DailySettlement) string {
+    if s.Status == "" {
+        return SettlementApproved
+    }
+    return s.Status
+}
+
+// ensureSettlementEditable 已审批和已锁定的结算不能修改
+func ensureSettlementEditable(s models.DailySettlement) error {
+    if st := settlementStatus(s); st == SettlementApproved || st == SettlementLocked {
+        return fmt.Errorf("该结算%s，不能修改，如需更正请联系超级管理员重新打开", settlementStatusLabels[st])
+    }
+    return nil
+}
+
+// lockSettlement 在事务内锁定结算行并读取最新数据，保存、回滚和状态变更都按锁定后的状态校验，
+// 避免校验之后、写入之前被其他请求审批或修改
+func lockSettlement(tx *gorm.DB, id uint) (models.DailySettlement, error) {
+    var cur models.DailySettlement
+    err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&cur, id).Error
+    return cur, err
+}
+
+// setSettlementStatus 修改状态并写入变更记录，不做权限和流转校验
+func setSettlementStatus(tx *gorm.DB, s *models.DailySettlement, to, reason string, userID uint) error {
+    from := settlementStatus(*s)
+    now := time.Now()
+    updates := map[string]any{"status": to, "status_changed_at": now}
+    if to == SettlementApproved {
+        updates["approved_by"] = userID
+        updates["approved_at"] = now
+        s.ApprovedBy, s.ApprovedAt = userID, &now
+    }
+    if err := tx.Model(&models.DailySettlement{}).Where("id = ?", s.ID).UpdateColumns(updates).Error; err != nil {
+        return err
+    }
+    s.Status = to
+    s.StatusChangedAt = &now
+    return tx.Create(&models.SettlementStatusLog{
+        SettlementID: s.ID,
+        FromStatus:   from,
+        ToStatus:     to,
+        Reason:       reason,
+        UserID:       userID,
+        CreatedAt:    now,
+    }).Error
+}
+
+// checkSettlementStatusChange 按角色校验能否修改结算状态，只校验不写入：
+// - 提交：创建人或管理员；
+// - 审批、锁定：管理员 / 超级管理员；
+// - 已提交退回草稿：创建人撤回，或管理员驳回（需填写原因）；
+// - 已审批 / 已锁定重新打开：仅超级管理员，必须填写原因。
+func checkSettlementStatusChange(s models.DailySettlement, to, reason, role string, userID uint) error {
+    from := settlementStatus(s)
+    reason = strings.TrimSpace(reason)
+    if _, ok := settlementStatusLabels[to]; !ok {
+        return fmt.Errorf("状态只能是 draft / submitted / approved / locked")
+    }
+    if from == to {
+        return fmt.Errorf("结算已经是“%s”状态", settlementStatusLabels[to])
+    }
+    allowed := false
+    for _, st := range settlementStatusTransitions[from] {
+        if st == to {
+            allowed = true
+        }
+    }
+    if !allowed {
+        return fmt.Errorf("不能从“%s”变更为“%s”", settlementStatusLabels[from], settlementStatusLabels[to])
+    }
+
+    isAdmin := role == "admin" || role == "superadmin"
+    isOwner := s.UserID == userID
+    switch {
+    case to == SettlementSubmitted:
+        if !isOwner && !isAdmin {
+            return fmt.Errorf("只能提交自己的结算")
+        }
+    case to == SettlementApproved || to == SettlementLocked:
+        if !isAdmin {
+            return fmt.Errorf("仅管理员可以审批或锁定结算")
+        }
+        if to == SettlementApproved && isOwner {
+            return fmt.Errorf("不能审批自己创建的结算，请由其他管理员审批")
+        }
+    case from == SettlementSubmitted:
+        if !isOwner && !isAdmin {
+            return fmt.Errorf("只能撤回自己的结算")
+        }
+        if !isOwner && reason == "" {
+            return fmt.Errorf("驳回需要填写原因")
+        }
+    default:
+        if role != "superadmin" {
+            return fmt.Errorf("已审批或已锁定的结算仅超级管理员可以重新打开")
+        }
+        if reason == "" {
+            return fmt.Errorf("重新打开需要填写原因")
+        }
+    }
+    return nil
+}
+
+// parseSettlementStatusFilter 解析 ListSettlements 的 status 参数：逗号分隔的多个状态，为空表示全部
+func parseSettlementStatusFilter(v string) ([]string, error) {
+    var list []string
+    for _, st := range strings.Split(v, ",") {
+        st = strings.TrimSpace(st)
+        if st == "" {
+            continue
+        }
+        if _, ok := settlementStatusLabels[st]; !ok {
+            return nil, fmt.Errorf("invalid status: %s", st)
+        }
+        list = append(list, st)
+    }
+    return list, nil
+}
+
+// SetSettlementStatus 提交 / 审批 / 驳回 / 锁定 / 重新打开结算，审批通过后推送当日利润汇总到企微群
+// POST /api/settlements/:id/status  {status: "approved", reason: ""}
+func SetSettlementStatus(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        var body struct {
+            Status string `json:"status"`
+            Reason string `json:"reason"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        s, ok := loadVisibleSettlement(c, db)
+        if !ok {
+            return
+        }
+        roleVal, _ := c.Get("role")
+        role, _ := roleVal.(string)
+        userIDVal, _ := c.Get("userID")
+        uid, _ := userIDVal.(uint)
+
+        // 已关账月份的结算状态也随账期冻结
+        if err := ensurePeriodOpen(db, s.Date); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        to := strings.TrimSpace(body.Status)
+        reason := strings.TrimSpace(body.Reason)
+        var statusErr error
+        err := db.Transaction(func(tx *gorm.DB) error {
+            cur, err := lockSettlement(tx, s.ID)
+            if err != nil {
+                return err
+            }
+            *s = cur
+            if statusErr = checkSettlementStatusChange(*s, to, reason, role, uid); statusErr != nil {
+                return statusErr
+            }
+            return setSettlementStatus(tx, s, to, reason, uid)
+        })
+        if statusErr != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": statusErr.Error()})
+            return
+        }
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        if to == SettlementApproved {
+            if err := NotifyWecomSettlementForDate(db, s.Date); err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "审批成功，但推送企业微信失败: " + err.Error()})
+                return
+            }
+        }
+        c.JSON(http.StatusOK, s)
+    }
+}
+
+// ListSettlementStatusLogs 结算审批状态变更记录，按时间倒序
+// GET /api/settlements/:id/status_logs
+func ListSettlementStatusLogs(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        s, ok := loadVisibleSettlement(c, db)
+        if !ok {
+            return
+        }
+        var logs []models.SettlementStatusLog
+        if err := db.Where("settlement_id = ?", s.ID).Order("id desc").Find(&logs).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"items": logs})
+    }
+}
@@ -0,0 +1,514 @@
+package handlers
+
+import (
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+)
+
+// normalizeSKU SKU 比较时忽略首尾空白和大小写（与 MySQL 默认排序规则一致）
+func normalizeSKU(s string) string {
+    return strings.ToLower(strings.TrimSpace(s))
+}
+
+// skuResolver 把订单上的 SKU 解析成商品 ID：
+// 先按商品 SKU 精确匹配，匹配不到再查 SKU 别名（指定店铺 > 指定平台 > 通用）。
+type skuResolver struct {
+    products map[string]uint // 规范化 SKU -> 商品 ID（同一 SKU 有多个商品时取 ID 最小的）
+    aliases  map[string][]models.SKUAlias
+}
+
+// loadSKUResolver 预加载 skus 涉及的商品和别名，避免逐条订单查询
+func loadSKUResolver(db *gorm.DB, skus []string) (*skuResolver, error) {
+    r := &skuResolver{products: map[string]uint{}, aliases: map[string][]models.SKUAlias{}}
+    list := make([]string, 0, len(skus))
+    for _, s := range skus {
+        if s = strings.TrimSpace(s); s != "" {
+            list = append(list, s)
+        }
+    }
+    if len(list) == 0 {
+        return r, nil
+    }
+
+    var products []models.Product
+    if err := db.Select("id, sku").Where("sku IN ?", list).Order("id asc").Find(&products).Error; err != nil {
+        return nil, err
+    }
+    for _, p := range products {
+        key := normalizeSKU(p.SKU)
+        if _, ok := r.products[key]; !ok {
+            r.products[key] = p.ID
+        }
+    }
+
+    var aliases []models.SKUAlias
+    if err := db.Where("external_sku IN ?", list).Find(&aliases).Error; err != nil {
+        return nil, err
+    }
+    for _, a := range aliases {
+        key := normalizeSKU(a.ExternalSKU)
+        r.aliases[key] = append(r.aliases[key], a)
+    }
+    return r, nil
+}
+
+// resolve 返回订单对应的商品 ID，匹配不到时返回 0
+func (r *skuResolver) resolve(platform string, storeID uint, sku string) uint {
+    key := normalizeSKU(sku)
+    if key == "" {
+        return 0
+    }
+    if id, ok := r.products[key]; ok {
+        return id
+    }
+    var best uint
+    bestScore := -1
+    for _, a := range r.aliases[key] {
+        score := 0
+        if a.StoreID != 0 {
+            // 店铺专属别名只看店铺，店铺本身已经确定了平台
+            if a.StoreID != storeID {
+                continue
+            }
+            score = 2
+        } else if a.Platform != "" {
+            if !strings.EqualFold(a.Platform, strings.TrimSpace(platform)) {
+                continue
+            }
+            score = 1
+        }
+        if score > bestScore {
+            best, bestScore = a.ProductID, score
+        }
+    }
+    return best
+}
+
+// orderSKUGroup 按 SKU、平台、店铺和当前商品分组的订单，同一分组的重新匹配结果相同
+type orderSKUGroup struct {
+    SKU       string
+    Platform  string
+    StoreID   uint
+    ProductID uint
+}
+
+// rematchOrderGroups 按当前的商品和别名重新匹配 q 范围内的订单，返回匹配结果会变化的分组及其新商品 ID
+func rematchOrderGroups(db *gorm.DB, q *gorm.DB) ([]orderSKUGroup, []uint, error) {
+    var groups []orderSKUGroup
+    if err := q.Select("sku, platform, store_id, product_id").
+        Group("sku, platform, store_id, product_id").
+        Scan(&groups).Error; err != nil {
+        return nil, nil, err
+    }
+    if len(groups) == 0 {
+        return nil, nil, nil
+    }
+
+    skus := make([]string, 0, len(groups))
+    for _, g := range groups {
+        skus = append(skus, g.SKU)
+    }
+    r, err := loadSKUResolver(db, skus)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    var changed []orderSKUGroup
+    var ids []uint
+    for _, g := range groups {
+        if id := r.resolve(g.Platform, g.StoreID, g.SKU); id != g.ProductID {
+            changed = append(changed, g)
+            ids = append(ids, id)
+        }
+    }
+    return changed, ids, nil
+}
+
+// resolveOrderProducts 重新计算订单的 product_id，scope 为 nil 时处理全部订单。
+// 返回匹配结果发生变化的商品 ID（变化前和变化后），调用方需要重新核算这些商品。
+// 变为未匹配的订单会同时清空核算结果，统计时按 0 成本处理并出现在“未匹配 SKU”报表中。
+// 已关账月份的订单保持关账时的匹配结果，不参与重新匹配。
+func resolveOrderProducts(db *gorm.DB, scope func(*gorm.DB) *gorm.DB) ([]uint, error) {
+    closed, err := closedPeriods(db)
+    if err != nil {
+        return nil, err
+    }
+    q := excludeClosedOrders(db.Model(&models.Order{}).Where("product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇"), closed)
+    if scope != nil {
+        q = scope(q)
+    }
+    groups, ids, err := rematchOrderGroups(db, q)
+    if err != nil {
+        return nil, err
+    }
+
+    affected := make([]uint, 0)
+    for i, g := range groups {
+        id := ids[i]
+        updates := map[string]any{"product_id": id}
+        if id == 0 {
+            updates["unit_cost"] = 0
+            updates["goods_cost"] = 0
+            updates["cost_method"] = ""
+        }
+        uq := db.Model(&models.Order{}).
+            Where("sku = ? AND platform = ? AND store_id = ? AND product_id = ?", g.SKU, g.Platform, g.StoreID, g.ProductID).
+            Where("product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇")
+        if err := excludeClosedOrders(uq, closed).UpdateColumns(updates).Error; err != nil {
+            return affected, err
+        }
+        affected = append(affected, g.ProductID, id)
+    }
+    return affected, nil
+}
+
+// ensureAliasPeriodsOpen 别名保存或删除后（在同一事务内调用），检查这些 SKU 在已关账月份的订单匹配是否会变化，
+// 会变化时返回错误，由调用方回滚：已关账月份的订单商品和成本不允许再被别名调整改动
+func ensureAliasPeriodsOpen(tx *gorm.DB, skus ...string) error {
+    closed, err := closedPeriods(tx)
+    if err != nil || len(closed) == 0 {
+        return err
+    }
+    list := make([]string, 0, len(skus))
+    for _, s := range skus {
+        if s = strings.TrimSpace(s); s != "" {
+            list = append(list, s)
+        }
+    }
+    if len(list) == 0 {
+        return nil
+    }
+    q := tx.Model(&models.Order{}).
+        Where("sku IN ? AND product_name NOT IN (?, ?)", list, "今日总额汇总", "今日总汇").
+        Where("DATE_FORMAT(created_at, '%Y-%m') IN ?", closed)
+    groups, _, err := rematchOrderGroups(tx, q)
+    if err != nil {
+        return err
+    }
+    if len(groups) > 0 {
+        return fmt.Errorf("已月结关账月份中有 SKU %s 的订单，修改该别名会改变这些订单的商品匹配和货款成本，如需调整请超级管理员重新打开账期", groups[0].SKU)
+    }
+    return nil
+}
+
+// resolveOrderProductsBySKU 只重新匹配指定 SKU 的订单
+func resolveOrderProductsBySKU(db *gorm.DB, skus ...string) ([]uint, error) {
+    list := make([]string, 0, len(skus))
+    seen := make(map[string]bool, len(skus))
+    for _, s := range skus {
+        s = strings.TrimSpace(s)
+        if seen[s] {
+            continue
+        }
+        seen[s] = true
+        list = append(list, s)
+    }
+    if len(list) == 0 {
+        return nil, nil
+    }
+    return resolveOrderProducts(db, func(q *gorm.DB) *gorm.DB {
+        return q.Where("sku IN ?", list)
+    })
+}
+
+// ResolveUnmatchedOrderProducts 给尚未匹配商品（product_id = 0）的订单补上匹配并核算受影响的商品。
+// 启动时在开始接收请求之前同步调用一次：已匹配的订单在订单、商品 SKU、别名变化时已经由各接口重新匹配，
+// 只补历史订单，也避免与接口中的订单写入和成本核算并发。
+func ResolveUnmatchedOrderProducts(db *gorm.DB) error {
+    affected, err := resolveOrderProducts(db, func(q *gorm.DB) *gorm.DB {
+        return q.Where("product_id = ?", 0)
+    })
+    if err != nil {
+        return err
+    }
+    if err := recostProducts(db, affected...); err != nil {
+        return err
+    }
+    log.Printf("[sku-alias] 订单商品匹配完成，涉及商品 %d 个", len(affected)/2)
+    return nil
+}
+
+// ListSKUAliases SKU 别名列表，可按 product_id / platform / store_id / keyword 过滤
+func ListSKUAliases(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        q := db.Model(&models.SKUAlias{})
+        if v := c.Query("product_id"); v != "" {
+            if id, err := strconv.Atoi(v); err == nil {
+                q = q.Where("product_id = ?", id)
+            }
+        }
+        if v := c.Query("store_id"); v != "" {
+            if id, err := strconv.Atoi(v); err == nil {
+                q = q.Where("store_id = ?", id)
+            }
+        }
+        if v := strings.TrimSpace(c.Query("platform")); v != "" {
+            q = q.Where("platform = ?", v)
+        }
+        if kw := strings.TrimSpace(c.Query("keyword")); kw != "" {
+            q = q.Where("external_sku LIKE ?", "%"+kw+"%")
+        }
+
+        var aliases []models.SKUAlias
+        if err := q.Order("external_sku asc, id asc").Find(&aliases).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        // 补充商品和店铺名称，方便前端展示
+        productIDs := make([]uint, 0, len(aliases))
+        storeIDs := make([]uint, 0)
+        for _, a := range aliases {
+            productIDs = append(productIDs, a.ProductID)
+            if a.StoreID != 0 {
+                storeIDs = append(storeIDs, a.StoreID)
+            }
+        }
+        products := map[uint]models.Product{}
+        if len(productIDs) > 0 {
+            var list []models.Product
+            db.Select("id, sku, name").Where("id IN ?", productIDs).Find(&list)
+            for _, p := range list {
+                products[p.ID] = p
+            }
+        }
+        stores := map[uint]string{}
+        if len(storeIDs) > 0 {
+            var list []models.Store
+            db.Select("id, name").Where("id IN ?", storeIDs).Find(&list)
+            for _, s := range list {
+                stores[s.ID] = s.Name
+            }
+        }
+
+        type item struct {
+            models.SKUAlias
+            ProductSKU  string `json:"product_sku"`
+            ProductName string `json:"product_name"`
+            StoreName   string `json:"store_name"`
+        }
+        res := make([]item, 0, len(aliases))
+        for _, a := range aliases {
+            p := products[a.ProductID]
+            res = append(res, item{SKUAlias: a, ProductSKU: p.SKU, ProductName: p.Name, StoreName: stores[a.StoreID]})
+        }
+        c.JSON(http.StatusOK, gin.H{"items": res})
+    }
+}
+
+// SaveSKUAlias 新增或修改 SKU 别名，保存后重新匹配相关订单并核算成本（管理员 / 超级管理员）
+func SaveSKUAlias(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以维护 SKU 别名"})
+            return
+        }
+
+        var body struct {
+            ID          uint   `json:"id"`
+            Platform    string `json:"platform"`
+            StoreID     uint   `json:"store_id"`
+            ExternalSKU string `json:"external_sku"`
+            // 内部商品可以用 product_id 或 product_sku 指定
+            ProductID  uint   `json:"product_id"`
+            ProductSKU string `json:"product_sku"`
+            Remark     string `json:"remark"`
+        }
+        if err := c.ShouldBindJSON(&body); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        body.ExternalSKU = strings.TrimSpace(body.ExternalSKU)
+        body.Platform = strings.TrimSpace(body.Platform)
+        if body.ExternalSKU == "" {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "平台 SKU 不能为空"})
+            return
+        }
+
+        var p models.Product
+        if body.ProductID != 0 {
+            if err := db.First(&p, body.ProductID).Error; err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "商品不存在"})
+                return
+            }
+        } else {
+            sku := strings.TrimSpace(body.ProductSKU)
+            if sku == "" || db.Where("sku = ?", sku).Order("id asc").First(&p).Error != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "商品不存在"})
+                return
+            }
+        }
+
+        // 订单 SKU 与商品 SKU 相同时会直接匹配商品，别名永远不会生效
+        var same models.Product
+        if err := db.Where("sku = ?", body.ExternalSKU).First(&same).Error; err == nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "该 SKU 与商品「" + same.Name + "」的 SKU 相同，订单会直接匹配该商品，无需配置别名"})
+            return
+        }
+
+        if body.StoreID != 0 {
+            var st models.Store
+            if err := db.First(&st, body.StoreID).Error; err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "店铺不存在"})
+                return
+            }
+            // 店铺专属别名的平台以店铺为准
+            body.Platform = st.Platform
+        }
+
+        var dup int64
+        db.Model(&models.SKUAlias{}).
+            Where("platform = ? AND store_id = ? AND external_sku = ? AND id <> ?", body.Platform, body.StoreID, body.ExternalSKU, body.ID).
+            Count(&dup)
+        if dup > 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "相同平台/店铺下该 SKU 已配置别名"})
+            return
+        }
+
+        var a models.SKUAlias
+        oldSKU := ""
+        if body.ID != 0 {
+            if err := db.First(&a, body.ID).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "别名不存在"})
+                return
+            }
+            oldSKU = a.ExternalSKU
+        }
+        a.Platform = body.Platform
+        a.StoreID = body.StoreID
+        a.ExternalSKU = body.ExternalSKU
+        a.ProductID = p.ID
+        a.Remark = body.Remark
+        var periodErr error
+        err := db.Transaction(func(tx *gorm.DB) error {
+            if err := tx.Save(&a).Error; err != nil {
+                return err
+            }
+            periodErr = ensureAliasPeriodsOpen(tx, oldSKU, a.ExternalSKU)
+            return periodErr
+        })
+        if periodErr != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": periodErr.Error()})
+            return
+        }
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        costErr := recostSKUs(db, oldSKU, a.ExternalSKU)
+        var matched int64
+        db.Model(&models.Order{}).Where("sku = ? AND product_id = ?", a.ExternalSKU, a.ProductID).Count(&matched)
+        c.JSON(http.StatusOK, gin.H{"alias": a, "matched_orders": matched, "warnings": costWarnings(costErr)})
+    }
+}
+
+// DeleteSKUAlias 删除 SKU 别名，相关订单重新匹配（管理员 / 超级管理员）
+func DeleteSKUAlias(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以维护 SKU 别名"})
+            return
+        }
+        var a models.SKUAlias
+        if err := db.First(&a, c.Param("id")).Error; err != nil {
+            if err == gorm.ErrRecordNotFound {
+                c.JSON(http.StatusNotFound, gin.H{"error": "别名不存在"})
+            } else {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            }
+            return
+        }
+        var periodErr error
+        err := db.Transaction(func(tx *gorm.DB) error {
+            if err := tx.Delete(&a).Error; err != nil {
+                return err
+            }
+            periodErr = ensureAliasPeriodsOpen(tx, a.ExternalSKU)
+            return periodErr
+        })
+        if periodErr != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": periodErr.Error()})
+            return
+        }
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        costErr := recostSKUs(db, a.ExternalSKU)
+        c.JSON(http.StatusOK, gin.H{"ok": true, "warnings": costWarnings(costErr)})
+    }
+}
+
+// UnmatchedSKUs 未匹配 SKU 报表：列出匹配不到任何商品的订单 SKU，便于补充商品或别名。
+// GET /api/sku_aliases/unmatched?start=YYYY-MM-DD&end=YYYY-MM-DD（不传日期时统计全部订单）
+func UnmatchedSKUs(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看未匹配 SKU"})
+            return
+        }
+
+        q := db.Table("orders AS o").
+            Where("o.product_id = 0 AND o.product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇")
+        var start, end string
+        if c.Query("start") != "" || c.Query("end") != "" {
+            var err error
+            start, end, err = parseStatsRange(c)
+            if err != nil {
+                c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+                return
+            }
+            q = q.Where("o.created_at >= ? AND o.created_at < DATE_ADD(?, INTERVAL 1 DAY)", start, end)
+        }
+
+        // 没填 SKU 的订单无法通过别名修复，单独计数
+        var missing int64
+        if err := q.Session(&gorm.Session{}).Where("o.sku = ''").Count(&missing).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        type row struct {
+            SKU         string    `json:"sku"`
+            Platform    string    `json:"platform"`
+            StoreID     uint      `json:"store_id"`
+            ProductName string    `json:"product_name"`
+            Orders      int64     `json:"orders"`
+            Quantity    int64     `json:"quantity"`
+            FirstAt     time.Time `json:"first_at"`
+            LastAt      time.Time `json:"last_at"`
+        }
+        var rows []row
+        if err := q.Session(&gorm.Session{}).Where("o.sku <> ''").
+            Select("o.sku AS sku, o.platform AS platform, o.store_id AS store_id, MAX(o.product_name) AS product_name, " +
+                "COUNT(*) AS orders, IFNULL(SUM(o.quantity), 0) AS quantity, MIN(o.created_at) AS first_at, MAX(o.created_at) AS last_at").
+            Group("o.sku, o.platform, o.store_id").
+            Order("orders DESC, last_at DESC").
+            Scan(&rows).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if rows == nil {
+            rows = []row{}
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "start":       start,
+            "end":         end,
+            "items":       rows,
+            "missing_sku": missing,
+        })
+    }
+}
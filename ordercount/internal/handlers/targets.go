@@ -0,0 +1,427 @@
+package handlers
+
+import (
+    "fmt"
+    "math"
+    "net/http"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+)
+
+// 目标范围
+const (
+    TargetScopeAll     = "all"
+    TargetScopeCountry = "country"
+    TargetScopeStore   = "store"
+)
+
+// 目标指标
+const (
+    TargetMetricSales  = "sales"  // 销售额（人民币），按订单总额统计
+    TargetMetricProfit = "profit" // 利润（人民币），按结算利润统计
+    TargetMetricOrders = "orders" // 订单数，按订单号去重
+)
+
+var targetScopeLabels = map[string]string{
+    TargetScopeAll:     "全部",
+    TargetScopeCountry: "国家",
+    TargetScopeStore:   "店铺",
+}
+
+var targetMetricLabels = map[string]string{
+    TargetMetricSales:  "销售额",
+    TargetMetricProfit: "利润",
+    TargetMetricOrders: "订单数",
+}
+
+// targetPeriodRange 目标月份 YYYY-MM 的首尾日期
+func targetPeriodRange(period string) (time.Time, time.Time, error) {
+    start, err := time.Parse("2006-01", period)
+    if err != nil {
+        return time.Time{}, time.Time{}, fmt.Errorf("period 格式应为 YYYY-MM")
+    }
+    return start, start.AddDate(0, 1, -1), nil
+}
+
+// validateTarget 校验并规范化目标：店铺范围自动带上店铺所属国家，全部范围清空国家和店铺。
+// 出错时同时返回对应的 HTTP 状态：参数不合法为 400，查询店铺失败为 500
+func validateTarget(db *gorm.DB, t *models.Target) (int, error) {
+    t.Period = strings.TrimSpace(t.Period)
+    if _, _, err := targetPeriodRange(t.Period); err != nil {
+        return http.StatusBadRequest, err
+    }
+    if _, ok := targetMetricLabels[t.Metric]; !ok {
+        return http.StatusBadRequest, fmt.Errorf("metric 只能是 sales / profit / orders")
+    }
+    if t.Value <= 0 {
+        return http.StatusBadRequest, fmt.Errorf("目标值必须大于 0")
+    }
+    switch t.Scope {
+    case TargetScopeAll:
+        t.Country, t.StoreID = "", 0
+    case TargetScopeCountry:
+        if _, ok := countryCurrency[t.Country]; !ok {
+            return http.StatusBadRequest, fmt.Errorf("未知国家 %s", t.Country)
+        }
+        t.StoreID = 0
+    case TargetScopeStore:
+        if t.StoreID == 0 {
+            return http.StatusBadRequest, fmt.Errorf("店铺不存在")
+        }
+        var st models.Store
+        if err := db.First(&st, t.StoreID).Error; err == gorm.ErrRecordNotFound {
+            return http.StatusBadRequest, fmt.Errorf("店铺不存在")
+        } else if err != nil {
+            return http.StatusInternalServerError, err
+        }
+        t.Country = st.Country
+    default:
+        return http.StatusBadRequest, fmt.Errorf("scope 只能是 all / country / store")
+    }
+    if t.Metric == TargetMetricOrders {
+        t.Value = math.Round(t.Value)
+    } else {
+        t.Value = roundMoney(t.Value)
+    }
+    return http.StatusOK, nil
+}
+
+// targetOrderScope 目标范围内 start ~ end 的订单（排除每日总额汇总行）
+func targetOrderScope(db *gorm.DB, t models.Target, start, end string) *gorm.DB {
+    q := db.Model(&models.Order{}).
+        Where("DATE(created_at) >= ? AND DATE(created_at) <= ?", start, end).
+        Where("product_name NOT IN (?, ?)", "今日总额汇总", "今日总汇")
+    switch t.Scope {
+    case TargetScopeCountry:
+        q = q.Where("country = ?", t.Country)
+    case TargetScopeStore:
+        q = q.Where("store_id = ?", t.StoreID)
+    }
+    return q
+}
+
+// targetActual 目标范围内 start ~ end 的实际完成值；
+// 销售额为订单总额（非人民币订单通过 cv 按实时汇率折算），利润为 statuses 状态结算的利润合计（statuses 为空时包含全部状态）
+func targetActual(db *gorm.DB, t models.Target, start, end string, statuses []string, cv *cnyConverter) (float64, error) {
+    switch t.Metric {
+    case TargetMetricSales:
+        var aggs []struct {
+            Currency string
+            Amount   float64
+        }
+        if err := targetOrderScope(db, t, start, end).
+            Select("currency, IFNULL(SUM(total_amount), 0) AS amount").
+            Group("currency").Scan(&aggs).Error; err != nil {
+            return 0, err
+        }
+        total := 0.0
+        for _, a := range aggs {
+            total += cv.convert(a.Amount, a.Currency)
+        }
+        return roundMoney(total), nil
+    case TargetMetricOrders:
+        var cnt int64
+        if err := targetOrderScope(db, t, start, end).
+            Select("COUNT(DISTINCT CASE WHEN order_no <> '' THEN order_no ELSE CONCAT('#', id) END)").
+            Scan(&cnt).Error; err != nil {
+            return 0, err
+        }
+        return float64(cnt), nil
+    case TargetMetricProfit:
+        q := db.Model(&models.DailySettlement{}).Where("date >= ? AND date <= ?", start, end)
+        switch t.Scope {
+        case TargetScopeCountry:
+            q = q.Where("country = ?", t.Country)
+        case TargetScopeStore:
+            q = q.Where("store_id = ?", t.StoreID)
+        }
+        if len(statuses) > 0 {
+            q = q.Where("status IN ?", statuses)
+        }
+        var profit float64
+        if err := q.Select("IFNULL(SUM(profit), 0)").Scan(&profit).Error; err != nil {
+            return 0, err
+        }
+        return roundMoney(profit), nil
+    }
+    return 0, fmt.Errorf("未知指标 %s", t.Metric)
+}
+
+// targetProgress 一个目标截至某天的完成进度
+type targetProgress struct {
+    models.Target
+    ScopeLabel  string `json:"scope_label"`
+    MetricLabel string `json:"metric_label"`
+    StoreName   string `json:"store_name,omitempty"`
+
+    Start         string `json:"start"`
+    End           string `json:"end"`
+    AsOf          string `json:"as_of"` // 统计截止日期（含），当月之前为空
+    DaysTotal     int    `json:"days_total"`
+    DaysElapsed   int    `json:"days_elapsed"`
+    DaysRemaining int    `json:"days_remaining"`
+
+    Actual           float64 `json:"actual"`
+    Progress         float64 `json:"progress"`          // 完成率 %
+    ExpectedProgress float64 `json:"expected_progress"` // 按时间进度应完成 %
+    Projection       float64 `json:"projection"`        // 按当前日均推算到月底的完成值
+    ProjectionPct    float64 `json:"projection_pct"`    // 推算完成率 %
+    Gap              float64 `json:"gap"`               // 距目标还差（已完成时为 0）
+    DailyNeeded      float64 `json:"daily_needed"`      // 剩余天数每天需要完成的值
+    OnTrack          bool    `json:"on_track"`          // 推算值能否达到目标
+}
+
+// computeTargetProgress 计算某月全部目标截至 asOf（含）的进度：
+// 已过天数按 asOf 截断到当月范围内，推算值 = 实际 / 已过天数 × 当月天数，每日所需 = 差额 / 剩余天数。
+func computeTargetProgress(db *gorm.DB, period string, asOf time.Time, statuses []string) ([]targetProgress, []string, error) {
+    periodStart, periodEnd, err := targetPeriodRange(period)
+    if err != nil {
+        return nil, nil, err
+    }
+    var targets []models.Target
+    if err := db.Where("period = ?", period).Order("scope asc, country asc, store_id asc, metric asc").Find(&targets).Error; err != nil {
+        return nil, nil, err
+    }
+    warnings := []string{}
+    result := make([]targetProgress, 0, len(targets))
+    if len(targets) == 0 {
+        return result, warnings, nil
+    }
+
+    daysTotal := periodEnd.Day()
+    cutoff := time.Date(asOf.Year(), asOf.Month(), asOf.Day(), 0, 0, 0, 0, time.UTC)
+    if cutoff.After(periodEnd) {
+        cutoff = periodEnd
+    }
+    daysElapsed := 0
+    if !cutoff.Before(periodStart) {
+        daysElapsed = int(cutoff.Sub(periodStart).Hours()/24) + 1
+    }
+
+    storeIDs := []uint{}
+    for _, t := range targets {
+        if t.StoreID != 0 {
+            storeIDs = append(storeIDs, t.StoreID)
+        }
+    }
+    storeNames := map[uint]string{}
+    if len(storeIDs) > 0 {
+        var stores []models.Store
+        if err := db.Where("id IN ?", storeIDs).Find(&stores).Error; err != nil {
+            return nil, nil, err
+        }
+        for _, s := range stores {
+            storeNames[s.ID] = s.Name
+        }
+    }
+
+    // 实时汇率在第一次遇到非人民币订单时才获取
+    cv := newCNYConverter(nil)
+    for _, t := range targets {
+        p := targetProgress{
+            Target:      t,
+            ScopeLabel:  targetScopeLabels[t.Scope],
+            MetricLabel: targetMetricLabels[t.Metric],
+            StoreName:   storeNames[t.StoreID],
+            Start:       periodStart.Format("2006-01-02"),
+            End:         periodEnd.Format("2006-01-02"),
+            DaysTotal:   daysTotal,
+            DaysElapsed: daysElapsed,
+        }
+        p.DaysRemaining = daysTotal - daysElapsed
+        if daysElapsed > 0 {
+            p.AsOf = cutoff.Format("2006-01-02")
+            if p.Actual, err = targetActual(db, t, p.Start, p.AsOf, statuses, cv); err != nil {
+                return nil, nil, err
+            }
+            p.Projection = roundMoney(p.Actual / float64(daysElapsed) * float64(daysTotal))
+        }
+        p.Progress = roundMoney(p.Actual / t.Value * 100)
+        p.ExpectedProgress = roundMoney(float64(daysElapsed) / float64(daysTotal) * 100)
+        p.ProjectionPct = roundMoney(p.Projection / t.Value * 100)
+        p.Gap = roundMoney(math.Max(t.Value-p.Actual, 0))
+        if p.DaysRemaining > 0 {
+            p.DailyNeeded = roundMoney(p.Gap / float64(p.DaysRemaining))
+        }
+        p.OnTrack = p.Projection >= t.Value || p.Actual >= t.Value
+        result = append(result, p)
+    }
+    warnings = append(warnings, cv.warnings()...)
+    return result, warnings, nil
+}
+
+// targetScopeName 目标范围的展示名称：全部 / 国家名 / 店铺名
+func targetScopeName(p targetProgress) string {
+    switch p.Scope {
+    case TargetScopeCountry:
+        return p.Country
+    case TargetScopeStore:
+        if p.StoreName != "" {
+            return p.StoreName
+        }
+        return fmt.Sprintf("店铺 %d", p.StoreID)
+    }
+    return "全部"
+}
+
+// targetWecomLines 订单日报中的目标进度：date 所在月份的目标截至 date 的完成情况，没有目标时返回空。
+// 利润只统计已审批 / 已锁定的结算，行内注明“已审批”，避免与包含草稿的利润混淆
+func targetWecomLines(db *gorm.DB, date string) (string, error) {
+    d, err := time.Parse("2006-01-02", date)
+    if err != nil {
+        return "", err
+    }
+    list, _, err := computeTargetProgress(db, d.Format("2006-01"), d, settlementFinalStatuses)
+    if err != nil || len(list) == 0 {
+        return "", err
+    }
+    var b strings.Builder
+    fmt.Fprintf(&b, "\n本月目标进度（时间进度 %.0f%%）：\n", list[0].ExpectedProgress)
+    for _, p := range list {
+        format := "> %s%s：￥%.2f / ￥%.2f（%.1f%%，预计 %.1f%%）\n"
+        if p.Metric == TargetMetricOrders {
+            format = "> %s%s：%.0f / %.0f（%.1f%%，预计 %.1f%%）\n"
+        }
+        label := p.MetricLabel
+        if p.Metric == TargetMetricProfit {
+            label += "（已审批）"
+        }
+        fmt.Fprintf(&b, format, targetScopeName(p), label, p.Actual, p.Value, p.Progress, p.ProjectionPct)
+    }
+    return b.String(), nil
+}
+
+// ListTargets 目标列表，可按月份筛选（管理员及以上）
+// GET /api/targets?period=YYYY-MM
+func ListTargets(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看目标"})
+            return
+        }
+        q := db.Model(&models.Target{})
+        if period := c.Query("period"); period != "" {
+            q = q.Where("period = ?", period)
+        }
+        var list []models.Target
+        if err := q.Order("period desc, scope asc, country asc, store_id asc, metric asc").Find(&list).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"items": list, "scopes": targetScopeLabels, "metrics": targetMetricLabels})
+    }
+}
+
+// SaveTarget 新增或修改目标（仅超级管理员），body 带 id 时为修改；同一月份、范围、指标已有目标时覆盖其目标值
+// POST /api/targets
+func SaveTarget(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, _ := c.Get("role"); roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以维护目标"})
+            return
+        }
+        var t models.Target
+        if err := c.ShouldBindJSON(&t); err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if status, err := validateTarget(db, &t); err != nil {
+            c.JSON(status, gin.H{"error": err.Error()})
+            return
+        }
+        var existing models.Target
+        err := db.Where("period = ? AND scope = ? AND country = ? AND store_id = ? AND metric = ?",
+            t.Period, t.Scope, t.Country, t.StoreID, t.Metric).First(&existing).Error
+        if err != nil && err != gorm.ErrRecordNotFound {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        if t.ID != 0 {
+            if err == nil && existing.ID != t.ID {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "该月份、范围和指标已有目标"})
+                return
+            }
+            var old models.Target
+            if err := db.First(&old, t.ID).Error; err != nil {
+                c.JSON(http.StatusNotFound, gin.H{"error": "目标不存在"})
+                return
+            }
+            t.CreatedAt = old.CreatedAt
+        } else if err == nil {
+            t.ID, t.CreatedAt = existing.ID, existing.CreatedAt
+        }
+        uidVal, _ := c.Get("userID")
+        t.UserID, _ = uidVal.(uint)
+        if err := db.Save(&t).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, t)
+    }
+}
+
+// DeleteTarget 删除目标（仅超级管理员）
+// DELETE /api/targets/:id
+func DeleteTarget(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, _ := c.Get("role"); roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以维护目标"})
+            return
+        }
+        var t models.Target
+        if err := db.First(&t, c.Param("id")).Error; err != nil {
+            c.JSON(http.StatusNotFound, gin.H{"error": "目标不存在"})
+            return
+        }
+        if err := db.Delete(&t).Error; err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"ok": true})
+    }
+}
+
+// TargetProgress 目标完成进度：实际完成值、按当前日均推算到月底的完成值，以及剩余每天需要完成的值（管理员及以上）
+// GET /api/targets/progress?period=YYYY-MM&date=YYYY-MM-DD&include_drafts=1
+// period 默认为 date 所在月份；date 为统计截止日期（含），默认昨天。
+// 利润默认只统计已审批 / 已锁定的结算，include_drafts=1 时包含草稿和已提交的结算。
+func TargetProgress(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        roleVal, _ := c.Get("role")
+        if roleVal != "superadmin" && roleVal != "admin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅管理员或超级管理员可以查看目标"})
+            return
+        }
+        asOf, err := time.Parse("2006-01-02", c.DefaultQuery("date", time.Now().AddDate(0, 0, -1).Format("2006-01-02")))
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "date 格式应为 YYYY-MM-DD"})
+            return
+        }
+        period := c.DefaultQuery("period", asOf.Format("2006-01"))
+        statuses := settlementFinalStatuses
+        if v := c.Query("include_drafts"); v == "1" || v == "true" {
+            statuses = nil
+        }
+        list, warnings, err := computeTargetProgress(db, period, asOf, statuses)
+        if err != nil {
+            status := http.StatusInternalServerError
+            if _, _, perr := targetPeriodRange(period); perr != nil {
+                status = http.StatusBadRequest
+            }
+            c.JSON(status, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{
+            "period":   period,
+            "as_of":    asOf.Format("2006-01-02"),
+            "items":    list,
+            "warnings": warnings,
+        })
+    }
+}
@@ -0,0 +1,251 @@
+package handlers
+
+import (
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "path"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "gorm.io/gorm"
+
+    "ordercount/internal/models"
+    "ordercount/internal/storage"
+    "ordercount/internal/utils"
+)
+
+// 商品图片在存储中的 key 前缀
+const productUploadPrefix = "products/"
+
+// 清理孤儿文件时，最近上传的文件保留一段时间（上传后商品可能还没保存）
+const orphanUploadGrace = 24 * time.Hour
+
+// 上传商品图片：按内容嗅探校验类型，限制大小和尺寸，按 SHA-256 去重，
+// 同时生成缩略图和 WebP 版本，返回可访问的 URL
+func UploadProductImage() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        // 限制整个请求体大小，避免超大文件直接写满内存或磁盘
+        c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, utils.MaxImageBytes+(1<<20))
+
+        file, err := c.FormFile("file")
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "file required"})
+            return
+        }
+        if file.Size > utils.MaxImageBytes {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "图片不能超过 5MB"})
+            return
+        }
+
+        f, err := file.Open()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        data, err := io.ReadAll(io.LimitReader(f, utils.MaxImageBytes+1))
+        f.Close()
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        img, err := utils.ProcessImage(data)
+        if err != nil {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+
+        originalPath, err := saveProductImage(img)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+
+        // url 用于前端直接预览（对象存储下可能是预签名地址），path 为保存到商品上的统一地址
+        thumbURL, webpURL := productImageVariants(originalPath)
+        c.JSON(http.StatusOK, gin.H{
+            "url":       resolveUploadURL(originalPath),
+            "path":      originalPath,
+            "thumb_url": thumbURL,
+            "webp_url":  webpURL,
+            "sha256":    img.SHA256,
+            "width":     img.Width,
+            "height":    img.Height,
+        })
+    }
+}
+
+// saveProductImage 把处理好的图片（原图、缩略图、WebP）写入存储，返回原图的统一地址。
+// 文件名即内容哈希，相同图片只保存一份。
+func saveProductImage(img *utils.ProcessedImage) (string, error) {
+    files := []struct {
+        name        string
+        content     []byte
+        contentType string
+    }{
+        {img.OriginalName(), img.Original, img.ContentType},
+        {img.ThumbName(), img.Thumb, "image/jpeg"},
+        {img.WebPName(), img.WebP, "image/webp"},
+    }
+    for _, f := range files {
+        key := productUploadPrefix + f.name
+        if _, err := UploadStorage.Stat(key); err == nil {
+            continue
+        } else if !errors.Is(err, storage.ErrNotExist) {
+            return "", err
+        }
+        if err := UploadStorage.Put(key, f.content, f.contentType); err != nil {
+            return "", err
+        }
+    }
+    return storage.CanonicalURL(productUploadPrefix + img.OriginalName()), nil
+}
+
+// ServeUpload 提供 /uploads/*filepath 访问：本地存储直接输出文件，
+// 对象存储重定向到公开地址或预签名地址
+func ServeUpload() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        key, err := storage.CleanKey(c.Param("filepath"))
+        if err != nil {
+            c.Status(http.StatusNotFound)
+            return
+        }
+
+        target, err := UploadStorage.URL(key)
+        if err != nil {
+            c.Status(http.StatusNotFound)
+            return
+        }
+        if target != storage.CanonicalURL(key) {
+            c.Redirect(http.StatusFound, target)
+            return
+        }
+
+        rc, obj, err := UploadStorage.Get(key)
+        if err != nil {
+            if errors.Is(err, storage.ErrNotExist) {
+                c.Status(http.StatusNotFound)
+            } else {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            }
+            return
+        }
+        defer rc.Close()
+
+        c.Header("Content-Type", storage.ContentTypeByKey(key))
+        // 文件名为内容哈希的图片内容不会变化，可以长期缓存
+        if utils.ImageHashOf(key) != "" {
+            c.Header("Cache-Control", "public, max-age=31536000, immutable")
+        }
+        if rs, ok := rc.(io.ReadSeeker); ok {
+            http.ServeContent(c.Writer, c.Request, path.Base(key), obj.ModTime, rs)
+            return
+        }
+        c.Status(http.StatusOK)
+        io.Copy(c.Writer, rc)
+    }
+}
+
+// resolveUploadURL 把保存在库里的统一地址转换成浏览器可访问的地址，非上传文件原样返回
+func resolveUploadURL(u string) string {
+    key, ok := storage.KeyFromCanonical(u)
+    if !ok {
+        return u
+    }
+    resolved, err := UploadStorage.URL(key)
+    if err != nil {
+        return u
+    }
+    return resolved
+}
+
+// normalizeUploadURL 把前端回传的地址（可能是预签名地址）还原为统一地址再入库
+func normalizeUploadURL(u string) string {
+    u = strings.TrimSpace(u)
+    if u == "" {
+        return u
+    }
+    if key, ok := UploadStorage.KeyFromURL(u); ok {
+        return storage.CanonicalURL(key)
+    }
+    return u
+}
+
+// productImageVariants 根据商品图片地址推导缩略图和 WebP 的访问地址，旧图片没有派生文件时返回空
+func productImageVariants(imageURL string) (thumbURL, webpURL string) {
+    key, ok := storage.KeyFromCanonical(imageURL)
+    if !ok || !strings.HasPrefix(key, productUploadPrefix) {
+        return "", ""
+    }
+    thumb, webp, ok := utils.ImageVariantNames(key)
+    if !ok {
+        return "", ""
+    }
+    return resolveUploadURL(storage.CanonicalURL(productUploadPrefix + thumb)),
+        resolveUploadURL(storage.CanonicalURL(productUploadPrefix + webp))
+}
+
+// CleanupOrphanUploads 删除商品图片目录下不再被任何 Product.ImageURL 引用的文件。
+// 原图被引用时，同哈希的缩略图和 WebP 也会保留；dryRun 为 true 时只返回待删除列表。
+func CleanupOrphanUploads(db *gorm.DB, dryRun bool) ([]string, error) {
+    var urls []string
+    if err := db.Model(&models.Product{}).Where("image_url <> ''").Pluck("image_url", &urls).Error; err != nil {
+        return nil, err
+    }
+    keepNames := make(map[string]bool, len(urls))
+    keepHashes := make(map[string]bool, len(urls))
+    for _, u := range urls {
+        name := path.Base(u)
+        keepNames[name] = true
+        if h := utils.ImageHashOf(name); h != "" {
+            keepHashes[h] = true
+        }
+    }
+
+    objs, err := UploadStorage.List(productUploadPrefix)
+    if err != nil {
+        return nil, err
+    }
+
+    removed := make([]string, 0)
+    for _, o := range objs {
+        name := path.Base(o.Key)
+        if !utils.IsImageFileName(name) || keepNames[name] {
+            continue
+        }
+        if h := utils.ImageHashOf(name); h != "" && keepHashes[h] {
+            continue
+        }
+        if time.Since(o.ModTime) < orphanUploadGrace {
+            continue
+        }
+        if !dryRun {
+            if err := UploadStorage.Delete(o.Key); err != nil {
+                log.Printf("[upload-cleanup] 删除 %s 失败：%v", o.Key, err)
+                continue
+            }
+        }
+        removed = append(removed, storage.CanonicalURL(o.Key))
+    }
+    return removed, nil
+}
+
+// CleanupUploads HTTP 接口：手动清理孤儿图片，?dry_run=1 时只预览不删除（仅超级管理员）
+func CleanupUploads(db *gorm.DB) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if roleVal, ok := c.Get("role"); !ok || roleVal != "superadmin" {
+            c.JSON(http.StatusForbidden, gin.H{"error": "仅超级管理员可以清理图片"})
+            return
+        }
+        dryRun := c.Query("dry_run") == "1" || c.Query("dry_run") == "true"
+        removed, err := CleanupOrphanUploads(db, dryRun)
+        if err != nil {
+            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"dry_run": dryRun, "count": len(removed), "files": removed})
+    }
+}
@@ -0,0 +1,147 @@
+package labels
+
+import (
+    "bytes"
+    "fmt"
+    "image"
+    "regexp"
+    "strconv"
+    "testing"
+
+    "github.com/boombuler/barcode/code128"
+)
+
+func TestCode128CheckSum(t *testing.T) {
+    // 校验值 = (起始符值 + Σ 位置 × 字符值) mod 103，按手工计算的结果核对
+    cases := []struct {
+        content string
+        want    int
+    }{
+        // Code B：起始符 104，字符值 = ASCII - 32
+        {"PJJ123C", 55},
+        {"SKU-001", 26},
+        // 全数字走 Code C：起始符 105，两位一组 12 34 56 78
+        {"12345678", 47},
+    }
+    for _, tc := range cases {
+        code, err := code128.Encode(tc.content)
+        if err != nil {
+            t.Fatalf("编码 %s 失败：%v", tc.content, err)
+        }
+        if got := code.CheckSum(); got != tc.want {
+            t.Errorf("%s 的校验值 = %d，期望 %d", tc.content, got, tc.want)
+        }
+    }
+}
+
+func TestDrawCode128(t *testing.T) {
+    dst := image.NewRGBA(image.Rect(0, 0, 600, 80))
+    rect := image.Rect(50, 10, 550, 70)
+    if err := drawCode128(dst, "SKU-001", rect); err != nil {
+        t.Fatal(err)
+    }
+    // 条码只画在 rect 内，且起始符第一条为黑色
+    dark := 0
+    for y := 0; y < 80; y++ {
+        for x := 0; x < 600; x++ {
+            if dst.RGBAAt(x, y).A == 0 {
+                continue
+            }
+            if !image.Pt(x, y).In(rect) {
+                t.Fatalf("(%d, %d) 超出条码区域", x, y)
+            }
+            dark++
+        }
+    }
+    if dark == 0 {
+        t.Fatal("没有画出条码")
+    }
+
+    if err := drawCode128(dst, "商品", rect); err == nil {
+        t.Error("非 ASCII 内容应无法编码")
+    }
+    if err := drawCode128(dst, "SKU-001", image.Rect(0, 0, 20, 10)); err == nil {
+        t.Error("宽度放不下条码时应报错")
+    }
+}
+
+var (
+    pdfXrefEntry  = regexp.MustCompile(`^(\d{10}) (\d{5}) ([fn]) $`)
+    pdfStartXref  = regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`)
+    pdfXrefHeader = regexp.MustCompile(`^xref\n0 (\d+)\n`)
+)
+
+// checkXref 校验 startxref 指向 xref 表，且每个对象的偏移量指向对应的 "N 0 obj"
+func checkXref(t *testing.T, pdf []byte) int {
+    t.Helper()
+    m := pdfStartXref.FindSubmatch(pdf)
+    if m == nil {
+        t.Fatal("缺少 startxref")
+    }
+    xref, _ := strconv.Atoi(string(m[1]))
+    if xref >= len(pdf) {
+        t.Fatalf("startxref %d 超出文件长度 %d", xref, len(pdf))
+    }
+    h := pdfXrefHeader.FindSubmatch(pdf[xref:])
+    if h == nil {
+        t.Fatalf("startxref %d 没有指向 xref 表", xref)
+    }
+    size, _ := strconv.Atoi(string(h[1]))
+    lines := bytes.Split(pdf[xref+len(h[0]):], []byte("\n"))
+    if len(lines) < size {
+        t.Fatalf("xref 表只有 %d 行，期望 %d 行", len(lines), size)
+    }
+    for i := 0; i < size; i++ {
+        e := pdfXrefEntry.FindSubmatch(lines[i])
+        if e == nil {
+            t.Fatalf("xref 第 %d 项格式错误：%q", i, lines[i])
+        }
+        if i == 0 {
+            if string(e[3]) != "f" || string(e[2]) != "65535" {
+                t.Errorf("xref 第 0 项应为空闲对象：%q", lines[i])
+            }
+            continue
+        }
+        off, _ := strconv.Atoi(string(e[1]))
+        want := fmt.Sprintf("%d 0 obj\n", i)
+        if off >= len(pdf) || !bytes.HasPrefix(pdf[off:], []byte(want)) {
+            t.Errorf("对象 %d 的偏移量 %d 没有指向 %q", i, off, want)
+        }
+    }
+    if !bytes.Contains(pdf[xref:], []byte(fmt.Sprintf("/Size %d", size))) {
+        t.Errorf("trailer 的 /Size 与 xref 表不一致")
+    }
+    return size
+}
+
+func TestPDFWriterXref(t *testing.T) {
+    w := &pdfWriter{}
+    w.reserve(2)
+    content := w.stream("", []byte("0 0 m 10 10 l S"))
+    w.set(2, "<< /Type /Pages /Kids [] /Count 0 >>")
+    w.set(1, "<< /Type /Catalog /Pages 2 0 R >>")
+    w.object(fmt.Sprintf("<< /Contents %d 0 R >>", content))
+
+    if size := checkXref(t, w.bytes()); size != 5 {
+        t.Errorf("xref 表 %d 项，期望 5 项", size)
+    }
+}
+
+func TestRendererPDFXref(t *testing.T) {
+    sheet, ok := FindSheet("roll-50x30")
+    if !ok {
+        t.Fatal("缺少 roll-50x30 规格")
+    }
+    list := []Placement{
+        {Item: Item{SKU: "SKU-001", Name: "Demo"}, Quantity: 2},
+        {Item: Item{SKU: "SKU-002", Name: "Demo 2"}, Quantity: 1},
+    }
+    pdf, err := Default().PDF(sheet, list, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) {
+        t.Fatal("缺少 PDF 文件头")
+    }
+    checkXref(t, pdf)
+}
@@ -0,0 +1,184 @@
+package labels
+
+import (
+    "bytes"
+    "compress/zlib"
+    "fmt"
+    "image"
+    "image/png"
+)
+
+const ptPerMM = 72 / 25.4
+
+// Placement 一种标签及其打印张数
+type Placement struct {
+    Item     Item
+    Quantity int
+}
+
+// PDF 按标签纸规格排版生成 PDF。skip 为第一页跳过的标签数（用于接着打印用过一部分的标签纸）。
+// 同一种标签只渲染一次，在页面中重复引用。
+func (r *Renderer) PDF(sheet Sheet, list []Placement, skip int) ([]byte, error) {
+    per := sheet.PerPage()
+    if per <= 0 {
+        return nil, fmt.Errorf("labels: 无效的标签纸规格 %s", sheet.Name)
+    }
+    if skip < 0 || skip >= per {
+        skip = 0
+    }
+
+    w := &pdfWriter{}
+    w.reserve(2) // 1: Catalog，2: Pages
+
+    // 每种标签渲染为一个图片对象
+    imageObjs := make([]int, len(list))
+    for i, p := range list {
+        if p.Quantity <= 0 {
+            continue
+        }
+        img, err := r.Label(p.Item, sheet.LabelW, sheet.LabelH)
+        if err != nil {
+            return nil, err
+        }
+        obj, err := w.image(img)
+        if err != nil {
+            return nil, err
+        }
+        imageObjs[i] = obj
+    }
+
+    // 按顺序展开成每个标签位置引用的图片对象
+    var slots []int
+    for i := 0; i < skip; i++ {
+        slots = append(slots, 0)
+    }
+    for i, p := range list {
+        for n := 0; n < p.Quantity; n++ {
+            slots = append(slots, imageObjs[i])
+        }
+    }
+    if len(slots) == skip {
+        return nil, fmt.Errorf("labels: 没有需要打印的标签")
+    }
+
+    var pageObjs []int
+    for start := 0; start < len(slots); start += per {
+        end := start + per
+        if end > len(slots) {
+            end = len(slots)
+        }
+        var content bytes.Buffer
+        used := map[int]bool{}
+        for pos, obj := range slots[start:end] {
+            if obj == 0 {
+                continue
+            }
+            used[obj] = true
+            col, row := pos%sheet.Cols, pos/sheet.Cols
+            x := sheet.MarginLeft + float64(col)*(sheet.LabelW+sheet.GapX)
+            top := sheet.MarginTop + float64(row)*(sheet.LabelH+sheet.GapY)
+            y := sheet.PageH - top - sheet.LabelH
+            fmt.Fprintf(&content, "q %.3f 0 0 %.3f %.3f %.3f cm /Im%d Do Q\n",
+                sheet.LabelW*ptPerMM, sheet.LabelH*ptPerMM, x*ptPerMM, y*ptPerMM, obj)
+        }
+        contentObj := w.stream("", content.Bytes())
+        var res bytes.Buffer
+        for obj := range used {
+            fmt.Fprintf(&res, "/Im%d %d 0 R ", obj, obj)
+        }
+        pageObjs = append(pageObjs, w.object(fmt.Sprintf(
+            "<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.3f %.3f] /Resources << /XObject << %s>> >> /Contents %d 0 R >>",
+            sheet.PageW*ptPerMM, sheet.PageH*ptPerMM, res.String(), contentObj)))
+    }
+
+    var kids bytes.Buffer
+    for _, obj := range pageObjs {
+        fmt.Fprintf(&kids, "%d 0 R ", obj)
+    }
+    w.set(1, "<< /Type /Catalog /Pages 2 0 R >>")
+    w.set(2, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", kids.String(), len(pageObjs)))
+    return w.bytes(), nil
+}
+
+// PNG 渲染单张标签为 PNG
+func (r *Renderer) PNG(item Item, wMM, hMM float64) ([]byte, error) {
+    img, err := r.Label(item, wMM, hMM)
+    if err != nil {
+        return nil, err
+    }
+    var buf bytes.Buffer
+    if err := png.Encode(&buf, img); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// pdfWriter 最小化的 PDF 1.4 写入器：只支持字典对象、内容流和 RGB 图片
+type pdfWriter struct {
+    objs [][]byte
+}
+
+func (w *pdfWriter) reserve(n int) {
+    for i := 0; i < n; i++ {
+        w.objs = append(w.objs, nil)
+    }
+}
+
+func (w *pdfWriter) set(id int, body string) {
+    w.objs[id-1] = []byte(body)
+}
+
+func (w *pdfWriter) object(body string) int {
+    w.objs = append(w.objs, []byte(body))
+    return len(w.objs)
+}
+
+// stream 写入压缩后的流对象，dict 为除 Length / Filter 之外的字典项
+func (w *pdfWriter) stream(dict string, data []byte) int {
+    var z bytes.Buffer
+    zw := zlib.NewWriter(&z)
+    zw.Write(data)
+    zw.Close()
+    var b bytes.Buffer
+    fmt.Fprintf(&b, "<< %s /Length %d /Filter /FlateDecode >>\nstream\n", dict, z.Len())
+    b.Write(z.Bytes())
+    b.WriteString("\nendstream")
+    w.objs = append(w.objs, b.Bytes())
+    return len(w.objs)
+}
+
+// image 以无损压缩的 RGB 图片对象写入，保证条码边缘清晰
+func (w *pdfWriter) image(img *image.RGBA) (int, error) {
+    b := img.Bounds()
+    if b.Empty() {
+        return 0, fmt.Errorf("labels: 空白标签")
+    }
+    raw := make([]byte, 0, b.Dx()*b.Dy()*3)
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+        row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+        for i := 0; i < len(row); i += 4 {
+            raw = append(raw, row[i], row[i+1], row[i+2])
+        }
+    }
+    dict := fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8", b.Dx(), b.Dy())
+    return w.stream(dict, raw), nil
+}
+
+func (w *pdfWriter) bytes() []byte {
+    var buf bytes.Buffer
+    buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+    offsets := make([]int, len(w.objs))
+    for i, body := range w.objs {
+        offsets[i] = buf.Len()
+        fmt.Fprintf(&buf, "%d 0 obj\n", i+1)
+        buf.Write(body)
+        buf.WriteString("\nendobj\n")
+    }
+    xref := buf.Len()
+    fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(w.objs)+1)
+    for _, off := range offsets {
+        fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+    }
+    fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(w.objs)+1, xref)
+    return buf.Bytes()
+}
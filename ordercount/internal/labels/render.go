@@ -0,0 +1,282 @@
+package labels
+
+import (
+    "fmt"
+    "image"
+    "image/color"
+    "image/draw"
+    "math"
+    "os"
+    "strings"
+
+    "github.com/boombuler/barcode"
+    "github.com/boombuler/barcode/code128"
+    "github.com/boombuler/barcode/qr"
+    xdraw "golang.org/x/image/draw"
+    "golang.org/x/image/font"
+    "golang.org/x/image/font/gofont/goregular"
+    "golang.org/x/image/font/opentype"
+    "golang.org/x/image/math/fixed"
+)
+
+// DefaultDPI 标签渲染分辨率，300dpi 可以保证条码在普通激光 / 热敏打印机上清晰可扫
+const DefaultDPI = 300
+
+// Item 一张标签的内容
+type Item struct {
+    SKU   string
+    Name  string
+    Image image.Image // 可选的商品图片，nil 表示不显示
+}
+
+// Renderer 标签渲染器，持有解析好的字体。内置字体只包含西文字符，
+// 中文等商品名称需要通过 NewRenderer 指定包含对应字形的 TTF/OTF 字体。
+type Renderer struct {
+    font *opentype.Font
+    dpi  float64
+    // 字体是否为内置西文字体
+    builtin bool
+}
+
+// NewRenderer 创建渲染器，fontPath 为空时使用内置字体
+func NewRenderer(fontPath string) (*Renderer, error) {
+    data := goregular.TTF
+    builtin := true
+    if fontPath != "" {
+        b, err := os.ReadFile(fontPath)
+        if err != nil {
+            return nil, fmt.Errorf("labels: 读取字体失败: %w", err)
+        }
+        data, builtin = b, false
+    }
+    f, err := opentype.Parse(data)
+    if err != nil {
+        // 字体集合（.ttc）取第一个字体
+        coll, cerr := opentype.ParseCollection(data)
+        if cerr != nil {
+            return nil, fmt.Errorf("labels: 解析字体失败: %w", err)
+        }
+        if f, err = coll.Font(0); err != nil {
+            return nil, fmt.Errorf("labels: 解析字体失败: %w", err)
+        }
+    }
+    return &Renderer{font: f, dpi: DefaultDPI, builtin: builtin}, nil
+}
+
+// Default 使用内置字体的渲染器
+func Default() *Renderer {
+    r, err := NewRenderer("")
+    if err != nil {
+        panic(err)
+    }
+    return r
+}
+
+// Builtin 是否使用内置西文字体（无法显示中文）
+func (r *Renderer) Builtin() bool {
+    return r.builtin
+}
+
+func (r *Renderer) px(mm float64) int {
+    return int(math.Round(mm / 25.4 * r.dpi))
+}
+
+func (r *Renderer) face(pt float64) (font.Face, error) {
+    return opentype.NewFace(r.font, &opentype.FaceOptions{Size: pt, DPI: r.dpi, Hinting: font.HintingFull})
+}
+
+// Label 渲染一张 wMM × hMM 的标签：左侧为 SKU 二维码（和可选的商品图片），
+// 右侧为商品名称、Code128 条码和 SKU 文本
+func (r *Renderer) Label(item Item, wMM, hMM float64) (*image.RGBA, error) {
+    if strings.TrimSpace(item.SKU) == "" {
+        return nil, fmt.Errorf("labels: SKU 为空")
+    }
+    w, h := r.px(wMM), r.px(hMM)
+    img := image.NewRGBA(image.Rect(0, 0, w, h))
+    draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+    pad := r.px(1.5)
+    inner := image.Rect(pad, pad, w-pad, h-pad)
+    left := inner.Min.X
+
+    // 二维码：边长为标签可用高度，小标签上不超过宽度的 40%
+    side := inner.Dy()
+    if max := inner.Dx() * 2 / 5; side > max {
+        side = max
+    }
+    top := inner.Min.Y + (inner.Dy()-side)/2
+    if err := drawQR(img, item.SKU, image.Rect(left, top, left+side, top+side)); err != nil {
+        return nil, err
+    }
+    left += side + pad
+
+    // 商品图片：与二维码同样大小，放在二维码右侧，空间不足时不显示
+    if item.Image != nil && inner.Max.X-left-side > inner.Dx()/3 {
+        drawFit(img, item.Image, image.Rect(left, top, left+side, top+side))
+        left += side + pad
+    }
+
+    text := image.Rect(left, inner.Min.Y, inner.Max.X, inner.Max.Y)
+    // 字号随标签高度变化：38mm 高的标签约 9pt
+    pt := math.Max(5, math.Min(10, hMM/4.2))
+    face, err := r.face(pt)
+    if err != nil {
+        return nil, err
+    }
+    defer face.Close()
+    lineH := face.Metrics().Height.Ceil()
+
+    // 名称最多两行，底部留一行给 SKU 文本，中间为条码
+    y := text.Min.Y
+    for _, line := range wrapText(face, item.Name, text.Dx(), 2) {
+        y += lineH
+        drawString(img, face, line, text.Min.X, y-face.Metrics().Descent.Ceil())
+    }
+    skuY := text.Max.Y
+    barTop := y + pad/2
+    barBottom := skuY - lineH - pad/2
+    if barBottom-barTop < r.px(4) {
+        // 空间太小时不显示名称，保证条码高度
+        barTop = text.Min.Y
+    }
+    if err := drawCode128(img, item.SKU, image.Rect(text.Min.X, barTop, text.Max.X, barBottom)); err != nil {
+        return nil, err
+    }
+    sku := truncate(face, item.SKU, text.Dx())
+    skuW := font.MeasureString(face, sku).Ceil()
+    drawString(img, face, sku, text.Min.X+(text.Dx()-skuW)/2, skuY-face.Metrics().Descent.Ceil())
+    return img, nil
+}
+
+// drawQR 在 rect 中绘制 SKU 二维码，模块按整数倍放大保证边缘清晰
+func drawQR(dst *image.RGBA, content string, rect image.Rectangle) error {
+    code, err := qr.Encode(content, qr.M, qr.Auto)
+    if err != nil {
+        return fmt.Errorf("labels: 生成二维码失败: %w", err)
+    }
+    n := code.Bounds().Dx()
+    // 两侧各留 2 个模块的静区
+    scale := rect.Dx() / (n + 4)
+    if scale < 1 {
+        return fmt.Errorf("labels: 标签太小，无法容纳二维码")
+    }
+    size := n * scale
+    ox := rect.Min.X + (rect.Dx()-size)/2
+    oy := rect.Min.Y + (rect.Dy()-size)/2
+    fillModules(dst, code, ox, oy, scale, scale)
+    return nil
+}
+
+// drawCode128 在 rect 中绘制 Code128 条码（水平居中），条宽按整数倍放大
+func drawCode128(dst *image.RGBA, content string, rect image.Rectangle) error {
+    code, err := code128.Encode(content)
+    if err != nil {
+        return fmt.Errorf("labels: SKU %s 无法编码为 Code128: %w", content, err)
+    }
+    n := code.Bounds().Dx()
+    scale := rect.Dx() / n
+    if scale < 1 {
+        return fmt.Errorf("labels: SKU %s 太长，标签宽度放不下条码", content)
+    }
+    ox := rect.Min.X + (rect.Dx()-n*scale)/2
+    fillModules(dst, code, ox, rect.Min.Y, scale, rect.Dy())
+    return nil
+}
+
+// fillModules 按条码的每个模块画黑色矩形：横向放大 sx 倍；一维条码的纵向高度为 sy
+func fillModules(dst *image.RGBA, code barcode.Barcode, ox, oy, sx, sy int) {
+    b := code.Bounds()
+    oneD := b.Dy() == 1
+    for my := b.Min.Y; my < b.Max.Y; my++ {
+        for mx := b.Min.X; mx < b.Max.X; mx++ {
+            if gray := color.GrayModel.Convert(code.At(mx, my)).(color.Gray); gray.Y >= 128 {
+                continue
+            }
+            x0 := ox + (mx-b.Min.X)*sx
+            var rect image.Rectangle
+            if oneD {
+                rect = image.Rect(x0, oy, x0+sx, oy+sy)
+            } else {
+                y0 := oy + (my-b.Min.Y)*sx
+                rect = image.Rect(x0, y0, x0+sx, y0+sx)
+            }
+            draw.Draw(dst, rect, image.Black, image.Point{}, draw.Src)
+        }
+    }
+}
+
+// drawFit 把图片等比缩放后居中放入 rect
+func drawFit(dst *image.RGBA, src image.Image, rect image.Rectangle) {
+    sb := src.Bounds()
+    if sb.Dx() == 0 || sb.Dy() == 0 {
+        return
+    }
+    scale := math.Min(float64(rect.Dx())/float64(sb.Dx()), float64(rect.Dy())/float64(sb.Dy()))
+    w, h := int(float64(sb.Dx())*scale), int(float64(sb.Dy())*scale)
+    x := rect.Min.X + (rect.Dx()-w)/2
+    y := rect.Min.Y + (rect.Dy()-h)/2
+    xdraw.CatmullRom.Scale(dst, image.Rect(x, y, x+w, y+h), src, sb, draw.Over, nil)
+}
+
+func drawString(dst *image.RGBA, face font.Face, s string, x, y int) {
+    d := &font.Drawer{Dst: dst, Src: image.Black, Face: face, Dot: fixed.P(x, y)}
+    d.DrawString(s)
+}
+
+// wrapText 按宽度逐字换行（兼容中文无空格的情况），超出 maxLines 时最后一行加省略号
+func wrapText(face font.Face, s string, width, maxLines int) []string {
+    s = strings.Join(strings.Fields(s), " ")
+    if s == "" {
+        return nil
+    }
+    var lines []string
+    var cur []rune
+    for _, r := range s {
+        next := append(cur, r)
+        if font.MeasureString(face, string(next)).Ceil() > width && len(cur) > 0 {
+            // 西文优先在空格处断行
+            if i := lastSpace(cur); i > 0 {
+                lines = append(lines, string(cur[:i]))
+                cur = append([]rune{}, cur[i+1:]...)
+                cur = append(cur, r)
+                continue
+            }
+            lines = append(lines, strings.TrimSpace(string(cur)))
+            cur = []rune{r}
+            continue
+        }
+        cur = next
+    }
+    if len(cur) > 0 {
+        lines = append(lines, strings.TrimSpace(string(cur)))
+    }
+    if len(lines) > maxLines {
+        rest := strings.Join(lines[maxLines-1:], " ")
+        lines = append(lines[:maxLines-1], truncate(face, rest+"…", width))
+    }
+    return lines
+}
+
+func lastSpace(rs []rune) int {
+    for i := len(rs) - 1; i >= 0; i-- {
+        if rs[i] == ' ' {
+            return i
+        }
+    }
+    return -1
+}
+
+// truncate 截断到指定宽度，截断时以省略号结尾
+func truncate(face font.Face, s string, width int) string {
+    if font.MeasureString(face, s).Ceil() <= width {
+        return s
+    }
+    runes := []rune(strings.TrimSuffix(s, "…"))
+    for len(runes) > 0 {
+        runes = runes[:len(runes)-1]
+        if t := string(runes) + "…"; font.MeasureString(face, t).Ceil() <= width {
+            return t
+        }
+    }
+    return ""
+}
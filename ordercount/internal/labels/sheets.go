@@ -0,0 +1,60 @@
+package labels
+
+import "strings"
+
+// Sheet 标签纸规格，尺寸单位均为毫米。单张标签（热敏卷纸）的页面大小即标签大小。
+type Sheet struct {
+    Name   string  `json:"name"`
+    Title  string  `json:"title"`
+    PageW  float64 `json:"page_w"`
+    PageH  float64 `json:"page_h"`
+    Cols   int     `json:"cols"`
+    Rows   int     `json:"rows"`
+    LabelW float64 `json:"label_w"`
+    LabelH float64 `json:"label_h"`
+    // 第一张标签左上角到纸张左上角的距离
+    MarginLeft float64 `json:"margin_left"`
+    MarginTop  float64 `json:"margin_top"`
+    // 相邻标签之间的间距
+    GapX float64 `json:"gap_x"`
+    GapY float64 `json:"gap_y"`
+}
+
+// PerPage 每页标签数
+func (s Sheet) PerPage() int {
+    return s.Cols * s.Rows
+}
+
+// Sheets 常用标签纸规格（Avery / 国内通用 A4 不干胶、热敏卷纸）
+var Sheets = []Sheet{
+    {Name: "a4-3x7", Title: "A4 3×7（63.5×38.1mm，Avery L7160）", PageW: 210, PageH: 297, Cols: 3, Rows: 7,
+        LabelW: 63.5, LabelH: 38.1, MarginLeft: 7.2, MarginTop: 15.15, GapX: 2.5, GapY: 0},
+    {Name: "a4-2x7", Title: "A4 2×7（99.1×38.1mm，Avery L7163）", PageW: 210, PageH: 297, Cols: 2, Rows: 7,
+        LabelW: 99.1, LabelH: 38.1, MarginLeft: 4.65, MarginTop: 15.15, GapX: 2.5, GapY: 0},
+    {Name: "a4-4x10", Title: "A4 4×10（48.5×25.4mm）", PageW: 210, PageH: 297, Cols: 4, Rows: 10,
+        LabelW: 48.5, LabelH: 25.4, MarginLeft: 8, MarginTop: 21.5, GapX: 0, GapY: 0},
+    {Name: "a4-5x13", Title: "A4 5×13（38.1×21.2mm，Avery L7651）", PageW: 210, PageH: 297, Cols: 5, Rows: 13,
+        LabelW: 38.1, LabelH: 21.2, MarginLeft: 4.75, MarginTop: 10.7, GapX: 2.5, GapY: 0},
+    {Name: "letter-3x10", Title: "Letter 3×10（66.7×25.4mm，Avery 5160）", PageW: 215.9, PageH: 279.4, Cols: 3, Rows: 10,
+        LabelW: 66.7, LabelH: 25.4, MarginLeft: 4.8, MarginTop: 12.7, GapX: 3.2, GapY: 0},
+    {Name: "roll-50x30", Title: "热敏卷纸 50×30mm", PageW: 50, PageH: 30, Cols: 1, Rows: 1, LabelW: 50, LabelH: 30},
+    {Name: "roll-60x40", Title: "热敏卷纸 60×40mm", PageW: 60, PageH: 40, Cols: 1, Rows: 1, LabelW: 60, LabelH: 40},
+    {Name: "roll-100x50", Title: "热敏卷纸 100×50mm", PageW: 100, PageH: 50, Cols: 1, Rows: 1, LabelW: 100, LabelH: 50},
+}
+
+// DefaultSheet 未指定规格时使用的标签纸
+const DefaultSheet = "a4-3x7"
+
+// FindSheet 按名称查找标签纸规格（不区分大小写）
+func FindSheet(name string) (Sheet, bool) {
+    name = strings.ToLower(strings.TrimSpace(name))
+    if name == "" {
+        name = DefaultSheet
+    }
+    for _, s := range Sheets {
+        if s.Name == name {
+            return s, true
+        }
+    }
+    return Sheet{}, false
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// ProductComponent 组合商品（套装）的组成：ProductID 为组合商品，ComponentID 为组件商品，
+// Quantity 为每套包含的组件数量。组件本身也可以是组合商品，但不能形成循环。
+type ProductComponent struct {
+    ID          uint `gorm:"primaryKey" json:"id"`
+    ProductID   uint `json:"product_id" gorm:"uniqueIndex:idx_product_component"`
+    ComponentID uint `json:"component_id" gorm:"uniqueIndex:idx_product_component;index"`
+    Quantity    int  `json:"quantity"`
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OrderComponentCost 组合商品订单按组件拆分的出库数量和货款成本，
+// 组合商品订单的 goods_cost 为各组件成本之和
+type OrderComponentCost struct {
+    ID uint `gorm:"primaryKey" json:"id"`
+
+    OrderID     uint    `json:"order_id" gorm:"index"`
+    ComponentID uint    `json:"component_id" gorm:"index"`
+    Quantity    int     `json:"quantity"`
+    GoodsCost   float64 `json:"goods_cost" gorm:"type:decimal(12,2)"`
+    CostMethod  string  `json:"cost_method" gorm:"size:20"`
+
+    CreatedAt time.Time `json:"created_at"`
+}
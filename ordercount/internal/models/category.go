@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// Category 商品分类，支持多级：ParentID 为 0 表示顶级分类
+type Category struct {
+    ID       uint   `gorm:"primaryKey" json:"id"`
+    Name     string `json:"name" gorm:"size:100"`
+    ParentID uint   `json:"parent_id" gorm:"index;default:0"`
+    // 同级排序，数字越小越靠前
+    Sort int `json:"sort" gorm:"default:0"`
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
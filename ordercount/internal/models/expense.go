@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// ExpenseCategory 费用分类，Kind 决定计入结算的哪一项成本
+type ExpenseCategory struct {
+    ID uint `gorm:"primaryKey" json:"id"`
+
+    Name string `json:"name" gorm:"size:50;uniqueIndex"`
+    // 计入结算的哪一项：shua_dan（刷单费用）/ fixed（固定成本）/ other（只记账，不计入结算）
+    Kind      string `json:"kind" gorm:"size:20"`
+    SortOrder int    `json:"sort_order"`
+    Remark    string `json:"remark" gorm:"size:255"`
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Expense 逐笔费用流水（刷单、房租、工资、杂费等），结算中的刷单费用和固定成本按流水合计得到
+type Expense struct {
+    ID uint `gorm:"primaryKey" json:"id"`
+
+    // 费用日期（YYYY-MM-DD），计入当天的结算
+    Date       string `json:"date" gorm:"size:10;index"`
+    CategoryID uint   `json:"category_id" gorm:"index"`
+
+    // 归属：国家必填；StoreID 为 0 表示国家级费用，计入结算时在该国家未封禁店铺间平均分摊
+    Country string `json:"country" gorm:"size:20;index"`
+    StoreID uint   `json:"store_id" gorm:"index;default:0"`
+
+    // 原币种金额（CNY 或本国货币），按 Exchange（1 外币 ≈ ? 人民币）折算的人民币金额保存在 AmountCNY
+    Amount     float64 `json:"amount" gorm:"type:decimal(14,2)"`
+    Currency   string  `json:"currency" gorm:"size:10"`
+    Exchange   float64 `json:"exchange"`
+    RateSource string  `json:"rate_source" gorm:"size:20"` // rate_service 实时汇率 / manual 手动输入
+    AmountCNY  float64 `json:"amount_cny" gorm:"type:decimal(14,2)"`
+
+    Payee string `json:"payee" gorm:"size:100"` // 收款方
+    Note  string `json:"note" gorm:"size:255"`
+    // 附件（发票、转账截图等）的统一地址，见 POST /api/expenses/upload
+    Attachment string `json:"attachment" gorm:"size:255"`
+
+    UserID uint `json:"user_id" gorm:"index"` // 录入人
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
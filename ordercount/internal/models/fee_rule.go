@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// FeeRule 结算费用规则（平台手续费、广告税等），按国家 / 平台 / 店铺和有效期匹配
+// 同一费用项（Code）有多条规则同时匹配时，只取范围最具体的一条；不同费用项累加。
+type FeeRule struct {
+    ID uint `gorm:"primaryKey" json:"id"`
+
+    Name string `json:"name" gorm:"size:100"`
+    // 费用项编码，例如 commission（平台佣金）、transaction（交易手续费）、ad_tax（广告税）
+    Code string `json:"code" gorm:"size:50;index"`
+    // 计入结算的哪一项：platform_fee（平台手续费）/ ad_tax（并入广告费折算）
+    Kind string `json:"kind" gorm:"size:20"`
+
+    // 适用范围，为空 / 0 表示不限
+    Country  string `json:"country" gorm:"size:20;index"`
+    Platform string `json:"platform" gorm:"size:50"`
+    StoreID  uint   `json:"store_id" gorm:"index"`
+
+    // 计算方式：percent 按基数的百分比（Value=7 表示 7%）；fixed 每天固定金额（人民币）
+    RateType string  `json:"rate_type" gorm:"size:10"`
+    Value    float64 `json:"value" gorm:"type:decimal(12,4)"`
+    // 百分比的基数字段：sale_total / ad_cost / goods_cost
+    Base string `json:"base" gorm:"size:20"`
+
+    // 有效期（含首尾，YYYY-MM-DD），为空表示不限
+    ValidFrom string `json:"valid_from" gorm:"size:10"`
+    ValidTo   string `json:"valid_to" gorm:"size:10"`
+
+    Enabled bool   `json:"enabled"`
+    Remark  string `json:"remark" gorm:"size:255"`
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// FixedCostSchedule 周期性固定成本（房租、工资、软件订阅等）：按频率摊到每天，再按权重分配到国家或店铺
+type FixedCostSchedule struct {
+    ID uint `gorm:"primaryKey" json:"id"`
+
+    Name     string `json:"name" gorm:"size:100"`
+    Category string `json:"category" gorm:"size:50"` // 可选分类，例如 房租 / 工资 / 软件订阅
+
+    // 每期金额及币种（CNY / PHP / IDR / MYR / USD）
+    Amount   float64 `json:"amount" gorm:"type:decimal(12,2)"`
+    Currency string  `json:"currency" gorm:"size:10"`
+    // 折算汇率（1 单位币种 ≈ ? 人民币）：保存时填写，未填写时记录保存当时的实时汇率，之后每天都按该汇率折算
+    Rate float64 `json:"rate" gorm:"type:decimal(16,8)"`
+    // 频率：monthly 每月（按当月天数摊到每天）/ weekly 每周（每天 1/7）
+    Frequency string `json:"frequency" gorm:"size:10"`
+
+    // 生效期间（含首尾，YYYY-MM-DD），EndDate 为空表示长期有效
+    StartDate string `json:"start_date" gorm:"size:10"`
+    EndDate   string `json:"end_date" gorm:"size:10"`
+
+    // 分配对象：country 按国家 / store 按店铺
+    AllocateTo string `json:"allocate_to" gorm:"size:10"`
+    // 参与分配的国家（逗号分隔，为空表示全部国家）；按店铺分配时用于筛选店铺
+    Countries string `json:"countries" gorm:"size:100"`
+    // 参与分配的店铺 ID（逗号分隔，为空表示范围内全部未封禁店铺），仅按店铺分配时使用
+    StoreIDs string `json:"store_ids" gorm:"size:255"`
+    // 权重：equal 平均分配 / sales 按当天销售额比例 / custom 自定义权重
+    WeightMethod string `json:"weight_method" gorm:"size:10"`
+    // 自定义权重（JSON），键为国家名或店铺 ID，例如 {"印尼":2,"菲律宾":1} / {"3":1,"5":2}
+    Weights string `json:"weights" gorm:"type:text"`
+
+    Enabled bool   `json:"enabled"`
+    Remark  string `json:"remark" gorm:"size:255"`
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
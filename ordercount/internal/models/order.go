@@ -13,8 +13,17 @@ type Order struct {
     OrderNo     string    `json:"order_no" gorm:"size:200;index"`
     ProductName string    `json:"product_name" gorm:"size:255"`
     SKU         string    `json:"sku" gorm:"size:200"`
+    // 订单所属店铺（可选，0 表示未指定），用于按店铺匹配 SKU 别名
+    StoreID     uint      `json:"store_id" gorm:"index;default:0"`
+    // 由 SKU 或 SKU 别名解析出的商品 ID，0 表示未匹配到商品；所有按商品统计的查询都通过它关联商品表
+    ProductID   uint      `json:"product_id" gorm:"index;default:0"`
     Quantity    int       `json:"quantity"`
     TotalAmount float64   `json:"total_amount"`
     Currency    string    `json:"currency" gorm:"size:10"`
+    // 货款成本（COGS），由成本核算引擎按商品的核算方式写入；
+    // CostMethod 为空表示该订单尚未核算，统计时回退到 数量 × Product.Cost
+    UnitCost    float64   `json:"unit_cost" gorm:"type:decimal(12,4);default:0"`
+    GoodsCost   float64   `json:"goods_cost" gorm:"type:decimal(12,2);default:0"`
+    CostMethod  string    `json:"cost_method" gorm:"size:20"`
     CreatedAt   time.Time `json:"created_at"`
 }
@@ -0,0 +1,55 @@
+package models
+
+import "time"
+
+// PayoutImport 一次平台结算单（Shopee / Lazada / TikTok 的打款或收入报表）导入记录
+type PayoutImport struct {
+    ID uint `gorm:"primaryKey" json:"id"`
+
+    Platform string `json:"platform" gorm:"size:20;index"` // Shopee / Lazada / TikTok
+    Format   string `json:"format" gorm:"size:50"`         // 识别出的报表格式，例如 shopee_income
+    FileName string `json:"file_name" gorm:"size:255"`
+    // 文件内容哈希，同一个文件不能重复导入
+    FileSHA256 string `json:"file_sha256" gorm:"size:64;uniqueIndex"`
+
+    // 结算单所属店铺（可选）和国家，用于对账时限定订单范围
+    StoreID  uint   `json:"store_id" gorm:"index;default:0"`
+    Country  string `json:"country" gorm:"size:20"`
+    Currency string `json:"currency" gorm:"size:10"`
+
+    LineCount      int     `json:"line_count"`
+    DuplicateCount int     `json:"duplicate_count"` // 与已导入结算单重复而跳过的行数
+    NetTotal       float64 `json:"net_total" gorm:"type:decimal(14,2)"`
+    // 结算单中最早 / 最晚的结算日期
+    PeriodStart string `json:"period_start" gorm:"size:10"`
+    PeriodEnd   string `json:"period_end" gorm:"size:10"`
+
+    UserID    uint      `json:"user_id"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// PayoutLine 结算单中的一行：一笔订单的打款，或 Lazada 这类按费用项拆分的一项收支（金额为原币种，扣费为负数）
+type PayoutLine struct {
+    ID       uint `gorm:"primaryKey" json:"id"`
+    ImportID uint `json:"import_id" gorm:"index"`
+
+    Platform string `json:"platform" gorm:"size:20;index:idx_payout_order,priority:1"`
+    OrderNo  string `json:"order_no" gorm:"size:200;index:idx_payout_order,priority:2"`
+    StoreID  uint   `json:"store_id" gorm:"index;default:0"`
+    Country  string `json:"country" gorm:"size:20"`
+
+    TransactionType string `json:"transaction_type" gorm:"size:100"` // 交易类型 / 费用项名称
+    PayoutDate      string `json:"payout_date" gorm:"size:10;index"` // 结算（打款）日期
+    Currency        string `json:"currency" gorm:"size:10"`
+
+    GrossAmount float64 `json:"gross_amount" gorm:"type:decimal(14,2)"` // 商品原价 / 订单收入
+    FeeAmount   float64 `json:"fee_amount" gorm:"type:decimal(14,2)"`   // 平台佣金、服务费等扣费（负数）
+    NetAmount   float64 `json:"net_amount" gorm:"type:decimal(14,2)"`   // 实际结算金额
+
+    // 行内容指纹（平台、订单号、类型、日期、金额），跨文件去重
+    LineKey string `json:"-" gorm:"size:64;uniqueIndex"`
+    // 原始行（JSON，表头 → 单元格），便于核对
+    Raw string `json:"raw" gorm:"type:text"`
+
+    CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,33 @@
+package models
+
+import "time"
+
+// AccountingPeriod 月度会计期间，没有记录的月份视为未关账
+// 关账后该月的订单、结算和店铺每日数据都不能再修改，Snapshot 保存关账时的汇总数据，用于和实时数据对比
+type AccountingPeriod struct {
+    ID uint `gorm:"primaryKey" json:"id"`
+
+    Period string `json:"period" gorm:"size:7;uniqueIndex"` // YYYY-MM
+    Status string `json:"status" gorm:"size:20"`             // open / closed
+
+    Snapshot string `json:"-" gorm:"type:mediumtext"`
+
+    ClosedBy     uint       `json:"closed_by"`
+    ClosedAt     *time.Time `json:"closed_at"`
+    ReopenedBy   uint       `json:"reopened_by"`
+    ReopenedAt   *time.Time `json:"reopened_at"`
+    ReopenReason string     `json:"reopen_reason" gorm:"size:255"`
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PeriodLog 会计期间关账 / 重新打开的审计记录
+type PeriodLog struct {
+    ID        uint      `gorm:"primaryKey" json:"id"`
+    Period    string    `json:"period" gorm:"size:7;index"`
+    Action    string    `json:"action" gorm:"size:20"` // close / reopen
+    Reason    string    `json:"reason" gorm:"size:255"`
+    UserID    uint      `json:"user_id"`
+    CreatedAt time.Time `json:"created_at"`
+}
@@ -11,9 +11,55 @@ type Product struct {
     ImageURL  string    `json:"image_url" gorm:"size:500"`
     // 基础成本（可理解为超级管理员视角的默认成本）
     Cost      float64   `json:"cost" gorm:"type:decimal(10,2);default:0"`
-    // 不同角色专属成本：管理员成本、员工成本
-    CostAdmin float64   `json:"cost_admin" gorm:"type:decimal(10,2);default:0"`
-    CostStaff float64   `json:"cost_staff" gorm:"type:decimal(10,2);default:0"`
+    // 各角色专属成本见 ProductRoleCost，未配置时回退到基础成本
+    // 成本核算方式：fifo（先进先出）、wavg（移动加权平均）；为空表示沿用基础成本 Cost
+    CostingMethod string `json:"costing_method" gorm:"size:20"`
+    // 所属分类（0 表示未分类）
+    CategoryID uint `json:"category_id" gorm:"index;default:0"`
+    // 逗号分隔的自由标签，例如: "爆款,新品"
+    Tags      string    `json:"tags" gorm:"size:500"`
+    // 生命周期状态：draft（草稿）、active（在售）、paused（暂停）、discontinued（停售 / 归档）；
+    // 只有在售商品可以录入新订单，停售商品保留成本供历史订单统计
+    Status          string     `json:"status" gorm:"size:20;index;default:active"`
+    StatusReason    string     `json:"status_reason" gorm:"size:255"`
+    StatusChangedAt *time.Time `json:"status_changed_at"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
 }
+
+// ProductPrice 商品在各国家的标价（本国货币），每个商品每个国家一条
+type ProductPrice struct {
+    ID        uint    `gorm:"primaryKey" json:"id"`
+    ProductID uint    `json:"product_id" gorm:"uniqueIndex:idx_product_country"`
+    Country   string  `json:"country" gorm:"size:20;uniqueIndex:idx_product_country"`
+    Currency  string  `json:"currency" gorm:"size:10"`
+    Price     float64 `json:"price" gorm:"type:decimal(14,2)"`
+    Remark    string  `json:"remark" gorm:"size:255"`
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProductRoleCost 商品按角色区分的成本档位（例如管理员成本、员工成本），每个商品每个档位一条；
+// 档位及其回退关系、查看 / 修改权限由 handlers.CostTiers 统一配置
+type ProductRoleCost struct {
+    ID        uint    `gorm:"primaryKey" json:"id"`
+    ProductID uint    `json:"product_id" gorm:"uniqueIndex:idx_product_role"`
+    Role      string  `json:"role" gorm:"size:50;uniqueIndex:idx_product_role"`
+    Cost      float64 `json:"cost" gorm:"type:decimal(10,2);default:0"`
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ProductStatusLog 商品状态变更记录
+type ProductStatusLog struct {
+    ID         uint   `gorm:"primaryKey" json:"id"`
+    ProductID  uint   `json:"product_id" gorm:"index"`
+    FromStatus string `json:"from_status" gorm:"size:20"`
+    ToStatus   string `json:"to_status" gorm:"size:20"`
+    Reason     string `json:"reason" gorm:"size:255"`
+    // 操作人，0 表示系统自动变更
+    UserID    uint      `json:"user_id"`
+    CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// PurchaseLot 采购入库批次（收货记录）
+// 同一个 SKU 可以多次以不同价格入库，成本核算引擎据此计算每笔订单的货款成本。
+type PurchaseLot struct {
+    ID uint `gorm:"primaryKey" json:"id"`
+
+    ProductID uint   `json:"product_id" gorm:"index"`
+    SKU       string `json:"sku" gorm:"size:100;index"`
+
+    Quantity  int     `json:"quantity"`                              // 入库数量
+    UnitCost  float64 `json:"unit_cost" gorm:"type:decimal(12,4)"`   // 入库单价（人民币）
+    Remaining int     `json:"remaining"`                             // FIFO 剩余可用数量，由核算引擎维护
+
+    ReceivedAt time.Time `json:"received_at" gorm:"index"` // 入库时间
+    Supplier   string    `json:"supplier" gorm:"size:100"`
+    Remark     string    `json:"remark" gorm:"size:255"`
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OrderCostAllocation 记录 FIFO 核算时某笔订单消耗了哪些入库批次，便于追溯成本来源
+type OrderCostAllocation struct {
+    ID uint `gorm:"primaryKey" json:"id"`
+
+    OrderID  uint    `json:"order_id" gorm:"index"`
+    LotID    uint    `json:"lot_id" gorm:"index"`
+    Quantity int     `json:"quantity"`
+    UnitCost float64 `json:"unit_cost" gorm:"type:decimal(12,4)"`
+
+    CreatedAt time.Time `json:"created_at"`
+}
@@ -3,17 +3,21 @@ package models
 import "time"
 
 // DailySettlement 记录每日的结账/利润明细
-// 一天可以有多次结算记录，按 date + created_at 区分
+// 按 date + store_id 唯一：每个店铺每天一条结算，国家汇总由店铺结算合计得到
 type DailySettlement struct {
     ID uint `gorm:"primaryKey" json:"id"`
 
     // 结算日期（只保留到天，统一用本地日期字符串）
-    Date string `json:"date" gorm:"size:10;index"`
+    Date string `json:"date" gorm:"size:10;index;uniqueIndex:idx_settlement_date_store,priority:1"`
+
+    // 结算所属店铺；启用按店铺结算前的历史记录为按国家结算，StoreID 为 NULL（唯一索引允许多条 NULL）
+    StoreID *uint `json:"store_id" gorm:"uniqueIndex:idx_settlement_date_store,priority:2"`
 
     // 记录这条结算记录由哪个登录用户创建，便于按用户区分可见范围
     UserID  uint   `json:"user_id" gorm:"index"`
 
     Country  string  `json:"country" gorm:"size:20"`
+    Platform string  `json:"platform" gorm:"size:50"` // 可选，用于匹配按平台配置的费用规则
     Currency string  `json:"currency" gorm:"size:10"`
 
     // 输入的原始数值（以外币为单位）
@@ -24,13 +28,86 @@ type DailySettlement struct {
     ShuaDanFee float64 `json:"shua_dan_fee"` // 刷单费用
     FixedCost  float64 `json:"fixed_cost"`   // 固定成本
 
+    // 各项输入金额的原币种（CNY 或本国货币 Currency）及折算后的人民币金额，报表统一按 *_cny 字段汇总。
+    // 上面的 SaleTotal 等字段为原币种金额，本国货币按 Exchange 折算成人民币。
+    SaleCurrency    string  `json:"sale_currency" gorm:"size:10"`
+    SaleTotalCNY    float64 `json:"sale_total_cny"`
+    AdCurrency      string  `json:"ad_currency" gorm:"size:10"`
+    AdCostCNY       float64 `json:"ad_cost_cny"`
+    GoodsCurrency   string  `json:"goods_currency" gorm:"size:10"`
+    GoodsCostCNY    float64 `json:"goods_cost_cny"`
+    ShuaDanCurrency string  `json:"shua_dan_currency" gorm:"size:10"`
+    ShuaDanFeeCNY   float64 `json:"shua_dan_fee_cny"`
+    FixedCurrency   string  `json:"fixed_currency" gorm:"size:10"`
+    FixedCostCNY    float64 `json:"fixed_cost_cny"`
+
+    // 汇率来源：rate_service 实时汇率 / manual 手动输入 / legacy 启用多币种前的数据（来源未知）
+    RateSource string `json:"rate_source" gorm:"size:20"`
+    // 币种口径：explicit 保存时声明了币种；legacy_assumed 为迁移时按 CurrencyNote 中的假设补齐
+    CurrencyBasis string `json:"currency_basis" gorm:"size:20"`
+    CurrencyNote  string `json:"currency_note" gorm:"size:255"`
+
     // 中间计算结果（人民币）
-    AdDeduction   float64 `json:"ad_deduction"`   // 广告成本折算：(广告费 + 广告费*11%) * 汇率
-    PlatformFee   float64 `json:"platform_fee"`   // 平台手续费：当天销售总额 * 7%（默认同币种，是否折算看业务）
+    AdDeduction   float64 `json:"ad_deduction"`   // 广告成本折算：广告费 * 汇率 + 广告税类费用规则
+    PlatformFee   float64 `json:"platform_fee"`   // 平台手续费：平台手续费类费用规则合计
     Profit        float64 `json:"profit"`         // 最终利润
 
+    // 本次计算使用的费用规则 ID（逗号分隔）和明细快照（JSON），规则之后被修改也能还原当时的计算
+    FeeRuleIDs string `json:"fee_rule_ids" gorm:"size:255"`
+    FeeDetail  string `json:"fee_detail" gorm:"type:text"`
+
+    // 刷单费用 / 固定成本的来源明细（JSON）：按费用流水和固定成本计划合计得到的各条记录，为空表示手动输入
+    CostDetail string `json:"cost_detail" gorm:"type:text"`
+
     // 备注信息，例如活动说明、特殊情况等
     Remark string `json:"remark" gorm:"size:255"`
 
+    // 当前版本：指向最新一条修订记录，每次保存 / 回滚都会新增一条修订
+    RevisionID uint `json:"revision_id"`
+    Revision   int  `json:"revision"`
+
+    // 审批状态：draft 草稿 / submitted 已提交 / approved 已审批 / locked 已锁定
+    // 已审批和已锁定的记录不能修改，需要超级管理员填写原因重新打开
+    Status          string     `json:"status" gorm:"size:20;index"`
+    StatusChangedAt *time.Time `json:"status_changed_at"`
+    ApprovedBy      uint       `json:"approved_by"`
+    ApprovedAt      *time.Time `json:"approved_at"`
+
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// SettlementLegacyCurrencyNote 历史结算补齐币种时采用的假设（与当时结账工具的录入方式一致），
+// 启动迁移和回滚到旧版本时都按此补齐并写入 CurrencyNote
+const SettlementLegacyCurrencyNote = "迁移假设：销售额、货款成本、刷单费用、固定成本按人民币录入；广告费为本国货币，按当时汇率折算"
+
+// SettlementRevision 结算记录的修订历史，每次保存 / 回滚生成一条，只增不改
+type SettlementRevision struct {
+    ID uint `gorm:"primaryKey" json:"id"`
+
+    SettlementID uint `json:"settlement_id" gorm:"index:idx_settlement_revision,unique"`
+    // 版本号，同一条结算记录从 1 开始递增
+    Revision int `json:"revision" gorm:"index:idx_settlement_revision,unique"`
+
+    // create 新建 / update 修改 / rollback 回滚 / baseline 启用修订历史前已有的数据
+    Action string `json:"action" gorm:"size:20"`
+    UserID uint   `json:"user_id"`
+
+    // 本版本的完整数据快照和相对上一版本的字段变化（JSON）
+    Data string `json:"-" gorm:"type:text"`
+    Diff string `json:"-" gorm:"type:text"`
+
+    Remark string `json:"remark" gorm:"size:255"`
+
     CreatedAt time.Time `json:"created_at"`
 }
+
+// SettlementStatusLog 结算审批状态变更记录
+type SettlementStatusLog struct {
+    ID           uint      `gorm:"primaryKey" json:"id"`
+    SettlementID uint      `json:"settlement_id" gorm:"index"`
+    FromStatus   string    `json:"from_status" gorm:"size:20"`
+    ToStatus     string    `json:"to_status" gorm:"size:20"`
+    Reason       string    `json:"reason" gorm:"size:255"`
+    UserID       uint      `json:"user_id"`
+    CreatedAt    time.Time `json:"created_at"`
+}
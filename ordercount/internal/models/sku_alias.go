@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// SKUAlias 平台 SKU 别名：各电商平台上卖家自定义的 SKU 与内部商品的对应关系。
+// Platform 为空表示所有平台通用，StoreID 为 0 表示该平台下所有店铺通用；匹配时越具体的优先。
+type SKUAlias struct {
+    ID          uint   `gorm:"primaryKey" json:"id"`
+    Platform    string `json:"platform" gorm:"size:100;uniqueIndex:idx_sku_alias"`
+    StoreID     uint   `json:"store_id" gorm:"uniqueIndex:idx_sku_alias;default:0"`
+    ExternalSKU string `json:"external_sku" gorm:"size:200;uniqueIndex:idx_sku_alias"`
+    ProductID   uint   `json:"product_id" gorm:"index"`
+    Remark      string `json:"remark" gorm:"size:255"`
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
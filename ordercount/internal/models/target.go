@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Target 月度经营目标：管理层按月为全部 / 某个国家 / 某个店铺设定销售额、利润或订单数目标
+type Target struct {
+    ID uint `gorm:"primaryKey" json:"id"`
+
+    // 目标月份 YYYY-MM；同一月份、范围、指标只能有一个目标
+    Period string `json:"period" gorm:"size:7;uniqueIndex:idx_target_scope,priority:1"`
+    // 范围：all 全部 / country 国家 / store 店铺
+    Scope   string `json:"scope" gorm:"size:10;uniqueIndex:idx_target_scope,priority:2"`
+    Country string `json:"country" gorm:"size:20;uniqueIndex:idx_target_scope,priority:3"` // 国家范围必填，店铺范围为店铺所属国家
+    StoreID uint   `json:"store_id" gorm:"default:0;uniqueIndex:idx_target_scope,priority:4"`
+    // 指标：sales 销售额（人民币）/ profit 利润（人民币）/ orders 订单数
+    Metric string  `json:"metric" gorm:"size:10;uniqueIndex:idx_target_scope,priority:5"`
+    Value  float64 `json:"value" gorm:"type:decimal(14,2)"`
+
+    Remark string `json:"remark" gorm:"size:255"`
+    UserID uint   `json:"user_id"` // 最后修改人
+
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestLocalPutConcurrentSameKey(t *testing.T) {
+	l := NewLocal(t.TempDir())
+	const key = "products/ab/abcdef.jpg"
+	data := bytes.Repeat([]byte("x"), 64<<10)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- l.Put(key, data, "image/jpeg")
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("并发写入同一个 key 失败：%v", err)
+		}
+	}
+
+	rc, obj, err := l.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) || obj.Size != int64(len(data)) {
+		t.Errorf("读取到 %d 字节，期望 %d 字节", len(got), len(data))
+	}
+
+	// 临时文件都已改名或清理，List 只有一个对象
+	objs, err := l.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].Key != key {
+		t.Errorf("List = %v，期望只有 %s", objs, key)
+	}
+}
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrNotExist 表示对象不存在
+var ErrNotExist = errors.New("storage: object not found")
+
+// URLPrefix 是上传文件在系统内的统一访问前缀，数据库中保存的是 URLPrefix + key，
+// 与具体存储后端无关，换后端或迁移文件时无需改库。
+const URLPrefix = "/uploads/"
+
+// Object 存储对象的基础信息
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage 上传文件的存储后端。key 为不带前导斜杠的相对路径，例如 "products/xxx.png"。
+type Storage interface {
+	// Put 保存对象，已存在时覆盖
+	Put(key string, data []byte, contentType string) error
+	// Get 读取对象内容，调用方负责关闭
+	Get(key string) (io.ReadCloser, *Object, error)
+	// Stat 查询对象信息，不存在时返回 ErrNotExist
+	Stat(key string) (*Object, error)
+	// Delete 删除对象，不存在时不报错
+	Delete(key string) error
+	// List 列出指定前缀下的所有对象
+	List(prefix string) ([]Object, error)
+	// URL 返回浏览器可直接访问的地址（本地路径、公开地址或预签名地址）
+	URL(key string) (string, error)
+	// KeyFromURL 识别由本后端生成的地址（例如预签名地址）并还原出 key
+	KeyFromURL(u string) (string, bool)
+}
+
+// Config 存储配置，对应 config.yaml 中的 storage 段
+type Config struct {
+	Driver string `yaml:"driver"` // local（默认）或 s3
+
+	Local struct {
+		Dir string `yaml:"dir"` // 本地目录，默认 uploads
+	} `yaml:"local"`
+
+	S3 S3Config `yaml:"s3"`
+}
+
+// New 根据配置创建存储后端
+func New(cfg Config) (Storage, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Driver)) {
+	case "", "local":
+		dir := cfg.Local.Dir
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewLocal(dir), nil
+	case "s3":
+		return NewS3(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+// CanonicalURL 返回保存到数据库中的统一地址
+func CanonicalURL(key string) string {
+	return URLPrefix + strings.TrimPrefix(key, "/")
+}
+
+// KeyFromCanonical 从统一地址中取出 key，不是上传文件地址时返回 false
+func KeyFromCanonical(u string) (string, bool) {
+	if !strings.HasPrefix(u, URLPrefix) {
+		return "", false
+	}
+	key, err := CleanKey(strings.TrimPrefix(u, URLPrefix))
+	if err != nil {
+		return "", false
+	}
+	return key, true
+}
+
+// CleanKey 校验并规范化 key，拒绝空值和 ".." 等路径穿越
+func CleanKey(key string) (string, error) {
+	key = strings.TrimLeft(strings.TrimSpace(key), "/")
+	if i := strings.IndexAny(key, "?#"); i >= 0 {
+		key = key[:i]
+	}
+	if key == "" {
+		return "", errors.New("storage: empty key")
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", fmt.Errorf("storage: invalid key %q", key)
+		}
+	}
+	return key, nil
+}
+
+// Copy 把 src 中指定前缀下的对象复制到 dst，目标已存在且大小一致的对象跳过。
+// 用于在本地目录和对象存储之间迁移已有文件。
+func Copy(src, dst Storage, prefix string, dryRun bool) (copied, skipped int, err error) {
+	objs, err := src.List(prefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, o := range objs {
+		if existing, err := dst.Stat(o.Key); err == nil && existing.Size == o.Size {
+			skipped++
+			continue
+		} else if err != nil && !errors.Is(err, ErrNotExist) {
+			return copied, skipped, err
+		}
+		if dryRun {
+			copied++
+			continue
+		}
+		rc, _, err := src.Get(o.Key)
+		if err != nil {
+			return copied, skipped, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return copied, skipped, err
+		}
+		if err := dst.Put(o.Key, data, ContentTypeByKey(o.Key)); err != nil {
+			return copied, skipped, fmt.Errorf("copy %s: %w", o.Key, err)
+		}
+		copied++
+	}
+	return copied, skipped, nil
+}
+
+// ContentTypeByKey 按扩展名推断 Content-Type
+func ContentTypeByKey(key string) string {
+	lower := strings.ToLower(key)
+	switch {
+	case strings.HasSuffix(lower, ".jpg"), strings.HasSuffix(lower, ".jpeg"):
+		return "image/jpeg"
+	case strings.HasSuffix(lower, ".png"):
+		return "image/png"
+	case strings.HasSuffix(lower, ".gif"):
+		return "image/gif"
+	case strings.HasSuffix(lower, ".webp"):
+		return "image/webp"
+	case strings.HasSuffix(lower, ".pdf"):
+		return "application/pdf"
+	}
+	return "application/octet-stream"
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/HugoSmits86/nativewebp"
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// 商品图片上传限制
+const (
+	MaxImageBytes     = 5 << 20     // 单张图片最大 5MB
+	MaxImageDimension = 4096        // 宽高上限，防止超大图片
+	MaxImagePixels    = 4096 * 4096 // 总像素上限，防止解压炸弹
+	ThumbMaxSide      = 320         // 缩略图最长边
+)
+
+// 允许的图片类型（按文件内容嗅探，不信任客户端扩展名）
+var allowedImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// 经过处理的上传文件名形如 <sha256>.<ext>，派生文件为 <sha256>_thumb.jpg 和 <sha256>.webp
+var hashedImageName = regexp.MustCompile(`^([0-9a-f]{64})(_thumb)?\.[a-z]+$`)
+
+// ProcessedImage 为校验和转换后的图片数据
+type ProcessedImage struct {
+	SHA256      string
+	Ext         string
+	ContentType string
+	Width       int
+	Height      int
+
+	Original []byte // 原图（原样保存）
+	Thumb    []byte // JPEG 缩略图
+	WebP     []byte // WebP 版本（无损）
+}
+
+// OriginalName 原图文件名
+func (p *ProcessedImage) OriginalName() string { return p.SHA256 + p.Ext }
+
+// ThumbName 缩略图文件名
+func (p *ProcessedImage) ThumbName() string { return p.SHA256 + "_thumb.jpg" }
+
+// WebPName WebP 文件名；原图本身是 WebP 时与原图同名
+func (p *ProcessedImage) WebPName() string { return p.SHA256 + ".webp" }
+
+// ProcessImage 校验上传的图片并生成缩略图和 WebP 版本。
+// 返回的 error 都是面向用户的校验失败原因。
+func ProcessImage(data []byte) (*ProcessedImage, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("文件为空")
+	}
+	if len(data) > MaxImageBytes {
+		return nil, fmt.Errorf("图片不能超过 %dMB", MaxImageBytes>>20)
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := allowedImageTypes[contentType]
+	if !ok {
+		return nil, fmt.Errorf("仅支持 JPEG / PNG / GIF / WebP 图片，检测到的类型为 %s", contentType)
+	}
+
+	// 先只读取头信息检查尺寸，避免解码超大图片
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("图片无法解析：%v", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("图片尺寸无效")
+	}
+	if cfg.Width > MaxImageDimension || cfg.Height > MaxImageDimension || cfg.Width*cfg.Height > MaxImagePixels {
+		return nil, fmt.Errorf("图片尺寸 %dx%d 超出限制（最大 %dx%d）", cfg.Width, cfg.Height, MaxImageDimension, MaxImageDimension)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("图片无法解析：%v", err)
+	}
+
+	sum := sha256.Sum256(data)
+	res := &ProcessedImage{
+		SHA256:      hex.EncodeToString(sum[:]),
+		Ext:         ext,
+		ContentType: contentType,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+		Original:    data,
+	}
+
+	// 缩略图：按最长边等比缩放，铺白底后输出 JPEG（透明 PNG 也能正常显示）
+	tw, th := cfg.Width, cfg.Height
+	if tw > ThumbMaxSide || th > ThumbMaxSide {
+		if tw >= th {
+			th = th * ThumbMaxSide / tw
+			tw = ThumbMaxSide
+		} else {
+			tw = tw * ThumbMaxSide / th
+			th = ThumbMaxSide
+		}
+		if tw < 1 {
+			tw = 1
+		}
+		if th < 1 {
+			th = 1
+		}
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, tw, th))
+	draw.Draw(thumb, thumb.Bounds(), image.White, image.Point{}, draw.Src)
+	xdraw.CatmullRom.Scale(thumb, thumb.Bounds(), img, img.Bounds(), xdraw.Over, nil)
+	var thumbBuf bytes.Buffer
+	if err := jpeg.Encode(&thumbBuf, thumb, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, fmt.Errorf("生成缩略图失败：%v", err)
+	}
+	res.Thumb = thumbBuf.Bytes()
+
+	// WebP：原图已经是 WebP 时直接复用
+	if contentType == "image/webp" {
+		res.WebP = data
+	} else {
+		var webpBuf bytes.Buffer
+		if err := nativewebp.Encode(&webpBuf, img, nil); err != nil {
+			return nil, fmt.Errorf("生成 WebP 失败：%v", err)
+		}
+		res.WebP = webpBuf.Bytes()
+	}
+
+	return res, nil
+}
+
+// ImageVariantNames 根据原图文件名推导派生文件名（缩略图、WebP）。
+// 旧版按时间戳命名的文件没有派生文件，返回 ok=false。
+func ImageVariantNames(name string) (thumb, webp string, ok bool) {
+	m := hashedImageName.FindStringSubmatch(path.Base(name))
+	if m == nil || m[2] != "" {
+		return "", "", false
+	}
+	return m[1] + "_thumb.jpg", m[1] + ".webp", true
+}
+
+// ImageHashOf 返回经过处理的上传文件名对应的 sha256（原图和派生文件共享同一个哈希）
+func ImageHashOf(name string) string {
+	m := hashedImageName.FindStringSubmatch(path.Base(name))
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// IsImageFileName 简单判断文件名是否为图片（用于清理任务过滤）
+func IsImageFileName(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+// testImage 生成 w×h 的渐变图片，alpha 为 0 时整张透明
+func testImage(w, h int, alpha uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: alpha})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessImageRejects(t *testing.T) {
+	pngData := encodePNG(t, testImage(8, 8, 255))
+	// 以 PNG 文件头开头、总大小超过 5MB，大小检查在嗅探和解码之前
+	oversize := append(append([]byte{}, pngData...), make([]byte, MaxImageBytes+1-len(pngData))...)
+
+	cases := []struct {
+		name string
+		data []byte
+		want string // 错误信息中应包含的内容
+	}{
+		{"空文件", nil, "文件为空"},
+		{"超过 5MB", oversize, "不能超过 5MB"},
+		{"纯文本", []byte("hello, world"), "检测到的类型为 text/plain"},
+		{"PDF", []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n1 0 obj\n"), "检测到的类型为 application/pdf"},
+		{"HTML 伪装", []byte("<html><script>alert(1)</script></html>"), "检测到的类型为 text/html"},
+		{"PNG 文件头但内容截断", pngData[:20], "图片无法解析"},
+		{"宽度超过 4096", encodePNG(t, image.NewGray(image.Rect(0, 0, MaxImageDimension+1, 1))), "超出限制"},
+		{"高度超过 4096", encodePNG(t, image.NewGray(image.Rect(0, 0, 1, MaxImageDimension+1))), "超出限制"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := ProcessImage(tc.data)
+			if err == nil {
+				t.Fatalf("期望报错，实际得到 %s", res.OriginalName())
+			}
+			if !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("错误信息 %q 中没有 %q", err.Error(), tc.want)
+			}
+		})
+	}
+}
+
+func TestProcessImageOutputs(t *testing.T) {
+	var gifBuf, jpegBuf, webpBuf bytes.Buffer
+	if err := gif.Encode(&gifBuf, testImage(120, 90, 255), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegBuf, testImage(1000, 200, 255), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := nativewebp.Encode(&webpBuf, testImage(64, 48, 255), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name         string
+		data         []byte
+		contentType  string
+		ext          string
+		w, h         int
+		thumbW       int
+		thumbH       int
+		webpIsSource bool // 原图为 WebP 时直接复用
+	}{
+		{"PNG 横图缩放", encodePNG(t, testImage(800, 400, 255)), "image/png", ".png", 800, 400, 320, 160, false},
+		{"PNG 竖图缩放", encodePNG(t, testImage(200, 1000, 255)), "image/png", ".png", 200, 1000, 64, 320, false},
+		{"小图不放大", encodePNG(t, testImage(100, 50, 255)), "image/png", ".png", 100, 50, 100, 50, false},
+		{"GIF", gifBuf.Bytes(), "image/gif", ".gif", 120, 90, 120, 90, false},
+		{"JPEG", jpegBuf.Bytes(), "image/jpeg", ".jpg", 1000, 200, 320, 64, false},
+		{"WebP", webpBuf.Bytes(), "image/webp", ".webp", 64, 48, 64, 48, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			res, err := ProcessImage(tc.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(tc.data)
+			if res.SHA256 != hex.EncodeToString(sum[:]) {
+				t.Errorf("SHA256 = %s，期望原图内容的哈希", res.SHA256)
+			}
+			if res.ContentType != tc.contentType || res.Ext != tc.ext {
+				t.Errorf("类型 %s / %s，期望 %s / %s", res.ContentType, res.Ext, tc.contentType, tc.ext)
+			}
+			if res.Width != tc.w || res.Height != tc.h {
+				t.Errorf("尺寸 %dx%d，期望 %dx%d", res.Width, res.Height, tc.w, tc.h)
+			}
+			if !bytes.Equal(res.Original, tc.data) {
+				t.Error("原图应原样保存")
+			}
+
+			cfg, format, err := image.DecodeConfig(bytes.NewReader(res.Thumb))
+			if err != nil {
+				t.Fatalf("缩略图无法解析：%v", err)
+			}
+			if format != "jpeg" || cfg.Width != tc.thumbW || cfg.Height != tc.thumbH {
+				t.Errorf("缩略图 %s %dx%d，期望 jpeg %dx%d", format, cfg.Width, cfg.Height, tc.thumbW, tc.thumbH)
+			}
+
+			if tc.webpIsSource && !bytes.Equal(res.WebP, tc.data) {
+				t.Error("原图为 WebP 时应直接复用")
+			}
+			cfg, format, err = image.DecodeConfig(bytes.NewReader(res.WebP))
+			if err != nil {
+				t.Fatalf("WebP 无法解析：%v", err)
+			}
+			if format != "webp" || cfg.Width != tc.w || cfg.Height != tc.h {
+				t.Errorf("WebP %s %dx%d，期望 webp %dx%d", format, cfg.Width, cfg.Height, tc.w, tc.h)
+			}
+		})
+	}
+}
+
+func TestProcessImageTransparentThumb(t *testing.T) {
+	res, err := ProcessImage(encodePNG(t, testImage(40, 40, 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb, err := jpeg.Decode(bytes.NewReader(res.Thumb))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 透明区域铺白底，而不是变成黑色
+	r, g, b, _ := thumb.At(20, 20).RGBA()
+	if r>>8 < 245 || g>>8 < 245 || b>>8 < 245 {
+		t.Errorf("透明图片的缩略图像素为 (%d, %d, %d)，期望接近白色", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestImageVariantNames(t *testing.T) {
+	hash := strings.Repeat("ab", 32)
+	thumb, webp, ok := ImageVariantNames("/uploads/products/" + hash + ".png")
+	if !ok || thumb != hash+"_thumb.jpg" || webp != hash+".webp" {
+		t.Errorf("派生文件名 %s / %s / %v", thumb, webp, ok)
+	}
+	for _, name := range []string{hash + "_thumb.jpg", "1700000000.png", "abc.png"} {
+		if _, _, ok := ImageVariantNames(name); ok {
+			t.Errorf("%s 不应有派生文件", name)
+		}
+	}
+	if got := ImageHashOf(hash + "_thumb.jpg"); got != hash {
+		t.Errorf("ImageHashOf = %q，期望 %q", got, hash)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+    "flag"
     "fmt"
     "io/ioutil"
     "log"
@@ -12,6 +13,8 @@ import (
 
     "ordercount/internal/db"
     "ordercount/internal/handlers"
+    "ordercount/internal/labels"
+    "ordercount/internal/storage"
 )
 
 type Config struct {
@@ -28,6 +31,17 @@ type Config struct {
         DoubaoEndpoint string `yaml:"doubao_endpoint"`
         DoubaoModel string `yaml:"doubao_model"`
     } `yaml:"ai"`
+    // 上传文件存储：local（默认，本地 uploads 目录）或 s3（S3 兼容对象存储）
+    Storage storage.Config `yaml:"storage"`
+    // 商品成本档位（按角色），不配置时使用默认的管理员 / 员工两档
+    CostTiers []handlers.CostTier `yaml:"cost_tiers"`
+    // SKU 标签：font_path 为包含中文字形的 TTF/OTF/TTC 字体，不配置时商品名称只能显示西文
+    Labels struct {
+        FontPath string `yaml:"font_path"`
+    } `yaml:"labels"`
+    Settlement struct {
+        FixedCosts map[string]float64 `yaml:"fixed_costs"`
+    } `yaml:"settlement"`
 }
 
 func main() {
@@ -45,6 +59,7 @@ func main() {
     var doubaoAPIKey string
     var doubaoEndpoint string
     var doubaoModel string
+    var storageCfg storage.Config
     // 优先从 config.yaml 读取
     if data, err := ioutil.ReadFile("config.yaml"); err == nil {
         var cfg Config
@@ -70,7 +85,61 @@ func main() {
             if cfg.AI.DoubaoModel != "" {
                 doubaoModel = cfg.AI.DoubaoModel
             }
+            storageCfg = cfg.Storage
+            if cfg.Labels.FontPath != "" {
+                if r, err := labels.NewRenderer(cfg.Labels.FontPath); err != nil {
+                    log.Printf("[labels] 加载标签字体失败，使用内置字体：%v", err)
+                } else {
+                    handlers.LabelRenderer = r
+                }
+            }
+            if len(cfg.Settlement.FixedCosts) > 0 {
+                handlers.SettlementFixedCosts = cfg.Settlement.FixedCosts
+            }
+            if len(cfg.CostTiers) > 0 {
+                if err := handlers.SetCostTiers(cfg.CostTiers); err != nil {
+                    log.Fatalf("config: %v", err)
+                }
+            }
+        }
+    }
+
+    // 对象存储的密钥等敏感信息也可通过环境变量注入，避免写进配置文件
+    if v := os.Getenv("STORAGE_DRIVER"); v != "" {
+        storageCfg.Driver = v
+    }
+    if v := os.Getenv("S3_ENDPOINT"); v != "" {
+        storageCfg.S3.Endpoint = v
+    }
+    if v := os.Getenv("S3_BUCKET"); v != "" {
+        storageCfg.S3.Bucket = v
+    }
+    if v := os.Getenv("S3_ACCESS_KEY"); v != "" {
+        storageCfg.S3.AccessKey = v
+    }
+    if v := os.Getenv("S3_SECRET_KEY"); v != "" {
+        storageCfg.S3.SecretKey = v
+    }
+    uploadStorage, err := storage.New(storageCfg)
+    if err != nil {
+        log.Fatalf("storage init: %v", err)
+    }
+    handlers.UploadStorage = uploadStorage
+
+    // 子命令：./ordercount migrate-uploads [-from uploads] [-dry-run]
+    // 把本地目录中已有的上传文件复制到当前配置的存储后端（例如从本地迁移到 S3）
+    if len(os.Args) > 1 && os.Args[1] == "migrate-uploads" {
+        fs := flag.NewFlagSet("migrate-uploads", flag.ExitOnError)
+        fromDir := fs.String("from", "uploads", "源目录（本地）")
+        dryRun := fs.Bool("dry-run", false, "只统计不复制")
+        fs.Parse(os.Args[2:])
+
+        copied, skipped, err := storage.Copy(storage.NewLocal(*fromDir), uploadStorage, "", *dryRun)
+        if err != nil {
+            log.Fatalf("migrate-uploads: %v（已复制 %d 个，跳过 %d 个）", err, copied, skipped)
         }
+        log.Printf("migrate-uploads 完成：复制 %d 个，跳过 %d 个（目标已存在），dry-run=%v", copied, skipped, *dryRun)
+        return
     }
     // 如设置了环境变量 MYSQL_DSN，则优先生效，便于 Docker / 线上环境覆盖配置文件
     if envDSN := os.Getenv("MYSQL_DSN"); envDSN != "" {
@@ -129,10 +198,86 @@ func main() {
         authGroup.POST("/order", handlers.PostOrder(gdb))
         authGroup.POST("/settlement", handlers.SaveSettlement(gdb))
         authGroup.GET("/settlements", handlers.ListSettlements(gdb))
+        authGroup.POST("/settlement/preview", handlers.PreviewSettlementFees(gdb))
+        // 结算建议：按订单、店铺广告费、商品成本、汇率和配置的固定成本生成，确认后再保存
+        authGroup.GET("/settlement/proposal", handlers.ProposeSettlement(gdb))
+        // 结算修订历史：查看、比较和回滚
+        authGroup.GET("/settlements/:id/revisions", handlers.ListSettlementRevisions(gdb))
+        authGroup.GET("/settlements/:id/revisions/diff", handlers.DiffSettlementRevisions(gdb))
+        authGroup.POST("/settlements/:id/rollback", handlers.RollbackSettlement(gdb))
+        // 结算审批：提交 / 审批 / 驳回 / 锁定 / 重新打开
+        authGroup.POST("/settlements/:id/status", handlers.SetSettlementStatus(gdb))
+        authGroup.GET("/settlements/:id/status_logs", handlers.ListSettlementStatusLogs(gdb))
+
+        // 结算费用规则（平台手续费、广告税等），维护仅限超级管理员
+        feeRules := api.Group("/fee_rules")
+        feeRules.Use(handlers.AuthMiddleware())
+        feeRules.GET("", handlers.ListFeeRules(gdb))
+        feeRules.POST("", handlers.SaveFeeRule(gdb))
+        feeRules.DELETE(":id", handlers.DeleteFeeRule(gdb))
+
+        // 周期性固定成本：按天摊销并分配到国家 / 店铺，维护仅限超级管理员
+        fixedCosts := api.Group("/fixed_costs")
+        fixedCosts.Use(handlers.AuthMiddleware())
+        fixedCosts.GET("", handlers.ListFixedCosts(gdb))
+        fixedCosts.GET("/allocation", handlers.FixedCostAllocation(gdb))
+        fixedCosts.POST("", handlers.SaveFixedCost(gdb))
+        fixedCosts.DELETE(":id", handlers.DeleteFixedCost(gdb))
+
+        // 费用流水：逐笔记录刷单、房租等费用，结算的刷单费用 / 固定成本按流水合计；分类维护仅限超级管理员
+        expenseCats := api.Group("/expense_categories")
+        expenseCats.Use(handlers.AuthMiddleware())
+        expenseCats.GET("", handlers.ListExpenseCategories(gdb))
+        expenseCats.POST("", handlers.SaveExpenseCategory(gdb))
+        expenseCats.DELETE(":id", handlers.DeleteExpenseCategory(gdb))
+        expenses := api.Group("/expenses")
+        expenses.Use(handlers.AuthMiddleware())
+        expenses.GET("", handlers.ListExpenses(gdb))
+        expenses.POST("", handlers.CreateExpense(gdb))
+        expenses.POST("/upload", handlers.UploadExpenseAttachment())
+        expenses.PUT("/:id", handlers.UpdateExpense(gdb))
+        expenses.DELETE("/:id", handlers.DeleteExpense(gdb))
+
+        // 平台结算单：导入 Shopee / Lazada / TikTok 打款报表并按订单号对账（管理员及以上）
+        payouts := api.Group("/payouts")
+        payouts.Use(handlers.AuthMiddleware())
+        payouts.POST("/import", handlers.ImportPayouts(gdb))
+        payouts.GET("/imports", handlers.ListPayoutImports(gdb))
+        payouts.DELETE("/imports/:id", handlers.DeletePayoutImport(gdb))
+        payouts.GET("/reconcile", handlers.ReconcilePayouts(gdb))
+
+        // 利润表：按国家 / 店铺 / 平台分组，环比、同比对比，可导出 Excel
+        authGroup.GET("/reports/pnl", handlers.PnLReport(gdb))
+        // 数据一致性检查（订单 / 店铺数据 / 结算）
+        authGroup.GET("/consistency", handlers.ConsistencyCheck(gdb))
+
+        // 月度经营目标及完成进度（维护仅超级管理员，查看为管理员及以上）
+        targets := api.Group("/targets")
+        targets.Use(handlers.AuthMiddleware())
+        targets.GET("", handlers.ListTargets(gdb))
+        targets.GET("/progress", handlers.TargetProgress(gdb))
+        targets.POST("", handlers.SaveTarget(gdb))
+        targets.DELETE("/:id", handlers.DeleteTarget(gdb))
+
+        // 销售额 / 利润预测及模型回测（管理员及以上）
+        forecast := api.Group("/forecast")
+        forecast.Use(handlers.AuthMiddleware())
+        forecast.GET("", handlers.Forecast(gdb))
+        forecast.GET("/backtest", handlers.ForecastBacktest(gdb))
+
+        // 月结关账：关账快照、重新打开（仅超级管理员）和快照对比报表
+        periods := api.Group("/periods")
+        periods.Use(handlers.AuthMiddleware())
+        periods.GET("", handlers.ListPeriods(gdb))
+        periods.POST("/:period/close", handlers.ClosePeriod(gdb))
+        periods.POST("/:period/reopen", handlers.ReopenPeriod(gdb))
+        periods.GET("/:period/report", handlers.PeriodReport(gdb))
+        periods.GET("/:period/logs", handlers.ListPeriodLogs(gdb))
         authGroup.GET("/orders", handlers.ListOrders(gdb))
         authGroup.PUT("/orders/:id", handlers.UpdateOrder(gdb))
         authGroup.PUT("/orders/:id/date", handlers.UpdateOrderDate(gdb))
         authGroup.DELETE("/orders/:id", handlers.DeleteOrder(gdb))
+        authGroup.GET("/orders/:id/cost", handlers.OrderCostDetail(gdb))
 
         // 汇总类统计保持原样，可按需要后续再加登录控制
         api.GET("/sales/today", handlers.TodaySales(gdb))
@@ -149,7 +294,55 @@ func main() {
         products.GET("", handlers.ListProducts(gdb))
         products.POST("", handlers.SaveProduct(gdb))
         products.DELETE(":id", handlers.DeleteProduct(gdb))
+        products.POST("/:id/status", handlers.SetProductStatus(gdb))
+        products.GET("/:id/status_logs", handlers.ListProductStatusLogs(gdb))
+        products.GET("/:id/label.png", handlers.ProductLabelPNG(gdb))
         products.POST("/upload", handlers.UploadProductImage())
+        products.GET("/export", handlers.ExportProducts(gdb))
+        products.POST("/import", handlers.ImportProducts(gdb))
+        products.POST("/uploads/cleanup", handlers.CleanupUploads(gdb))
+        products.GET("/tags", handlers.ListTags(gdb))
+        products.GET("/cost_tiers", handlers.ListCostTiers())
+        products.PUT("/tags", handlers.RenameTag(gdb))
+
+        // 商品分国家标价与定价计算
+        productPrices := api.Group("/product_prices")
+        productPrices.Use(handlers.AuthMiddleware())
+        productPrices.GET("", handlers.ListProductPrices(gdb))
+        productPrices.POST("", handlers.SaveProductPrice(gdb))
+        productPrices.DELETE(":id", handlers.DeleteProductPrice(gdb))
+        authGroup.GET("/pricing", handlers.ProductPricing(gdb))
+
+        // SKU 条码 / 二维码标签打印
+        labelGroup := api.Group("/labels")
+        labelGroup.Use(handlers.AuthMiddleware())
+        labelGroup.GET("/sheets", handlers.ListLabelSheets())
+        labelGroup.POST("", handlers.PrintLabels(gdb))
+
+        // 平台 SKU 别名与未匹配 SKU 报表
+        skuAliases := api.Group("/sku_aliases")
+        skuAliases.Use(handlers.AuthMiddleware())
+        skuAliases.GET("", handlers.ListSKUAliases(gdb))
+        skuAliases.POST("", handlers.SaveSKUAlias(gdb))
+        skuAliases.DELETE(":id", handlers.DeleteSKUAlias(gdb))
+        skuAliases.GET("/unmatched", handlers.UnmatchedSKUs(gdb))
+
+        // 商品分类（多级）
+        categories := api.Group("/categories")
+        categories.Use(handlers.AuthMiddleware())
+        categories.GET("", handlers.ListCategories(gdb))
+        categories.POST("", handlers.SaveCategory(gdb))
+        categories.DELETE(":id", handlers.DeleteCategory(gdb))
+        // 按分类统计销量、销售额、货款成本和毛利率（需要登录，仅管理员可见）
+        authGroup.GET("/stats/categories", handlers.CategoryStats(gdb))
+
+        // 采购入库批次与成本核算（需登录，仅超级管理员可操作）
+        purchaseLots := api.Group("/purchase_lots")
+        purchaseLots.Use(handlers.AuthMiddleware())
+        purchaseLots.GET("", handlers.ListPurchaseLots(gdb))
+        purchaseLots.POST("", handlers.SavePurchaseLot(gdb))
+        purchaseLots.DELETE(":id", handlers.DeletePurchaseLot(gdb))
+        authGroup.POST("/costing/recalculate", handlers.RecalculateCosts(gdb))
 
         // 店铺管理（需登录）
         shops := api.Group("/shops")
@@ -185,10 +378,10 @@ func main() {
     r.StaticFile("/", "frontend/dist/index.html")
     r.Static("/assets", "frontend/dist/assets")
     r.StaticFile("/vite.svg", "frontend/dist/vite.svg")
-    // 本地上传的文件（商品图片等）
-    r.Static("/uploads", "uploads")
+    // 上传的文件（商品图片等），由存储后端提供：本地直接读文件，对象存储重定向
+    r.GET("/uploads/*filepath", handlers.ServeUpload())
 
-    // 启动一个后台协程，每天在配置的时间自动推送前一天的订单日报到企业微信
+    // 启动一个后台协程，每天在配置的时间自动推送前一天的订单日报到企业微信（附带前一天的数据一致性检查摘要）
     // push_time 配置格式为 "HH:MM"，例如 "05:00" 表示每天早上 5 点（北京时间）。
     go func() {
         // 默认时间 05:00（北京时间）
@@ -372,5 +565,24 @@ func main() {
         }
     }()
 
+    // 启动一个后台协程，每天清理一次不再被商品引用的上传图片
+    go func() {
+        for {
+            time.Sleep(24 * time.Hour)
+            removed, err := handlers.CleanupOrphanUploads(gdb, false)
+            if err != nil {
+                log.Printf("[upload-cleanup] 清理孤儿图片失败：%v", err)
+                continue
+            }
+            log.Printf("[upload-cleanup] 已清理孤儿图片 %d 个", len(removed))
+        }
+    }()
+
+    // 开始接收请求之前给未匹配的历史订单补上商品（SKU / 平台 SKU 别名），并核算受影响商品的成本；
+    // 同步执行，避免与接口中的订单写入并发
+    if err := handlers.ResolveUnmatchedOrderProducts(gdb); err != nil {
+        log.Printf("[sku-alias] 订单商品匹配失败：%v", err)
+    }
+
     r.Run(":8080")
 }